@@ -0,0 +1,81 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+// AlertKind describes why an alert was raised.
+type AlertKind string
+
+const (
+	// AlertWaitThreshold is raised when queue wait times exceed the
+	// threshold set with WithWaitThreshold.
+	AlertWaitThreshold AlertKind = "WaitThreshold"
+
+	// AlertStarvation is raised when a test has been queued for longer
+	// than the threshold set with WithStarvationWarning.
+	AlertStarvation AlertKind = "Starvation"
+
+	// AlertRegression is raised when a test's wait or hold time has regressed
+	// compared to the baseline set with WithBaseline.
+	AlertRegression AlertKind = "Regression"
+
+	// AlertBudget is raised when the run costs more than the budget set with
+	// WithBudget, or Verify finds more tests were skipped than the budget set
+	// with WithSkipBudget.
+	AlertBudget AlertKind = "Budget"
+
+	// AlertLeaseLost is raised when a backend reclaimed a held allocation,
+	// for example after missed heartbeats, and it could not be re-acquired.
+	AlertLeaseLost AlertKind = "LeaseLost"
+
+	// AlertDeadlock is raised when queued tests can never be granted their
+	// resources, and are failed.
+	AlertDeadlock AlertKind = "Deadlock"
+
+	// AlertSchedulerPanic is raised when the scheduler panicked, and every
+	// waiting test is failed.
+	AlertSchedulerPanic AlertKind = "SchedulerPanic"
+
+	// AlertOvercommit is raised by Start when the pool is far larger than
+	// the host limits set with WithHostLimits.
+	AlertOvercommit AlertKind = "Overcommit"
+
+	// AlertLeak is raised by Verify when resources were never returned to
+	// the pool.
+	AlertLeak AlertKind = "Leak"
+)
+
+// Alert is raised when the scheduler detects something that a human should
+// probably know about.
+type Alert struct {
+	// Kind is the type of alert.
+	Kind AlertKind
+
+	// Message is a human readable description of the problem.
+	Message string
+}
+
+// raise prints an alert and passes it to any registered hooks.
+func raise(alert Alert) {
+	emit(nil, event{
+		Action:  "warn",
+		Message: alert.Message,
+	})
+
+	for _, hook := range config.alertHooks {
+		hook(alert)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Allocation is a set of resources granted to a test.
+type Allocation struct {
+	// t is the test that owns the allocation.
+	t *testing.T
+
+	// record is the allocation's history.
+	record *record
+
+	// instances are provisioned by providers, keyed by resource name.
+	instances map[string][]any
+
+	// lock protects released, explicit, ended, assigned and children.
+	lock sync.Mutex
+
+	// released is set once the resources have started to be returned.
+	released bool
+
+	// explicit is set once the test has called Release.
+	explicit bool
+
+	// ended is set once the test has completed, and may no longer be used
+	// to report errors.
+	ended bool
+
+	// done is closed once the resources have been returned.
+	done chan struct{}
+
+	// passthrough is set when the resources were never accounted, so only
+	// instances need to be returned.
+	passthrough bool
+
+	// assigned are the resources held by children.
+	assigned ResourceSet
+
+	// children counts the children created, to give each a unique ID.
+	children int
+}
+
+var (
+	// ErrDoubleRelease is reported when a test releases an allocation more
+	// than once, which is likely a bug in the test.
+	ErrDoubleRelease = errors.New("allocation released twice")
+
+	// ErrReleaseAfterEnd is reported when an allocation is released after
+	// its test completed, for example from a leaked goroutine.
+	ErrReleaseAfterEnd = errors.New("allocation released after the test completed")
+)
+
+var (
+	// releasing counts releases in progress, so Stop can wait for them.
+	releasing atomic.Int64
+)
+
+// newAllocation returns an allocation of the record's resources to the test.
+func newAllocation(t *testing.T, r *record) *Allocation {
+	return &Allocation{
+		t:      t,
+		record: r,
+		done:   make(chan struct{}),
+	}
+}
+
+// newAllocationID returns a random identifier that is unique across runs,
+// so it can be used to trace orphaned infrastructure back to a test.
+func newAllocationID() string {
+	id := make([]byte, 8)
+
+	// This never returns an error on supported platforms.
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// ID returns the allocation's unique identifier.  This is logged on every
+// lifecycle transition, and exported, so external fixtures e.g. clusters or
+// VMs can be tagged with it and leaks traced back to the test.
+func (a *Allocation) ID() string {
+	return a.record.id
+}
+
+// Resources returns a copy of the resources granted to the test.
+func (a *Allocation) Resources() ResourceSet {
+	resources := make(ResourceSet, len(a.record.required))
+
+	for k, v := range a.record.required {
+		resources[k] = v
+	}
+
+	return resources
+}
+
+// markReleased records that the allocation has been released, returning false
+// if it already was.
+func (a *Allocation) markReleased() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.released {
+		return false
+	}
+
+	a.released = true
+
+	return true
+}
+
+// claimRelease records an explicit release, returning an error if the test has
+// already released the allocation.
+func (a *Allocation) claimRelease() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.explicit {
+		return fmt.Errorf("%w: %s released allocation %s more than once", ErrDoubleRelease, a.t.Name(), a.record.id)
+	}
+
+	a.explicit = true
+
+	return nil
+}
+
+// hasEnded returns whether the test has completed.
+func (a *Allocation) hasEnded() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.ended
+}
+
+// Release returns the resources to the pool.  This also happens automatically
+// when the test completes, even if it panics or calls FailNow.  Releasing more
+// than once is reported as a test error, and releasing after the test has
+// completed is reported as a warning.
+func (a *Allocation) Release() {
+	if a.hasEnded() {
+		emit(nil, event{
+			Action:  "warn",
+			Test:    a.record.name,
+			ID:      a.record.id,
+			Message: fmt.Sprintf("%v: %s allocation %s", ErrReleaseAfterEnd, a.record.name, a.record.id),
+		})
+
+		return
+	}
+
+	a.t.Helper()
+
+	if err := a.claimRelease(); err != nil {
+		a.t.Error(err)
+		return
+	}
+
+	a.release()
+}
+
+// cleanup is called when the test completes, it returns the resources, or
+// waits for a release already in progress e.g. from another goroutine, so the
+// test is never used after it has completed.
+func (a *Allocation) cleanup() {
+	a.t.Helper()
+
+	a.release()
+
+	<-a.done
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.ended = true
+}
+
+// release returns the resources to the pool, if they haven't been already.
+func (a *Allocation) release() {
+	a.t.Helper()
+
+	if !a.markReleased() {
+		return
+	}
+
+	releasing.Add(1)
+
+	defer func() {
+		untrack(a)
+		close(a.done)
+		releasing.Add(-1)
+	}()
+
+	r := a.record
+
+	if err := a.deprovision(); err != nil {
+		a.t.Errorf("failed to release resource instances: %v", err)
+	}
+
+	if a.passthrough {
+		return
+	}
+
+	clearProfileLabels()
+	releaseRecord(r)
+	journal(journalRelease, r)
+
+	if config.backend != nil {
+		backendRelease(r)
+	}
+
+	export(r)
+
+	emit(a.t, event{
+		Action:    "end",
+		Test:      a.t.Name(),
+		ID:        r.id,
+		Resources: r.required,
+		Elapsed:   r.released.Sub(r.scheduled).Seconds(),
+	})
+
+	if err := submit(release, r, "release of "+r.name, enqueueTimeout()); err != nil {
+		a.t.Error(err)
+	}
+}
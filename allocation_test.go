@@ -0,0 +1,328 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocationID(t *testing.T) {
+	allocation := Acquire(t, ResourceSet{"cpu": 1})
+	defer allocation.Release()
+
+	if id := allocation.ID(); len(id) != 16 {
+		t.Fatalf("unexpected allocation ID %q", id)
+	}
+
+	if id := newAllocationID(); id == allocation.ID() {
+		t.Fatalf("expected unique allocation IDs, got %q twice", id)
+	}
+}
+
+func TestDoubleRelease(t *testing.T) {
+	allocation := Acquire(t, ResourceSet{"cpu": 1})
+	allocation.Release()
+
+	// Calling Release again would fail this test.
+	err := allocation.claimRelease()
+	if !errors.Is(err, ErrDoubleRelease) || !strings.Contains(err.Error(), t.Name()) {
+		t.Fatalf("expected a double release error naming the test, got %v", err)
+	}
+}
+
+func TestReleaseAfterEnd(t *testing.T) {
+	t.Parallel()
+
+	var allocation *Allocation
+
+	// Acquire makes the test parallel, so group it to wait for it to end.
+	t.Run("Group", func(t *testing.T) {
+		t.Run("Leaky", func(t *testing.T) {
+			allocation = Acquire(t, ResourceSet{"cpu": 1})
+		})
+	})
+
+	if !allocation.hasEnded() {
+		t.Fatal("expected allocation to be released when the test ended")
+	}
+
+	// Releasing from a goroutine that outlived the test must not use it,
+	// which would panic.
+	allocation.Release()
+
+	if allocation.explicit {
+		t.Fatal("expected release after the test ended to be ignored")
+	}
+}
+
+func TestCheckStarted(t *testing.T) {
+	t.Parallel()
+
+	if err := checkStarted(nil); !errors.Is(err, ErrNotStarted) {
+		t.Fatalf("expected not started error, got %v", err)
+	}
+
+	if err := checkStarted(capacity()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckStart(t *testing.T) {
+	t.Parallel()
+
+	if err := checkStart(nil, ResourceSet{"cpu": 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkStart(capacity(), ResourceSet{"cpu": 8}); !errors.Is(err, ErrAlreadyStarted) {
+		t.Fatalf("expected already started error, got %v", err)
+	}
+
+	for _, resources := range []ResourceSet{nil, {}, {"": 1}, {"cpu": -1}} {
+		if err := checkStart(nil, resources); !errors.Is(err, ErrInvalidResourceSet) {
+			t.Fatalf("expected invalid resource set error for %v, got %v", resources, err)
+		}
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected a second Start to panic")
+		}
+	}()
+
+	Start(ResourceSet{"cpu": 8})
+}
+
+func TestWithdrawFrom(t *testing.T) {
+	t.Parallel()
+
+	b := NewMemoryBackend(ResourceSet{"cpu": 8})
+
+	queue := newWaitQueue()
+	queue.add(&queueItem{record: &record{id: "a", name: "TestA"}})
+
+	// Withdrawn while queued...
+	if withdrawFrom(queue, b, "a") {
+		t.Fatal("expected queued allocation not to be granted")
+	}
+
+	if queue.len() != 0 {
+		t.Fatal("expected allocation to be removed from the queue")
+	}
+
+	// ... and after being granted.
+	if ok, _ := b.Acquire(context.Background(), "b", ResourceSet{"cpu": 8}); !ok {
+		t.Fatal("expected acquire to succeed")
+	}
+
+	if !withdrawFrom(queue, b, "b") {
+		t.Fatal("expected granted allocation to be reported")
+	}
+
+	if free := b.Free(); free["cpu"] != 8 {
+		t.Fatalf("expected resources to be returned, got %v", free)
+	}
+}
+
+func TestCheckKnown(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 8, "gpu": 0}
+
+	if err := checkKnown(pool, ResourceSet{"cpus": 1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkKnown(pool, ResourceSet{"cpu": 1, "gpu": 1}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	err := checkKnown(pool, ResourceSet{"cpus": 1}, true)
+	if !errors.Is(err, ErrUnknownResource) || !strings.Contains(err.Error(), "cpus, expected one of cpu, gpu") {
+		t.Fatalf("expected an unknown resource error, got %v", err)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	t.Parallel()
+
+	ch := make(chan int, 1)
+
+	if err := submit(ch, 1, "test", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is receiving and the buffer is full, like a wedged scheduler.
+	err := submit(ch, 2, "enqueue of TestWedged", 10*time.Millisecond)
+	if !errors.Is(err, ErrSchedulerBlocked) || !strings.Contains(err.Error(), "TestWedged") {
+		t.Fatalf("expected a blocked scheduler error naming the test, got %v", err)
+	}
+}
+
+func TestDuplicateNames(t *testing.T) {
+	t.Parallel()
+
+	// Two allocations with the same test name must not overwrite each
+	// other in the queue.
+	var items []*queueItem
+
+	for i := 0; i < 2; i++ {
+		r := &record{
+			id:       newAllocationID(),
+			name:     "TestDuplicate",
+			required: ResourceSet{"cpu": 1},
+			charged:  ResourceSet{"cpu": 1},
+			enqueued: time.Now(),
+		}
+
+		item := &queueItem{
+			wait:     make(chan interface{}),
+			required: r.charged,
+			enqueued: r.enqueued,
+			record:   r,
+		}
+
+		items = append(items, item)
+
+		enqueue <- item
+	}
+
+	for _, item := range items {
+		select {
+		case <-item.wait:
+		case <-time.After(10 * time.Second):
+			t.Fatal("allocation was orphaned")
+		}
+
+		release <- item.record
+	}
+}
+
+// TestGrantInline is not parallel as it needs nothing else to be queued.
+func TestGrantInline(t *testing.T) {
+	if config.chaos {
+		t.Skip("tests are never granted inline in chaos mode")
+	}
+
+	r := &record{
+		id:       newAllocationID(),
+		name:     "TestGrantInline",
+		required: ResourceSet{"cpu": 1},
+		charged:  ResourceSet{"cpu": 1},
+		enqueued: time.Now(),
+	}
+
+	// Releases by earlier tests may still be in flight.
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !grantInline(r) {
+		cancelSubmit()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected an uncontended request to be granted inline")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	release <- r
+
+	huge := &record{
+		id:       newAllocationID(),
+		name:     "TestGrantInline",
+		required: ResourceSet{"cpu": 1 << 20},
+		charged:  ResourceSet{"cpu": 1 << 20},
+		enqueued: time.Now(),
+	}
+
+	if grantInline(huge) {
+		t.Fatal("expected a request that doesn't fit to be queued")
+	}
+
+	cancelSubmit()
+}
+
+// TestGrantInlineOrder is not parallel as it needs nothing else to be queued.
+func TestGrantInlineOrder(t *testing.T) {
+	if config.chaos {
+		t.Skip("tests are never granted inline in chaos mode")
+	}
+
+	first := &record{
+		id:       newAllocationID(),
+		name:     "TestGrantInlineOrder",
+		required: ResourceSet{"cpu": 1 << 20},
+		charged:  ResourceSet{"cpu": 1 << 20},
+		enqueued: time.Now(),
+	}
+
+	second := &record{
+		id:       newAllocationID(),
+		name:     "TestGrantInlineOrder",
+		required: ResourceSet{"cpu": 1},
+		charged:  ResourceSet{"cpu": 1},
+		enqueued: time.Now(),
+	}
+
+	// Wait for the scheduler to be idle, so the first test would be
+	// granted inline if it fit.
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !uncontended.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the scheduler to become uncontended")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if grantInline(first) {
+		t.Fatal("expected a request that doesn't fit to be queued")
+	}
+
+	// Until the scheduler has taken the first test onto the queue, nothing
+	// says it is contended but the submission, and under PolicyFIFO the
+	// second test must not be granted ahead of it.
+	if grantInline(second) {
+		release <- second
+
+		t.Fatal("expected a request made while another is being queued to be queued")
+	}
+
+	cancelSubmit()
+	cancelSubmit()
+
+	for !grantInline(second) {
+		cancelSubmit()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected an uncontended request to be granted inline")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	release <- second
+}
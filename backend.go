@@ -0,0 +1,177 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// LeaseDuration is how long a backend should hold an allocation without
+	// it being renewed, after which the resources may be reclaimed.
+	LeaseDuration = 30 * time.Second
+
+	// backendRenewInterval is how often leases are renewed, this allows a
+	// couple of failures before a lease expires.
+	backendRenewInterval = LeaseDuration / 3
+
+	// backendPollInterval is how often queued tests retry the backend when
+	// the shared pool is exhausted by other processes.
+	backendPollInterval = time.Second
+)
+
+var (
+	// ErrLeaseLost should be wrapped by errors returned from Backend.Renew
+	// when the allocation has been reclaimed, so the scheduler can try to
+	// recover it.
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// Backend holds pool accounting that is shared between processes, so that
+// multiple test binaries, possibly on different machines, respect a single
+// pool.  The resources passed to Start should describe the whole shared pool,
+// the in-process scheduler still bounds what this process may use, and the
+// backend is consulted before any test is granted its resources.
+//
+// The scheduler is written against this interface, accounting for its own
+// process with a MemoryBackend, so new coordination backends, including
+// private ones, can be added without any changes to scheduling.
+type Backend interface {
+	// Acquire atomically takes the resources from the shared pool for the
+	// allocation if they are all free, returning false if they are not.
+	Acquire(ctx context.Context, id string, required ResourceSet) (bool, error)
+
+	// Renew extends the allocation's lease.  Backends should reclaim resources
+	// whose leases have expired e.g. when a CI job was killed, and return an
+	// error wrapping ErrLeaseLost when renewing one.
+	Renew(ctx context.Context, id string) error
+
+	// Release returns the allocation's resources to the shared pool.
+	Release(ctx context.Context, id string) error
+}
+
+// Notifier may be implemented by a Backend that is told when resources become
+// free, for example by a streaming grant from a central scheduler, so queued
+// tests are retried immediately rather than on the next poll.
+type Notifier interface {
+	// Notify returns a channel that receives a value whenever an Acquire
+	// that previously failed may now succeed.
+	Notify() <-chan struct{}
+}
+
+// backendAcquire is called by the scheduler to acquire resources from the
+// shared pool, failures are reported and retried later.
+func backendAcquire(r *record) bool {
+	ok, err := config.backend.Acquire(context.Background(), r.id, r.charged)
+	if err != nil {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("backend acquire %s failed: %v", r.id, err),
+		})
+
+		return false
+	}
+
+	return ok
+}
+
+// backendRelease returns resources to the shared pool.
+func backendRelease(r *record) {
+	if err := config.backend.Release(context.Background(), r.id); err != nil {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("backend release %s failed: %v", r.id, err),
+		})
+	}
+}
+
+// renewLease renews an allocation's lease.  If the backend has reclaimed it,
+// for example because heartbeats were lost during a network partition, then
+// the resources are re-acquired if they are still free.  It returns whether the
+// lease was lost and could not be recovered.
+func renewLease(backend Backend, r *record) (bool, error) {
+	err := backend.Renew(context.Background(), r.id)
+	if err == nil || !errors.Is(err, ErrLeaseLost) {
+		return false, err
+	}
+
+	ok, acquireErr := backend.Acquire(context.Background(), r.id, r.charged)
+	if acquireErr != nil {
+		return true, acquireErr
+	}
+
+	if !ok {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// renewLeases periodically renews the leases of all held allocations.  Lost
+// leases are alerted once, as another process may now be using the resources.
+func renewLeases(stop <-chan struct{}) {
+	ticker := time.NewTicker(backendRenewInterval)
+	defer ticker.Stop()
+
+	lost := map[*record]bool{}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		recordsLock.Lock()
+
+		records := make([]*record, 0, len(held))
+
+		for r := range held {
+			records = append(records, r)
+		}
+
+		for r := range lost {
+			if _, ok := held[r]; !ok {
+				delete(lost, r)
+			}
+		}
+
+		recordsLock.Unlock()
+
+		for _, r := range records {
+			gone, err := renewLease(config.backend, r)
+
+			switch {
+			case gone && !lost[r]:
+				lost[r] = true
+
+				raise(Alert{
+					Kind:    AlertLeaseLost,
+					Message: fmt.Sprintf("test %s lost its lease on %v, the resources may be in use by another process: %v", r.name, r.required, err),
+				})
+			case err != nil && !gone:
+				emit(nil, event{
+					Action:  "warn",
+					Message: fmt.Sprintf("backend renew %s failed: %v", r.id, err),
+				})
+			}
+		}
+	}
+}
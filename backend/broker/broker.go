@@ -0,0 +1,233 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package broker defines a small REST protocol for a central resource broker,
+// so organizations can implement one in any language, and a client that allows
+// tests to share its pool e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   smtest.Start(resources, smtest.WithBackend(broker.NewClient("https://broker.example.com", "my-job")))
+//	   ...
+//	}
+//
+// All requests are POSTs with JSON bodies:
+//
+//   - /v1/acquire takes an AcquireRequest, and responds 200 OK if the resources
+//     were granted, or 409 Conflict if they are not all free.
+//   - /v1/renew takes a LeaseRequest and responds 200 OK if the lease was
+//     extended, or 404 Not Found if it has expired or doesn't exist.
+//   - /v1/release takes a LeaseRequest and responds 200 OK, releasing an unknown
+//     lease is not an error.
+//
+// Errors may have an ErrorResponse body.  Leases not renewed within their TTL
+// should be reclaimed by the broker.  A reference implementation is provided
+// by NewHandler.
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// PathAcquire is where acquire requests are sent.
+	PathAcquire = "/v1/acquire"
+
+	// PathRenew is where renew requests are sent.
+	PathRenew = "/v1/renew"
+
+	// PathRelease is where release requests are sent.
+	PathRelease = "/v1/release"
+)
+
+var (
+	// ErrLeaseNotFound is returned when renewing a lease that has expired.
+	ErrLeaseNotFound = fmt.Errorf("%w: not found", smtest.ErrLeaseLost)
+)
+
+// AcquireRequest asks the broker for resources.
+type AcquireRequest struct {
+	// ID uniquely identifies the allocation.
+	ID string `json:"id"`
+
+	// Owner identifies who is making the request e.g. a CI job.
+	Owner string `json:"owner"`
+
+	// Resources are the resources required.
+	Resources smtest.ResourceSet `json:"resources"`
+
+	// TTLSeconds is how long the lease lasts without being renewed.
+	TTLSeconds float64 `json:"ttlSeconds"`
+}
+
+// LeaseRequest refers to an existing allocation.
+type LeaseRequest struct {
+	// ID uniquely identifies the allocation.
+	ID string `json:"id"`
+
+	// Owner identifies who made the allocation.
+	Owner string `json:"owner"`
+
+	// TTLSeconds is how long the lease is extended by when renewing.
+	TTLSeconds float64 `json:"ttlSeconds,omitempty"`
+}
+
+// ErrorResponse describes why a request failed.
+type ErrorResponse struct {
+	// Message is a human readable error.
+	Message string `json:"message"`
+}
+
+// Client talks to a broker, and implements smtest.Backend.
+type Client struct {
+	// url is the broker's base URL.
+	url string
+
+	// owner identifies who is making requests.
+	owner string
+
+	// client is the HTTP client.
+	client *http.Client
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Backend = &Client{}
+
+// ClientOption modifies the default behaviour of the client.
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the HTTP client, for example to add authentication or
+// connect over a unix socket.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// NewClient returns a client for the broker at the URL, owner identifies
+// who holds leases, typically the CI job name.
+func NewClient(url, owner string, opts ...ClientOption) *Client {
+	c := &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		owner:  owner,
+		client: http.DefaultClient,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// post sends a request and returns the status code.
+func (c *Client) post(ctx context.Context, path string, body any) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusConflict, http.StatusNotFound:
+		return response.StatusCode, nil
+	}
+
+	var e ErrorResponse
+
+	if err := json.NewDecoder(response.Body).Decode(&e); err == nil && e.Message != "" {
+		return 0, fmt.Errorf("broker %s: %s: %s", path, response.Status, e.Message)
+	}
+
+	return 0, fmt.Errorf("broker %s: unexpected status %s", path, response.Status)
+}
+
+// Acquire asks the broker for the resources.
+func (c *Client) Acquire(ctx context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	request := &AcquireRequest{
+		ID:         id,
+		Owner:      c.owner,
+		Resources:  required,
+		TTLSeconds: smtest.LeaseDuration.Seconds(),
+	}
+
+	status, err := c.post(ctx, PathAcquire, request)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("broker %s: unexpected status %d", PathAcquire, status)
+}
+
+// Renew extends the lease.
+func (c *Client) Renew(ctx context.Context, id string) error {
+	request := &LeaseRequest{
+		ID:         id,
+		Owner:      c.owner,
+		TTLSeconds: smtest.LeaseDuration.Seconds(),
+	}
+
+	status, err := c.post(ctx, PathRenew, request)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrLeaseNotFound, id)
+	}
+
+	return nil
+}
+
+// Release returns the resources to the broker.
+func (c *Client) Release(ctx context.Context, id string) error {
+	request := &LeaseRequest{
+		ID:    id,
+		Owner: c.owner,
+	}
+
+	if _, err := c.post(ctx, PathRelease, request); err != nil {
+		return err
+	}
+
+	return nil
+}
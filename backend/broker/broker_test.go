@@ -0,0 +1,63 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestBroker(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	server := httptest.NewServer(NewHandler(smtest.ResourceSet{"cpu": 8}))
+	defer server.Close()
+
+	first := NewClient(server.URL, "first")
+	second := NewClient(server.URL, "second")
+
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := first.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the owner can manipulate a lease.
+	if err := second.Renew(ctx, "a"); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("expected lease not found, got %v", err)
+	}
+
+	if err := first.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
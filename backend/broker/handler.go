@@ -0,0 +1,190 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// lease is a set of resources granted to an owner.
+type lease struct {
+	// owner is who holds the lease.
+	owner string
+
+	// resources are the resources held.
+	resources smtest.ResourceSet
+
+	// expires is when the lease may be reclaimed.
+	expires time.Time
+}
+
+// handler is an in-memory broker.
+type handler struct {
+	// lock protects the pool.
+	lock sync.Mutex
+
+	// free are the resources that are not leased.
+	free smtest.ResourceSet
+
+	// leases are the current leases keyed by ID.
+	leases map[string]*lease
+
+	// now returns the current time, this allows tests to control time.
+	now func() time.Time
+}
+
+// NewHandler returns a reference broker that holds the pool in memory, suitable
+// for running as a standalone service shared by CI jobs.
+func NewHandler(resources smtest.ResourceSet) http.Handler {
+	h := &handler{
+		free:   smtest.ResourceSet{},
+		leases: map[string]*lease{},
+		now:    time.Now,
+	}
+
+	for k, v := range resources {
+		h.free[k] = v
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(PathAcquire, h.acquire)
+	mux.HandleFunc(PathRenew, h.renew)
+	mux.HandleFunc(PathRelease, h.release)
+
+	return mux
+}
+
+// reclaim returns the resources of expired leases to the pool, this must be
+// called with the lock held.
+func (h *handler) reclaim() {
+	now := h.now()
+
+	for id, l := range h.leases {
+		if now.After(l.expires) {
+			h.releaseLease(id, l)
+		}
+	}
+}
+
+// releaseLease returns a lease's resources to the pool.
+func (h *handler) releaseLease(id string, l *lease) {
+	for k, v := range l.resources {
+		h.free[k] += v
+	}
+
+	delete(h.leases, id)
+}
+
+// fail writes an error response.
+func fail(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(&ErrorResponse{Message: message})
+}
+
+// decode reads a JSON request body.
+func decode(w http.ResponseWriter, r *http.Request, body any) bool {
+	if r.Method != http.MethodPost {
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (h *handler) acquire(w http.ResponseWriter, r *http.Request) {
+	var request AcquireRequest
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.reclaim()
+
+	// Acquiring is idempotent so clients can safely retry.
+	if _, ok := h.leases[request.ID]; ok {
+		return
+	}
+
+	for k, v := range request.Resources {
+		if h.free[k] < v {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+
+	for k, v := range request.Resources {
+		h.free[k] -= v
+	}
+
+	h.leases[request.ID] = &lease{
+		owner:     request.Owner,
+		resources: request.Resources,
+		expires:   h.now().Add(time.Duration(request.TTLSeconds * float64(time.Second))),
+	}
+}
+
+func (h *handler) renew(w http.ResponseWriter, r *http.Request) {
+	var request LeaseRequest
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.reclaim()
+
+	l, ok := h.leases[request.ID]
+	if !ok || l.owner != request.Owner {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	l.expires = h.now().Add(time.Duration(request.TTLSeconds * float64(time.Second)))
+}
+
+func (h *handler) release(w http.ResponseWriter, r *http.Request) {
+	var request LeaseRequest
+
+	if !decode(w, r, &request) {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if l, ok := h.leases[request.ID]; ok && l.owner == request.Owner {
+		h.releaseLease(request.ID, l)
+	}
+}
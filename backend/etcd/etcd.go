@@ -0,0 +1,246 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package etcd shares a pool between test binaries via etcd, for teams that
+// need strong consistency e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client, err := clientv3.New(clientv3.Config{Endpoints: []string{"etcd:2379"}})
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   backend, err := smtestetcd.New(context.Background(), client, resources)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithBackend(backend))
+//	   ...
+//	}
+//
+// Every allocation is a key attached to an etcd lease, so when a test runner
+// dies its allocations expire with the lease and the resources are reclaimed
+// automatically.  Free resources are never stored, they are the pool capacity
+// minus all live allocations, which are checked and created in a single
+// transaction.
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// defaultPrefix is the default key prefix.
+	defaultPrefix = "/smtest"
+)
+
+// Backend keeps allocations in etcd.
+type Backend struct {
+	// client is the etcd client.
+	client *clientv3.Client
+
+	// prefix is prepended to all keys.
+	prefix string
+
+	// capacity is the size of the shared pool.
+	capacity smtest.ResourceSet
+
+	// lock protects leases.
+	lock sync.Mutex
+
+	// leases maps allocation IDs to their etcd leases.
+	leases map[string]clientv3.LeaseID
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Backend = &Backend{}
+
+// Option modifies the default behaviour of the backend.
+type Option func(*Backend)
+
+// WithPrefix sets the key prefix, allowing multiple pools to share a cluster.
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// capacityKey is where the pool capacity is stored.
+func (b *Backend) capacityKey() string {
+	return b.prefix + "/capacity"
+}
+
+// allocationPrefix is the prefix of all allocation keys.
+func (b *Backend) allocationPrefix() string {
+	return b.prefix + "/allocations/"
+}
+
+// New returns a backend.  The first process to use the pool sets its capacity
+// to the resources, subsequent processes use the stored capacity.
+func New(ctx context.Context, client *clientv3.Client, resources smtest.ResourceSet, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		client: client,
+		prefix: defaultPrefix,
+		leases: map[string]clientv3.LeaseID{},
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	key := b.capacityKey()
+
+	response, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, resources.String())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	b.capacity = resources
+
+	if !response.Succeeded {
+		kvs := response.Responses[0].GetResponseRange().Kvs
+		if len(kvs) != 1 {
+			return nil, fmt.Errorf("etcd: pool capacity missing")
+		}
+
+		if b.capacity, err = smtest.ParseResourceSet(string(kvs[0].Value)); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// Acquire takes the resources from the pool if they are all free.  Usage is
+// calculated from all live allocations, and the new allocation is only created
+// if none have been created since, otherwise this is retried.
+func (b *Backend) Acquire(ctx context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	key := b.allocationPrefix() + id
+
+	for {
+		response, err := b.client.Get(ctx, b.allocationPrefix(), clientv3.WithPrefix())
+		if err != nil {
+			return false, err
+		}
+
+		used := smtest.ResourceSet{}
+
+		for _, kv := range response.Kvs {
+			// Acquiring is idempotent so callers can safely retry.
+			if string(kv.Key) == key {
+				return true, nil
+			}
+
+			resources, err := smtest.ParseResourceSet(string(kv.Value))
+			if err != nil {
+				return false, err
+			}
+
+			for k, v := range resources {
+				used[k] += v
+			}
+		}
+
+		for k, v := range required {
+			if b.capacity[k]-used[k] < v {
+				return false, nil
+			}
+		}
+
+		lease, err := b.client.Grant(ctx, int64(smtest.LeaseDuration.Seconds()))
+		if err != nil {
+			return false, err
+		}
+
+		txn, err := b.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(b.allocationPrefix()).WithPrefix(), "<", response.Header.Revision+1)).
+			Then(clientv3.OpPut(key, required.String(), clientv3.WithLease(lease.ID))).
+			Commit()
+		if err != nil {
+			return false, err
+		}
+
+		if txn.Succeeded {
+			b.lock.Lock()
+			b.leases[id] = lease.ID
+			b.lock.Unlock()
+
+			return true, nil
+		}
+
+		// Someone else allocated in the meantime, try again.
+		if _, err := b.client.Revoke(ctx, lease.ID); err != nil {
+			return false, err
+		}
+	}
+}
+
+// lease returns the etcd lease for an allocation.
+func (b *Backend) lease(id string) (clientv3.LeaseID, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	lease, ok := b.leases[id]
+
+	return lease, ok
+}
+
+// Renew keeps the allocation's lease alive.
+func (b *Backend) Renew(ctx context.Context, id string) error {
+	lease, ok := b.lease(id)
+	if !ok {
+		return fmt.Errorf("etcd: unknown allocation %s", id)
+	}
+
+	if _, err := b.client.KeepAliveOnce(ctx, lease); err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return fmt.Errorf("%w: %s", smtest.ErrLeaseLost, id)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Release revokes the allocation's lease, deleting it.
+func (b *Backend) Release(ctx context.Context, id string) error {
+	lease, ok := b.lease(id)
+	if !ok {
+		return nil
+	}
+
+	b.lock.Lock()
+	delete(b.leases, id)
+	b.lock.Unlock()
+
+	_, err := b.client.Revoke(ctx, lease)
+
+	return err
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd runs an embedded etcd server for the duration of the test.
+func startEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+
+	config := embed.NewConfig()
+	config.Dir = t.TempDir()
+	config.LogLevel = "error"
+
+	peer, _ := url.Parse("http://127.0.0.1:0")
+	client, _ := url.Parse("http://127.0.0.1:0")
+
+	config.ListenPeerUrls = []url.URL{*peer}
+	config.ListenClientUrls = []url.URL{*client}
+
+	server, err := embed.StartEtcd(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd failed to start")
+	}
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{server.Clients[0].Addr().String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	return c
+}
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	client := startEtcd(t)
+
+	first, err := New(ctx, client, smtest.ResourceSet{"cpu": 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second process gets the capacity defined by the first.
+	second, err := New(ctx, client, smtest.ResourceSet{"cpu": 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := first.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+	// The lease is reclaimed e.g. after missed heartbeats.
+	if _, err := client.Revoke(ctx, second.leases["b"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Renew(ctx, "b"); !errors.Is(err, smtest.ErrLeaseLost) {
+		t.Fatalf("expected lease lost, got %v", err)
+	}
+}
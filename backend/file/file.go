@@ -0,0 +1,267 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package file shares a pool between test binaries on the same machine, for
+// example the concurrent package binaries run by go test ./..., without any
+// server e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   backend, err := file.New(resources)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithBackend(backend))
+//	   ...
+//	}
+//
+// The pool is held in a state file guarded by an advisory lock.  Allocations
+// are reclaimed when their lease expires or the process that made them exits.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// stateFile holds the pool state.
+	stateFile = "state.json"
+
+	// lockFile serializes access to the state file.
+	lockFile = "state.lock"
+)
+
+// lease is an allocation held by a process.
+type lease struct {
+	// PID is the process that holds the lease.
+	PID int `json:"pid"`
+
+	// Resources are the resources held.
+	Resources smtest.ResourceSet `json:"resources"`
+
+	// Expires is when the lease may be reclaimed.
+	Expires time.Time `json:"expires"`
+}
+
+// state is the shared pool.
+type state struct {
+	// Capacity is the size of the pool.
+	Capacity smtest.ResourceSet `json:"capacity"`
+
+	// Leases are the current allocations keyed by ID.
+	Leases map[string]*lease `json:"leases"`
+}
+
+// Backend keeps the pool in a file.
+type Backend struct {
+	// dir contains the state and lock files.
+	dir string
+
+	// capacity is used to initialize the pool.
+	capacity smtest.ResourceSet
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Backend = &Backend{}
+
+// Option modifies the default behaviour of the backend.
+type Option func(*Backend)
+
+// WithDirectory sets where state is kept, the default is a directory in the
+// system temporary directory that is unique to the user.  Processes sharing
+// the pool must use the same directory.
+func WithDirectory(dir string) Option {
+	return func(b *Backend) {
+		b.dir = dir
+	}
+}
+
+// New returns a backend.  The capacity of the pool is set to the resources
+// whenever nothing holds an allocation, so a changed pool takes effect once it
+// is idle, until then processes use the stored capacity.
+func New(resources smtest.ResourceSet, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		dir:      filepath.Join(os.TempDir(), fmt.Sprintf("smtest-%d", os.Getuid())),
+		capacity: resources,
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// update reads the pool state under the lock, reclaims any dead allocations,
+// re-seeds the capacity if the pool is idle, and writes back the state if any
+// of these, or the callback, modified it.
+func (b *Backend) update(callback func(s *state) (bool, error)) error {
+	f, err := os.OpenFile(filepath.Join(b.dir, lockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := lock(f); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = unlock(f)
+	}()
+
+	path := filepath.Join(b.dir, stateFile)
+
+	// The capacity is seeded below, rather than here, as decoding into it
+	// would overwrite the caller's resources with the stored capacity.
+	s := &state{
+		Leases: map[string]*lease{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+
+	reclaimed := false
+
+	for id, l := range s.Leases {
+		if now.After(l.Expires) || !alive(l.PID) {
+			delete(s.Leases, id)
+
+			reclaimed = true
+		}
+	}
+
+	// The stored capacity may be from a run with a different pool, which is
+	// only safe to replace when nothing is allocated from it.
+	if len(s.Leases) == 0 && !maps.Equal(s.Capacity, b.capacity) {
+		s.Capacity = b.capacity
+
+		reclaimed = true
+	}
+
+	modified, err := callback(s)
+	if err != nil {
+		return err
+	}
+
+	if !modified && !reclaimed {
+		return nil
+	}
+
+	if data, err = json.Marshal(s); err != nil {
+		return err
+	}
+
+	// Write atomically so a crash can't corrupt the state.
+	temp := path + ".tmp"
+
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// Acquire takes the resources from the pool if they are all free.
+func (b *Backend) Acquire(_ context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	var granted bool
+
+	err := b.update(func(s *state) (bool, error) {
+		// Acquiring is idempotent so callers can safely retry.
+		if _, ok := s.Leases[id]; ok {
+			granted = true
+			return false, nil
+		}
+
+		used := smtest.ResourceSet{}
+
+		for _, l := range s.Leases {
+			for k, v := range l.Resources {
+				used[k] += v
+			}
+		}
+
+		for k, v := range required {
+			if s.Capacity[k]-used[k] < v {
+				return false, nil
+			}
+		}
+
+		s.Leases[id] = &lease{
+			PID:       os.Getpid(),
+			Resources: required,
+			Expires:   time.Now().Add(smtest.LeaseDuration),
+		}
+
+		granted = true
+
+		return true, nil
+	})
+
+	return granted, err
+}
+
+// Renew extends the allocation's lease.
+func (b *Backend) Renew(_ context.Context, id string) error {
+	return b.update(func(s *state) (bool, error) {
+		l, ok := s.Leases[id]
+		if !ok {
+			return false, fmt.Errorf("%w: %s has expired", smtest.ErrLeaseLost, id)
+		}
+
+		l.Expires = time.Now().Add(smtest.LeaseDuration)
+
+		return true, nil
+	})
+}
+
+// Release returns the allocation's resources to the pool.
+func (b *Backend) Release(_ context.Context, id string) error {
+	return b.update(func(s *state) (bool, error) {
+		if _, ok := s.Leases[id]; !ok {
+			return false, nil
+		}
+
+		delete(s.Leases, id)
+
+		return true, nil
+	})
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	first, err := New(smtest.ResourceSet{"cpu": 8}, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := New(smtest.ResourceSet{"cpu": 8}, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := first.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
+
+func TestReclaim(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	b, err := New(smtest.ResourceSet{"cpu": 8}, WithDirectory(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate allocations by a process that has died, and one that has
+	// stopped renewing its lease.
+	if err := b.update(func(s *state) (bool, error) {
+		s.Leases["dead"] = &lease{PID: 1 << 30, Resources: smtest.ResourceSet{"cpu": 4}, Expires: time.Now().Add(time.Hour)}
+		s.Leases["expired"] = &lease{PID: 1, Resources: smtest.ResourceSet{"cpu": 4}, Expires: time.Now().Add(-time.Second)}
+
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := b.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 8}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dir := t.TempDir()
+
+	small, err := New(smtest.ResourceSet{"cpu": 4}, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large, err := New(smtest.ResourceSet{"cpu": 8}, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := small.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 2}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	// The stored capacity is kept while allocations are held.
+	if ok, err := large.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := small.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once idle the pool takes the new capacity.
+	if ok, err := large.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 8}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lock takes an exclusive advisory lock on the file, blocking until it is
+// available.  The lock is dropped by the kernel if the process dies.
+func lock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlock releases the advisory lock.
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// alive returns whether the process exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	// EPERM means it exists, but is owned by someone else.
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"context"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// agentInterval is how often agents check their health and renew their
+	// registration, this allows a couple of failures before it expires.
+	agentInterval = smtest.LeaseDuration / 3
+
+	// deregisterTimeout bounds how long a stopping agent waits to remove
+	// itself from the pool.
+	deregisterTimeout = 5 * time.Second
+)
+
+// HealthCheck returns an error if the machine should not be scheduled on e.g.
+// a GPU has fallen off the bus.
+type HealthCheck func(ctx context.Context) error
+
+// Agent contributes a machine's capacity to the central pool while it is
+// healthy.
+type Agent struct {
+	// conn is the connection to the server.
+	conn grpc.ClientConnInterface
+
+	// name uniquely identifies the agent.
+	name string
+
+	// resources are what the agent contributes.
+	resources smtest.ResourceSet
+
+	// checks must all pass for the capacity to be contributed.
+	checks []HealthCheck
+}
+
+// AgentOption is passed to NewAgent to modify the default behaviour.
+type AgentOption func(*Agent)
+
+// WithHealthCheck adds a check that must pass for the agent's capacity to be
+// part of the pool.  Checks are run before every registration.
+func WithHealthCheck(check HealthCheck) AgentOption {
+	return func(a *Agent) {
+		a.checks = append(a.checks, check)
+	}
+}
+
+// NewAgent returns an agent that contributes the resources, typically read
+// with a discovery package e.g. local.Capacity, to the pool under the name,
+// typically the host name.
+func NewAgent(conn grpc.ClientConnInterface, name string, resources smtest.ResourceSet, opts ...AgentOption) *Agent {
+	a := &Agent{
+		conn:      conn,
+		name:      name,
+		resources: resources,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// healthy runs all health checks.
+func (a *Agent) healthy(ctx context.Context) bool {
+	for _, check := range a.checks {
+		if err := check(ctx); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// register sends the agent's current contribution.
+func (a *Agent) register(ctx context.Context, resources smtest.ResourceSet) error {
+	request := &AgentRequest{
+		Name:       a.name,
+		Resources:  resources,
+		TTLSeconds: smtest.LeaseDuration.Seconds(),
+	}
+
+	return a.conn.Invoke(ctx, MethodRegister, request, &Empty{}, grpc.CallContentSubtype(codecName))
+}
+
+// Run registers the agent, then periodically checks its health and renews the
+// registration until the context is cancelled, when the agent removes itself
+// from the pool.  Unhealthy agents contribute nothing until they recover.  Only
+// the first registration's error is returned, subsequent failures are retried
+// so the agent survives server restarts.
+func (a *Agent) Run(ctx context.Context) error {
+	update := func() error {
+		resources := a.resources
+
+		if !a.healthy(ctx) {
+			resources = nil
+		}
+
+		return a.register(ctx, resources)
+	}
+
+	if err := update(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(agentInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+			defer cancel()
+
+			return a.register(ctx, nil)
+		case <-ticker.C:
+			_ = update()
+		}
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// heartbeatInterval is how often unclaimed grants are renewed, this
+	// allows a couple of failures before a lease expires.
+	heartbeatInterval = smtest.LeaseDuration / 3
+)
+
+// wait is a queued allocation.
+type wait struct {
+	// cancel closes the stream, or stops heartbeats once granted.
+	cancel context.CancelFunc
+
+	// granted is set when the server has granted the resources.
+	granted bool
+
+	// position is the last reported position in the server's queue.
+	position int
+
+	// err is set if the stream failed.
+	err error
+}
+
+// Client queues allocations with the central scheduler, and implements
+// smtest.Backend, smtest.Notifier and smtest.Positioner.
+type Client struct {
+	// conn is the connection to the server.
+	conn grpc.ClientConnInterface
+
+	// owner identifies who is making requests.
+	owner string
+
+	// lock protects waits.
+	lock sync.Mutex
+
+	// waits are the allocations with open streams, keyed by ID.
+	waits map[string]*wait
+
+	// notify is signalled when a stream completes.
+	notify chan struct{}
+}
+
+// Ensure the interfaces are implemented.
+var (
+	_ smtest.Backend    = &Client{}
+	_ smtest.Notifier   = &Client{}
+	_ smtest.Positioner = &Client{}
+)
+
+// NewClient returns a client that uses the connection, owner identifies who
+// holds leases, typically the CI job name.
+func NewClient(conn grpc.ClientConnInterface, owner string) *Client {
+	return &Client{
+		conn:   conn,
+		owner:  owner,
+		waits:  map[string]*wait{},
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Acquire queues the allocation with the server the first time it's called,
+// and reports whether the resources have been granted on subsequent calls.
+func (c *Client) Acquire(_ context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	w, ok := c.waits[id]
+	if !ok {
+		c.wait(id, required)
+
+		return false, nil
+	}
+
+	switch {
+	case w.err != nil:
+		delete(c.waits, id)
+
+		return false, w.err
+	case w.granted:
+		w.cancel()
+		delete(c.waits, id)
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// wait opens a stream to the server in the background, the scheduler is
+// notified when it is granted or fails.  This must be called with the lock
+// held.
+func (c *Client) wait(id string, required smtest.ResourceSet) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := &wait{
+		cancel: cancel,
+	}
+
+	c.waits[id] = w
+
+	request := &broker.AcquireRequest{
+		ID:         id,
+		Owner:      c.owner,
+		Resources:  required,
+		TTLSeconds: smtest.LeaseDuration.Seconds(),
+	}
+
+	go func() {
+		defer cancel()
+
+		err := c.stream(ctx, request, w)
+
+		c.lock.Lock()
+		w.granted = err == nil
+		w.err = err
+		c.lock.Unlock()
+
+		select {
+		case c.notify <- struct{}{}:
+		default:
+		}
+
+		if err == nil {
+			c.heartbeat(ctx, request.ID)
+		}
+	}()
+}
+
+// heartbeat keeps a granted lease alive until the scheduler claims it, which
+// may take a while if it's waiting for resources in this process.
+func (c *Client) heartbeat(ctx context.Context, id string) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures will be retried on the next tick, and the lease
+			// only expires after several have been missed.
+			_ = c.Renew(ctx, id)
+		}
+	}
+}
+
+// stream queues the allocation, recording its position in the queue, and waits
+// for the grant.
+func (c *Client) stream(ctx context.Context, request *broker.AcquireRequest, w *wait) error {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], MethodAcquire, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(request); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var grant Grant
+
+		if err := stream.RecvMsg(&grant); err != nil {
+			return fmt.Errorf("acquire %s: %w", request.ID, err)
+		}
+
+		if grant.Position == 0 {
+			return nil
+		}
+
+		c.lock.Lock()
+		w.position = grant.Position
+		c.lock.Unlock()
+	}
+}
+
+// Position implements smtest.Positioner.
+func (c *Client) Position(id string) (int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	w, ok := c.waits[id]
+	if !ok || w.granted || w.position == 0 {
+		return 0, false
+	}
+
+	return w.position, true
+}
+
+// Notify implements smtest.Notifier.
+func (c *Client) Notify() <-chan struct{} {
+	return c.notify
+}
+
+// Renew sends a heartbeat that extends the lease.
+func (c *Client) Renew(ctx context.Context, id string) error {
+	request := &broker.LeaseRequest{
+		ID:         id,
+		Owner:      c.owner,
+		TTLSeconds: smtest.LeaseDuration.Seconds(),
+	}
+
+	if err := c.conn.Invoke(ctx, MethodRenew, request, &Empty{}, grpc.CallContentSubtype(codecName)); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s", broker.ErrLeaseNotFound, id)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Release returns the resources to the server, or leaves the queue if they
+// have not been granted yet.
+func (c *Client) Release(ctx context.Context, id string) error {
+	c.lock.Lock()
+
+	if w, ok := c.waits[id]; ok {
+		w.cancel()
+		delete(c.waits, id)
+	}
+
+	c.lock.Unlock()
+
+	request := &broker.LeaseRequest{
+		ID:    id,
+		Owner: c.owner,
+	}
+
+	if err := c.conn.Invoke(ctx, MethodRelease, request, &Empty{}, grpc.CallContentSubtype(codecName)); err != nil {
+		return err
+	}
+
+	return nil
+}
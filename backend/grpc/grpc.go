@@ -0,0 +1,218 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grpc provides a central scheduler service, so many CI jobs across
+// machines queue against one shared pool, and a client that allows tests to
+// use it e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   conn, err := grpc.NewClient("smtest-server:7070", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithBackend(smtestgrpc.NewClient(conn, "my-job")))
+//	   ...
+//	}
+//
+// Unlike a broker that is polled, queued allocations hold a stream open to
+// the server, which grants them in order as soon as resources are free.  Held
+// allocations are kept alive with heartbeats and reclaimed when they stop.
+//
+// The pool may be grown by agents that contribute the capacity of the machine
+// they run on, see NewAgent, federating lab machines into one pool.
+//
+// Messages are the broker package's JSON types, carried with the "json" content
+// subtype, so no code generation is required and clients in other languages
+// only need an equivalent codec.  The server is provided by cmd/smtest-server.
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// ServiceName is the fully qualified gRPC service name.
+	ServiceName = "smtest.v1.Scheduler"
+
+	// MethodAcquire queues an allocation, the server streams a Grant as its
+	// position in the queue changes, and when the resources are leased to it.
+	MethodAcquire = "/" + ServiceName + "/Acquire"
+
+	// MethodRenew extends a lease.
+	MethodRenew = "/" + ServiceName + "/Renew"
+
+	// MethodRelease returns a lease's resources to the pool.
+	MethodRelease = "/" + ServiceName + "/Release"
+
+	// MethodRegister adds, or updates, an agent's contribution to the pool.
+	MethodRegister = "/" + ServiceName + "/Register"
+
+	// codecName is the content subtype messages are encoded with.
+	codecName = "json"
+)
+
+// Grant is streamed to a queued allocation whenever its position in the queue
+// changes, and once it holds its resources.
+type Grant struct {
+	// ID uniquely identifies the allocation.
+	ID string `json:"id"`
+
+	// Position is the allocation's place in the queue, starting at 1, it is
+	// not set once the resources are granted.
+	Position int `json:"position,omitempty"`
+}
+
+// AgentRequest registers a machine's capacity with the pool, and must be
+// repeated before the TTL expires or the capacity is removed.
+type AgentRequest struct {
+	// Name uniquely identifies the agent e.g. a host name.
+	Name string `json:"name"`
+
+	// Resources are what the agent contributes to the pool, empty if it is
+	// unhealthy or leaving.
+	Resources smtest.ResourceSet `json:"resources,omitempty"`
+
+	// TTLSeconds is how long the registration lasts.
+	TTLSeconds float64 `json:"ttlSeconds"`
+}
+
+// Empty is the response to requests that return nothing.
+type Empty struct{}
+
+// codec encodes messages as JSON.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return codecName
+}
+
+// init registers the codec, so the server decodes requests with it.
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// scheduler is implemented by the server.
+type scheduler interface {
+	acquire(request *broker.AcquireRequest, stream grpc.ServerStream) error
+	renew(request *broker.LeaseRequest) error
+	release(request *broker.LeaseRequest)
+	register(request *AgentRequest)
+}
+
+// intercept calls the handler with the request, via the server's interceptor
+// if it has one, as generated code does, so authentication, logging and metrics
+// apply to unary methods.  Stream interceptors are applied by the server.
+func intercept(ctx context.Context, srv any, method string, request any, interceptor grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) (any, error) {
+	if interceptor == nil {
+		return handler(ctx, request)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: method,
+	}
+
+	return interceptor(ctx, request, info, handler)
+}
+
+// serviceDesc describes the service for registration with a gRPC server.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*scheduler)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Renew",
+			Handler: func(srv any, ctx context.Context, decode func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				var request broker.LeaseRequest
+
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+
+				return intercept(ctx, srv, MethodRenew, &request, interceptor, func(_ context.Context, request any) (any, error) {
+					if err := srv.(scheduler).renew(request.(*broker.LeaseRequest)); err != nil {
+						return nil, err
+					}
+
+					return &Empty{}, nil
+				})
+			},
+		},
+		{
+			MethodName: "Release",
+			Handler: func(srv any, ctx context.Context, decode func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				var request broker.LeaseRequest
+
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+
+				return intercept(ctx, srv, MethodRelease, &request, interceptor, func(_ context.Context, request any) (any, error) {
+					srv.(scheduler).release(request.(*broker.LeaseRequest))
+
+					return &Empty{}, nil
+				})
+			},
+		},
+		{
+			MethodName: "Register",
+			Handler: func(srv any, ctx context.Context, decode func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				var request AgentRequest
+
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+
+				return intercept(ctx, srv, MethodRegister, &request, interceptor, func(_ context.Context, request any) (any, error) {
+					srv.(scheduler).register(request.(*AgentRequest))
+
+					return &Empty{}, nil
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Acquire",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				var request broker.AcquireRequest
+
+				if err := stream.RecvMsg(&request); err != nil {
+					return err
+				}
+
+				return srv.(scheduler).acquire(&request, stream)
+			},
+		},
+	},
+}
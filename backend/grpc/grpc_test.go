@@ -0,0 +1,496 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve starts the server in memory and returns a connection to it.
+func serve(t *testing.T, s *Server, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer(opts...)
+	s.Register(server)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}
+
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return conn
+}
+
+// granted waits for a notification then checks the allocation was granted.
+func granted(t *testing.T, c *Client, id string, required smtest.ResourceSet) {
+	t.Helper()
+
+	select {
+	case <-c.Notify():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", id)
+	}
+
+	ok, err := c.Acquire(context.Background(), id, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatalf("expected %s to be granted", id)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	conn := serve(t, NewServer(smtest.ResourceSet{"cpu": 8}))
+
+	first := NewClient(conn, "first")
+	second := NewClient(conn, "second")
+
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || ok {
+		t.Fatalf("expected acquire to be queued: %v", err)
+	}
+
+	granted(t, first, "a", smtest.ResourceSet{"cpu": 6})
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be queued: %v", err)
+	}
+
+	select {
+	case <-second.Notify():
+		t.Fatal("unexpected grant while resources are held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The queued allocation is told where it is in the queue.
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if position, ok := second.Position("b"); ok {
+			if position != 1 {
+				t.Fatalf("expected to be first in the queue, got %d", position)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for queue position")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := first.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the owner can manipulate a lease.
+	if err := second.Renew(ctx, "a"); !errors.Is(err, broker.ErrLeaseNotFound) {
+		t.Fatalf("expected lease not found, got %v", err)
+	}
+
+	if err := first.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, second, "b", smtest.ResourceSet{"cpu": 4})
+
+	if err := second.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	s := NewServer(smtest.ResourceSet{"cpu": 8})
+
+	conn := serve(t, s)
+
+	holder := NewClient(conn, "holder")
+
+	if _, err := holder.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, holder, "a", smtest.ResourceSet{"cpu": 8})
+
+	// The client retries, for example after restarting, while its first
+	// attempt is still queued.
+	first := NewClient(conn, "waiter")
+	retry := NewClient(conn, "waiter")
+
+	for _, c := range []*Client{first, retry} {
+		if ok, err := c.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+			t.Fatalf("expected acquire to be queued: %v", err)
+		}
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if position, ok := retry.Position("b"); ok {
+			if position != 1 {
+				t.Fatalf("expected the retry to share the first attempt's place, got %d", position)
+			}
+
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for queue position")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.lock.Lock()
+	queued := len(s.queue)
+	s.lock.Unlock()
+
+	if queued != 1 {
+		t.Fatalf("expected the retry to be queued once, got %d", queued)
+	}
+
+	if err := holder.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, first, "b", smtest.ResourceSet{"cpu": 4})
+	granted(t, retry, "b", smtest.ResourceSet{"cpu": 4})
+
+	if err := retry.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Granted once, so releasing it returns everything to the pool.
+	s.lock.Lock()
+	free := s.free["cpu"]
+	s.lock.Unlock()
+
+	if free != 8 {
+		t.Fatalf("expected the whole pool to be free, got %d", free)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var lock sync.Mutex
+
+	called := map[string]bool{}
+
+	unary := func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		lock.Lock()
+		called[info.FullMethod] = true
+		lock.Unlock()
+
+		return handler(ctx, request)
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		lock.Lock()
+		called[info.FullMethod] = true
+		lock.Unlock()
+
+		return handler(srv, ss)
+	}
+
+	conn := serve(t, NewServer(smtest.ResourceSet{"cpu": 8}), grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+
+	c := NewClient(conn, "owner")
+
+	if _, err := c.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, c, "a", smtest.ResourceSet{"cpu": 1})
+
+	if err := c.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := NewAgent(conn, "lab-1", smtest.ResourceSet{"gpu": 1})
+
+	if err := agent.register(ctx, agent.resources); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, method := range []string{MethodAcquire, MethodRenew, MethodRelease, MethodRegister} {
+		if !called[method] {
+			t.Fatalf("expected %s to be intercepted", method)
+		}
+	}
+}
+
+func TestSchedulerTooLarge(t *testing.T) {
+	t.Parallel()
+
+	c := NewClient(serve(t, NewServer(smtest.ResourceSet{"cpu": 8})), "owner")
+
+	if _, err := c.Acquire(context.Background(), "a", smtest.ResourceSet{"cpu": 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-c.Notify()
+
+	if _, err := c.Acquire(context.Background(), "a", smtest.ResourceSet{"cpu": 16}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSchedulerReclaim(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Time is only read with the lock held.
+	now := time.Now()
+
+	s := NewServer(smtest.ResourceSet{"cpu": 8})
+	s.now = func() time.Time {
+		return now
+	}
+
+	conn := serve(t, s)
+
+	dead := NewClient(conn, "dead")
+	alive := NewClient(conn, "alive")
+
+	if _, err := dead.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, dead, "a", smtest.ResourceSet{"cpu": 8})
+
+	if _, err := alive.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dead client stops sending heartbeats...
+	s.lock.Lock()
+	now = now.Add(2 * smtest.LeaseDuration)
+	s.lock.Unlock()
+
+	granted(t, alive, "b", smtest.ResourceSet{"cpu": 8})
+
+	if err := dead.Renew(ctx, "a"); !errors.Is(err, broker.ErrLeaseNotFound) {
+		t.Fatalf("expected lease not found, got %v", err)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	s := NewServer(nil)
+
+	conn := serve(t, s)
+
+	var healthy atomic.Bool
+
+	healthy.Store(true)
+
+	check := func(context.Context) error {
+		if !healthy.Load() {
+			return errors.New("unhealthy")
+		}
+
+		return nil
+	}
+
+	agent := NewAgent(conn, "lab-1", smtest.ResourceSet{"gpu": 2}, WithHealthCheck(check))
+
+	if !agent.healthy(ctx) {
+		t.Fatal("expected agent to be healthy")
+	}
+
+	if err := agent.register(ctx, agent.resources); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(conn, "owner")
+
+	if _, err := c.Acquire(ctx, "a", smtest.ResourceSet{"gpu": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	granted(t, c, "a", smtest.ResourceSet{"gpu": 2})
+
+	if err := c.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	healthy.Store(false)
+
+	if agent.healthy(ctx) {
+		t.Fatal("expected agent to be unhealthy")
+	}
+
+	// Unhealthy agents contribute nothing, so the request can never be
+	// satisfied.
+	if err := agent.register(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Acquire(ctx, "b", smtest.ResourceSet{"gpu": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-c.Notify()
+
+	if _, err := c.Acquire(ctx, "b", smtest.ResourceSet{"gpu": 1}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAgentRun(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(nil)
+
+	conn := serve(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+
+	go func() {
+		done <- NewAgent(conn, "lab-1", smtest.ResourceSet{"cpu": 4}).Run(ctx)
+	}()
+
+	// Wait for registration...
+	for {
+		s.lock.Lock()
+		cpus := s.resources["cpu"]
+		s.lock.Unlock()
+
+		if cpus == 4 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.resources["cpu"] != 0 || len(s.agents) != 0 {
+		t.Fatalf("expected agent to be deregistered, got %v", s.resources)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	queue := func(s *Server, owners ...string) {
+		for i, owner := range owners {
+			s.queue = append(s.queue, &waiter{
+				request: &broker.AcquireRequest{
+					ID:        strconv.Itoa(i),
+					Owner:     owner,
+					Resources: smtest.ResourceSet{"cpu": 1},
+				},
+				granted: make(chan interface{}),
+			})
+		}
+	}
+
+	ids := func(waiters []*waiter) string {
+		var ids []string
+
+		for _, w := range waiters {
+			ids = append(ids, w.request.ID)
+		}
+
+		return strings.Join(ids, ",")
+	}
+
+	fifo := NewServer(smtest.ResourceSet{"cpu": 1})
+	queue(fifo, "big", "big", "big", "small")
+
+	if order := ids(fifo.order()); order != "0,1,2,3" {
+		t.Fatalf("expected arrival order, got %s", order)
+	}
+
+	fair := NewServer(smtest.ResourceSet{"cpu": 1}, WithPolicy(PolicyRoundRobin))
+	queue(fair, "big", "big", "big", "small")
+
+	if order := ids(fair.order()); order != "0,3,1,2" {
+		t.Fatalf("expected clients to take turns, got %s", order)
+	}
+
+	// Having just been granted, the big client goes to the back.
+	fair.lock.Lock()
+	fair.schedule()
+	fair.lock.Unlock()
+
+	if order := ids(fair.order()); order != "3,1,2" {
+		t.Fatalf("expected the small client to go next, got %s", order)
+	}
+}
@@ -0,0 +1,456 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// reclaimInterval is how often queued allocations check for expired
+	// leases, so a killed CI job doesn't block the queue.
+	reclaimInterval = time.Second
+)
+
+// lease is a set of resources granted to an owner.
+type lease struct {
+	// owner is who holds the lease.
+	owner string
+
+	// resources are the resources held.
+	resources smtest.ResourceSet
+
+	// expires is when the lease may be reclaimed.
+	expires time.Time
+}
+
+// waiter is a queued allocation.
+type waiter struct {
+	// request is what was asked for.
+	request *broker.AcquireRequest
+
+	// granted is closed when the lease is created.
+	granted chan interface{}
+
+	// streams is the number of acquire calls waiting for the grant, a
+	// client may retry while its first call is still queued.
+	streams int
+}
+
+// agent is a machine contributing capacity.
+type agent struct {
+	// resources are what the agent contributes.
+	resources smtest.ResourceSet
+
+	// expires is when the capacity is removed unless re-registered.
+	expires time.Time
+}
+
+// Server schedules allocations from many clients against a single pool.
+type Server struct {
+	// lock protects the pool.
+	lock sync.Mutex
+
+	// resources is the whole pool, including agents.
+	resources smtest.ResourceSet
+
+	// free are the resources that are not leased.
+	free smtest.ResourceSet
+
+	// leases are the current leases keyed by ID.
+	leases map[string]*lease
+
+	// agents are registered agents keyed by name.
+	agents map[string]*agent
+
+	// queue are allocations waiting for resources in arrival order.
+	queue []*waiter
+
+	// policy decides the order queued allocations are granted in.
+	policy Policy
+
+	// last is the client most recently granted resources.
+	last string
+
+	// now returns the current time, this allows tests to control time.
+	now func() time.Time
+}
+
+// Policy decides the order queued allocations are granted in.
+type Policy string
+
+const (
+	// PolicyFIFO grants allocations in arrival order, regardless of which
+	// client made them.
+	PolicyFIFO Policy = "fifo"
+
+	// PolicyRoundRobin grants allocations from each client in turn, so one
+	// CI job queueing hundreds of tests can't starve another with only a
+	// handful.
+	PolicyRoundRobin Policy = "round-robin"
+)
+
+// ServerOption modifies the default behaviour of the server.
+type ServerOption func(*Server)
+
+// WithPolicy sets the order queued allocations are granted in, the default is
+// PolicyFIFO.  In either case an allocation that doesn't fit is skipped in
+// favour of later ones that do.
+func WithPolicy(policy Policy) ServerOption {
+	return func(s *Server) {
+		s.policy = policy
+	}
+}
+
+// NewServer returns a scheduler that holds the pool in memory.
+func NewServer(resources smtest.ResourceSet, opts ...ServerOption) *Server {
+	s := &Server{
+		resources: smtest.ResourceSet{},
+		free:      smtest.ResourceSet{},
+		leases:    map[string]*lease{},
+		agents:    map[string]*agent{},
+		policy:    PolicyFIFO,
+		now:       time.Now,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	for k, v := range resources {
+		s.resources[k] = v
+		s.free[k] = v
+	}
+
+	return s
+}
+
+// Register adds the scheduler service to a gRPC server.
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	registrar.RegisterService(&serviceDesc, s)
+}
+
+// expiry returns when a lease with the TTL expires, defaulting to the standard
+// lease duration.
+func (s *Server) expiry(ttl float64) time.Time {
+	if ttl <= 0 {
+		return s.now().Add(smtest.LeaseDuration)
+	}
+
+	return s.now().Add(time.Duration(ttl * float64(time.Second)))
+}
+
+// schedule reclaims expired leases and agent registrations, then grants queued allocations, in order,
+// that fit in the free pool.  This must be called with the lock held.
+func (s *Server) schedule() {
+	now := s.now()
+
+	for id, l := range s.leases {
+		if now.After(l.expires) {
+			s.releaseLease(id, l)
+		}
+	}
+
+	for name, a := range s.agents {
+		if now.After(a.expires) {
+			s.removeAgent(name, a)
+		}
+	}
+
+	granted := map[*waiter]bool{}
+
+	for _, w := range s.order() {
+		ok := true
+
+		for k, v := range w.request.Resources {
+			if s.free[k] < v {
+				ok = false
+				break
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		for k, v := range w.request.Resources {
+			s.free[k] -= v
+		}
+
+		granted[w] = true
+		s.last = w.request.Owner
+
+		s.leases[w.request.ID] = &lease{
+			owner:     w.request.Owner,
+			resources: w.request.Resources,
+			expires:   s.expiry(w.request.TTLSeconds),
+		}
+
+		close(w.granted)
+	}
+
+	queue := s.queue[:0]
+
+	for _, w := range s.queue {
+		if !granted[w] {
+			queue = append(queue, w)
+		}
+	}
+
+	s.queue = queue
+}
+
+// order returns the queue in the order allocations should be considered for
+// resources, according to the policy.  This must be called with the lock held.
+func (s *Server) order() []*waiter {
+	if s.policy != PolicyRoundRobin {
+		return s.queue
+	}
+
+	// Group by client, in order of arrival, with the client after the one
+	// last granted going first so no client is favoured.
+	var owners []string
+
+	queues := map[string][]*waiter{}
+
+	for _, w := range s.queue {
+		if _, ok := queues[w.request.Owner]; !ok {
+			owners = append(owners, w.request.Owner)
+		}
+
+		queues[w.request.Owner] = append(queues[w.request.Owner], w)
+	}
+
+	for i, owner := range owners {
+		if owner == s.last {
+			owners = append(owners[i+1:], owners[:i+1]...)
+			break
+		}
+	}
+
+	// Then take one from each client in turn.
+	order := make([]*waiter, 0, len(s.queue))
+
+	for len(order) < len(s.queue) {
+		for _, owner := range owners {
+			if queue := queues[owner]; len(queue) > 0 {
+				order = append(order, queue[0])
+				queues[owner] = queue[1:]
+			}
+		}
+	}
+
+	return order
+}
+
+// queued returns the waiter for the allocation, or nil if it isn't queued.
+// This must be called with the lock held.
+func (s *Server) queued(id string) *waiter {
+	for _, w := range s.queue {
+		if w.request.ID == id {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// dequeue removes a waiter that has gone away.  This must be called with the
+// lock held.
+func (s *Server) dequeue(target *waiter) {
+	for i, w := range s.queue {
+		if w == target {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseLease returns a lease's resources to the pool.
+func (s *Server) releaseLease(id string, l *lease) {
+	for k, v := range l.resources {
+		s.free[k] += v
+	}
+
+	delete(s.leases, id)
+}
+
+// removeAgent removes an agent's capacity from the pool.  If it's in use then
+// the free pool goes negative, and nothing more is granted until enough is
+// released.
+func (s *Server) removeAgent(name string, a *agent) {
+	for k, v := range a.resources {
+		s.resources[k] -= v
+		s.free[k] -= v
+	}
+
+	delete(s.agents, name)
+}
+
+// acquire queues an allocation and streams a grant once it holds the resources.
+// If the client goes away while queued, the allocation is dropped.
+func (s *Server) acquire(request *broker.AcquireRequest, stream grpc.ServerStream) error {
+	s.lock.Lock()
+
+	for k, v := range request.Resources {
+		if v > s.resources[k] {
+			s.lock.Unlock()
+
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("requires %d %s, pool has %d", v, k, s.resources[k]))
+		}
+	}
+
+	// Acquiring is idempotent so clients can safely retry.
+	if _, ok := s.leases[request.ID]; ok {
+		s.lock.Unlock()
+
+		return stream.SendMsg(&Grant{ID: request.ID})
+	}
+
+	// A retry while still queued waits for the same grant, rather than
+	// queueing again and being granted twice.
+	w := s.queued(request.ID)
+
+	if w == nil {
+		w = &waiter{
+			request: request,
+			granted: make(chan interface{}),
+		}
+
+		s.queue = append(s.queue, w)
+	}
+
+	w.streams++
+
+	s.schedule()
+	s.lock.Unlock()
+
+	ticker := time.NewTicker(reclaimInterval)
+	defer ticker.Stop()
+
+	var position int
+
+	for {
+		select {
+		case <-w.granted:
+			return stream.SendMsg(&Grant{ID: request.ID})
+		case <-stream.Context().Done():
+			// If the grant raced with this, the lease is released by the
+			// client or expires.
+			s.lock.Lock()
+
+			if w.streams--; w.streams == 0 {
+				s.dequeue(w)
+			}
+
+			s.lock.Unlock()
+
+			return stream.Context().Err()
+		case <-ticker.C:
+			s.lock.Lock()
+			s.schedule()
+			current := s.position(w)
+			s.lock.Unlock()
+
+			if current != 0 && current != position {
+				position = current
+
+				if err := stream.SendMsg(&Grant{ID: request.ID, Position: position}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// position returns where the waiter is in the queue, starting at 1, or 0 if
+// it isn't queued.  This must be called with the lock held.
+func (s *Server) position(target *waiter) int {
+	for i, w := range s.order() {
+		if w == target {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// renew extends a lease.
+func (s *Server) renew(request *broker.LeaseRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.schedule()
+
+	l, ok := s.leases[request.ID]
+	if !ok || l.owner != request.Owner {
+		return status.Error(codes.NotFound, "lease not found")
+	}
+
+	l.expires = s.expiry(request.TTLSeconds)
+
+	return nil
+}
+
+// release returns a lease's resources to the pool and grants any queued
+// allocations that now fit.  Releasing an unknown lease is not an error.
+func (s *Server) release(request *broker.LeaseRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if l, ok := s.leases[request.ID]; ok && l.owner == request.Owner {
+		s.releaseLease(request.ID, l)
+	}
+
+	s.schedule()
+}
+
+// register replaces an agent's contribution to the pool, and grants any queued
+// allocations that now fit.  An empty registration removes the agent.
+func (s *Server) register(request *AgentRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if a, ok := s.agents[request.Name]; ok {
+		s.removeAgent(request.Name, a)
+	}
+
+	if len(request.Resources) != 0 {
+		a := &agent{
+			resources: request.Resources,
+			expires:   s.expiry(request.TTLSeconds),
+		}
+
+		for k, v := range a.resources {
+			s.resources[k] += v
+			s.free[k] += v
+		}
+
+		s.agents[request.Name] = a
+	}
+
+	s.schedule()
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package leader shares a pool between test binaries started together on the
+// same machine, for example the concurrent package binaries run by go test
+// ./..., by electing one of them to run a scheduler that the others connect to
+// e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   backend, err := leader.New(resources)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   defer backend.Close()
+//
+//	   smtest.Start(resources, smtest.WithBackend(backend))
+//	   ...
+//	}
+//
+// The leader serves the broker protocol on a unix socket.  If it exits, the
+// next process to find the socket unreachable takes over, and the others
+// re-acquire the allocations they hold as they renew them.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+)
+
+const (
+	// socketFile is where the leader listens.
+	socketFile = "leader.sock"
+
+	// lockFile serializes elections.
+	lockFile = "leader.lock"
+)
+
+// Backend is a client of the elected leader, which may be itself.
+type Backend struct {
+	// dir contains the socket and lock files.
+	dir string
+
+	// resources are the pool served if this process is elected.
+	resources smtest.ResourceSet
+
+	// client talks to the leader.
+	client *broker.Client
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// held are the resources of the allocations granted to this process,
+	// keyed by ID, so they can be re-acquired from a new leader.
+	held map[string]smtest.ResourceSet
+
+	// server is set when this process is the leader.
+	server *http.Server
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Backend = &Backend{}
+
+// Option modifies the default behaviour of the backend.
+type Option func(*Backend)
+
+// WithDirectory sets where the socket is created, the default is a directory
+// in the system temporary directory that is unique to the user.  Processes
+// sharing the pool must use the same directory.
+func WithDirectory(dir string) Option {
+	return func(b *Backend) {
+		b.dir = dir
+	}
+}
+
+// New returns a backend, electing this process as the leader if there isn't
+// one already.
+func New(resources smtest.ResourceSet, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		dir:       filepath.Join(os.TempDir(), fmt.Sprintf("smtest-%d", os.Getuid())),
+		resources: resources,
+		held:      map[string]smtest.ResourceSet{},
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	if err := os.MkdirAll(b.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	socket := filepath.Join(b.dir, socketFile)
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	b.client = broker.NewClient("http://leader", strconv.Itoa(os.Getpid()), broker.WithHTTPClient(&http.Client{Transport: transport}))
+
+	if err := b.elect(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Leader returns whether this process is running the scheduler.
+func (b *Backend) Leader() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.server != nil
+}
+
+// Close stops serving the pool if this process is the leader, another process
+// will take over.  It should be called once all tests have completed.
+func (b *Backend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.server == nil {
+		return nil
+	}
+
+	err := b.server.Close()
+
+	b.server = nil
+
+	return err
+}
+
+// elect becomes the leader if the current one is unreachable.
+func (b *Backend) elect() error {
+	f, err := os.OpenFile(filepath.Join(b.dir, lockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if err := lock(f); err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = unlock(f)
+	}()
+
+	socket := filepath.Join(b.dir, socketFile)
+
+	// Someone else may have won the election.
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+
+		return nil
+	}
+
+	// The socket is left behind if the leader was killed.
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler: broker.NewHandler(b.resources),
+	}
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	b.lock.Lock()
+	b.server = server
+	b.lock.Unlock()
+
+	return nil
+}
+
+// call makes a request to the leader, holding an election and retrying if it
+// is unreachable.
+func (b *Backend) call(callback func() error) error {
+	err := callback()
+
+	// Transport errors are always wrapped by the HTTP client.
+	var urlErr *url.Error
+
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+
+	if err := b.elect(); err != nil {
+		return err
+	}
+
+	return callback()
+}
+
+// Acquire asks the leader for the resources.
+func (b *Backend) Acquire(ctx context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	var ok bool
+
+	err := b.call(func() error {
+		var err error
+
+		ok, err = b.client.Acquire(ctx, id, required)
+
+		return err
+	})
+	if err != nil || !ok {
+		return false, err
+	}
+
+	b.lock.Lock()
+	b.held[id] = required
+	b.lock.Unlock()
+
+	return true, nil
+}
+
+// Renew extends the lease, if the leader has changed and doesn't know about it
+// then it is re-acquired.
+func (b *Backend) Renew(ctx context.Context, id string) error {
+	err := b.call(func() error {
+		return b.client.Renew(ctx, id)
+	})
+	if !errors.Is(err, broker.ErrLeaseNotFound) {
+		return err
+	}
+
+	b.lock.Lock()
+	required, held := b.held[id]
+	b.lock.Unlock()
+
+	if !held {
+		return err
+	}
+
+	ok, acquireErr := b.client.Acquire(ctx, id, required)
+	if acquireErr != nil {
+		return acquireErr
+	}
+
+	if !ok {
+		return err
+	}
+
+	return nil
+}
+
+// Release returns the resources to the leader.
+func (b *Backend) Release(ctx context.Context, id string) error {
+	b.lock.Lock()
+	delete(b.held, id)
+	b.lock.Unlock()
+
+	return b.call(func() error {
+		return b.client.Release(ctx, id)
+	})
+}
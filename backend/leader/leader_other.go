@@ -0,0 +1,39 @@
+//go:build !unix
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	// errUnsupported is returned on platforms without advisory locks.
+	errUnsupported = errors.New("leader backend is not supported on this platform")
+)
+
+// lock is unsupported.
+func lock(_ *os.File) error {
+	return errUnsupported
+}
+
+// unlock is unsupported.
+func unlock(_ *os.File) error {
+	return errUnsupported
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"context"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestLeader(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	resources := smtest.ResourceSet{"cpu": 8}
+
+	dir := t.TempDir()
+
+	first, err := New(resources, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer first.Close()
+
+	second, err := New(resources, WithDirectory(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer second.Close()
+
+	if !first.Leader() || second.Leader() {
+		t.Fatal("expected the first backend to be elected")
+	}
+
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 2}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := second.Acquire(ctx, "c", smtest.ResourceSet{"cpu": 1}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	// The leader goes away, the next request elects a new one that has
+	// forgotten all allocations...
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Renew(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !second.Leader() {
+		t.Fatal("expected the second backend to be elected")
+	}
+
+	// ... but the renewal re-acquired the held allocation.
+	if ok, err := second.Acquire(ctx, "c", smtest.ResourceSet{"cpu": 7}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := second.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "c", smtest.ResourceSet{"cpu": 8}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redis shares a pool between test binaries, on any number of CI
+// agents, via Redis e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client := redis.NewClient(&redis.Options{Addr: "redis:6379"})
+//
+//	   backend, err := smtestredis.New(context.Background(), client, resources)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithBackend(backend))
+//	   ...
+//	}
+//
+// All accounting is performed atomically by Lua scripts, and leases that are
+// not renewed are reclaimed, so a killed CI job cannot deplete the pool.
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultPrefix is the default key prefix.  The hash tag keeps all keys
+	// in the same slot so scripts work with Redis Cluster.
+	defaultPrefix = "{smtest}"
+)
+
+// reclaim is shared by all scripts, it returns the resources of any expired
+// leases to the free pool.  Every key is passed in KEYS, so Redis Cluster can
+// route the script: KEYS[1] is the free pool, KEYS[2] the leases, a hash of ID
+// to the JSON encoded resources held, and KEYS[3] the lease expiry times.
+const reclaim = `
+local free = KEYS[1]
+local leases = KEYS[2]
+local expiry = KEYS[3]
+
+local function now()
+  local t = redis.call('TIME')
+  return tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+end
+
+local function release(id)
+  local held = redis.call('HGET', leases, id)
+
+  if held then
+    for name, amount in pairs(cjson.decode(held)) do
+      redis.call('HINCRBY', free, name, amount)
+    end
+  end
+
+  redis.call('HDEL', leases, id)
+  redis.call('ZREM', expiry, id)
+end
+
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', expiry, '-inf', now())) do
+  release(id)
+end
+`
+
+var (
+	// initScript sets the free pool if it doesn't already exist.
+	// ARGV: resource name and amount pairs.
+	initScript = redis.NewScript(reclaim + `
+for i = 1, #ARGV, 2 do
+  redis.call('HSETNX', free, ARGV[i], ARGV[i + 1])
+end
+
+return 1
+`)
+
+	// acquireScript takes resources from the free pool if they are all
+	// available, returning 1 if granted.
+	// ARGV: ID, TTL in milliseconds, then resource name and amount pairs.
+	acquireScript = redis.NewScript(reclaim + `
+local id = ARGV[1]
+local ttl = tonumber(ARGV[2])
+
+if redis.call('ZSCORE', expiry, id) then
+  return 1
+end
+
+for i = 3, #ARGV, 2 do
+  if tonumber(redis.call('HGET', free, ARGV[i]) or '0') < tonumber(ARGV[i + 1]) then
+    return 0
+  end
+end
+
+local held = {}
+
+for i = 3, #ARGV, 2 do
+  redis.call('HINCRBY', free, ARGV[i], -tonumber(ARGV[i + 1]))
+  held[ARGV[i]] = tonumber(ARGV[i + 1])
+end
+
+redis.call('HSET', leases, id, cjson.encode(held))
+redis.call('ZADD', expiry, now() + ttl, id)
+
+return 1
+`)
+
+	// renewScript extends a lease, returning 1 if it exists.
+	// ARGV: ID, TTL in milliseconds.
+	renewScript = redis.NewScript(reclaim + `
+local id = ARGV[1]
+
+if not redis.call('ZSCORE', expiry, id) then
+  return 0
+end
+
+redis.call('ZADD', expiry, now() + tonumber(ARGV[2]), id)
+
+return 1
+`)
+
+	// releaseScript returns a lease's resources to the free pool.
+	// ARGV: ID.
+	releaseScript = redis.NewScript(reclaim + `
+release(ARGV[1])
+
+return 1
+`)
+)
+
+// Backend keeps the free pool in Redis.
+type Backend struct {
+	// client is the Redis client.
+	client redis.UniversalClient
+
+	// prefix is prepended to all keys.
+	prefix string
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Backend = &Backend{}
+
+// Option modifies the default behaviour of the backend.
+type Option func(*Backend)
+
+// WithPrefix sets the key prefix, allowing multiple pools to share a Redis
+// instance.  It should contain a hash tag e.g. "{my-pool}" when using Redis
+// Cluster.
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// keys returns the keys every script uses, all sharing the prefix, so they
+// are in the same slot when it contains a hash tag.
+func (b *Backend) keys() []string {
+	return []string{
+		b.prefix + ":free",
+		b.prefix + ":leases",
+		b.prefix + ":expiry",
+	}
+}
+
+// pairs flattens resources into alternating name and amount arguments.
+func pairs(resources smtest.ResourceSet) []any {
+	args := make([]any, 0, len(resources)*2)
+
+	for k, v := range resources {
+		args = append(args, k, v)
+	}
+
+	return args
+}
+
+// New returns a backend, initializing the pool with the resources if this is
+// the first process to use it.
+func New(ctx context.Context, client redis.UniversalClient, resources smtest.ResourceSet, opts ...Option) (*Backend, error) {
+	b := &Backend{
+		client: client,
+		prefix: defaultPrefix,
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	if err := initScript.Run(ctx, b.client, b.keys(), pairs(resources)...).Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Acquire takes the resources from the pool if they are all free.
+func (b *Backend) Acquire(ctx context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	args := append([]any{id, smtest.LeaseDuration.Milliseconds()}, pairs(required)...)
+
+	granted, err := acquireScript.Run(ctx, b.client, b.keys(), args...).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return granted == 1, nil
+}
+
+// Renew extends the allocation's lease.
+func (b *Backend) Renew(ctx context.Context, id string) error {
+	renewed, err := renewScript.Run(ctx, b.client, b.keys(), id, smtest.LeaseDuration.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+
+	if renewed != 1 {
+		return fmt.Errorf("%w: %s has expired", smtest.ErrLeaseLost, id)
+	}
+
+	return nil
+}
+
+// Release returns the allocation's resources to the pool.
+func (b *Backend) Release(ctx context.Context, id string) error {
+	return releaseScript.Run(ctx, b.client, b.keys(), id).Err()
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	server := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	resources := smtest.ResourceSet{"cpu": 8, "memory": 32}
+
+	first, err := New(ctx, client, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second process must not reset the pool.
+	if ok, err := first.Acquire(ctx, "a", smtest.ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	second, err := New(ctx, client, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if err := first.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := second.Acquire(ctx, "b", smtest.ResourceSet{"cpu": 4}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	// Scripts only touch the keys they declare, which share a hash tag so
+	// are in the same Redis Cluster slot.
+	if keys := server.Keys(); len(keys) != 3 || keys[0] != "{smtest}:expiry" || keys[1] != "{smtest}:free" || keys[2] != "{smtest}:leases" {
+		t.Fatalf("expected only the declared keys, got %v", keys)
+	}
+
+	// Leases that aren't renewed are reclaimed.
+	server.SetTime(time.Now().Add(2 * smtest.LeaseDuration))
+
+	if err := second.Renew(ctx, "b"); !errors.Is(err, smtest.ErrLeaseLost) {
+		t.Fatal("expected lease to have expired")
+	}
+
+	if ok, err := first.Acquire(ctx, "c", smtest.ResourceSet{"cpu": 8}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+}
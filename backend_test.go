@@ -0,0 +1,101 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeBackend has a fixed number of units, of any resource, and forgets
+// allocations on demand.
+type fakeBackend struct {
+	free   int
+	leases map[string]int
+}
+
+func (b *fakeBackend) Acquire(_ context.Context, id string, required ResourceSet) (bool, error) {
+	var units int
+
+	for _, v := range required {
+		units += v
+	}
+
+	if units > b.free {
+		return false, nil
+	}
+
+	b.free -= units
+	b.leases[id] = units
+
+	return true, nil
+}
+
+func (b *fakeBackend) Renew(_ context.Context, id string) error {
+	if _, ok := b.leases[id]; !ok {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+func (b *fakeBackend) Release(_ context.Context, id string) error {
+	b.free += b.leases[id]
+	delete(b.leases, id)
+
+	return nil
+}
+
+// reclaim forgets an allocation as if its heartbeats were missed.
+func (b *fakeBackend) reclaim(id string) {
+	_ = b.Release(context.Background(), id)
+}
+
+func TestRenewLease(t *testing.T) {
+	t.Parallel()
+
+	backend := &fakeBackend{free: 8, leases: map[string]int{}}
+
+	r := &record{id: "a", required: ResourceSet{"cpu": 6}, charged: ResourceSet{"cpu": 6}}
+
+	if ok, err := backend.Acquire(context.Background(), r.id, r.required); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if lost, err := renewLease(backend, r); lost || err != nil {
+		t.Fatalf("expected renew to succeed: %v", err)
+	}
+
+	// Reclaimed, but still free so recovered...
+	backend.reclaim(r.id)
+
+	if lost, err := renewLease(backend, r); lost || err != nil {
+		t.Fatalf("expected lease to be recovered: %v", err)
+	}
+
+	// ... reclaimed and used by another process.
+	backend.reclaim(r.id)
+
+	if ok, err := backend.Acquire(context.Background(), "b", ResourceSet{"cpu": 4}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if lost, err := renewLease(backend, r); !lost || !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected lease to be lost, got %v", err)
+	}
+}
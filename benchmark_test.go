@@ -0,0 +1,144 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// policies are compared by every benchmark that grants.
+var policies = []Policy{PolicyFirstFit, PolicyFIFO}
+
+func BenchmarkEnqueue(b *testing.B) {
+	b.ReportAllocs()
+
+	q := newWaitQueue()
+
+	for i := 0; i < b.N; i++ {
+		q.add(newQueueItem(fmt.Sprint(i), int64(i), ResourceSet{"cpu": 1}))
+	}
+}
+
+// BenchmarkGrantRelease measures steady state throughput, each operation
+// releases one allocation and grants whatever then fits from a queue of
+// thousands of tests of varying size.
+func BenchmarkGrantRelease(b *testing.B) {
+	for _, policy := range policies {
+		b.Run(policy.String(), func(b *testing.B) {
+			q := newWaitQueue()
+			m := NewMemoryBackend(ResourceSet{"cpu": 64, "memory": 256})
+
+			enqueued := int64(0)
+
+			// enqueue tops the queue up, so it never runs dry.
+			enqueue := func() {
+				for q.len() < 4096 {
+					required := ResourceSet{"cpu": 1 + int(enqueued%8), "memory": 1 + int(enqueued%32)}
+
+					q.add(newQueueItem(fmt.Sprint(enqueued), enqueued, required))
+
+					enqueued++
+				}
+			}
+
+			enqueue()
+			grant(q, m, nil, policy)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for id := range m.leases {
+					_ = m.Release(context.Background(), id)
+
+					break
+				}
+
+				enqueue()
+
+				if q.pending(m.Free(), policy) {
+					grant(q, m, nil, policy)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkContention measures the round trip of many concurrent callers
+// enqueueing, being granted, and releasing through the scheduler started by
+// TestMain, which is how Parallel drives it.
+func BenchmarkContention(b *testing.B) {
+	for _, callers := range []int{1, 10, 100, 500} {
+		b.Run(fmt.Sprintf("callers=%d", callers), func(b *testing.B) {
+			var sequence atomic.Int64
+
+			b.ReportAllocs()
+			b.SetParallelism(callers)
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := cycle(sequence.Add(1)); err != nil {
+						b.Error(err)
+
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// cycle enqueues a small allocation, waits for it to be granted, then
+// releases it, as Parallel does without a test to run.
+func cycle(sequence int64) error {
+	r := &record{
+		id:       fmt.Sprintf("benchmark-%d", sequence),
+		name:     "BenchmarkContention",
+		required: ResourceSet{"cpu": 1 + int(sequence%4)},
+		charged:  ResourceSet{"cpu": 1 + int(sequence%4)},
+		enqueued: time.Now(),
+	}
+
+	if grantInline(r) {
+		return submit(release, r, "release of "+r.name, enqueueTimeout())
+	}
+
+	item := &queueItem{
+		wait:     make(chan interface{}),
+		required: r.charged,
+		enqueued: r.enqueued,
+		record:   r,
+	}
+
+	if err := submit(enqueue, item, "enqueue of "+r.name, enqueueTimeout()); err != nil {
+		cancelSubmit()
+
+		return err
+	}
+
+	<-item.wait
+
+	if item.err != nil {
+		return item.err
+	}
+
+	return submit(release, r, "release of "+r.name, enqueueTimeout())
+}
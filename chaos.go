@@ -0,0 +1,59 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// chaosMaxDelay is the longest a grant is delayed by in chaos mode.
+	chaosMaxDelay = 100 * time.Millisecond
+)
+
+var (
+	// ErrInvalidChaos is raised when chaos mode is not off, on or a seed.
+	ErrInvalidChaos = errors.New("invalid chaos mode, must be off, on or an integer seed")
+)
+
+// chaos perturbs scheduling to flush out hidden dependencies between tests,
+// for example one that only passes if another ran first.  It is owned by the
+// scheduler goroutine.
+type chaos struct {
+	// rand is seeded so a run's decisions can be reproduced.
+	rand *rand.Rand
+}
+
+// newChaos returns a perturbation seeded with the given value.
+func newChaos(seed int64) *chaos {
+	return &chaos{
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// rank returns a random position in the queue, so tests are granted in a
+// random order, rather than the order they arrived in.
+func (c *chaos) rank() int64 {
+	return c.rand.Int63()
+}
+
+// delay returns a random time to hold back a grant for.
+func (c *chaos) delay() time.Duration {
+	return time.Duration(c.rand.Int63n(int64(chaosMaxDelay)))
+}
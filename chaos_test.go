@@ -0,0 +1,88 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// chaosOrder queues ten tests, in chaos mode with the seed, and returns the
+// order they are considered in.
+func chaosOrder(seed int64) string {
+	q := newWaitQueue()
+	q.chaos = newChaos(seed)
+
+	for i := 0; i < 10; i++ {
+		q.add(newQueueItem(fmt.Sprint(i), int64(i), nil))
+	}
+
+	return ids(q.ordered())
+}
+
+func TestChaosOrder(t *testing.T) {
+	t.Parallel()
+
+	order := chaosOrder(1)
+
+	if order == "0123456789" {
+		t.Fatal("expected tests to be considered out of order")
+	}
+
+	// The same seed reproduces the same decisions.
+	if again := chaosOrder(1); again != order {
+		t.Fatalf("expected order %s to be reproduced, got %s", order, again)
+	}
+
+	if other := chaosOrder(2); other == order {
+		t.Fatal("expected a different seed to perturb differently")
+	}
+}
+
+func TestChaosDelay(t *testing.T) {
+	t.Parallel()
+
+	q := newWaitQueue()
+	q.chaos = newChaos(1)
+
+	b := NewMemoryBackend(ResourceSet{"cpu": 1})
+
+	item := newQueueItem("a", 1, ResourceSet{"cpu": 1})
+
+	q.add(item)
+
+	start := time.Now()
+
+	grant(q, b, nil, PolicyFirstFit)
+
+	// The resources are taken straight away, but the test is only released
+	// once the delay expires.
+	if q.len() != 0 || b.Free()["cpu"] != 0 {
+		t.Fatal("expected the test to be granted")
+	}
+
+	select {
+	case <-item.wait:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the test to be released")
+	}
+
+	if elapsed := time.Since(start); elapsed > chaosMaxDelay+time.Second {
+		t.Fatalf("expected at most %v delay, took %v", chaosMaxDelay, elapsed)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	// ErrExceedsParent is returned when a child allocation requires more than
+	// is left of its parent's resources.
+	ErrExceedsParent = errors.New("child allocation exceeds its parent")
+
+	// ErrInvalidChild is returned when a child allocation can't be created,
+	// for example because its parent has been released.
+	ErrInvalidChild = errors.New("invalid child allocation")
+)
+
+// Child is a share of an allocation's resources, handed to a goroutine the test
+// starts, for example a worker generating load, so the test gets accounting of
+// how its grant is used without a scheduler of its own.  Children never hold
+// more, between them, than their parent.
+type Child struct {
+	// parent is the allocation the resources are taken from.
+	parent *Allocation
+
+	// id uniquely identifies the child.
+	id string
+
+	// resources are the child's share.
+	resources ResourceSet
+
+	// released is set once the child has been released.
+	released atomic.Bool
+}
+
+// ID returns the child's unique identifier, its parent's followed by a
+// sequence number, so external fixtures can be tagged with it.
+func (c *Child) ID() string {
+	return c.id
+}
+
+// Resources returns a copy of the child's share of its parent's resources.
+func (c *Child) Resources() ResourceSet {
+	resources := make(ResourceSet, len(c.resources))
+
+	for k, v := range c.resources {
+		resources[k] = v
+	}
+
+	return resources
+}
+
+// Release returns the child's share to its parent, so it can be given to
+// another child.  Releasing more than once is reported as a test error, and
+// releasing after the test has completed is reported as a warning.  Children
+// don't need to be released when the parent is.
+func (c *Child) Release() {
+	a := c.parent
+
+	if a.hasEnded() {
+		emit(nil, event{
+			Action:  "warn",
+			Test:    a.record.name,
+			ID:      c.id,
+			Message: fmt.Sprintf("%v: %s child allocation %s", ErrReleaseAfterEnd, a.record.name, c.id),
+		})
+
+		return
+	}
+
+	if !c.released.CompareAndSwap(false, true) {
+		a.t.Error(fmt.Errorf("%w: %s released child allocation %s more than once", ErrDoubleRelease, a.record.name, c.id))
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for k, v := range c.resources {
+		a.assigned[k] -= v
+	}
+}
+
+// unassignedLocked returns the resources not held by children, the lock must
+// be held.
+func (a *Allocation) unassignedLocked() ResourceSet {
+	free := make(ResourceSet, len(a.record.required))
+
+	for k, v := range a.record.required {
+		free[k] = v - a.assigned[k]
+	}
+
+	return free
+}
+
+// newChildLocked assigns the resources to a new child, the lock must be held.
+func (a *Allocation) newChildLocked(resources ResourceSet) (*Child, error) {
+	if a.released {
+		return nil, fmt.Errorf("%w: allocation %s has been released", ErrInvalidChild, a.record.id)
+	}
+
+	if a.assigned == nil {
+		a.assigned = ResourceSet{}
+	}
+
+	for k, v := range resources {
+		a.assigned[k] += v
+	}
+
+	a.children++
+
+	return &Child{
+		parent:    a,
+		id:        fmt.Sprintf("%s.%d", a.record.id, a.children),
+		resources: resources,
+	}, nil
+}
+
+// Child takes the required resources from the allocation for a goroutine the
+// test starts.  If more is required than is left, once other children have
+// taken their share, an error wrapping ErrExceedsParent is returned, the
+// caller may wait for another child to be released and try again.
+func (a *Allocation) Child(required ResourceSet) (*Child, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	free := a.unassignedLocked()
+
+	resources := make(ResourceSet, len(required))
+
+	for k, v := range required {
+		if v < 0 {
+			return nil, fmt.Errorf("%w: negative amount of %s", ErrInvalidChild, k)
+		}
+
+		if v > free[k] {
+			return nil, fmt.Errorf("%w: requires %d %s, %d left", ErrExceedsParent, v, k, free[k])
+		}
+
+		resources[k] = v
+	}
+
+	return a.newChildLocked(resources)
+}
+
+// Split divides the resources that aren't held by children evenly between n
+// new children e.g. one per worker.  Where a resource doesn't divide evenly,
+// the first children are each given one more, so shares differ by at most one.
+func (a *Allocation) Split(n int) ([]*Child, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("%w: cannot split into %d", ErrInvalidChild, n)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	free := a.unassignedLocked()
+
+	children := make([]*Child, n)
+
+	for i := range children {
+		resources := ResourceSet{}
+
+		for k, v := range free {
+			share := v / n
+
+			if i < v%n {
+				share++
+			}
+
+			if share > 0 {
+				resources[k] = share
+			}
+		}
+
+		child, err := a.newChildLocked(resources)
+		if err != nil {
+			return nil, err
+		}
+
+		children[i] = child
+	}
+
+	return children, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestChild(t *testing.T) {
+	allocation := Acquire(t, ResourceSet{"cpu": 4})
+
+	child, err := allocation.Child(ResourceSet{"cpu": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(child.ID(), allocation.ID()+".") {
+		t.Fatalf("expected child ID to be derived from %s, got %s", allocation.ID(), child.ID())
+	}
+
+	if _, err := allocation.Child(ResourceSet{"cpu": 2}); !errors.Is(err, ErrExceedsParent) {
+		t.Fatalf("expected children to be limited by their parent, got %v", err)
+	}
+
+	if _, err := allocation.Child(ResourceSet{"memory": 1}); !errors.Is(err, ErrExceedsParent) {
+		t.Fatalf("expected children to be limited to their parent's resources, got %v", err)
+	}
+
+	if _, err := allocation.Child(ResourceSet{"cpu": -1}); !errors.Is(err, ErrInvalidChild) {
+		t.Fatalf("expected a negative amount to be invalid, got %v", err)
+	}
+
+	// Once released, the share can be given to another child.
+	child.Release()
+
+	if _, err := allocation.Child(ResourceSet{"cpu": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	allocation.Release()
+
+	if _, err := allocation.Child(ResourceSet{"cpu": 1}); !errors.Is(err, ErrInvalidChild) {
+		t.Fatalf("expected children of a released allocation to be invalid, got %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	allocation := Acquire(t, ResourceSet{"cpu": 5, "memory": 2})
+	defer allocation.Release()
+
+	if _, err := allocation.Split(0); !errors.Is(err, ErrInvalidChild) {
+		t.Fatalf("expected splitting into nothing to be invalid, got %v", err)
+	}
+
+	children, err := allocation.Split(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What doesn't divide evenly is spread one each over the first children.
+	for i, expected := range []string{"cpu=2,memory=1", "cpu=2,memory=1", "cpu=1"} {
+		if resources := children[i].Resources(); resources.String() != expected {
+			t.Fatalf("expected child %d to have %s, got %v", i, expected, resources)
+		}
+	}
+
+	if _, err := allocation.Child(ResourceSet{"cpu": 1}); !errors.Is(err, ErrExceedsParent) {
+		t.Fatalf("expected everything to be split, got %v", err)
+	}
+
+	// Workers release their share concurrently.
+	var wg sync.WaitGroup
+
+	for _, child := range children {
+		wg.Add(1)
+
+		go func(child *Child) {
+			defer wg.Done()
+
+			child.Release()
+		}(child)
+	}
+
+	wg.Wait()
+
+	if _, err := allocation.Child(ResourceSet{"cpu": 5, "memory": 2}); err != nil {
+		t.Fatalf("expected everything to be returned, got %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/spjmurray/testing/cmd/smtest-gen
+
+go 1.21.1
+
+require (
+	github.com/spjmurray/testing v0.1.0
+	github.com/spjmurray/testing/config v0.1.0
+)
+
+require sigs.k8s.io/yaml v1.4.0 // indirect
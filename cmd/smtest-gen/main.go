@@ -0,0 +1,331 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-gen generates typed constants, and constructors, for the
+// resources and bundles declared in a configuration file, so requirements in
+// tests can't drift from the pool e.g.
+//
+//	//go:generate go run github.com/spjmurray/testing/cmd/smtest-gen -config smtest.yaml
+//
+// Given the configuration:
+//
+//	resources:
+//	  cpu: 16
+//	  memory: 64
+//	bundles:
+//	  small-cluster:
+//	    cpu: 4
+//	    memory: 16
+//
+// tests may then require resources with:
+//
+//	defer smtest.Parallel(t, Requirements(SmallCluster(), CPU(1)))()
+//
+// Every resource in the pool, or any profile, gets a Resource constant and a
+// constructor, and every bundle a constructor.  Environment variables
+// referenced by the configuration without a default must be set when
+// generating.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/spjmurray/testing/config"
+)
+
+var (
+	// ErrIdentifier is returned when a name can't be made into a Go
+	// identifier, or two names make the same one.
+	ErrIdentifier = errors.New("invalid identifier")
+)
+
+// initialisms are written in upper case, as is Go convention.
+var initialisms = map[string]bool{
+	"api":  true,
+	"cpu":  true,
+	"gpu":  true,
+	"id":   true,
+	"ip":   true,
+	"ram":  true,
+	"tpu":  true,
+	"url":  true,
+	"vcpu": true,
+	"vm":   true,
+}
+
+// identifier returns an exported Go identifier for a resource or bundle name
+// e.g. "floating-ip" becomes "FloatingIP".
+func identifier(name string) (string, error) {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+
+	for _, part := range parts {
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+
+			continue
+		}
+
+		runes := []rune(part)
+
+		b.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+	}
+
+	result := b.String()
+
+	if !token.IsIdentifier(result) || !token.IsExported(result) {
+		return "", fmt.Errorf("%w: %q can't be made into an exported identifier", ErrIdentifier, name)
+	}
+
+	return result, nil
+}
+
+// resource is a generated resource.
+type resource struct {
+	// Name is the resource name.
+	Name string
+
+	// Ident is the identifier of its constructor.
+	Ident string
+}
+
+// amount is a resource in a bundle.
+type amount struct {
+	// Ident is the identifier of the resource's constructor.
+	Ident string
+
+	// Amount is how much the bundle requires.
+	Amount int
+}
+
+// bundle is a generated bundle.
+type bundle struct {
+	// Name is the bundle name.
+	Name string
+
+	// Ident is the identifier of its constructor.
+	Ident string
+
+	// Resources are what the bundle requires, in the form "cpu=4,memory=16".
+	Resources string
+
+	// Amounts are what the bundle requires, in resource order.
+	Amounts []amount
+}
+
+// data is what the template is rendered with.
+type data struct {
+	Source    string
+	Package   string
+	Resources []resource
+	Bundles   []bundle
+}
+
+// source is the template of the generated file.
+var source = template.Must(template.New("source").Parse(`// Code generated by smtest-gen from {{ .Source }}. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	smtest "github.com/spjmurray/testing"
+)
+
+// Resource is a resource declared in {{ .Source }}.
+type Resource string
+
+const (
+{{- range .Resources }}
+	// Resource{{ .Ident }} is the {{ printf "%q" .Name }} resource.
+	Resource{{ .Ident }} Resource = {{ printf "%q" .Name }}
+{{ end -}}
+)
+{{ range .Resources }}
+// {{ .Ident }} requires n of the {{ printf "%q" .Name }} resource.
+func {{ .Ident }}(n int) smtest.ResourceSet {
+	return smtest.ResourceSet{string(Resource{{ .Ident }}): n}
+}
+{{ end }}
+{{- range .Bundles }}
+// {{ .Ident }} requires the {{ printf "%q" .Name }} bundle, {{ .Resources }}.
+func {{ .Ident }}() smtest.ResourceSet {
+	return smtest.ResourceSet{
+{{- range .Amounts }}
+		string(Resource{{ .Ident }}): {{ .Amount }},
+{{- end }}
+	}
+}
+{{ end }}
+// Requirements merges resource sets, adding together amounts of the same
+// resource, e.g. Requirements({{ with .Resources }}{{ (index . 0).Ident }}(1){{ end }}).
+func Requirements(sets ...smtest.ResourceSet) smtest.ResourceSet {
+	result := smtest.ResourceSet{}
+
+	for _, set := range sets {
+		for k, v := range set {
+			result[k] += v
+		}
+	}
+
+	return result
+}
+`))
+
+// generate writes Go source declaring the configuration's resources and
+// bundles.
+func generate(w io.Writer, c *config.Config, path, pkg string) error {
+	d := &data{
+		Source:  filepath.Base(path),
+		Package: pkg,
+	}
+
+	// Every identifier must be unique, including those that are always
+	// generated.
+	used := map[string]string{
+		"Resource":     "",
+		"Requirements": "",
+	}
+
+	use := func(ident, name string) error {
+		if other, ok := used[ident]; ok {
+			return fmt.Errorf("%w: %q and %q are both %s", ErrIdentifier, name, other, ident)
+		}
+
+		used[ident] = name
+
+		return nil
+	}
+
+	idents := map[string]string{}
+
+	for _, name := range c.ResourceNames() {
+		ident, err := identifier(name)
+		if err != nil {
+			return err
+		}
+
+		if err := use(ident, name); err != nil {
+			return err
+		}
+
+		if err := use("Resource"+ident, name); err != nil {
+			return err
+		}
+
+		idents[name] = ident
+
+		d.Resources = append(d.Resources, resource{Name: name, Ident: ident})
+	}
+
+	names := make([]string, 0, len(c.Bundles))
+
+	for name := range c.Bundles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		ident, err := identifier(name)
+		if err != nil {
+			return err
+		}
+
+		if err := use(ident, name); err != nil {
+			return err
+		}
+
+		b := bundle{
+			Name:      name,
+			Ident:     ident,
+			Resources: c.Bundles[name].String(),
+		}
+
+		for _, r := range d.Resources {
+			if v, ok := c.Bundles[name][r.Name]; ok {
+				b.Amounts = append(b.Amounts, amount{Ident: idents[r.Name], Amount: v})
+			}
+		}
+
+		d.Bundles = append(d.Bundles, b)
+	}
+
+	var buf bytes.Buffer
+
+	if err := source.Execute(&buf, d); err != nil {
+		return err
+	}
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(formatted)
+
+	return err
+}
+
+// run parses the flags and generates the file.
+func run() error {
+	path := flag.String("config", "", "configuration file to read resources and bundles from")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file, set by go generate")
+	output := flag.String("output", "smtest_gen.go", "file to write")
+
+	flag.Parse()
+
+	if *path == "" {
+		return fmt.Errorf("a configuration file is required")
+	}
+
+	if *pkg == "" {
+		return fmt.Errorf("a package is required")
+	}
+
+	c, err := config.Read(*path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if err := generate(&buf, c, *path, *pkg); err != nil {
+		return fmt.Errorf("%s: %w", *path, err)
+	}
+
+	return os.WriteFile(*output, buf.Bytes(), 0o644)
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/config"
+)
+
+// TestIdentifier checks names are made into exported identifiers.
+func TestIdentifier(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]string{
+		"cpu":            "CPU",
+		"memory":         "Memory",
+		"floating-ip":    "FloatingIP",
+		"small_cluster":  "SmallCluster",
+		"nvidia.com/gpu": "NvidiaComGPU",
+	} {
+		ident, err := identifier(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ident != expected {
+			t.Fatalf("expected %q to be %s, got %s", name, expected, ident)
+		}
+	}
+
+	for _, name := range []string{"", "-", "2xlarge"} {
+		if _, err := identifier(name); !errors.Is(err, ErrIdentifier) {
+			t.Fatalf("expected %q to be invalid, got %v", name, err)
+		}
+	}
+}
+
+// TestGenerate checks constants and constructors are generated for resources,
+// including those only in profiles, and bundles.
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	c, err := config.Parse([]byte(`
+version: smtest/v1
+resources:
+  cpu: 16
+  memory: 64
+profiles:
+  nightly:
+    gpu: 2
+bundles:
+  small-cluster:
+    cpu: 4
+    memory: 16
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := generate(&buf, c, "testdata/smtest.yaml", "e2e"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "smtest_gen.go", buf.Bytes(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"// Code generated by smtest-gen from smtest.yaml. DO NOT EDIT.",
+		"package e2e",
+		`ResourceGPU Resource = "gpu"`,
+		"func Memory(n int) smtest.ResourceSet {",
+		"string(ResourceCPU):    4,",
+		"func SmallCluster() smtest.ResourceSet {",
+		"func Requirements(sets ...smtest.ResourceSet) smtest.ResourceSet {",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+// TestGenerateCollision checks names that make the same identifier are
+// rejected.
+func TestGenerateCollision(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []*config.Config{
+		{Resources: smtest.ResourceSet{"floating-ip": 1, "floating_ip": 1}},
+		{Resources: smtest.ResourceSet{"cpu": 1}, Bundles: map[string]smtest.ResourceSet{"CPU": {"cpu": 1}}},
+		{Resources: smtest.ResourceSet{"requirements": 1}},
+	} {
+		if err := generate(&bytes.Buffer{}, c, "smtest.yaml", "e2e"); !errors.Is(err, ErrIdentifier) {
+			t.Fatalf("expected %+v to be rejected, got %v", c, err)
+		}
+	}
+}
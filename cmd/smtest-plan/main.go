@@ -0,0 +1,547 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-plan estimates how long a suite will take with a given pool,
+// and which pool is cheapest for a target duration, by simulating the
+// scheduler with each test's requirements and historical duration e.g.
+//
+//	smtest-plan -history allocations.json -pool cpu=16,memory=64
+//	smtest-plan -history allocations.json -pool cpu=16,memory=64 -target 30m -prices prices.json
+//
+// Durations, and what tests require, are read from allocation records
+// exported with the SMTEST_EXPORT environment variable, from one or more runs.
+// Requirements can also be declared with a requirements file, as used by
+// smtest.WithRequirements, which takes precedence.  Tests that have never run,
+// for example those listed with go test -list, are assumed to take as long as
+// the average test.
+//
+// When a target is given, pools the shape of -pool, scaled from a sixteenth to
+// four times its size, are simulated, and the cheapest that finishes within the
+// target is reported.  The cost of a pool is its hourly price for the duration
+// of the run.
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// scaleSteps is how many pools are simulated per multiple of -pool.
+	scaleSteps = 16
+
+	// maxScale is the largest multiple of -pool simulated.
+	maxScale = 4
+)
+
+var (
+	// ErrNoPlan is returned when no pool meets the target.
+	ErrNoPlan = errors.New("no pool meets the target")
+)
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+
+	return nil
+}
+
+// job is a test to simulate.
+type job struct {
+	// name is the test name.
+	name string
+
+	// resources are what the test requires.
+	resources smtest.ResourceSet
+
+	// duration is how long the test holds its resources for.
+	duration time.Duration
+
+	// estimated is set when the test has no history.
+	estimated bool
+}
+
+// requirement is a compiled smtest.Requirement.
+type requirement struct {
+	test      *regexp.Regexp
+	resources smtest.ResourceSet
+}
+
+// readRequirements reads a requirements file, a JSON array of
+// smtest.Requirement.
+func readRequirements(path string) ([]requirement, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []smtest.Requirement
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	result := make([]requirement, 0, len(entries))
+
+	for _, entry := range entries {
+		test, err := regexp.Compile(entry.Test)
+		if err != nil {
+			return nil, fmt.Errorf("%s: test %q: %w", path, entry.Test, err)
+		}
+
+		result = append(result, requirement{
+			test:      test,
+			resources: entry.Resources,
+		})
+	}
+
+	return result, nil
+}
+
+// lookup returns the resources required by the first requirement that matches
+// the test.
+func lookup(requirements []requirement, name string) (smtest.ResourceSet, bool) {
+	for _, r := range requirements {
+		if r.test.MatchString(name) {
+			return r.resources, true
+		}
+	}
+
+	return nil, false
+}
+
+// readListing reads test names, one per line, as output by go test -list.
+// Anything that isn't a test name, for example the package summary, is
+// ignored.
+func readListing(r io.Reader) ([]string, error) {
+	var names []string
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "Test") && !strings.ContainsAny(line, " \t") {
+			names = append(names, line)
+		}
+	}
+
+	return names, scanner.Err()
+}
+
+// jobs builds the tests to simulate, in the order they were first queued, then
+// the order tests without history are listed in.  Durations are averaged over
+// every run in the history.
+func jobs(history []smtest.Record, listing []string, requirements []requirement) []job {
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Enqueued.Before(history[j].Enqueued)
+	})
+
+	var order []string
+
+	resources := map[string]smtest.ResourceSet{}
+	totals := map[string]time.Duration{}
+	counts := map[string]int{}
+
+	var total time.Duration
+
+	var count int
+
+	for _, r := range history {
+		if _, ok := resources[r.Test]; !ok {
+			order = append(order, r.Test)
+		}
+
+		resources[r.Test] = r.Resources
+
+		if r.Released.IsZero() || r.Scheduled.IsZero() {
+			continue
+		}
+
+		d := r.Released.Sub(r.Scheduled)
+
+		totals[r.Test] += d
+		counts[r.Test]++
+
+		total += d
+		count++
+	}
+
+	var average time.Duration
+
+	if count > 0 {
+		average = total / time.Duration(count)
+	}
+
+	for _, name := range listing {
+		if _, ok := resources[name]; !ok {
+			order = append(order, name)
+			resources[name] = nil
+		}
+	}
+
+	result := make([]job, 0, len(order))
+
+	for _, name := range order {
+		j := job{
+			name:      name,
+			resources: resources[name],
+			duration:  average,
+			estimated: true,
+		}
+
+		if counts[name] > 0 {
+			j.duration = totals[name] / time.Duration(counts[name])
+			j.estimated = false
+		}
+
+		if declared, ok := lookup(requirements, name); ok {
+			j.resources = declared
+		}
+
+		result = append(result, j)
+	}
+
+	return result
+}
+
+// fits returns whether the required resources are available.
+func fits(free, required smtest.ResourceSet) bool {
+	for k, v := range required {
+		if free[k] < v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// running is a test being simulated, ordered by when it finishes.
+type running struct {
+	// finish is when the test finishes.
+	finish time.Duration
+
+	// resources are what the test holds.
+	resources smtest.ResourceSet
+}
+
+// runningHeap is a min-heap of running tests.
+type runningHeap []running
+
+func (h runningHeap) Len() int           { return len(h) }
+func (h runningHeap) Less(i, j int) bool { return h[i].finish < h[j].finish }
+func (h runningHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *runningHeap) Push(x any) {
+	*h = append(*h, x.(running))
+}
+
+func (h *runningHeap) Pop() any {
+	old := *h
+	n := len(old) - 1
+	x := old[n]
+	*h = old[:n]
+
+	return x
+}
+
+// simulate runs the tests against the pool, granting whatever fits in order as
+// the scheduler's default policy does, and returns how long the run takes, and
+// the names of tests that can never fit, which would be skipped.
+func simulate(tests []job, pool smtest.ResourceSet) (time.Duration, []string) {
+	free := smtest.ResourceSet{}
+
+	for k, v := range pool {
+		free[k] = v
+	}
+
+	var queue []job
+
+	var skipped []string
+
+	for _, j := range tests {
+		if !fits(pool, j.resources) {
+			skipped = append(skipped, j.name)
+
+			continue
+		}
+
+		queue = append(queue, j)
+	}
+
+	var now time.Duration
+
+	var h runningHeap
+
+	for len(queue) > 0 || h.Len() > 0 {
+		waiting := queue[:0]
+
+		for _, j := range queue {
+			if !fits(free, j.resources) {
+				waiting = append(waiting, j)
+
+				continue
+			}
+
+			for k, v := range j.resources {
+				free[k] -= v
+			}
+
+			heap.Push(&h, running{finish: now + j.duration, resources: j.resources})
+		}
+
+		queue = waiting
+
+		// Everything that finishes at the same time is released together.
+		next := heap.Pop(&h).(running)
+
+		now = next.finish
+
+		for {
+			for k, v := range next.resources {
+				free[k] += v
+			}
+
+			if h.Len() == 0 || h[0].finish != now {
+				break
+			}
+
+			next = heap.Pop(&h).(running)
+		}
+	}
+
+	return now, skipped
+}
+
+// scalePool returns the pool scaled by the factor, rounding up so no resource
+// is scaled away entirely.
+func scalePool(pool smtest.ResourceSet, factor float64) smtest.ResourceSet {
+	result := smtest.ResourceSet{}
+
+	for k, v := range pool {
+		result[k] = int(math.Ceil(float64(v) * factor))
+	}
+
+	return result
+}
+
+// plan is a simulated pool.
+type plan struct {
+	// pool is what was simulated.
+	pool smtest.ResourceSet
+
+	// duration is how long the run takes.
+	duration time.Duration
+
+	// cost is the price of the pool for the duration.
+	cost float64
+}
+
+// cheapest returns the cheapest pool, the shape of the given one, that runs
+// every test that fits the given pool within the target.
+func cheapest(tests []job, pool smtest.ResourceSet, prices smtest.Prices, target time.Duration) (*plan, error) {
+	_, skipped := simulate(tests, pool)
+
+	excluded := map[string]bool{}
+
+	for _, name := range skipped {
+		excluded[name] = true
+	}
+
+	runnable := make([]job, 0, len(tests))
+
+	for _, j := range tests {
+		if !excluded[j.name] {
+			runnable = append(runnable, j)
+		}
+	}
+
+	var best *plan
+
+	var fastest time.Duration
+
+	for step := 1; step <= scaleSteps*maxScale; step++ {
+		candidate := scalePool(pool, float64(step)/scaleSteps)
+
+		duration, skipped := simulate(runnable, candidate)
+		if len(skipped) > 0 {
+			continue
+		}
+
+		if fastest == 0 || duration < fastest {
+			fastest = duration
+		}
+
+		if duration > target {
+			continue
+		}
+
+		cost := prices.Cost(candidate, duration)
+
+		if best == nil || cost < best.cost {
+			best = &plan{
+				pool:     candidate,
+				duration: duration,
+				cost:     cost,
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("%w: up to %dx %v takes at least %v", ErrNoPlan, maxScale, pool, fastest)
+	}
+
+	return best, nil
+}
+
+// report writes the estimate for the pool and, if there is a target, the
+// cheapest plan that meets it.
+func report(w io.Writer, tests []job, pool smtest.ResourceSet, prices smtest.Prices, target time.Duration) error {
+	estimated := 0
+
+	for _, j := range tests {
+		if j.estimated {
+			estimated++
+		}
+	}
+
+	fmt.Fprintf(w, "%d tests, %d without history\n", len(tests), estimated)
+
+	duration, skipped := simulate(tests, pool)
+
+	fmt.Fprintf(w, "pool %v takes %v", pool, duration.Round(time.Second))
+
+	if prices != nil {
+		fmt.Fprintf(w, " costing %.2f", prices.Cost(pool, duration))
+	}
+
+	fmt.Fprintln(w)
+
+	for _, name := range skipped {
+		fmt.Fprintf(w, "  %s never fits, it would be skipped\n", name)
+	}
+
+	if target == 0 {
+		return nil
+	}
+
+	best, err := cheapest(tests, pool, prices, target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "cheapest pool within %v is %v, taking %v costing %.2f\n", target, best.pool, best.duration.Round(time.Second), best.cost)
+
+	return nil
+}
+
+// run parses the flags and reports the plan.
+func run() error {
+	var histories stringList
+
+	flag.Var(&histories, "history", "allocation export file written via SMTEST_EXPORT, may be repeated")
+
+	requirementsPath := flag.String("requirements", "", "requirements file, as used by smtest.WithRequirements")
+	listingPath := flag.String("tests", "", "test names, one per line, as output by go test -list")
+	poolFlag := flag.String("pool", "", "pool to plan for e.g. cpu=16,memory=64")
+	pricesPath := flag.String("prices", "", "hourly prices of each resource, as read by smtest.ReadPrices")
+	target := flag.Duration("target", 0, "find the cheapest pool that runs the suite within this duration")
+
+	flag.Parse()
+
+	pool, err := smtest.ParseResourceSet(*poolFlag)
+	if err != nil {
+		return err
+	}
+
+	if len(pool) == 0 {
+		return fmt.Errorf("a pool is required")
+	}
+
+	var history []smtest.Record
+
+	for _, path := range histories {
+		records, err := smtest.ReadRecords(path)
+		if err != nil {
+			return err
+		}
+
+		history = append(history, records...)
+	}
+
+	var requirements []requirement
+
+	if *requirementsPath != "" {
+		if requirements, err = readRequirements(*requirementsPath); err != nil {
+			return err
+		}
+	}
+
+	var listing []string
+
+	if *listingPath != "" {
+		f, err := os.Open(*listingPath)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if listing, err = readListing(f); err != nil {
+			return err
+		}
+	}
+
+	var prices smtest.Prices
+
+	if *pricesPath != "" {
+		if prices, err = smtest.ReadPrices(*pricesPath); err != nil {
+			return err
+		}
+	}
+
+	if *target != 0 && prices == nil {
+		return fmt.Errorf("a target requires prices")
+	}
+
+	return report(os.Stdout, jobs(history, listing, requirements), pool, prices, *target)
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest-plan: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// history returns records of three tests, TestA is run twice.
+func history() []smtest.Record {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	record := func(test string, resources smtest.ResourceSet, offset, duration time.Duration) smtest.Record {
+		return smtest.Record{
+			Test:      test,
+			Resources: resources,
+			Enqueued:  start.Add(offset),
+			Scheduled: start.Add(offset),
+			Released:  start.Add(offset + duration),
+		}
+	}
+
+	return []smtest.Record{
+		record("TestB", smtest.ResourceSet{"cpu": 2}, time.Second, 20*time.Minute),
+		record("TestA", smtest.ResourceSet{"cpu": 2}, 0, 10*time.Minute),
+		record("TestA", smtest.ResourceSet{"cpu": 2}, time.Hour, 20*time.Minute),
+		record("TestC", smtest.ResourceSet{"cpu": 4}, 2*time.Second, 10*time.Minute),
+	}
+}
+
+// TestJobs checks history is averaged, and tests without history are
+// estimated and take declared requirements.
+func TestJobs(t *testing.T) {
+	t.Parallel()
+
+	requirements := []requirement{
+		{test: regexp.MustCompile("^TestD$"), resources: smtest.ResourceSet{"cpu": 1}},
+	}
+
+	result := jobs(history(), []string{"TestA", "TestD"}, requirements)
+
+	expected := []job{
+		{name: "TestA", resources: smtest.ResourceSet{"cpu": 2}, duration: 15 * time.Minute},
+		{name: "TestB", resources: smtest.ResourceSet{"cpu": 2}, duration: 20 * time.Minute},
+		{name: "TestC", resources: smtest.ResourceSet{"cpu": 4}, duration: 10 * time.Minute},
+		{name: "TestD", resources: smtest.ResourceSet{"cpu": 1}, duration: 15 * time.Minute, estimated: true},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+// TestReadListing checks only test names are read from go test -list output.
+func TestReadListing(t *testing.T) {
+	t.Parallel()
+
+	names, err := readListing(strings.NewReader("TestA\nTestB\nok  \texample.com/e2e\t0.01s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"TestA", "TestB"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+}
+
+// TestSimulate checks tests are granted first fit, and those that never fit
+// are reported.
+func TestSimulate(t *testing.T) {
+	t.Parallel()
+
+	tests := []job{
+		{name: "TestA", resources: smtest.ResourceSet{"cpu": 2}, duration: 10 * time.Minute},
+		{name: "TestB", resources: smtest.ResourceSet{"cpu": 4}, duration: 10 * time.Minute},
+		{name: "TestC", resources: smtest.ResourceSet{"cpu": 2}, duration: 5 * time.Minute},
+		{name: "TestD", resources: smtest.ResourceSet{"cpu": 8}, duration: time.Minute},
+	}
+
+	// TestA and TestC run together, TestB waits for TestA to finish.
+	duration, skipped := simulate(tests, smtest.ResourceSet{"cpu": 4})
+	if duration != 20*time.Minute {
+		t.Fatalf("expected 20m, got %v", duration)
+	}
+
+	if !reflect.DeepEqual(skipped, []string{"TestD"}) {
+		t.Fatalf("unexpected skipped tests %v", skipped)
+	}
+
+	// Everything but TestD runs at once, then TestD.
+	if duration, _ := simulate(tests, smtest.ResourceSet{"cpu": 8}); duration != 11*time.Minute {
+		t.Fatalf("expected 11m, got %v", duration)
+	}
+}
+
+// TestCheapest checks the cheapest pool meeting the target is chosen.
+func TestCheapest(t *testing.T) {
+	t.Parallel()
+
+	var tests []job
+
+	for i := 0; i < 5; i++ {
+		tests = append(tests, job{resources: smtest.ResourceSet{"cpu": 1}, duration: 10 * time.Minute})
+	}
+
+	pool := smtest.ResourceSet{"cpu": 16}
+	prices := smtest.Prices{"cpu": 1}
+
+	// Idle resources are paid for, so running all five at once is cheaper than
+	// two or three at a time, which also meet the target.
+	best, err := cheapest(tests, pool, prices, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if best.pool["cpu"] != 5 || best.duration != 10*time.Minute {
+		t.Fatalf("unexpected plan %+v", best)
+	}
+
+	if _, err := cheapest(tests, pool, prices, 5*time.Minute); !errors.Is(err, ErrNoPlan) {
+		t.Fatalf("expected ErrNoPlan, got %v", err)
+	}
+}
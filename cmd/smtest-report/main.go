@@ -0,0 +1,466 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-report produces the reports that smtest.Report prints at the
+// end of a run offline, from CI artifacts, for teams that don't call it, or
+// want to look across several runs e.g.
+//
+//	smtest-report -export allocations.json -test-json test.json -gantt gantt.svg
+//	smtest-report -export allocations.json -baseline previous.json -threshold 5s
+//
+// Each allocation export, written via the SMTEST_EXPORT environment variable,
+// is treated as a run.  A summary of every run is written, with its duration,
+// queue wait time percentiles, the tests that held resources longest and, with
+// -prices, its cost.  When go test -json output is given, test results are
+// included, and colour the Gantt chart.
+//
+// The Gantt chart is an SVG with one row per allocation, showing the time it
+// waited in the queue in grey, then the time it held its resources.
+//
+// With -baseline, tests whose mean wait or hold time has regressed by more than
+// the threshold are reported, and the command fails, so CI can be gated on it.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// slowest is how many of the longest held allocations are reported.
+	slowest = 5
+
+	// ganttLabelWidth is the width of the test name column of the Gantt chart.
+	ganttLabelWidth = 300
+
+	// ganttWidth is the width of the time line of the Gantt chart.
+	ganttWidth = 1000
+
+	// ganttRow is the height of each row of the Gantt chart.
+	ganttRow = 12
+)
+
+var (
+	// ErrRegression is returned when tests have regressed against the
+	// baseline.
+	ErrRegression = errors.New("tests regressed")
+)
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+
+	return nil
+}
+
+// testRun is an allocation export.
+type testRun struct {
+	// name is where the run was read from.
+	name string
+
+	// records are the allocations made during the run, in the order they
+	// were queued.
+	records []smtest.Record
+}
+
+// start returns when the first test was queued.
+func (r *testRun) start() time.Time {
+	if len(r.records) == 0 {
+		return time.Time{}
+	}
+
+	return r.records[0].Enqueued
+}
+
+// end returns when the last test released its resources.
+func (r *testRun) end() time.Time {
+	var end time.Time
+
+	for i := range r.records {
+		if r.records[i].Released.After(end) {
+			end = r.records[i].Released
+		}
+	}
+
+	return end
+}
+
+// readRun reads an allocation export.
+func readRun(path string) (*testRun, error) {
+	records, err := smtest.ReadRecords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Enqueued.Before(records[j].Enqueued)
+	})
+
+	return &testRun{
+		name:    path,
+		records: records,
+	}, nil
+}
+
+// event is the part of a test2json event that is of interest.
+type event struct {
+	Action string
+	Test   string
+}
+
+// readResults reads the final result of each test from go test -json output.
+// Anything that isn't an event, for example build output, is ignored.
+func readResults(r io.Reader, results map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var e event
+
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Test == "" {
+			continue
+		}
+
+		switch e.Action {
+		case "pass", "fail", "skip":
+			results[e.Test] = e.Action
+		}
+	}
+
+	return scanner.Err()
+}
+
+// percentile returns the nearest rank percentile (0-100) of a sorted
+// set of durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
+
+// summarize writes a summary of the run.
+func summarize(w io.Writer, r *testRun, results map[string]string, prices smtest.Prices) {
+	fmt.Fprintf(w, "%s: %d allocations in %v\n", r.name, len(r.records), r.end().Sub(r.start()).Round(time.Millisecond))
+
+	var waits []time.Duration
+
+	var held []*smtest.Record
+
+	var cost float64
+
+	counts := map[string]int{}
+
+	for i := range r.records {
+		record := &r.records[i]
+
+		if result, ok := results[record.Test]; ok {
+			counts[result]++
+		}
+
+		if record.Scheduled.IsZero() {
+			continue
+		}
+
+		waits = append(waits, record.Scheduled.Sub(record.Enqueued))
+
+		if record.Released.IsZero() {
+			continue
+		}
+
+		held = append(held, record)
+
+		cost += prices.Cost(record.Resources, record.Released.Sub(record.Scheduled))
+	}
+
+	if len(counts) > 0 {
+		fmt.Fprintf(w, "  results: %d passed, %d failed, %d skipped\n", counts["pass"], counts["fail"], counts["skip"])
+	}
+
+	sort.Slice(waits, func(i, j int) bool {
+		return waits[i] < waits[j]
+	})
+
+	if len(waits) > 0 {
+		fmt.Fprintf(w, "  wait: p50 %.2fs, p90 %.2fs, p99 %.2fs\n", percentile(waits, 50).Seconds(), percentile(waits, 90).Seconds(), percentile(waits, 99).Seconds())
+	}
+
+	if prices != nil {
+		fmt.Fprintf(w, "  cost: %.2f\n", cost)
+	}
+
+	sort.SliceStable(held, func(i, j int) bool {
+		return held[i].Released.Sub(held[i].Scheduled) > held[j].Released.Sub(held[j].Scheduled)
+	})
+
+	if len(held) > slowest {
+		held = held[:slowest]
+	}
+
+	if len(held) > 0 {
+		fmt.Fprintln(w, "  longest held:")
+	}
+
+	for _, record := range held {
+		fmt.Fprintf(w, "    %s held %v for %.2fs\n", record.Test, record.Resources, record.Released.Sub(record.Scheduled).Seconds())
+	}
+}
+
+// colour returns the fill of a held allocation given the test result.
+func colour(result string) string {
+	switch result {
+	case "pass":
+		return "seagreen"
+	case "fail":
+		return "firebrick"
+	case "skip":
+		return "goldenrod"
+	}
+
+	return "steelblue"
+}
+
+// writeGantt draws the runs as an SVG Gantt chart, one row per allocation and
+// runs one after another, each scaled to the width of the chart.
+func writeGantt(w io.Writer, runs []*testRun, results map[string]string) error {
+	rows := 0
+
+	for _, r := range runs {
+		rows += 1 + len(r.records)
+	}
+
+	if _, err := fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"monospace\" font-size=\"10\">\n", ganttLabelWidth+ganttWidth, rows*ganttRow); err != nil {
+		return err
+	}
+
+	y := 0
+
+	for _, r := range runs {
+		start := r.start()
+		duration := r.end().Sub(start)
+
+		if _, err := fmt.Fprintf(w, "<text x=\"0\" y=\"%d\" font-weight=\"bold\">%s (%v)</text>\n", y+ganttRow-2, html.EscapeString(r.name), duration.Round(time.Millisecond)); err != nil {
+			return err
+		}
+
+		y += ganttRow
+
+		// Scale to pixels, avoiding dividing by zero for empty runs.
+		x := func(t time.Time) float64 {
+			if duration <= 0 || t.IsZero() {
+				return ganttLabelWidth
+			}
+
+			return ganttLabelWidth + float64(t.Sub(start))/float64(duration)*ganttWidth
+		}
+
+		for i := range r.records {
+			record := &r.records[i]
+
+			name := html.EscapeString(record.Test)
+
+			if _, err := fmt.Fprintf(w, "<text x=\"0\" y=\"%d\">%s</text>\n", y+ganttRow-2, name); err != nil {
+				return err
+			}
+
+			// Tests that were never granted, or never released, wait, or hold,
+			// until the end of the run.
+			scheduled := record.Scheduled
+			if scheduled.IsZero() {
+				scheduled = r.end()
+			}
+
+			released := record.Released
+			if released.IsZero() {
+				released = r.end()
+			}
+
+			if _, err := fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%d\" width=\"%.1f\" height=\"%d\" fill=\"lightgrey\"><title>%s waited %.2fs</title></rect>\n", x(record.Enqueued), y+1, x(scheduled)-x(record.Enqueued), ganttRow-2, name, scheduled.Sub(record.Enqueued).Seconds()); err != nil {
+				return err
+			}
+
+			if !record.Scheduled.IsZero() {
+				if _, err := fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%d\" width=\"%.1f\" height=\"%d\" fill=\"%s\"><title>%s held %s for %.2fs</title></rect>\n", x(scheduled), y+1, x(released)-x(scheduled), ganttRow-2, colour(results[record.Test]), name, html.EscapeString(record.Resources.String()), released.Sub(scheduled).Seconds()); err != nil {
+					return err
+				}
+			}
+
+			y += ganttRow
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "</svg>")
+
+	return err
+}
+
+// regressions writes every test that has regressed against the baseline, and
+// returns an error if any have.
+func regressions(w io.Writer, baseline []smtest.Record, runs []*testRun, threshold time.Duration) error {
+	var current []smtest.Record
+
+	for _, r := range runs {
+		current = append(current, r.records...)
+	}
+
+	regressions := smtest.Compare(baseline, current, threshold)
+
+	for i := range regressions {
+		fmt.Fprintln(w, regressions[i].String())
+	}
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("%w: %d regressions", ErrRegression, len(regressions))
+	}
+
+	return nil
+}
+
+// writeGanttFile writes the Gantt chart to the named file.
+func writeGanttFile(path string, runs []*testRun, results map[string]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeGantt(f, runs, results); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// run parses the flags and writes the reports.
+func run() error {
+	var exports, testJSON, baselines stringList
+
+	flag.Var(&exports, "export", "allocation export file written via SMTEST_EXPORT, may be repeated")
+	flag.Var(&testJSON, "test-json", "go test -json output, may be repeated")
+	flag.Var(&baselines, "baseline", "allocation export file of a previous run to check for regressions against, may be repeated")
+
+	threshold := flag.Duration("threshold", time.Second, "how much longer a test may wait or hold resources before it's a regression")
+	gantt := flag.String("gantt", "", "file to write an SVG Gantt chart to")
+	pricesPath := flag.String("prices", "", "hourly prices of each resource, as read by smtest.ReadPrices")
+
+	flag.Parse()
+
+	if len(exports) == 0 {
+		return fmt.Errorf("at least one export is required")
+	}
+
+	runs := make([]*testRun, 0, len(exports))
+
+	for _, path := range exports {
+		r, err := readRun(path)
+		if err != nil {
+			return err
+		}
+
+		runs = append(runs, r)
+	}
+
+	results := map[string]string{}
+
+	for _, path := range testJSON {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		err = readResults(f, results)
+
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var prices smtest.Prices
+
+	if *pricesPath != "" {
+		var err error
+
+		if prices, err = smtest.ReadPrices(*pricesPath); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range runs {
+		summarize(os.Stdout, r, results, prices)
+	}
+
+	if *gantt != "" {
+		if err := writeGanttFile(*gantt, runs, results); err != nil {
+			return err
+		}
+	}
+
+	if len(baselines) == 0 {
+		return nil
+	}
+
+	var baseline []smtest.Record
+
+	for _, path := range baselines {
+		records, err := smtest.ReadRecords(path)
+		if err != nil {
+			return err
+		}
+
+		baseline = append(baseline, records...)
+	}
+
+	return regressions(os.Stdout, baseline, runs, *threshold)
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest-report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// newRun returns a run where TestA holds cpu for 10s, then TestB, which
+// waited for it, holds cpu for 20s.
+func newRun(hold time.Duration) *testRun {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return &testRun{
+		name: "allocations.json",
+		records: []smtest.Record{
+			{
+				ID:        "a",
+				Test:      "TestA",
+				Resources: smtest.ResourceSet{"cpu": 2},
+				Enqueued:  start,
+				Scheduled: start,
+				Released:  start.Add(10 * time.Second),
+			},
+			{
+				ID:        "b",
+				Test:      "TestB",
+				Resources: smtest.ResourceSet{"cpu": 2},
+				Enqueued:  start,
+				Scheduled: start.Add(10 * time.Second),
+				Released:  start.Add(10*time.Second + hold),
+			},
+		},
+	}
+}
+
+// TestReadResults checks the final result of each test is read, and anything
+// else ignored.
+func TestReadResults(t *testing.T) {
+	t.Parallel()
+
+	input := `{"Action":"run","Test":"TestA"}
+{"Action":"pass","Test":"TestA"}
+build output
+{"Action":"fail","Test":"TestB"}
+{"Action":"fail"}
+`
+
+	results := map[string]string{}
+
+	if err := readResults(strings.NewReader(input), results); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results["TestA"] != "pass" || results["TestB"] != "fail" {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+// TestSummarize checks the summary of a run.
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	summarize(&buf, newRun(20*time.Second), map[string]string{"TestA": "pass", "TestB": "fail"}, smtest.Prices{"cpu": 3600})
+
+	expected := `allocations.json: 2 allocations in 30s
+  results: 1 passed, 1 failed, 0 skipped
+  wait: p50 0.00s, p90 10.00s, p99 10.00s
+  cost: 60.00
+  longest held:
+    TestB held cpu=2 for 20.00s
+    TestA held cpu=2 for 10.00s
+`
+
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// TestWriteGantt checks each allocation is drawn, coloured by its result.
+func TestWriteGantt(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	if err := writeGantt(&buf, []*testRun{newRun(20 * time.Second)}, map[string]string{"TestB": "fail"}); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := buf.String()
+
+	// TestA is held from the start for a third of the run, TestB waits for a
+	// third of the run, then fails.
+	for _, expected := range []string{
+		`<rect x="300.0" y="13" width="333.3" height="10" fill="steelblue"><title>TestA held cpu=2 for 10.00s</title></rect>`,
+		`<rect x="300.0" y="25" width="333.3" height="10" fill="lightgrey"><title>TestB waited 10.00s</title></rect>`,
+		`<rect x="633.3" y="25" width="666.7" height="10" fill="firebrick"><title>TestB held cpu=2 for 20.00s</title></rect>`,
+	} {
+		if !strings.Contains(svg, expected) {
+			t.Fatalf("expected %s in:\n%s", expected, svg)
+		}
+	}
+}
+
+// TestRegressions checks regressions against the baseline fail the report.
+func TestRegressions(t *testing.T) {
+	t.Parallel()
+
+	baseline := newRun(20 * time.Second).records
+
+	var buf bytes.Buffer
+
+	if err := regressions(&buf, baseline, []*testRun{newRun(20 * time.Second)}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := regressions(&buf, baseline, []*testRun{newRun(30 * time.Second)}, time.Second); !errors.Is(err, ErrRegression) {
+		t.Fatalf("expected ErrRegression, got %v", err)
+	}
+
+	if buf.String() != "TestB hold time regressed from 20.00s to 30.00s\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/spjmurray/testing/cmd/smtest-server
+
+go 1.25.0
+
+require (
+	github.com/spjmurray/testing v0.1.0
+	github.com/spjmurray/testing/backend/grpc v0.1.0
+	google.golang.org/grpc v1.84.0
+)
+
+require (
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+)
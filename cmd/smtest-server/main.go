@@ -0,0 +1,232 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-server runs the central scheduler, so CI jobs across many
+// machines queue against one shared pool e.g.
+//
+//	smtest-server -listen :7070 -resource cpu=256 -resource memory=1024
+//
+// By default tests are granted resources in the order they queued, with
+// -policy round-robin clients take turns, so one CI job can't starve another.
+//
+// Test binaries connect to it with the backend/grpc client.
+//
+// In agent mode it instead contributes the CPUs, memory and, optionally, NVIDIA
+// GPUs of the machine it runs on to a server's pool while health checks pass
+// e.g.
+//
+//	smtest-server agent -server smtest-server:7070 -gpu -check /usr/local/bin/healthy
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	smtest "github.com/spjmurray/testing"
+	smtestgrpc "github.com/spjmurray/testing/backend/grpc"
+	"github.com/spjmurray/testing/discovery/gpu"
+	"github.com/spjmurray/testing/discovery/local"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// resourceFlag is a repeatable name=count flag.
+type resourceFlag smtest.ResourceSet
+
+func (r resourceFlag) String() string {
+	return fmt.Sprint(smtest.ResourceSet(r))
+}
+
+func (r resourceFlag) Set(value string) error {
+	name, count, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("resource %q must be of the form name=count", value)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return fmt.Errorf("resource %q must have a non-negative count", value)
+	}
+
+	r[name] = n
+
+	return nil
+}
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
+
+// commandCheck returns a health check that runs a command, which must exit
+// successfully.
+func commandCheck(command string) smtestgrpc.HealthCheck {
+	return func(ctx context.Context) error {
+		return exec.CommandContext(ctx, command).Run()
+	}
+}
+
+// gpuCheck returns a health check that fails if any of the GPUs disappear.
+func gpuCheck(expected int) smtestgrpc.HealthCheck {
+	return func(ctx context.Context) error {
+		devices, err := gpu.Devices(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(devices) != expected {
+			return fmt.Errorf("expected %d GPUs, found %d", expected, len(devices))
+		}
+
+		return nil
+	}
+}
+
+// interrupted returns a context that is cancelled on SIGINT or SIGTERM.
+func interrupted() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+func runAgent(args []string) error {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+
+	var checks stringList
+
+	flags.Var(&checks, "check", "command that must succeed for the machine to be schedulable, may be repeated")
+
+	server := flags.String("server", "localhost:7070", "address of the server")
+	name := flags.String("name", "", "unique agent name, defaults to the host name")
+	gpus := flags.Bool("gpu", false, "contribute NVIDIA GPUs, and check they remain present")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+
+		*name = hostname
+	}
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	resources, err := local.Capacity()
+	if err != nil {
+		return err
+	}
+
+	var opts []smtestgrpc.AgentOption
+
+	if *gpus {
+		devices, err := gpu.Devices(ctx)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range gpu.Capacity(devices) {
+			resources[k] = v
+		}
+
+		opts = append(opts, smtestgrpc.WithHealthCheck(gpuCheck(len(devices))))
+	}
+
+	for _, check := range checks {
+		opts = append(opts, smtestgrpc.WithHealthCheck(commandCheck(check)))
+	}
+
+	conn, err := grpc.NewClient(*server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	return smtestgrpc.NewAgent(conn, *name, resources, opts...).Run(ctx)
+}
+
+func runServer(args []string) error {
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+
+	resources := resourceFlag{}
+
+	flags.Var(resources, "resource", "resource in the pool as name=count, may be repeated, agents may contribute more")
+
+	listen := flags.String("listen", ":7070", "address to listen on")
+	policy := flags.String("policy", string(smtestgrpc.PolicyFIFO), "order queued tests are granted in, either fifo or round-robin between clients")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if p := smtestgrpc.Policy(*policy); p != smtestgrpc.PolicyFIFO && p != smtestgrpc.PolicyRoundRobin {
+		return fmt.Errorf("unknown policy %q", *policy)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+
+	smtestgrpc.NewServer(smtest.ResourceSet(resources), smtestgrpc.WithPolicy(smtestgrpc.Policy(*policy))).Register(server)
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	return server.Serve(listener)
+}
+
+func run() error {
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		return runAgent(os.Args[2:])
+	}
+
+	return runServer(os.Args[1:])
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest-server: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestResourceFlag(t *testing.T) {
+	t.Parallel()
+
+	resources := resourceFlag{}
+
+	for _, value := range []string{"cpu=16", "memory=64"} {
+		if err := resources.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if smtest.ResourceSet(resources)["cpu"] != 16 || smtest.ResourceSet(resources)["memory"] != 64 {
+		t.Fatalf("unexpected resources %v", resources)
+	}
+
+	for _, value := range []string{"cpu", "=16", "cpu=lots", "cpu=-1"} {
+		if err := resources.Set(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestCommandCheck(t *testing.T) {
+	t.Parallel()
+
+	if err := commandCheck("true")(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := commandCheck("false")(context.Background()); err == nil {
+		t.Fatal("expected failing command to be unhealthy")
+	}
+}
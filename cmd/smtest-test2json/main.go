@@ -0,0 +1,271 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-test2json merges allocation records, exported with the
+// SMTEST_EXPORT environment variable, into go test -json output, so CI
+// dashboards can see how long each test waited for resources and what it
+// held alongside its result e.g.
+//
+//	SMTEST_EXPORT=allocations.json go test -json ./... | smtest-test2json -export allocations.json
+//
+// By default every test2json event is passed through, and test result events
+// gain an Allocations field, so the output can be consumed by anything that
+// understands test2json, for example gotestsum --raw-command.  With -format
+// report only test results are written, one JSON object per line.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// formatTest2JSON passes through all events.
+	formatTest2JSON = "test2json"
+
+	// formatReport only writes test results.
+	formatReport = "report"
+)
+
+// Allocation is the part of an allocation record that is merged into a test
+// result.
+type Allocation struct {
+	// ID uniquely identifies the allocation.
+	ID string
+
+	// Resources are the resources the test required.
+	Resources smtest.ResourceSet
+
+	// Wait is the time in seconds the test was queued for.
+	Wait float64
+
+	// Hold is the time in seconds the test held its resources for.
+	Hold float64
+}
+
+// event is what identifies a test2json event, everything else in it is
+// passed through untouched.
+type event struct {
+	Action  string
+	Package string
+	Test    string
+}
+
+// key identifies a test, tests in different packages may share a name.
+type key struct {
+	// pkg is the import path of the package.
+	pkg string
+
+	// test is the test name.
+	test string
+}
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+
+	return nil
+}
+
+// result returns whether the event is the final result of a test.
+func result(e *event) bool {
+	if e.Test == "" {
+		return false
+	}
+
+	switch e.Action {
+	case "pass", "fail", "skip":
+		return true
+	}
+
+	return false
+}
+
+// allocations indexes allocation records by package and test name.
+type allocations struct {
+	// paths are the export files.
+	paths []string
+
+	// records are the unclaimed records for each test in release order.
+	records map[key][]smtest.Record
+
+	// read is the number of records read from each path.
+	read map[string]int
+}
+
+// newAllocations returns an empty index of the export files.
+func newAllocations(paths []string) *allocations {
+	return &allocations{
+		paths:   paths,
+		records: map[key][]smtest.Record{},
+		read:    map[string]int{},
+	}
+}
+
+// load reads any records added to the export files since the last call, as
+// they are written while the tests run.
+func (a *allocations) load() error {
+	for _, path := range a.paths {
+		records, err := smtest.ReadRecords(path)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records[a.read[path]:] {
+			k := key{pkg: r.Package, test: r.Test}
+
+			a.records[k] = append(a.records[k], r)
+		}
+
+		a.read[path] = len(records)
+	}
+
+	return nil
+}
+
+// claim returns the next record for the test.  Tests may run more than once
+// e.g. with -count, in which case records are claimed in order.  Records
+// exported before packages were recorded match the test in any package.
+func (a *allocations) claim(pkg, test string) (*smtest.Record, error) {
+	k := key{pkg: pkg, test: test}
+
+	if len(a.records[k]) == 0 {
+		if err := a.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.records[k]) == 0 {
+		k.pkg = ""
+	}
+
+	records := a.records[k]
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	a.records[k] = records[1:]
+
+	return &records[0], nil
+}
+
+// merge copies events from the reader to the writer, adding allocations to
+// test results.
+func merge(r io.Reader, w io.Writer, a *allocations, format string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	for scanner.Scan() {
+		var fields map[string]json.RawMessage
+
+		var e event
+
+		// Anything that isn't an event, for example build output, is
+		// passed through untouched.
+		if json.Unmarshal(scanner.Bytes(), &fields) != nil || json.Unmarshal(scanner.Bytes(), &e) != nil {
+			if format == formatTest2JSON {
+				fmt.Fprintln(w, scanner.Text())
+			}
+
+			continue
+		}
+
+		if result(&e) {
+			record, err := a.claim(e.Package, e.Test)
+			if err != nil {
+				return err
+			}
+
+			if record != nil {
+				allocations, err := json.Marshal([]Allocation{
+					{
+						ID:        record.ID,
+						Resources: record.Resources,
+						Wait:      record.Scheduled.Sub(record.Enqueued).Seconds(),
+						Hold:      record.Released.Sub(record.Scheduled).Seconds(),
+					},
+				})
+				if err != nil {
+					return err
+				}
+
+				fields["Allocations"] = allocations
+			}
+		} else if format == formatReport {
+			continue
+		}
+
+		if err := encoder.Encode(fields); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// run parses the flags and merges the input.
+func run() error {
+	var exports stringList
+
+	flag.Var(&exports, "export", "allocation export file written via SMTEST_EXPORT, may be repeated")
+
+	input := flag.String("input", "", "go test -json output to read, defaults to stdin")
+	format := flag.String("format", formatTest2JSON, "output format, either test2json or report")
+
+	flag.Parse()
+
+	if *format != formatTest2JSON && *format != formatReport {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	r := io.Reader(os.Stdin)
+
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		r = f
+	}
+
+	return merge(r, os.Stdout, newAllocations(exports), *format)
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest-test2json: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const input = `{"Action":"start","Package":"example.com/e2e"}
+{"Action":"run","Package":"example.com/e2e","Test":"TestCluster"}
+{"Action":"output","Package":"example.com/e2e","Test":"TestCluster","Output":"=== RUN   TestCluster\n","OutputType":"frame"}
+{"Action":"pass","Package":"example.com/e2e","Test":"TestCluster","Elapsed":3}
+{"Action":"pass","Package":"example.com/unit","Test":"TestCluster","Elapsed":0}
+{"Action":"run","Package":"example.com/e2e","Test":"TestUnit"}
+{"Action":"pass","Package":"example.com/e2e","Test":"TestUnit","Elapsed":0}
+not json
+{"Action":"pass","Package":"example.com/e2e","Elapsed":3}
+`
+
+// writeExport writes an allocation record for TestCluster in example.com/e2e,
+// the test of the same name in example.com/unit doesn't acquire resources.
+func writeExport(t *testing.T) string {
+	t.Helper()
+
+	enqueued := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	record := smtest.Record{
+		ID:        "0123456789abcdef",
+		Package:   "example.com/e2e",
+		Test:      "TestCluster",
+		Resources: smtest.ResourceSet{"cpu": 2},
+		Enqueued:  enqueued,
+		Scheduled: enqueued.Add(time.Second),
+		Released:  enqueued.Add(3 * time.Second),
+	}
+
+	data, err := json.Marshal(&record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "allocations.json")
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// outputEvent is a test2json event as written by merge.
+type outputEvent struct {
+	Action      string
+	Package     string
+	Test        string
+	OutputType  string
+	Allocations []Allocation
+}
+
+// decode returns the events written by merge.
+func decode(t *testing.T, output string) []outputEvent {
+	t.Helper()
+
+	var events []outputEvent
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var e outputEvent
+
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestMergeTest2JSON(t *testing.T) {
+	t.Parallel()
+
+	a := newAllocations([]string{writeExport(t)})
+
+	var output bytes.Buffer
+
+	if err := merge(strings.NewReader(input), &output, a, formatTest2JSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), "not json\n") {
+		t.Fatal("expected non-JSON output to be passed through")
+	}
+
+	events := decode(t, output.String())
+
+	if len(events) != 8 {
+		t.Fatalf("expected all events to be passed through, got %d", len(events))
+	}
+
+	if events[2].OutputType != "frame" {
+		t.Fatalf("expected fields merge doesn't know about to be passed through, got %+v", events[2])
+	}
+
+	allocations := events[3].Allocations
+
+	if len(allocations) != 1 || allocations[0].ID != "0123456789abcdef" || allocations[0].Wait != 1 || allocations[0].Hold != 2 {
+		t.Fatalf("unexpected allocations %+v", allocations)
+	}
+
+	if len(events[4].Allocations) != 0 {
+		t.Fatalf("expected a test of the same name in another package to have no allocations, got %+v", events[4].Allocations)
+	}
+}
+
+func TestMergeReport(t *testing.T) {
+	t.Parallel()
+
+	a := newAllocations([]string{writeExport(t)})
+
+	var output bytes.Buffer
+
+	if err := merge(strings.NewReader(input), &output, a, formatReport); err != nil {
+		t.Fatal(err)
+	}
+
+	events := decode(t, output.String())
+
+	if len(events) != 3 || events[0].Test != "TestCluster" || events[1].Test != "TestCluster" || events[2].Test != "TestUnit" {
+		t.Fatalf("expected only test results, got %+v", events)
+	}
+
+	if len(events[0].Allocations) != 1 || len(events[1].Allocations) != 0 || len(events[2].Allocations) != 0 {
+		t.Fatalf("unexpected allocations %+v", events)
+	}
+}
+
+func TestMergeWithoutPackage(t *testing.T) {
+	t.Parallel()
+
+	enqueued := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Exports written before packages were recorded.
+	data, err := json.Marshal(&smtest.Record{
+		ID:        "0123456789abcdef",
+		Test:      "TestCluster",
+		Enqueued:  enqueued,
+		Scheduled: enqueued,
+		Released:  enqueued,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "allocations.json")
+
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+
+	if err := merge(strings.NewReader(input), &output, newAllocations([]string{path}), formatReport); err != nil {
+		t.Fatal(err)
+	}
+
+	events := decode(t, output.String())
+
+	if len(events) != 3 || len(events[0].Allocations) != 1 {
+		t.Fatalf("expected the record to match by test name, got %+v", events)
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command smtest-vet finds misuse of the scheduler, for example release
+// functions returned by Parallel that are kept but never called, resources
+// that aren't in the pool, and tests that require more than the pool has, with
+// go vet e.g.
+//
+//	go install github.com/spjmurray/testing/cmd/smtest-vet@latest
+//	go vet -vettool=$(which smtest-vet) ./...
+//
+// Tests are checked against the pool passed to Start, or a target pool e.g.
+//
+//	go vet -vettool=$(which smtest-vet) -overcommit.pool=cpu=16,memory=64 ./...
+//
+// It may also be run on its own, in the same way as any other analyzer, see
+// the vet package for the checks it makes.
+package main
+
+import (
+	"github.com/spjmurray/testing/vet"
+
+	"golang.org/x/tools/go/analysis/multichecker"
+)
+
+func main() {
+	multichecker.Main(vet.Analyzer, vet.OvercommitAnalyzer)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Metric is something that is compared between runs.
+type Metric string
+
+const (
+	// MetricWait is the time a test spent queued for resources.
+	MetricWait Metric = "wait"
+
+	// MetricHold is the time a test held its resources for.
+	MetricHold Metric = "hold"
+)
+
+// Regression is a test that got slower between runs.
+type Regression struct {
+	// Test is the test name.
+	Test string
+
+	// Metric is what got slower.
+	Metric Metric
+
+	// Previous is the mean duration in the previous run.
+	Previous time.Duration
+
+	// Current is the mean duration in the current run.
+	Current time.Duration
+}
+
+// String returns a human readable description of the regression.
+func (r *Regression) String() string {
+	return fmt.Sprintf("%s %s time regressed from %.2fs to %.2fs", r.Test, r.Metric, r.Previous.Seconds(), r.Current.Seconds())
+}
+
+// means returns the mean wait and hold times of every completed test.  Tests
+// may run multiple times e.g. with -count, so these are averaged.
+func means(records []Record) map[string]map[Metric]time.Duration {
+	type total struct {
+		wait  time.Duration
+		hold  time.Duration
+		count int
+	}
+
+	totals := map[string]*total{}
+
+	for i := range records {
+		r := &records[i]
+
+		if r.Released.IsZero() {
+			continue
+		}
+
+		t, ok := totals[r.Test]
+		if !ok {
+			t = &total{}
+			totals[r.Test] = t
+		}
+
+		t.wait += r.Scheduled.Sub(r.Enqueued)
+		t.hold += r.Released.Sub(r.Scheduled)
+		t.count++
+	}
+
+	result := map[string]map[Metric]time.Duration{}
+
+	for name, t := range totals {
+		result[name] = map[Metric]time.Duration{
+			MetricWait: t.wait / time.Duration(t.count),
+			MetricHold: t.hold / time.Duration(t.count),
+		}
+	}
+
+	return result
+}
+
+// Compare returns every test whose mean wait or hold time in the current run
+// is more than threshold longer than in the previous run, for example to gate
+// CI on scheduler level performance regressions.  Tests that only appear in
+// one of the runs are ignored.
+func Compare(previous, current []Record, threshold time.Duration) []Regression {
+	return compareMeans(means(previous), means(current), threshold)
+}
+
+// compareMeans returns every test whose mean wait or hold time got more than
+// threshold longer.
+func compareMeans(before, after map[string]map[Metric]time.Duration, threshold time.Duration) []Regression {
+	names := make([]string, 0, len(after))
+
+	for name := range after {
+		if _, ok := before[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var regressions []Regression
+
+	for _, name := range names {
+		for _, metric := range []Metric{MetricWait, MetricHold} {
+			if after[name][metric]-before[name][metric] > threshold {
+				regressions = append(regressions, Regression{
+					Test:     name,
+					Metric:   metric,
+					Previous: before[name][metric],
+					Current:  after[name][metric],
+				})
+			}
+		}
+	}
+
+	return regressions
+}
+
+// summaryMeans returns the mean wait and hold times of every test summarized.
+func summaryMeans(summaries map[string]*summary) map[string]map[Metric]time.Duration {
+	result := map[string]map[Metric]time.Duration{}
+
+	for name, s := range summaries {
+		result[name] = map[Metric]time.Duration{
+			MetricWait: s.wait / time.Duration(s.count),
+			MetricHold: s.hold / time.Duration(s.count),
+		}
+	}
+
+	return result
+}
+
+// compareBaseline compares the run against the configured baseline and raises
+// an alert for every regression.
+func compareBaseline(summaries map[string]*summary) {
+	previous, err := ReadRecords(config.baseline)
+	if err != nil {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("unable to read baseline: %v", err),
+		})
+
+		return
+	}
+
+	for _, regression := range compareMeans(means(previous), summaryMeans(summaries), config.baselineThreshold) {
+		raise(Alert{
+			Kind:    AlertRegression,
+			Message: regression.String(),
+		})
+	}
+}
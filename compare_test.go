@@ -0,0 +1,53 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	previous := []Record{
+		{Test: "TestWait", Enqueued: now, Scheduled: now.Add(time.Second), Released: now.Add(2 * time.Second)},
+		{Test: "TestHold", Enqueued: now, Scheduled: now, Released: now.Add(time.Second)},
+		{Test: "TestSame", Enqueued: now, Scheduled: now, Released: now.Add(time.Second)},
+		{Test: "TestRemoved", Enqueued: now, Scheduled: now, Released: now.Add(time.Second)},
+	}
+
+	current := []Record{
+		{Test: "TestWait", Enqueued: now, Scheduled: now.Add(5 * time.Second), Released: now.Add(6 * time.Second)},
+		{Test: "TestHold", Enqueued: now, Scheduled: now, Released: now.Add(2 * time.Second)},
+		{Test: "TestHold", Enqueued: now, Scheduled: now, Released: now.Add(4 * time.Second)},
+		{Test: "TestSame", Enqueued: now, Scheduled: now, Released: now.Add(1100 * time.Millisecond)},
+		{Test: "TestAdded", Enqueued: now, Scheduled: now, Released: now.Add(time.Minute)},
+	}
+
+	expected := []Regression{
+		{Test: "TestHold", Metric: MetricHold, Previous: time.Second, Current: 3 * time.Second},
+		{Test: "TestWait", Metric: MetricWait, Previous: time.Second, Current: 5 * time.Second},
+	}
+
+	if actual := Compare(previous, current, 500*time.Millisecond); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,539 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config starts the scheduler from a versioned configuration file,
+// so large projects can manage the pool and scheduler behaviour as reviewed
+// configuration rather than code scattered across TestMain functions e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   if err := config.StartFromConfig("smtest.yaml"); err != nil {
+//	     log.Fatal(err)
+//	   }
+//	   ...
+//	}
+//
+// The file may be YAML or JSON, for example:
+//
+//	version: smtest/v1
+//	resources:
+//	  cpu: 16
+//	  memory: 64
+//	cpuResource: cpu
+//	memoryResource:
+//	  name: memory
+//	  unit: 1073741824
+//	ordering: acquireFirst
+//	starvationWarning: 5m
+//	backend:
+//	  type: file
+//
+// Values may reference environment variables as ${NAME}, or ${NAME:-default},
+// and be arithmetic expressions, so one file serves runners of different sizes
+// e.g.
+//
+//	resources:
+//	  cpu: ${RUNNER_CPUS} - 2
+//
+// Named profiles resize the pool for different environments, and are selected
+// with the SMTEST_PROFILE environment variable, so the same suite can run
+// conservatively on a laptop and aggressively on large nightly runners e.g.
+//
+//	profiles:
+//	  laptop:
+//	    cpu: 4
+//	    memory: 8
+//	  nightly:
+//	    cpu: 64
+//	    memory: 256
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/backend/broker"
+	"github.com/spjmurray/testing/backend/file"
+	"github.com/spjmurray/testing/backend/leader"
+)
+
+const (
+	// Version is the configuration version understood by this package.
+	Version = "smtest/v1"
+
+	// profileEnvironmentVariable selects a profile, overriding the one set in
+	// the configuration.
+	profileEnvironmentVariable = "SMTEST_PROFILE"
+)
+
+var (
+	// ErrInvalidConfig is returned when a configuration is unusable.
+	ErrInvalidConfig = errors.New("invalid configuration")
+)
+
+// Duration is a time.Duration that is written as a string e.g. "90s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// MemoryResource names the resource that maps to memory.
+type MemoryResource struct {
+	// Name is the resource name.
+	Name string `json:"name"`
+
+	// Unit is the number of bytes in one unit of the resource.
+	Unit int64 `json:"unit"`
+}
+
+// WaitThreshold defines a contention SLO.
+type WaitThreshold struct {
+	// Percentile of wait times to check e.g. 90.
+	Percentile float64 `json:"percentile"`
+
+	// Threshold the percentile must not exceed.
+	Threshold Duration `json:"threshold"`
+}
+
+// BackendType selects how the pool is shared.
+type BackendType string
+
+const (
+	// BackendBroker uses a central broker, see backend/broker.
+	BackendBroker BackendType = "broker"
+
+	// BackendFile uses a locked file on the local machine, see backend/file.
+	BackendFile BackendType = "file"
+
+	// BackendLeader elects a local test binary to serve the pool, see
+	// backend/leader.
+	BackendLeader BackendType = "leader"
+)
+
+// Backend shares the pool with other processes.
+type Backend struct {
+	// Type of the backend.
+	Type BackendType `json:"type"`
+
+	// URL of the broker.
+	URL string `json:"url,omitempty"`
+
+	// Owner identifies this process to the broker, by default the host name.
+	Owner string `json:"owner,omitempty"`
+
+	// Directory holds the state shared between file and leader backends, if
+	// not set their default is used.
+	Directory string `json:"directory,omitempty"`
+}
+
+// Config is the configuration file format.
+type Config struct {
+	// Version must be Version.
+	Version string `json:"version"`
+
+	// Resources are the pool's capacity.
+	Resources smtest.ResourceSet `json:"resources"`
+
+	// Profiles are named pools, the selected profile's resources are merged
+	// over Resources.
+	Profiles map[string]smtest.ResourceSet `json:"profiles,omitempty"`
+
+	// Profile is selected by default, the SMTEST_PROFILE environment
+	// variable overrides it.
+	Profile string `json:"profile,omitempty"`
+
+	// Bundles are named sets of resources that tests commonly require
+	// together e.g. a small cluster.  They don't affect the scheduler, but
+	// cmd/smtest-gen generates constructors for them, so requirements are
+	// defined once alongside the pool.
+	Bundles map[string]smtest.ResourceSet `json:"bundles,omitempty"`
+
+	// CPUResource, see smtest.WithCPUResource.
+	CPUResource string `json:"cpuResource,omitempty"`
+
+	// MemoryResource, see smtest.WithMemoryResource.
+	MemoryResource *MemoryResource `json:"memoryResource,omitempty"`
+
+	// Ordering is either "parallelFirst", the default, or "acquireFirst",
+	// see smtest.Ordering.
+	Ordering string `json:"ordering,omitempty"`
+
+	// Policy is either "firstfit", the default, or "fifo", see
+	// smtest.Policy.
+	Policy string `json:"policy,omitempty"`
+
+	// Requirements, see smtest.WithRequirements.
+	Requirements string `json:"requirements,omitempty"`
+
+	// Passthrough, see smtest.WithPassthrough.
+	Passthrough bool `json:"passthrough,omitempty"`
+
+	// Scale, see smtest.WithScale.
+	Scale float64 `json:"scale,omitempty"`
+
+	// Strict, see smtest.WithStrict.
+	Strict bool `json:"strict,omitempty"`
+
+	// WaitThreshold, see smtest.WithWaitThreshold.
+	WaitThreshold *WaitThreshold `json:"waitThreshold,omitempty"`
+
+	// StarvationWarning, see smtest.WithStarvationWarning.
+	StarvationWarning Duration `json:"starvationWarning,omitempty"`
+
+	// Progress, see smtest.WithProgress.
+	Progress Duration `json:"progress,omitempty"`
+
+	// EnqueueTimeout, see smtest.WithEnqueueTimeout.
+	EnqueueTimeout Duration `json:"enqueueTimeout,omitempty"`
+
+	// ShutdownGrace, see smtest.WithShutdownGrace.
+	ShutdownGrace Duration `json:"shutdownGrace,omitempty"`
+
+	// Budget, see smtest.WithBudget.
+	Budget float64 `json:"budget,omitempty"`
+
+	// Journal, see smtest.WithJournal, stale allocations are kept in the
+	// journal for a sweeper to clean up.
+	Journal string `json:"journal,omitempty"`
+
+	// InterruptCleanup, see smtest.WithInterruptCleanup.
+	InterruptCleanup bool `json:"interruptCleanup,omitempty"`
+
+	// Backend, if set, shares the pool with other processes.
+	Backend *Backend `json:"backend,omitempty"`
+}
+
+// Parse decodes a YAML or JSON configuration.  Environment variables are
+// interpolated, and arithmetic expressions are evaluated, first.  Unknown
+// fields and values of the wrong type are errors, reported with their line.
+func Parse(data []byte) (*Config, error) {
+	data, err := interpolate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if err := validate(data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	data, err = toJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	config := &Config{}
+
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if config.Version != Version {
+		return nil, fmt.Errorf("%w: version %q is not supported, expected %q", ErrInvalidConfig, config.Version, Version)
+	}
+
+	if err := config.checkBundles(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Read decodes a YAML or JSON configuration file.
+func Read(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// ResourceNames returns the name of every resource in the pool, or any of
+// the profiles, in order.
+func (c *Config) ResourceNames() []string {
+	seen := map[string]bool{}
+
+	var names []string
+
+	add := func(resources smtest.ResourceSet) {
+		for k := range resources {
+			if !seen[k] {
+				seen[k] = true
+				names = append(names, k)
+			}
+		}
+	}
+
+	add(c.Resources)
+
+	for _, profile := range c.Profiles {
+		add(profile)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// checkBundles ensures bundles only require resources that are declared.
+func (c *Config) checkBundles() error {
+	declared := map[string]bool{}
+
+	for _, name := range c.ResourceNames() {
+		declared[name] = true
+	}
+
+	for bundle, resources := range c.Bundles {
+		for k := range resources {
+			if !declared[k] {
+				return fmt.Errorf("%w: bundle %q requires undeclared resource %q", ErrInvalidConfig, bundle, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ordering maps the configured ordering to the scheduler's.
+func (c *Config) ordering() (smtest.Ordering, error) {
+	switch c.Ordering {
+	case "", "parallelFirst":
+		return smtest.OrderingParallelFirst, nil
+	case "acquireFirst":
+		return smtest.OrderingAcquireFirst, nil
+	}
+
+	return 0, fmt.Errorf("%w: unknown ordering %q", ErrInvalidConfig, c.Ordering)
+}
+
+// Pool returns the resources to start the scheduler with, including those of
+// the selected profile, if any.
+func (c *Config) Pool() (smtest.ResourceSet, error) {
+	name := c.Profile
+
+	if value := os.Getenv(profileEnvironmentVariable); value != "" {
+		name = value
+	}
+
+	pool := smtest.ResourceSet{}
+
+	for k, v := range c.Resources {
+		pool[k] = v
+	}
+
+	if name == "" {
+		return pool, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown profile %q", ErrInvalidConfig, name)
+	}
+
+	for k, v := range profile {
+		pool[k] = v
+	}
+
+	return pool, nil
+}
+
+// backend creates the configured backend, sharing the pool.
+func (c *Config) backend(pool smtest.ResourceSet) (smtest.Backend, error) {
+	b := c.Backend
+
+	switch b.Type {
+	case BackendBroker:
+		if b.URL == "" {
+			return nil, fmt.Errorf("%w: broker backend requires a url", ErrInvalidConfig)
+		}
+
+		owner := b.Owner
+
+		if owner == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+
+			owner = hostname
+		}
+
+		return broker.NewClient(b.URL, owner), nil
+	case BackendFile:
+		var opts []file.Option
+
+		if b.Directory != "" {
+			opts = append(opts, file.WithDirectory(b.Directory))
+		}
+
+		return file.New(pool, opts...)
+	case BackendLeader:
+		var opts []leader.Option
+
+		if b.Directory != "" {
+			opts = append(opts, leader.WithDirectory(b.Directory))
+		}
+
+		return leader.New(pool, opts...)
+	}
+
+	return nil, fmt.Errorf("%w: unknown backend type %q", ErrInvalidConfig, b.Type)
+}
+
+// Options returns the scheduler options defined by the configuration.  If a
+// backend is configured it's created, so this should only be called once.
+func (c *Config) Options() ([]smtest.Option, error) {
+	ordering, err := c.ordering()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []smtest.Option{
+		smtest.WithOrdering(ordering),
+	}
+
+	if c.Policy != "" {
+		policy, err := smtest.ParsePolicy(c.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+
+		opts = append(opts, smtest.WithPolicy(policy))
+	}
+
+	if c.CPUResource != "" {
+		opts = append(opts, smtest.WithCPUResource(c.CPUResource))
+	}
+
+	if c.MemoryResource != nil {
+		opts = append(opts, smtest.WithMemoryResource(c.MemoryResource.Name, c.MemoryResource.Unit))
+	}
+
+	if c.Requirements != "" {
+		opts = append(opts, smtest.WithRequirements(c.Requirements))
+	}
+
+	if c.Passthrough {
+		opts = append(opts, smtest.WithPassthrough())
+	}
+
+	if c.Scale != 0 {
+		opts = append(opts, smtest.WithScale(c.Scale))
+	}
+
+	if c.Strict {
+		opts = append(opts, smtest.WithStrict())
+	}
+
+	if c.WaitThreshold != nil {
+		opts = append(opts, smtest.WithWaitThreshold(c.WaitThreshold.Percentile, time.Duration(c.WaitThreshold.Threshold)))
+	}
+
+	if c.StarvationWarning > 0 {
+		opts = append(opts, smtest.WithStarvationWarning(time.Duration(c.StarvationWarning)))
+	}
+
+	if c.Progress > 0 {
+		opts = append(opts, smtest.WithProgress(time.Duration(c.Progress)))
+	}
+
+	if c.EnqueueTimeout > 0 {
+		opts = append(opts, smtest.WithEnqueueTimeout(time.Duration(c.EnqueueTimeout)))
+	}
+
+	if c.ShutdownGrace > 0 {
+		opts = append(opts, smtest.WithShutdownGrace(time.Duration(c.ShutdownGrace)))
+	}
+
+	if c.Budget > 0 {
+		opts = append(opts, smtest.WithBudget(c.Budget))
+	}
+
+	if c.Journal != "" {
+		opts = append(opts, smtest.WithJournal(c.Journal, nil))
+	}
+
+	if c.InterruptCleanup {
+		opts = append(opts, smtest.WithInterruptCleanup())
+	}
+
+	if c.Backend != nil {
+		pool, err := c.Pool()
+		if err != nil {
+			return nil, err
+		}
+
+		backend, err := c.backend(pool)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, smtest.WithBackend(backend))
+	}
+
+	return opts, nil
+}
+
+// StartFromConfig reads the configuration file and starts the scheduler with
+// it.  Any options are applied after those from the file, so take precedence.
+func StartFromConfig(path string, opts ...smtest.Option) error {
+	config, err := Read(path)
+	if err != nil {
+		return err
+	}
+
+	pool, err := config.Pool()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	configured, err := config.Options()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	smtest.Start(pool, append(configured, opts...)...)
+
+	return nil
+}
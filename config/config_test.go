@@ -0,0 +1,204 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const yamlConfig = `
+version: smtest/v1
+resources:
+  cpu: 16
+  memory: 64
+cpuResource: cpu
+memoryResource:
+  name: memory
+  unit: 1073741824
+ordering: acquireFirst
+policy: fifo
+strict: true
+waitThreshold:
+  percentile: 90
+  threshold: 30s
+starvationWarning: 5m
+bundles:
+  cluster:
+    cpu: 4
+    memory: 16
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	config, err := Parse([]byte(yamlConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Resources.String() != "cpu=16,memory=64" {
+		t.Fatalf("unexpected resources %v", config.Resources)
+	}
+
+	if config.MemoryResource == nil || config.MemoryResource.Unit != 1<<30 {
+		t.Fatalf("unexpected memory resource %v", config.MemoryResource)
+	}
+
+	if config.Bundles["cluster"].String() != "cpu=4,memory=16" {
+		t.Fatalf("unexpected bundles %v", config.Bundles)
+	}
+
+	if time.Duration(config.StarvationWarning) != 5*time.Minute || time.Duration(config.WaitThreshold.Threshold) != 30*time.Second {
+		t.Fatal("expected durations to be parsed")
+	}
+
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ordering, policy, CPU, memory, strict, wait threshold and starvation.
+	if len(opts) != 7 {
+		t.Fatalf("expected 7 options, got %d", len(opts))
+	}
+
+	// JSON is YAML.
+	config, err = Parse([]byte(`{"version": "smtest/v1", "resources": {"cpu": 4}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Resources["cpu"] != 4 {
+		t.Fatalf("unexpected resources %v", config.Resources)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{
+		`resources: {cpu: 4}`,
+		`{version: smtest/v2, resources: {cpu: 4}}`,
+		`{version: smtest/v1, resources: {cpu: lots}}`,
+		`{version: smtest/v1, starvationWarning: soon}`,
+		`{version: smtest/v1, resources: {cpu: 4}, bundles: {cluster: {gpu: 1}}}`,
+	} {
+		if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected %q to be invalid, got %v", data, err)
+		}
+	}
+
+	for _, config := range []*Config{
+		{Ordering: "random"},
+		{Policy: "lifo"},
+		{Backend: &Backend{Type: "carrier-pigeon"}},
+		{Backend: &Backend{Type: BackendBroker}},
+	} {
+		if _, err := config.Options(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("expected %+v to be invalid, got %v", config, err)
+		}
+	}
+}
+
+// TestPool is not parallel as it modifies the environment.
+func TestPool(t *testing.T) {
+	config, err := Parse([]byte(`
+version: smtest/v1
+resources:
+  cpu: 16
+  memory: 64
+profiles:
+  laptop:
+    cpu: 4
+    memory: 8
+  nightly:
+    cpu: 64
+    gpu: 2
+profile: laptop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(profileEnvironmentVariable, "")
+
+	pool, err := config.Pool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.String() != "cpu=4,memory=8" {
+		t.Fatalf("unexpected default profile pool %v", pool)
+	}
+
+	// The environment overrides the default, and profiles are merged over
+	// the base resources.
+	t.Setenv(profileEnvironmentVariable, "nightly")
+
+	pool, err = config.Pool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.String() != "cpu=64,gpu=2,memory=64" {
+		t.Fatalf("unexpected nightly profile pool %v", pool)
+	}
+
+	if config.Resources.String() != "cpu=16,memory=64" {
+		t.Fatalf("expected resources to be unmodified, got %v", config.Resources)
+	}
+
+	if names := config.ResourceNames(); strings.Join(names, ",") != "cpu,gpu,memory" {
+		t.Fatalf("unexpected resource names %v", names)
+	}
+
+	t.Setenv(profileEnvironmentVariable, "mainframe")
+
+	if _, err := config.Pool(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected unknown profile to be invalid, got %v", err)
+	}
+}
+
+// TestStartFromConfig is not parallel as it starts the scheduler.
+func TestStartFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtest.yaml")
+
+	if err := os.WriteFile(path, []byte(yamlConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StartFromConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer smtest.Stop()
+
+	// Acquire makes the test parallel, so group it to stop the scheduler
+	// once it ends.
+	t.Run("Group", func(t *testing.T) {
+		t.Run("Acquire", func(t *testing.T) {
+			smtest.Acquire(t, smtest.ResourceSet{"cpu": 16}).Release()
+		})
+	})
+}
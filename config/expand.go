@@ -0,0 +1,264 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// ErrUnsetVariable is returned when the configuration references an
+	// environment variable that is not set, and has no default.
+	ErrUnsetVariable = errors.New("environment variable not set")
+
+	// variablePattern matches ${NAME} and ${NAME:-default}.
+	variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+	// expressionPattern matches strings that may be arithmetic expressions.
+	expressionPattern = regexp.MustCompile(`^[0-9.\s()+\-*/]*[0-9][0-9.\s()+\-*/]*$`)
+)
+
+// toJSON converts a YAML or JSON document to JSON, evaluating arithmetic.
+func toJSON(data []byte) ([]byte, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document any
+
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(evaluateAll(document))
+}
+
+// interpolate replaces ${NAME} with the value of the environment variable, or
+// ${NAME:-default} with the default if it's not set.
+func interpolate(data []byte) ([]byte, error) {
+	var errs []error
+
+	result := variablePattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := variablePattern.FindSubmatch(match)
+
+		if value, ok := os.LookupEnv(string(groups[1])); ok {
+			return []byte(value)
+		}
+
+		if groups[2] != nil {
+			return groups[3]
+		}
+
+		errs = append(errs, fmt.Errorf("%w: %s", ErrUnsetVariable, groups[1]))
+
+		return match
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// evaluateAll replaces every string in a decoded JSON document that is an
+// arithmetic expression e.g. "16 - 2" with its value.
+func evaluateAll(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = evaluateAll(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = evaluateAll(e)
+		}
+	case string:
+		if n, ok := evaluate(t); ok {
+			return n
+		}
+	}
+
+	return v
+}
+
+// evaluate returns the value of an arithmetic expression of numbers, +, -, *,
+// / and parentheses, and whether the string was one.  If every number is an
+// integer, division truncates, so resource quantities stay integers.  Strings
+// without an operator are left alone, as YAML quoted them deliberately.
+func evaluate(s string) (json.Number, bool) {
+	if !expressionPattern.MatchString(s) || !strings.ContainsAny(s, "+-*/") {
+		return "", false
+	}
+
+	e := &expression{
+		input:   strings.ReplaceAll(s, " ", ""),
+		integer: !strings.Contains(s, "."),
+	}
+
+	value, err := e.sum()
+	if err != nil || e.pos != len(e.input) || math.IsInf(value, 0) || math.IsNaN(value) {
+		return "", false
+	}
+
+	if e.integer {
+		return json.Number(strconv.FormatInt(int64(value), 10)), true
+	}
+
+	return json.Number(strconv.FormatFloat(value, 'f', -1, 64)), true
+}
+
+// expression is a recursive descent parser for arithmetic.
+type expression struct {
+	// input is the expression without spaces.
+	input string
+
+	// pos is the next character to parse.
+	pos int
+
+	// integer is set when division should truncate.
+	integer bool
+}
+
+// errSyntax is returned when the input is not an expression.
+var errSyntax = errors.New("syntax error")
+
+// peek returns the next character, or 0 at the end of the input.
+func (e *expression) peek() byte {
+	if e.pos < len(e.input) {
+		return e.input[e.pos]
+	}
+
+	return 0
+}
+
+// sum parses terms separated by + and -.
+func (e *expression) sum() (float64, error) {
+	value, err := e.product()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+
+		if op != '+' && op != '-' {
+			return value, nil
+		}
+
+		e.pos++
+
+		rhs, err := e.product()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '+' {
+			value += rhs
+		} else {
+			value -= rhs
+		}
+	}
+}
+
+// product parses factors separated by * and /.
+func (e *expression) product() (float64, error) {
+	value, err := e.factor()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+
+		if op != '*' && op != '/' {
+			return value, nil
+		}
+
+		e.pos++
+
+		rhs, err := e.factor()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '*' {
+			value *= rhs
+
+			continue
+		}
+
+		if rhs == 0 {
+			return 0, errSyntax
+		}
+
+		value /= rhs
+
+		if e.integer {
+			value = math.Trunc(value)
+		}
+	}
+}
+
+// factor parses a number, a negated factor or a parenthesized sum.
+func (e *expression) factor() (float64, error) {
+	switch e.peek() {
+	case '-':
+		e.pos++
+
+		value, err := e.factor()
+
+		return -value, err
+	case '(':
+		e.pos++
+
+		value, err := e.sum()
+		if err != nil {
+			return 0, err
+		}
+
+		if e.peek() != ')' {
+			return 0, errSyntax
+		}
+
+		e.pos++
+
+		return value, nil
+	}
+
+	start := e.pos
+
+	for c := e.peek(); c >= '0' && c <= '9' || c == '.'; c = e.peek() {
+		e.pos++
+	}
+
+	return strconv.ParseFloat(e.input[start:e.pos], 64)
+}
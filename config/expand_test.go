@@ -0,0 +1,88 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	for expression, expected := range map[string]string{
+		"16 - 2":       "14",
+		"2 + 3 * 4":    "14",
+		"(2 + 3) * 4":  "20",
+		"7 / 2":        "3",
+		"7.0 / 2":      "3.5",
+		"-4 + 6":       "2",
+		"64 * 3 / 4":   "48",
+		"--1":          "1",
+		"1.5 * 2 - .5": "2.5",
+	} {
+		value, ok := evaluate(expression)
+		if !ok {
+			t.Fatalf("expected %q to be an expression", expression)
+		}
+
+		if value.String() != expected {
+			t.Fatalf("expected %q to be %s, got %s", expression, expected, value)
+		}
+	}
+
+	for _, s := range []string{"16", "5m", "acquireFirst", "1 +", "(1", "1 / 0", "/tmp", "1..2 + 1", ""} {
+		if value, ok := evaluate(s); ok {
+			t.Fatalf("expected %q not to be evaluated, got %s", s, value)
+		}
+	}
+}
+
+// TestParseExpanded is not parallel as it modifies the environment.
+func TestParseExpanded(t *testing.T) {
+	t.Setenv("SMTEST_TEST_CPUS", "16")
+
+	config, err := Parse([]byte(`
+version: smtest/v1
+resources:
+  cpu: ${SMTEST_TEST_CPUS} - 2
+  memory: ${SMTEST_TEST_MEMORY:-8} * 4
+budget: ${SMTEST_TEST_CPUS} * 0.25
+ordering: ${SMTEST_TEST_ORDERING:-acquireFirst}
+starvationWarning: 5m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Resources.String() != "cpu=14,memory=32" {
+		t.Fatalf("unexpected resources %v", config.Resources)
+	}
+
+	if config.Budget != 4 {
+		t.Fatalf("unexpected budget %v", config.Budget)
+	}
+
+	if config.Ordering != "acquireFirst" {
+		t.Fatalf("unexpected ordering %q", config.Ordering)
+	}
+
+	_, err = Parse([]byte(`{version: smtest/v1, resources: {cpu: "${SMTEST_TEST_UNSET}"}}`))
+	if !errors.Is(err, ErrInvalidConfig) || !errors.Is(err, ErrUnsetVariable) {
+		t.Fatalf("expected unset variable to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/spjmurray/testing/config
+
+go 1.21.1
+
+require (
+	github.com/spjmurray/testing v0.1.0
+	sigs.k8s.io/yaml v1.4.0
+)
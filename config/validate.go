@@ -0,0 +1,269 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+// validate checks a YAML or JSON document against the configuration format,
+// returning every unknown field, and every value of the wrong type, with the
+// line it's on, rather than silently ignoring misspelled fields.
+func validate(data []byte) error {
+	var document yaml.Node
+
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	return errors.Join(check(document.Content[0], reflect.TypeOf(Config{}), "")...)
+}
+
+// fields returns a struct's fields keyed by their JSON name.
+func fields(t reflect.Type) map[string]reflect.StructField {
+	result := map[string]reflect.StructField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		result[name] = field
+	}
+
+	return result
+}
+
+// join appends a field to a path e.g. "backend.type".
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// check validates a node against the type it's decoded into.
+func check(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		return nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Types that decode themselves e.g. Duration are scalars.
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return checkScalar(node, t, path)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return checkStruct(node, t, path)
+	case reflect.Map:
+		return checkMap(node, t, path)
+	case reflect.Slice:
+		return checkSlice(node, t, path)
+	case reflect.Interface:
+		return nil
+	}
+
+	return checkScalar(node, t, path)
+}
+
+// checkStruct validates a mapping against a struct's fields.
+func checkStruct(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind != yaml.MappingNode {
+		return []error{mismatch(node, path, "a mapping")}
+	}
+
+	known := fields(t)
+
+	var errs []error
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		field, ok := known[key.Value]
+		if !ok {
+			errs = append(errs, fmt.Errorf("line %d: unknown field %q%s", key.Line, join(path, key.Value), suggest(key.Value, known)))
+
+			continue
+		}
+
+		errs = append(errs, check(value, field.Type, join(path, key.Value))...)
+	}
+
+	return errs
+}
+
+// checkMap validates every value of a mapping.
+func checkMap(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind != yaml.MappingNode {
+		return []error{mismatch(node, path, "a mapping")}
+	}
+
+	var errs []error
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		errs = append(errs, check(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value))...)
+	}
+
+	return errs
+}
+
+// checkSlice validates every item of a sequence.
+func checkSlice(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind != yaml.SequenceNode {
+		return []error{mismatch(node, path, "a list")}
+	}
+
+	var errs []error
+
+	for i, item := range node.Content {
+		errs = append(errs, check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+	}
+
+	return errs
+}
+
+// checkScalar validates a scalar by decoding it, after any arithmetic is
+// evaluated, as the configuration will be.
+func checkScalar(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind != yaml.ScalarNode {
+		return []error{mismatch(node, path, typeName(t))}
+	}
+
+	var value any
+
+	if err := node.Decode(&value); err != nil {
+		return []error{fmt.Errorf("line %d: %s: %w", node.Line, path, err)}
+	}
+
+	data, err := json.Marshal(evaluateAll(value))
+	if err != nil {
+		return []error{fmt.Errorf("line %d: %s: %w", node.Line, path, err)}
+	}
+
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+		return []error{fmt.Errorf("line %d: %s: cannot use %q as %s", node.Line, path, node.Value, typeName(t))}
+	}
+
+	return nil
+}
+
+// mismatch reports a node of the wrong kind.
+func mismatch(node *yaml.Node, path, expected string) error {
+	kinds := map[yaml.Kind]string{
+		yaml.MappingNode:  "a mapping",
+		yaml.SequenceNode: "a list",
+		yaml.ScalarNode:   fmt.Sprintf("%q", node.Value),
+	}
+
+	return fmt.Errorf("line %d: %s: expected %s, got %s", node.Line, path, expected, kinds[node.Kind])
+}
+
+// typeName describes a type for error messages.
+func typeName(t reflect.Type) string {
+	if t == reflect.TypeOf(Duration(0)) {
+		return "a duration e.g. 90s"
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	}
+
+	return "a " + t.Kind().String()
+}
+
+// suggest returns a hint naming the closest known field, if any is close
+// enough to be a likely misspelling.
+func suggest(name string, known map[string]reflect.StructField) string {
+	names := make([]string, 0, len(known))
+
+	for k := range known {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	best, distance := "", 3
+
+	for _, k := range names {
+		if d := levenshtein(strings.ToLower(name), strings.ToLower(k)); d < distance {
+			best, distance = k, d
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous = current
+	}
+
+	return previous[len(b)]
+}
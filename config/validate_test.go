@@ -0,0 +1,72 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := validate([]byte(yamlConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := validate([]byte(`version: smtest/v1
+resources:
+  cpu: lots
+  memory: 16 * 4
+ordrring: acquireFirst
+starvationWarning: soon
+backend:
+  type: file
+  directroy: /tmp
+profiles: [laptop]
+`))
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+
+	for _, expected := range []string{
+		`line 3: resources.cpu: cannot use "lots" as an integer`,
+		`line 5: unknown field "ordrring", did you mean "ordering"?`,
+		`line 6: starvationWarning: cannot use "soon" as a duration e.g. 90s`,
+		`line 9: unknown field "backend.directroy", did you mean "directory"?`,
+		`line 10: profiles: expected a mapping, got a list`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in:\n%v", expected, err)
+		}
+	}
+
+	// Arithmetic is evaluated before checking.
+	if strings.Contains(err.Error(), "memory") {
+		t.Fatalf("expected arithmetic to be valid, got:\n%v", err)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse([]byte(`{version: smtest/v1, resources: {cpu: 4}, strickt: true}`))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `unknown field "strickt", did you mean "strict"?`) {
+		t.Fatalf("expected unknown field to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// Watch polls the configuration file every interval while the scheduler runs,
+// and resizes the pool when its resources, or those of the selected profile,
+// change, so an operator can open up more quota part way through a long run
+// without restarting it.  Other settings are only read at start.  If the file
+// can't be read, or the pool can't be resized, the error is passed to the
+// handler, if set, and the pool is left as it was.  The returned function
+// stops watching, and must be called before smtest.Stop e.g.
+//
+//	stop := config.Watch("smtest.yaml", time.Minute, nil)
+//	code := m.Run()
+//	stop()
+func Watch(path string, interval time.Duration, handler func(error)) func() {
+	w := &watcher{
+		path:    path,
+		handler: handler,
+		stop:    make(chan struct{}),
+	}
+
+	// Resizing to the pool we started with would be redundant.
+	if config, err := Read(path); err == nil {
+		if pool, err := config.Pool(); err == nil {
+			w.pool = pool.String()
+		}
+	}
+
+	w.wg.Add(1)
+
+	go w.run(interval)
+
+	return func() {
+		close(w.stop)
+		w.wg.Wait()
+	}
+}
+
+// watcher polls a configuration file.
+type watcher struct {
+	// path is the configuration file.
+	path string
+
+	// handler, if set, is called with any errors.
+	handler func(error)
+
+	// pool is the last pool that was applied.
+	pool string
+
+	// stop is closed to stop polling.
+	stop chan struct{}
+
+	// wg is used to wait for polling to stop.
+	wg sync.WaitGroup
+}
+
+// run polls the file until stopped.
+func (w *watcher) run(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.reload(); err != nil && w.handler != nil {
+				w.handler(err)
+			}
+		}
+	}
+}
+
+// reload resizes the pool if the configuration has changed.
+func (w *watcher) reload() error {
+	config, err := Read(w.path)
+	if err != nil {
+		return err
+	}
+
+	pool, err := config.Pool()
+	if err != nil {
+		return err
+	}
+
+	if pool.String() == w.pool {
+		return nil
+	}
+
+	if err := smtest.Resize(pool); err != nil {
+		return err
+	}
+
+	w.pool = pool.String()
+
+	return nil
+}
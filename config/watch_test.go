@@ -0,0 +1,92 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// TestWatch is not parallel as it starts the scheduler.
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtest.yaml")
+
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{version: smtest/v1, resources: {cpu: 4}}`)
+
+	if err := StartFromConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer smtest.Stop()
+
+	errs := make(chan error, 1)
+
+	stop := Watch(path, 10*time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	// Invalid edits are reported, and leave the pool alone.
+	write(`{version: smtest/v1, resources: {cpu: lots}}`)
+
+	select {
+	case <-errs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected invalid configuration to be reported")
+	}
+
+	stop()
+
+	// Reload synchronously, rather than racing the watcher.
+	write(`{version: smtest/v1, resources: {cpu: 8}}`)
+
+	w := &watcher{
+		path: path,
+	}
+
+	if err := w.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := false
+
+	// Acquire makes the test parallel, so group it to wait for it to end.
+	t.Run("Group", func(t *testing.T) {
+		t.Run("Large", func(t *testing.T) {
+			// This would be skipped if the pool wasn't resized.
+			smtest.Acquire(t, smtest.ResourceSet{"cpu": 8}).Release()
+
+			ran = true
+		})
+	})
+
+	if !ran {
+		t.Fatal("expected test requiring the resized pool to run")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Prices are the cost of holding one unit of each resource for an hour, for
+// example an instance type's hourly rate divided by its vCPUs.  Resources
+// without a price are free.
+type Prices map[string]float64
+
+// ReadPrices reads prices from a JSON file that maps resource names to their
+// hourly price.
+func ReadPrices(path string) (Prices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prices Prices
+
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// Cost returns the cost of holding the resources for the duration.
+func (p Prices) Cost(resources ResourceSet, d time.Duration) float64 {
+	var cost float64
+
+	for k, v := range resources {
+		cost += p[k] * float64(v) * d.Hours()
+	}
+
+	return cost
+}
+
+// costs returns the total cost of the run, and the cost of each test and team.
+// Allocations that are still held are costed up until now.
+func costs(summaries map[string]*summary, records map[*record]interface{}, o *options, now time.Time) (float64, map[string]float64, map[string]float64) {
+	var total float64
+
+	tests := map[string]float64{}
+	teams := map[string]float64{}
+
+	charge := func(name string, cost float64) {
+		total += cost
+		tests[name] += cost
+
+		if o.team != nil {
+			teams[o.team(name)] += cost
+		}
+	}
+
+	for name, s := range summaries {
+		var cost float64
+
+		for k, v := range s.usage {
+			cost += o.prices[k] * v
+		}
+
+		charge(name, cost)
+	}
+
+	for r := range records {
+		if r.scheduled.IsZero() {
+			continue
+		}
+
+		charge(r.name, o.prices.Cost(r.required, now.Sub(r.scheduled)))
+	}
+
+	return total, tests, teams
+}
+
+// byCost returns the keys of the map, most expensive first.
+func byCost(costs map[string]float64) []string {
+	names := make([]string, 0, len(costs))
+
+	for name := range costs {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if costs[names[i]] != costs[names[j]] {
+			return costs[names[i]] > costs[names[j]]
+		}
+
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// reportCosts prints the cost of the run, by team and by test, and checks it
+// against the budget.
+func reportCosts(summaries map[string]*summary, records map[*record]interface{}) {
+	total, tests, teams := costs(summaries, records, &config, time.Now())
+
+	emit(nil, event{
+		Action:  "cost",
+		Message: fmt.Sprintf("run cost %.2f", total),
+	})
+
+	for _, team := range byCost(teams) {
+		emit(nil, event{
+			Action:  "cost",
+			Message: fmt.Sprintf("team %s cost %.2f", team, teams[team]),
+		})
+	}
+
+	for _, test := range byCost(tests) {
+		emit(nil, event{
+			Action:  "cost",
+			Test:    test,
+			Message: fmt.Sprintf("%s cost %.2f", test, tests[test]),
+		})
+	}
+
+	if config.budget > 0 && total > config.budget {
+		raise(Alert{
+			Kind:    AlertBudget,
+			Message: fmt.Sprintf("run cost %.2f exceeds budget %.2f", total, config.budget),
+		})
+	}
+}
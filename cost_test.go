@@ -0,0 +1,100 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCosts(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	records := []*record{
+		{name: "TestA", required: ResourceSet{"cpu": 2}, scheduled: now.Add(-2 * time.Hour), released: now.Add(-time.Hour)},
+		{name: "TestA", required: ResourceSet{"cpu": 2, "gpu": 1}, scheduled: now.Add(-time.Hour), released: now.Add(-30 * time.Minute)},
+		{name: "TestB", required: ResourceSet{"cpu": 1}, scheduled: now.Add(-time.Hour)},
+		{name: "TestC", required: ResourceSet{"cpu": 8}},
+	}
+
+	o := &options{
+		prices: Prices{"cpu": 0.5, "gpu": 3},
+		team: func(test string) string {
+			if strings.HasPrefix(test, "TestA") {
+				return "a-team"
+			}
+
+			return "other"
+		},
+	}
+
+	live := map[*record]interface{}{}
+
+	for _, r := range records {
+		if r.released.IsZero() {
+			live[r] = nil
+		}
+	}
+
+	total, tests, teams := costs(summarize(records), live, o, now)
+
+	// TestA: 2*0.5*1 + (2*0.5+3)*0.5, TestB is still running so is costed
+	// until now, TestC was never scheduled.
+	expected := map[string]float64{
+		"TestA": 3,
+		"TestB": 0.5,
+	}
+
+	for name, cost := range expected {
+		if math.Abs(tests[name]-cost) > 1e-9 {
+			t.Fatalf("expected %s to cost %v, got %v", name, cost, tests[name])
+		}
+	}
+
+	if math.Abs(total-3.5) > 1e-9 || math.Abs(teams["a-team"]-3) > 1e-9 || math.Abs(teams["other"]-0.5) > 1e-9 {
+		t.Fatalf("unexpected totals %v %v", total, teams)
+	}
+
+	if names := byCost(tests); names[0] != "TestA" {
+		t.Fatalf("expected most expensive test first, got %v", names)
+	}
+}
+
+func TestReadPrices(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "prices.json")
+
+	if err := os.WriteFile(path, []byte(`{"cpu": 0.0416, "gpu": 2.5}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	prices, err := ReadPrices(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prices["cpu"] != 0.0416 || prices["gpu"] != 2.5 {
+		t.Fatalf("unexpected prices %v", prices)
+	}
+}
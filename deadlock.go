@@ -0,0 +1,223 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// deadlockInterval is how often the queue is checked for deadlock.
+	deadlockInterval = time.Second
+)
+
+var (
+	// ErrDeadlock is raised when queued tests can never be granted their
+	// resources, for example because they are subtests of a test that holds
+	// the resources and only releases them once its subtests complete.
+	ErrDeadlock = errors.New("deadlock")
+)
+
+var (
+	// suspected is set when the last check found the queue deadlocked, it is
+	// only acted on if the next check agrees, so the scheduler is never failed
+	// in the middle of a grant or release.  It is owned by the scheduler
+	// goroutine.
+	suspected bool
+)
+
+// holder is a test holding resources.
+type holder struct {
+	// name is the test name.
+	name string
+
+	// paused is set while the test waits to run in parallel.
+	paused bool
+}
+
+// isAncestor returns whether the test named parent is an ancestor of the test
+// named child.
+func isAncestor(parent, child string) bool {
+	return strings.HasPrefix(child, parent+"/")
+}
+
+// isSibling returns whether two tests have the same parent.
+func isSibling(a, b string) bool {
+	return a[:strings.LastIndex(a, "/")+1] == b[:strings.LastIndex(b, "/")+1]
+}
+
+// blockedBy returns why the holder can't release its resources until the
+// queued test completes, or an empty string if it can.  A parent only releases
+// once its subtests complete, and a test paused waiting to run in parallel only
+// resumes once its queued sibling returns.
+func blockedBy(h holder, queued string) string {
+	switch {
+	case isAncestor(h.name, queued):
+		return "its parent " + h.name
+	case h.paused && isSibling(h.name, queued):
+		return h.name + " waiting to run in parallel"
+	}
+
+	return ""
+}
+
+// fits returns whether the required resources are all free.
+func fits(free, required ResourceSet) bool {
+	for k, v := range required {
+		if free[k] < v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// deadlocked returns whether the queue can never progress.  That is nothing
+// queued fits in what is free, and every test holding resources is blocked by
+// a queued test, so cannot release them until that test completes.  Held
+// allocations may be unknown when they have been granted but the test hasn't
+// recorded them, or are being released, in which case progress is possible.
+func deadlocked(free ResourceSet, queue map[string]*queueItem, holders []holder, unknown bool) bool {
+	if len(queue) == 0 || unknown {
+		return false
+	}
+
+	for _, item := range queue {
+		if fits(free, item.required) {
+			return false
+		}
+	}
+
+	for _, h := range holders {
+		blocked := false
+
+		for _, item := range queue {
+			if blockedBy(h, item.record.name) != "" {
+				blocked = true
+				break
+			}
+		}
+
+		if !blocked {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describeDeadlock reports what each stuck test is waiting for, sorted so the
+// report is stable.
+func describeDeadlock(queue map[string]*queueItem, holders []holder) string {
+	lines := make([]string, 0, len(queue))
+
+	for _, item := range queue {
+		var blockers []string
+
+		for _, h := range holders {
+			if reason := blockedBy(h, item.record.name); reason != "" {
+				blockers = append(blockers, reason)
+			}
+		}
+
+		line := fmt.Sprintf("%s waiting for %v", item.record.name, item.required)
+
+		if len(blockers) > 0 {
+			sort.Strings(blockers)
+
+			line += fmt.Sprintf(", held by %s", strings.Join(blockers, ", "))
+		}
+
+		lines = append(lines, line)
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "; ")
+}
+
+// heldBy returns the tests holding the local leases, and whether any lease
+// doesn't belong to a recorded holder.
+func heldBy() ([]holder, bool) {
+	local.lock.Lock()
+	defer local.lock.Unlock()
+
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	holders := make([]holder, 0, len(held))
+
+	ids := map[string]bool{}
+
+	for r := range held {
+		holders = append(holders, holder{
+			name:   r.name,
+			paused: r.paused,
+		})
+
+		ids[r.id] = true
+	}
+
+	if len(ids) != len(local.leases) {
+		return holders, true
+	}
+
+	for id := range local.leases {
+		if !ids[id] {
+			return holders, true
+		}
+	}
+
+	return holders, false
+}
+
+// checkDeadlock is called periodically by the scheduler, and fails every
+// queued test once the queue is found deadlocked twice in a row, rather than
+// leaving the run to hang until the test timeout.
+func checkDeadlock() {
+	holders, unknown := heldBy()
+
+	if !deadlocked(local.Free(), queue.items, holders, unknown) {
+		suspected = false
+		return
+	}
+
+	if !suspected {
+		suspected = true
+		return
+	}
+
+	suspected = false
+
+	err := fmt.Errorf("%w: %s", ErrDeadlock, describeDeadlock(queue.items, holders))
+
+	raise(Alert{
+		Kind:    AlertDeadlock,
+		Message: err.Error(),
+	})
+
+	for id, item := range queue.items {
+		item.err = err
+
+		queue.remove(id)
+		close(item.wait)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+func TestDeadlocked(t *testing.T) {
+	t.Parallel()
+
+	queue := map[string]*queueItem{
+		"b": {
+			required: ResourceSet{"cpu": 4},
+			record:   &record{id: "b", name: "TestA/sub"},
+		},
+	}
+
+	free := ResourceSet{"cpu": 2}
+
+	// The parent holds what its subtest needs, and only releases it once the
+	// subtest completes...
+	if !deadlocked(free, queue, []holder{{name: "TestA"}}, false) {
+		t.Fatal("expected subtest waiting on its parent to deadlock")
+	}
+
+	// ... but an unrelated holder will release eventually...
+	if deadlocked(free, queue, []holder{{name: "TestA"}, {name: "TestAB"}}, false) {
+		t.Fatal("expected unrelated holder to make progress")
+	}
+
+	// ... as will an allocation that's being granted or released...
+	if deadlocked(free, queue, []holder{{name: "TestA"}}, true) {
+		t.Fatal("expected unknown holder to make progress")
+	}
+
+	// ... and anything that fits will be granted.
+	if deadlocked(ResourceSet{"cpu": 4}, queue, []holder{{name: "TestA"}}, false) {
+		t.Fatal("expected test that fits to make progress")
+	}
+
+	if deadlocked(free, map[string]*queueItem{}, nil, false) {
+		t.Fatal("expected empty queue to make progress")
+	}
+
+	// A sibling waiting to run in parallel only resumes once the queued test
+	// returns...
+	if !deadlocked(free, queue, []holder{{name: "TestA/other", paused: true}}, false) {
+		t.Fatal("expected paused sibling to deadlock")
+	}
+
+	// ... but a running one will release eventually.
+	if deadlocked(free, queue, []holder{{name: "TestA/other"}}, false) {
+		t.Fatal("expected running sibling to make progress")
+	}
+}
+
+func TestDescribeDeadlock(t *testing.T) {
+	t.Parallel()
+
+	queue := map[string]*queueItem{
+		"b": {
+			required: ResourceSet{"cpu": 4},
+			record:   &record{id: "b", name: "TestA/sub"},
+		},
+		"c": {
+			required: ResourceSet{"cpu": 1},
+			record:   &record{id: "c", name: "TestB"},
+		},
+	}
+
+	expected := "TestA/sub waiting for cpu=4, held by its parent TestA; TestB waiting for cpu=1, held by TestC waiting to run in parallel"
+
+	if actual := describeDeadlock(queue, []holder{{name: "TestA"}, {name: "TestC", paused: true}}); actual != expected {
+		t.Fatalf("expected %q, got %q", expected, actual)
+	}
+}
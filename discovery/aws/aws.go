@@ -0,0 +1,229 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package aws discovers the remaining regional quota of an AWS account, so
+// cloud e2e tests stop tripping LimitExceeded errors e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   cfg, err := config.LoadDefaultConfig(context.Background())
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   resources, err := smtestaws.Quota(context.Background(), servicequotas.NewFromConfig(cfg), ec2.NewFromConfig(cfg))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	quotatypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+)
+
+const (
+	// ResourceVCPUs is the number of vCPUs available to standard (A, C, D,
+	// H, I, M, R, T and Z) on-demand instances.
+	ResourceVCPUs = "vcpus"
+
+	// ResourceElasticIPs is the number of elastic IP addresses.
+	ResourceElasticIPs = "eips"
+
+	// ResourceVPCs is the number of VPCs.
+	ResourceVPCs = "vpcs"
+)
+
+// quota identifies a service quota.
+type quota struct {
+	// serviceCode is the service that owns the quota.
+	serviceCode string
+
+	// quotaCode identifies the quota within the service.
+	quotaCode string
+}
+
+var (
+	// quotas maps resources to their service quotas.
+	quotas = map[string]quota{
+		ResourceVCPUs:      {serviceCode: "ec2", quotaCode: "L-1216C47A"},
+		ResourceElasticIPs: {serviceCode: "ec2", quotaCode: "L-0263D0A3"},
+		ResourceVPCs:       {serviceCode: "vpc", quotaCode: "L-F678F1CE"},
+	}
+
+	// nonStandardPrefixes are instance families that begin with a standard
+	// family letter, but are accounted against a different quota.
+	nonStandardPrefixes = []string{"dl", "hpc", "inf", "mac", "trn", "u-"}
+)
+
+// QuotaAPI is the subset of the Service Quotas client that is required.
+type QuotaAPI interface {
+	GetServiceQuota(context.Context, *servicequotas.GetServiceQuotaInput, ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error)
+	GetAWSDefaultServiceQuota(context.Context, *servicequotas.GetAWSDefaultServiceQuotaInput, ...func(*servicequotas.Options)) (*servicequotas.GetAWSDefaultServiceQuotaOutput, error)
+}
+
+// EC2API is the subset of the EC2 client that is required.
+type EC2API interface {
+	ec2.DescribeInstancesAPIClient
+	ec2.DescribeVpcsAPIClient
+	DescribeAddresses(context.Context, *ec2.DescribeAddressesInput, ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+}
+
+// limit returns the applied value of a quota, or the AWS default if it has
+// never been changed for the account.
+func limit(ctx context.Context, client QuotaAPI, q quota) (int, error) {
+	output, err := client.GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(q.serviceCode),
+		QuotaCode:   aws.String(q.quotaCode),
+	})
+	if err == nil {
+		return int(aws.ToFloat64(output.Quota.Value)), nil
+	}
+
+	var notFound *quotatypes.NoSuchResourceException
+
+	if !errors.As(err, &notFound) {
+		return 0, err
+	}
+
+	defaultOutput, err := client.GetAWSDefaultServiceQuota(ctx, &servicequotas.GetAWSDefaultServiceQuotaInput{
+		ServiceCode: aws.String(q.serviceCode),
+		QuotaCode:   aws.String(q.quotaCode),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(aws.ToFloat64(defaultOutput.Quota.Value)), nil
+}
+
+// standard returns whether the instance type counts against the standard
+// on-demand instance quota.
+func standard(instanceType ec2types.InstanceType) bool {
+	t := string(instanceType)
+
+	for _, prefix := range nonStandardPrefixes {
+		if strings.HasPrefix(t, prefix) {
+			return false
+		}
+	}
+
+	return t != "" && strings.ContainsRune("acdhimrtz", rune(t[0]))
+}
+
+// usedVCPUs returns the vCPUs used by running standard instances.
+func usedVCPUs(ctx context.Context, client EC2API) (int, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running", "stopping"},
+			},
+		},
+	}
+
+	var used int
+
+	paginator := ec2.NewDescribeInstancesPaginator(client, input)
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if !standard(instance.InstanceType) || instance.CpuOptions == nil {
+					continue
+				}
+
+				used += int(aws.ToInt32(instance.CpuOptions.CoreCount) * aws.ToInt32(instance.CpuOptions.ThreadsPerCore))
+			}
+		}
+	}
+
+	return used, nil
+}
+
+// usedVPCs returns the number of VPCs in the region.
+func usedVPCs(ctx context.Context, client EC2API) (int, error) {
+	var used int
+
+	paginator := ec2.NewDescribeVpcsPaginator(client, &ec2.DescribeVpcsInput{})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		used += len(page.Vpcs)
+	}
+
+	return used, nil
+}
+
+// usedElasticIPs returns the number of elastic IPs allocated in the region.
+func usedElasticIPs(ctx context.Context, client EC2API) (int, error) {
+	output, err := client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(output.Addresses), nil
+}
+
+// Quota returns the remaining headroom of the vCPU, elastic IP and VPC quotas
+// for the region the clients are configured for, as the quota minus what is
+// currently in use.
+func Quota(ctx context.Context, quotaClient QuotaAPI, ec2Client EC2API) (smtest.ResourceSet, error) {
+	usage := map[string]func(context.Context, EC2API) (int, error){
+		ResourceVCPUs:      usedVCPUs,
+		ResourceElasticIPs: usedElasticIPs,
+		ResourceVPCs:       usedVPCs,
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for name, q := range quotas {
+		limit, err := limit(ctx, quotaClient, q)
+		if err != nil {
+			return nil, err
+		}
+
+		used, err := usage[name](ctx, ec2Client)
+		if err != nil {
+			return nil, err
+		}
+
+		resources[name] = max(limit-used, 0)
+	}
+
+	return resources, nil
+}
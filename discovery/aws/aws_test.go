@@ -0,0 +1,119 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	quotatypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+)
+
+// fakeQuotas returns applied quotas where set, otherwise defaults.
+type fakeQuotas struct {
+	applied  map[string]float64
+	defaults map[string]float64
+}
+
+func (f *fakeQuotas) GetServiceQuota(_ context.Context, input *servicequotas.GetServiceQuotaInput, _ ...func(*servicequotas.Options)) (*servicequotas.GetServiceQuotaOutput, error) {
+	value, ok := f.applied[*input.QuotaCode]
+	if !ok {
+		return nil, &quotatypes.NoSuchResourceException{}
+	}
+
+	return &servicequotas.GetServiceQuotaOutput{Quota: &quotatypes.ServiceQuota{Value: aws.Float64(value)}}, nil
+}
+
+func (f *fakeQuotas) GetAWSDefaultServiceQuota(_ context.Context, input *servicequotas.GetAWSDefaultServiceQuotaInput, _ ...func(*servicequotas.Options)) (*servicequotas.GetAWSDefaultServiceQuotaOutput, error) {
+	return &servicequotas.GetAWSDefaultServiceQuotaOutput{Quota: &quotatypes.ServiceQuota{Value: aws.Float64(f.defaults[*input.QuotaCode])}}, nil
+}
+
+// fakeEC2 returns canned resources.
+type fakeEC2 struct {
+	instances []ec2types.Instance
+	addresses int
+	vpcs      int
+}
+
+func (f *fakeEC2) DescribeInstances(context.Context, *ec2.DescribeInstancesInput, ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: f.instances}}}, nil
+}
+
+func (f *fakeEC2) DescribeVpcs(context.Context, *ec2.DescribeVpcsInput, ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error) {
+	return &ec2.DescribeVpcsOutput{Vpcs: make([]ec2types.Vpc, f.vpcs)}, nil
+}
+
+func (f *fakeEC2) DescribeAddresses(context.Context, *ec2.DescribeAddressesInput, ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	return &ec2.DescribeAddressesOutput{Addresses: make([]ec2types.Address, f.addresses)}, nil
+}
+
+func instance(instanceType string, cores int32) ec2types.Instance {
+	return ec2types.Instance{
+		InstanceType: ec2types.InstanceType(instanceType),
+		CpuOptions: &ec2types.CpuOptions{
+			CoreCount:      aws.Int32(cores),
+			ThreadsPerCore: aws.Int32(2),
+		},
+	}
+}
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	quotaClient := &fakeQuotas{
+		applied: map[string]float64{
+			"L-1216C47A": 64,
+		},
+		defaults: map[string]float64{
+			"L-0263D0A3": 5,
+			"L-F678F1CE": 5,
+		},
+	}
+
+	ec2Client := &fakeEC2{
+		instances: []ec2types.Instance{
+			instance("m5.xlarge", 2),
+			instance("c6i.2xlarge", 4),
+			instance("p4d.24xlarge", 48),
+			instance("inf1.xlarge", 2),
+		},
+		addresses: 2,
+		vpcs:      6,
+	}
+
+	expected := smtest.ResourceSet{
+		ResourceVCPUs:      52,
+		ResourceElasticIPs: 3,
+		ResourceVPCs:       0,
+	}
+
+	actual, err := Quota(context.Background(), quotaClient, ec2Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
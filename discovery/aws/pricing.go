@@ -0,0 +1,121 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	pricingtypes "github.com/aws/aws-sdk-go-v2/service/pricing/types"
+)
+
+var (
+	// ErrPriceNotFound is returned when the pricing API has no on-demand
+	// price for the instance type.
+	ErrPriceNotFound = errors.New("price not found")
+)
+
+// PricingAPI is the subset of the Pricing client that is required.
+type PricingAPI interface {
+	GetProducts(context.Context, *pricing.GetProductsInput, ...func(*pricing.Options)) (*pricing.GetProductsOutput, error)
+}
+
+// product is the subset of a price list entry that is required.
+type product struct {
+	Product struct {
+		Attributes struct {
+			VCPU string `json:"vcpu"`
+		} `json:"attributes"`
+	} `json:"product"`
+
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// Prices returns the on-demand Linux price of a vCPU hour for the instance type
+// in the region, for use with smtest.WithPrices, so the cost of a run can be
+// accounted against the vcpus resource.  The Pricing API is only served from
+// a few regions e.g. us-east-1, the client must be configured for one of them.
+func Prices(ctx context.Context, client PricingAPI, region, instanceType string) (smtest.Prices, error) {
+	filter := func(field, value string) pricingtypes.Filter {
+		return pricingtypes.Filter{
+			Type:  pricingtypes.FilterTypeTermMatch,
+			Field: aws.String(field),
+			Value: aws.String(value),
+		}
+	}
+
+	output, err := client.GetProducts(ctx, &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []pricingtypes.Filter{
+			filter("regionCode", region),
+			filter("instanceType", instanceType),
+			filter("operatingSystem", "Linux"),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("capacitystatus", "Used"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, document := range output.PriceList {
+		var p product
+
+		if err := json.Unmarshal([]byte(document), &p); err != nil {
+			return nil, err
+		}
+
+		vcpus, err := strconv.Atoi(p.Product.Attributes.VCPU)
+		if err != nil || vcpus == 0 {
+			continue
+		}
+
+		for _, term := range p.Terms.OnDemand {
+			for _, dimension := range term.PriceDimensions {
+				if dimension.Unit != "Hrs" {
+					continue
+				}
+
+				price, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+				if err != nil {
+					return nil, err
+				}
+
+				return smtest.Prices{
+					ResourceVCPUs: price / float64(vcpus),
+				}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s in %s", ErrPriceNotFound, instanceType, region)
+}
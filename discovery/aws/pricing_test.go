@@ -0,0 +1,70 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+)
+
+// fakePricing returns a fixed price list.
+type fakePricing struct {
+	priceList []string
+}
+
+func (f *fakePricing) GetProducts(context.Context, *pricing.GetProductsInput, ...func(*pricing.Options)) (*pricing.GetProductsOutput, error) {
+	return &pricing.GetProductsOutput{PriceList: f.priceList}, nil
+}
+
+func TestPrices(t *testing.T) {
+	t.Parallel()
+
+	client := &fakePricing{
+		priceList: []string{`{
+  "product": {"attributes": {"instanceType": "m5.xlarge", "vcpu": "4"}},
+  "terms": {
+    "OnDemand": {
+      "ABC.JRTCKXETXF": {
+        "priceDimensions": {
+          "ABC.JRTCKXETXF.6YS6EN2CT7": {"unit": "Hrs", "pricePerUnit": {"USD": "0.1920000000"}}
+        }
+      }
+    }
+  }
+}`},
+	}
+
+	prices, err := Prices(context.Background(), client, "us-east-1", "m5.xlarge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if price := prices[ResourceVCPUs]; price != 0.048 {
+		t.Fatalf("expected 0.048 per vCPU hour, got %v", price)
+	}
+}
+
+func TestPricesNotFound(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Prices(context.Background(), &fakePricing{}, "us-east-1", "m5.xlarge"); !errors.Is(err, ErrPriceNotFound) {
+		t.Fatalf("expected price not found, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package azure discovers the remaining regional quota of an Azure subscription,
+// so Azure based e2e suites can initialize the pool from live limits e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   credential, err := azidentity.NewDefaultAzureCredential(nil)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   resources, err := smtestazure.Quota(context.Background(), credential, subscriptionID, "uksouth")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package azure
+
+import (
+	"context"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v6"
+)
+
+const (
+	// ResourceCores is the total number of regional vCPUs.
+	ResourceCores = "cores"
+
+	// ResourcePublicIPAddresses is the number of public IP addresses.
+	ResourcePublicIPAddresses = "PublicIPAddresses"
+)
+
+// options are optional settings that alter how quotas are read.
+type options struct {
+	// usages are the names of usages to read.
+	usages []string
+
+	// clientOptions are passed to the Azure clients.
+	clientOptions *arm.ClientOptions
+}
+
+// Option is passed to Quota to modify the default behaviour.
+type Option func(*options)
+
+// WithUsages reads the named compute or network usages e.g. "standardDSv3Family"
+// or "VirtualNetworks", rather than the default of cores and public IP addresses.
+func WithUsages(names ...string) Option {
+	return func(o *options) {
+		o.usages = names
+	}
+}
+
+// WithClientOptions sets the options used to create Azure clients, for example
+// to target a sovereign cloud.
+func WithClientOptions(clientOptions *arm.ClientOptions) Option {
+	return func(o *options) {
+		o.clientOptions = clientOptions
+	}
+}
+
+// Quota returns the remaining headroom, limit minus current usage, of compute
+// and network usages in the location.  Resources are named after the usage.
+func Quota(ctx context.Context, credential azcore.TokenCredential, subscriptionID, location string, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		usages: []string{ResourceCores, ResourcePublicIPAddresses},
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	wanted := map[string]bool{}
+
+	for _, name := range o.usages {
+		wanted[name] = true
+	}
+
+	resources := smtest.ResourceSet{}
+
+	add := func(name *string, limit int64, current int64) {
+		if name == nil || !wanted[*name] {
+			return
+		}
+
+		resources[*name] = max(int(limit-current), 0)
+	}
+
+	computeClient, err := armcompute.NewUsageClient(subscriptionID, credential, o.clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	computePager := computeClient.NewListPager(location, nil)
+
+	for computePager.More() {
+		page, err := computePager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, usage := range page.Value {
+			if usage.Name == nil || usage.Limit == nil || usage.CurrentValue == nil {
+				continue
+			}
+
+			add(usage.Name.Value, *usage.Limit, int64(*usage.CurrentValue))
+		}
+	}
+
+	networkClient, err := armnetwork.NewUsagesClient(subscriptionID, credential, o.clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	networkPager := networkClient.NewListPager(location, nil)
+
+	for networkPager.More() {
+		page, err := networkPager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, usage := range page.Value {
+			if usage.Name == nil || usage.Limit == nil || usage.CurrentValue == nil {
+				continue
+			}
+
+			add(usage.Name.Value, *usage.Limit, *usage.CurrentValue)
+		}
+	}
+
+	return resources, nil
+}
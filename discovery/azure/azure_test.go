@@ -0,0 +1,85 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// fakeCredential issues dummy tokens.
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// fakeTransport returns canned usages.
+type fakeTransport struct{}
+
+func (fakeTransport) Do(r *http.Request) (*http.Response, error) {
+	body := `{"value":[]}`
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/subscriptions/test/providers/Microsoft.Compute/locations/uksouth/usages"):
+		body = `{"value":[{"name":{"value":"cores"},"currentValue":12,"limit":100,"unit":"Count"},{"name":{"value":"virtualMachines"},"currentValue":2,"limit":25000,"unit":"Count"}]}`
+	case strings.HasPrefix(r.URL.Path, "/subscriptions/test/providers/Microsoft.Network/locations/uksouth/usages"):
+		body = `{"value":[{"name":{"value":"PublicIPAddresses"},"currentValue":3,"limit":10,"unit":"Count"}]}`
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	clientOptions := &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Transport: fakeTransport{},
+		},
+	}
+
+	expected := smtest.ResourceSet{
+		ResourceCores:             88,
+		ResourcePublicIPAddresses: 7,
+	}
+
+	actual, err := Quota(context.Background(), fakeCredential{}, "test", "uksouth", WithClientOptions(clientOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ci detects the CI system running the tests and sizes the pool to
+// suit, removing per-CI boilerplate from TestMain e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   resources, err := ci.Capacity()
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+//
+// The pool is the capacity of the runner, as reported by the local package,
+// less a reservation for the CI agent and anything else the CI system runs
+// alongside the tests.  Reservations and the resulting values can be
+// overridden.
+package ci
+
+import (
+	"os"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+	"github.com/spjmurray/testing/discovery/local"
+)
+
+// Environment is a CI system.
+type Environment string
+
+const (
+	// EnvironmentNone is used when not running under CI e.g. on a developer
+	// machine.
+	EnvironmentNone Environment = ""
+
+	// EnvironmentGitHubActions is GitHub Actions.
+	EnvironmentGitHubActions Environment = "github-actions"
+
+	// EnvironmentGitLab is GitLab CI.
+	EnvironmentGitLab Environment = "gitlab"
+
+	// EnvironmentJenkins is Jenkins.
+	EnvironmentJenkins Environment = "jenkins"
+
+	// EnvironmentCircleCI is CircleCI.
+	EnvironmentCircleCI Environment = "circleci"
+)
+
+// Profile is what is held back from the runner's capacity.
+type Profile struct {
+	// CPU is the number of CPUs to reserve.
+	CPU int
+
+	// Memory is the number of bytes of memory to reserve.
+	Memory int64
+}
+
+var (
+	// getenv reads the environment, it can be replaced in tests.
+	getenv = os.Getenv
+
+	// profiles are the default reservations.  Developer machines keep some
+	// room so they remain usable, and Jenkins agents are JVMs.
+	profiles = map[Environment]Profile{
+		EnvironmentNone:          {CPU: 1, Memory: 2 << 30},
+		EnvironmentGitHubActions: {Memory: 1 << 30},
+		EnvironmentGitLab:        {Memory: 1 << 30},
+		EnvironmentJenkins:       {CPU: 1, Memory: 1 << 30},
+		EnvironmentCircleCI:      {Memory: 1 << 30},
+	}
+)
+
+// Runner describes the CI environment.
+type Runner struct {
+	// Environment is the CI system.
+	Environment Environment
+
+	// Name identifies the runner, agent or node, where known.
+	Name string
+
+	// Hosted is set when the runner is known to be provided by the CI
+	// vendor, rather than self-hosted.
+	Hosted bool
+}
+
+// Detect returns the CI environment from the variables each system sets.
+func Detect() Runner {
+	switch {
+	case getenv("GITHUB_ACTIONS") == "true":
+		return Runner{
+			Environment: EnvironmentGitHubActions,
+			Name:        getenv("RUNNER_NAME"),
+			Hosted:      getenv("RUNNER_ENVIRONMENT") == "github-hosted",
+		}
+	case getenv("GITLAB_CI") == "true":
+		return Runner{
+			Environment: EnvironmentGitLab,
+			Name:        getenv("CI_RUNNER_DESCRIPTION"),
+			Hosted:      getenv("CI_SERVER_HOST") == "gitlab.com" && strings.Contains(getenv("CI_RUNNER_TAGS"), "saas-"),
+		}
+	case getenv("JENKINS_URL") != "":
+		return Runner{
+			Environment: EnvironmentJenkins,
+			Name:        getenv("NODE_NAME"),
+		}
+	case getenv("CIRCLECI") == "true":
+		return Runner{
+			Environment: EnvironmentCircleCI,
+			Name:        getenv("CIRCLE_JOB"),
+		}
+	}
+
+	return Runner{}
+}
+
+// options are optional settings that alter how the pool is sized.
+type options struct {
+	// cpuResource is the name of the CPU resource.
+	cpuResource string
+
+	// memoryResource is the name of the memory resource.
+	memoryResource string
+
+	// memoryUnit is the number of bytes represented by one unit of memory.
+	memoryUnit int64
+
+	// profiles override the default reservations.
+	profiles map[Environment]Profile
+
+	// overrides replace detected values.
+	overrides smtest.ResourceSet
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithCPUResource names the CPU resource, the default is "cpu".
+func WithCPUResource(name string) Option {
+	return func(o *options) {
+		o.cpuResource = name
+	}
+}
+
+// WithMemoryResource names the memory resource, where a single unit is the
+// given number of bytes, the default is "memory" measured in GiB.
+func WithMemoryResource(name string, unit int64) Option {
+	return func(o *options) {
+		o.memoryResource = name
+		o.memoryUnit = unit
+	}
+}
+
+// WithProfile replaces the reservation for a CI environment, for example when
+// builds run sidecar services that compete with the tests.
+func WithProfile(environment Environment, profile Profile) Option {
+	return func(o *options) {
+		o.profiles[environment] = profile
+	}
+}
+
+// WithOverrides sets resources to fixed values, these are used as is and take
+// precedence over anything detected.
+func WithOverrides(resources smtest.ResourceSet) Option {
+	return func(o *options) {
+		for k, v := range resources {
+			o.overrides[k] = v
+		}
+	}
+}
+
+// Capacity returns the capacity of the runner less the reservation for the
+// detected CI environment.  At least one CPU is always left for the tests.
+func Capacity(opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		cpuResource:    local.ResourceCPU,
+		memoryResource: local.ResourceMemory,
+		memoryUnit:     1 << 30,
+		profiles:       map[Environment]Profile{},
+		overrides:      smtest.ResourceSet{},
+	}
+
+	for environment, profile := range profiles {
+		o.profiles[environment] = profile
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	resources, err := local.Capacity(local.WithCPUResource(o.cpuResource), local.WithMemoryResource(o.memoryResource, o.memoryUnit))
+	if err != nil {
+		return nil, err
+	}
+
+	profile := o.profiles[Detect().Environment]
+
+	resources[o.cpuResource] = max(resources[o.cpuResource]-profile.CPU, 1)
+
+	if memory, ok := resources[o.memoryResource]; ok {
+		resources[o.memoryResource] = max(memory-int(profile.Memory/o.memoryUnit), 0)
+	}
+
+	for k, v := range o.overrides {
+		resources[k] = v
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ci
+
+import (
+	"testing"
+
+	"github.com/spjmurray/testing/discovery/local"
+)
+
+// setEnvironment replaces the environment with the variables.
+func setEnvironment(variables map[string]string) {
+	getenv = func(key string) string {
+		return variables[key]
+	}
+}
+
+// TestDetect is not parallel as it replaces the environment.
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		variables map[string]string
+		expected  Runner
+	}{
+		{
+			variables: map[string]string{},
+			expected:  Runner{},
+		},
+		{
+			variables: map[string]string{"GITHUB_ACTIONS": "true", "RUNNER_NAME": "GitHub Actions 2", "RUNNER_ENVIRONMENT": "github-hosted"},
+			expected:  Runner{Environment: EnvironmentGitHubActions, Name: "GitHub Actions 2", Hosted: true},
+		},
+		{
+			variables: map[string]string{"GITLAB_CI": "true", "CI_RUNNER_DESCRIPTION": "builder", "CI_SERVER_HOST": "gitlab.example.com"},
+			expected:  Runner{Environment: EnvironmentGitLab, Name: "builder"},
+		},
+		{
+			variables: map[string]string{"GITLAB_CI": "true", "CI_SERVER_HOST": "gitlab.com", "CI_RUNNER_TAGS": `["saas-linux-small-amd64"]`},
+			expected:  Runner{Environment: EnvironmentGitLab, Hosted: true},
+		},
+		{
+			variables: map[string]string{"JENKINS_URL": "https://jenkins.example.com", "NODE_NAME": "agent-1"},
+			expected:  Runner{Environment: EnvironmentJenkins, Name: "agent-1"},
+		},
+		{
+			variables: map[string]string{"CIRCLECI": "true", "CIRCLE_JOB": "test"},
+			expected:  Runner{Environment: EnvironmentCircleCI, Name: "test"},
+		},
+	}
+
+	for _, test := range tests {
+		setEnvironment(test.variables)
+
+		if runner := Detect(); runner != test.expected {
+			t.Fatalf("expected %+v, got %+v", test.expected, runner)
+		}
+	}
+}
+
+// TestCapacity is not parallel as it replaces the environment.
+func TestCapacity(t *testing.T) {
+	setEnvironment(map[string]string{"JENKINS_URL": "https://jenkins.example.com"})
+
+	unit := int64(1 << 20)
+
+	runner, err := local.Capacity(local.WithMemoryResource(local.ResourceMemory, unit))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := Capacity(WithMemoryResource(local.ResourceMemory, unit), WithProfile(EnvironmentJenkins, Profile{Memory: 1 << 50}), WithOverrides(map[string]int{"gpu": 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resources[local.ResourceCPU] != runner[local.ResourceCPU] {
+		t.Fatalf("expected CPU reservation to be overridden, got %v", resources)
+	}
+
+	// More memory is reserved than any runner has.
+	if _, ok := runner[local.ResourceMemory]; ok && resources[local.ResourceMemory] != 0 {
+		t.Fatalf("expected memory to be reserved, got %v", resources)
+	}
+
+	if resources["gpu"] != 2 {
+		t.Fatalf("expected override, got %v", resources)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package clusterapi discovers how many more Cluster API Machines may be
+// created, for e2e suites that provision workload clusters e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   quota, err := smtestaws.Quota(ctx, servicequotas.NewFromConfig(cfg), ec2.NewFromConfig(cfg))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   resources, err := clusterapi.Capacity(ctx, dynamic.NewForConfigOrDie(config),
+//	     clusterapi.WithInfrastructureQuota(quota, smtest.ResourceSet{smtestaws.ResourceVCPUs: 4}),
+//	   )
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+//
+//	func TestWorkloadCluster(t *testing.T) {
+//	   // One control plane and two workers.
+//	   defer smtest.Parallel(t, smtest.ResourceSet{clusterapi.ResourceMachines: 3})()
+//	   ...
+//	}
+//
+// Resources are read with the dynamic client so the Cluster API module isn't
+// a dependency.
+package clusterapi
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	smtest "github.com/spjmurray/testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// ResourceMachines is the number of Machines that may be created.
+	ResourceMachines = "machines"
+
+	// group is the Cluster API group.
+	group = "cluster.x-k8s.io"
+
+	// defaultVersion is served by all supported Cluster API releases.
+	defaultVersion = "v1beta1"
+)
+
+var (
+	// ErrNoLimit is returned when no limit on machines has been set.
+	ErrNoLimit = errors.New("either a machine limit or infrastructure quota is required")
+)
+
+// options are optional settings that alter how capacity is calculated.
+type options struct {
+	// namespace restricts discovery to a namespace, by default all are
+	// considered.
+	namespace string
+
+	// version is the Cluster API version to read.
+	version string
+
+	// limit is the total number of machines allowed.
+	limit int
+
+	// quota is the free infrastructure quota.
+	quota smtest.ResourceSet
+
+	// perMachine is the quota consumed by each machine.
+	perMachine smtest.ResourceSet
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithNamespace only counts machines in the namespace, for example when the
+// limit applies to a single tenant of a shared management cluster.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithVersion sets the Cluster API version to read, the default is v1beta1.
+func WithVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// WithMachineLimit sets the total number of machines that may exist, machines
+// that already exist or are yet to be created are subtracted from this.
+func WithMachineLimit(limit int) Option {
+	return func(o *options) {
+		o.limit = limit
+	}
+}
+
+// WithInfrastructureQuota limits machines by the free quota of the underlying
+// infrastructure, for example from one of the cloud discovery packages, and
+// the quota each machine consumes.  As the quota is what is free, only
+// machines that are yet to be created are subtracted from it.
+func WithInfrastructureQuota(quota, perMachine smtest.ResourceSet) Option {
+	return func(o *options) {
+		o.quota = quota
+		o.perMachine = perMachine
+	}
+}
+
+// list returns all objects of the resource.
+func list(ctx context.Context, client dynamic.Interface, resource string, o *options) ([]unstructured.Unstructured, error) {
+	gvr := schema.GroupVersionResource{
+		Group:    group,
+		Version:  o.version,
+		Resource: resource,
+	}
+
+	objects, err := client.Resource(gvr).Namespace(o.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects.Items, nil
+}
+
+// pending returns the number of machines owners want that don't yet exist.
+// These will consume capacity as soon as they are created.
+func pending(ctx context.Context, client dynamic.Interface, o *options) (int, error) {
+	var total int
+
+	for _, resource := range []string{"machinedeployments", "machinesets"} {
+		objects, err := list(ctx, client, resource, o)
+		if err != nil {
+			return 0, err
+		}
+
+		for i := range objects {
+			object := &objects[i]
+
+			// Machine sets owned by a deployment are counted by it.
+			if resource == "machinesets" && len(object.GetOwnerReferences()) > 0 {
+				continue
+			}
+
+			desired, _, err := unstructured.NestedInt64(object.Object, "spec", "replicas")
+			if err != nil {
+				return 0, err
+			}
+
+			actual, _, err := unstructured.NestedInt64(object.Object, "status", "replicas")
+			if err != nil {
+				return 0, err
+			}
+
+			total += int(max(desired-actual, 0))
+		}
+	}
+
+	return total, nil
+}
+
+// Capacity returns the number of machines that may still be created as the
+// ResourceMachines resource.  Either WithMachineLimit or WithInfrastructureQuota
+// is required, if both are set the most restrictive is used.
+func Capacity(ctx context.Context, client dynamic.Interface, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		version: defaultVersion,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.limit == 0 && o.perMachine == nil {
+		return nil, ErrNoLimit
+	}
+
+	machines, err := list(ctx, client, "machines", o)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := pending(ctx, client, o)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := math.MaxInt
+
+	if o.limit > 0 {
+		remaining = o.limit - len(machines) - pending
+	}
+
+	for k, v := range o.perMachine {
+		if v > 0 {
+			remaining = min(remaining, o.quota[k]/v-pending)
+		}
+	}
+
+	return smtest.ResourceSet{
+		ResourceMachines: max(remaining, 0),
+	}, nil
+}
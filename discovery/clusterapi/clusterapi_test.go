@@ -0,0 +1,125 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// object returns a Cluster API object with the requested and actual replicas.
+func object(kind, name string, desired, actual int64, owned bool) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": group + "/" + defaultVersion,
+			"kind":       kind,
+			"metadata": map[string]any{
+				"name":      name,
+				"namespace": "default",
+			},
+			"spec": map[string]any{
+				"replicas": desired,
+			},
+			"status": map[string]any{
+				"replicas": actual,
+			},
+		},
+	}
+
+	if owned {
+		o.SetOwnerReferences([]metav1.OwnerReference{{Kind: "MachineDeployment", Name: "md"}})
+	}
+
+	return o
+}
+
+// client returns a fake client with two machines, a deployment waiting for one
+// more, and its machine set which mustn't be double counted.
+func client() *dynamicfake.FakeDynamicClient {
+	listKinds := map[schema.GroupVersionResource]string{
+		{Group: group, Version: defaultVersion, Resource: "machines"}:           "MachineList",
+		{Group: group, Version: defaultVersion, Resource: "machinedeployments"}: "MachineDeploymentList",
+		{Group: group, Version: defaultVersion, Resource: "machinesets"}:        "MachineSetList",
+	}
+
+	machine := func(name string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": group + "/" + defaultVersion,
+				"kind":       "Machine",
+				"metadata": map[string]any{
+					"name":      name,
+					"namespace": "default",
+				},
+			},
+		}
+	}
+
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds,
+		machine("a"),
+		machine("b"),
+		object("MachineDeployment", "md", 3, 2, false),
+		object("MachineSet", "md-abcde", 3, 2, true),
+	)
+}
+
+func TestMachineLimit(t *testing.T) {
+	t.Parallel()
+
+	resources, err := Capacity(context.Background(), client(), WithMachineLimit(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := resources[ResourceMachines]; n != 7 {
+		t.Fatalf("expected 7 machines, got %d", n)
+	}
+}
+
+func TestInfrastructureQuota(t *testing.T) {
+	t.Parallel()
+
+	quota := smtest.ResourceSet{"vcpus": 34, "eips": 10}
+	perMachine := smtest.ResourceSet{"vcpus": 4}
+
+	resources, err := Capacity(context.Background(), client(), WithInfrastructureQuota(quota, perMachine), WithMachineLimit(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 machines fit in the quota, but one is about to be created.
+	if n := resources[ResourceMachines]; n != 7 {
+		t.Fatalf("expected 7 machines, got %d", n)
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Capacity(context.Background(), client()); !errors.Is(err, ErrNoLimit) {
+		t.Fatalf("expected no limit error, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package docker discovers the capacity of a Docker daemon, for suites that
+// spin up many containers e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   resources, err := docker.Capacity(context.Background(), docker.WithMaxContainers(50))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// ResourceCPU is the number of CPUs available to the daemon.
+	ResourceCPU = "cpu"
+
+	// ResourceMemory is the memory available to the daemon.
+	ResourceMemory = "memory"
+
+	// ResourceContainers is the number of containers that may be started.
+	ResourceContainers = "containers"
+
+	// defaultHost is used when DOCKER_HOST is not set.
+	defaultHost = "unix:///var/run/docker.sock"
+)
+
+// info is the subset of the daemon's /info response that is required.
+type info struct {
+	// NCPU is the number of CPUs available to the daemon.
+	NCPU int `json:"NCPU"`
+
+	// MemTotal is the memory available to the daemon in bytes.
+	MemTotal int64 `json:"MemTotal"`
+
+	// ContainersRunning is the number of containers that are running.
+	ContainersRunning int `json:"ContainersRunning"`
+}
+
+// options are optional settings that alter how the daemon is queried.
+type options struct {
+	// host is the daemon address e.g. unix:///var/run/docker.sock or
+	// tcp://localhost:2375.
+	host string
+
+	// memoryUnit is the number of bytes represented by one unit of memory.
+	memoryUnit int64
+
+	// maxContainers, if set, is the total number of containers that may
+	// run at once.
+	maxContainers int
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithHost sets the daemon address, by default DOCKER_HOST is used, falling
+// back to the local socket.
+func WithHost(host string) Option {
+	return func(o *options) {
+		o.host = host
+	}
+}
+
+// WithMemoryUnit sets the number of bytes represented by one unit of memory,
+// the default is 1<<30 e.g. GiB.
+func WithMemoryUnit(unit int64) Option {
+	return func(o *options) {
+		o.memoryUnit = unit
+	}
+}
+
+// WithMaxContainers sets the total number of containers that may run at once,
+// the containers resource is then this minus those already running.  Without
+// this the containers resource is omitted.
+func WithMaxContainers(n int) Option {
+	return func(o *options) {
+		o.maxContainers = n
+	}
+}
+
+// client returns a HTTP client and base URL for the daemon.
+func client(host string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+
+				return d.DialContext(ctx, "unix", u.Path)
+			},
+		}
+
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		return http.DefaultClient, "http://" + u.Host, nil
+	case "https":
+		return http.DefaultClient, "https://" + u.Host, nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported docker host %q", host)
+}
+
+// Capacity returns the CPUs and memory available to the daemon, and optionally
+// the number of containers that may still be started.
+func Capacity(ctx context.Context, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		host:       os.Getenv("DOCKER_HOST"),
+		memoryUnit: 1 << 30,
+	}
+
+	if o.host == "" {
+		o.host = defaultHost
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	c, base, err := client(o.host)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("docker info: unexpected status %s", response.Status)
+	}
+
+	var i info
+
+	if err := json.NewDecoder(response.Body).Decode(&i); err != nil {
+		return nil, err
+	}
+
+	resources := smtest.ResourceSet{
+		ResourceCPU:    i.NCPU,
+		ResourceMemory: int(i.MemTotal / o.memoryUnit),
+	}
+
+	if o.maxContainers > 0 {
+		resources[ResourceContainers] = max(o.maxContainers-i.ContainersRunning, 0)
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcp discovers Compute Engine regional quotas, for suites that
+// provision real GCP resources e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   service, err := compute.NewService(context.Background())
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   resources, err := smtestgcp.Quota(context.Background(), service, "my-project", "europe-west2", smtestgcp.WithUsage())
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package gcp
+
+import (
+	"context"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	// MetricCPUs is the number of regional vCPUs.
+	MetricCPUs = "CPUS"
+
+	// MetricInUseAddresses is the number of regional external IP addresses.
+	MetricInUseAddresses = "IN_USE_ADDRESSES"
+
+	// MetricDisksTotalGB is the regional standard persistent disk capacity.
+	MetricDisksTotalGB = "DISKS_TOTAL_GB"
+
+	// MetricSSDTotalGB is the regional SSD persistent disk capacity.
+	MetricSSDTotalGB = "SSD_TOTAL_GB"
+)
+
+// options are optional settings that alter how quotas are read.
+type options struct {
+	// metrics are the quota metrics to read.
+	metrics []string
+
+	// usage, if set, subtracts current usage from the quota.
+	usage bool
+}
+
+// Option is passed to Quota to modify the default behaviour.
+type Option func(*options)
+
+// WithMetrics reads the named quota metrics e.g. "N2_CPUS" or "GPUS_ALL_REGIONS",
+// rather than the default of CPUs, in-use addresses and persistent disk capacity.
+func WithMetrics(metrics ...string) Option {
+	return func(o *options) {
+		o.metrics = metrics
+	}
+}
+
+// WithUsage subtracts current usage from the quota, so resources consumed by
+// anything other than the tests are accounted for.
+func WithUsage() Option {
+	return func(o *options) {
+		o.usage = true
+	}
+}
+
+// ResourceName returns the resource name for a quota metric e.g. "cpus" for
+// CPUS.
+func ResourceName(metric string) string {
+	return strings.ToLower(metric)
+}
+
+// Quota returns the regional quotas for the project, named with ResourceName.
+func Quota(ctx context.Context, service *compute.Service, project, region string, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		metrics: []string{MetricCPUs, MetricInUseAddresses, MetricDisksTotalGB, MetricSSDTotalGB},
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	r, err := service.Regions.Get(project, region).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+
+	for _, metric := range o.metrics {
+		wanted[metric] = true
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for _, quota := range r.Quotas {
+		if !wanted[quota.Metric] {
+			continue
+		}
+
+		value := quota.Limit
+
+		if o.usage {
+			value -= quota.Usage
+		}
+
+		resources[ResourceName(quota.Metric)] = max(int(value), 0)
+	}
+
+	return resources, nil
+}
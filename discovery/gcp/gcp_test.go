@@ -0,0 +1,76 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	region := &compute.Region{
+		Name: "europe-west2",
+		Quotas: []*compute.Quota{
+			{Metric: MetricCPUs, Limit: 24, Usage: 8},
+			{Metric: MetricInUseAddresses, Limit: 8, Usage: 10},
+			{Metric: MetricDisksTotalGB, Limit: 4096, Usage: 100},
+			{Metric: "GPUS_ALL_REGIONS", Limit: 4},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/test/regions/europe-west2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(region)
+	}))
+
+	defer server.Close()
+
+	service, err := compute.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := smtest.ResourceSet{
+		"cpus":             16,
+		"in_use_addresses": 0,
+		"disks_total_gb":   3996,
+	}
+
+	actual, err := Quota(context.Background(), service, "test", "europe-west2", WithUsage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
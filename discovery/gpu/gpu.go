@@ -0,0 +1,241 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gpu discovers local NVIDIA GPUs, and hands specific free devices to
+// tests so hardware accelerated tests never share a GPU e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   devices, err := gpu.Devices(context.Background())
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(gpu.Capacity(devices), smtest.WithProvider(gpu.ResourceGPU, gpu.NewProvider(devices)))
+//	   ...
+//	}
+//
+//	func TestTraining(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{gpu.ResourceGPU: 1})
+//	   defer allocation.Release()
+//
+//	   cmd.Env = append(cmd.Env, "CUDA_VISIBLE_DEVICES="+gpu.VisibleDevices(gpu.Allocated(allocation)))
+//	   ...
+//	}
+//
+// Devices are enumerated with nvidia-smi, which ships with the driver, so no
+// cgo bindings to NVML are required.
+package gpu
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// ResourceGPU is the number of GPUs.
+	ResourceGPU = "gpu"
+)
+
+var (
+	// ErrNoDevice is returned when there is no free device, which implies
+	// the provider is registered against more GPUs than it manages.
+	ErrNoDevice = errors.New("no free GPU")
+
+	// query returns the CSV formatted device list, it can be replaced in
+	// tests.
+	query = func(ctx context.Context, command string) ([]byte, error) {
+		return exec.CommandContext(ctx, command, "--query-gpu=index,uuid,name,memory.total", "--format=csv,noheader,nounits").Output()
+	}
+)
+
+// GPU is a physical device.
+type GPU struct {
+	// Index is the device index as used by CUDA_VISIBLE_DEVICES.
+	Index int
+
+	// UUID uniquely identifies the device.
+	UUID string
+
+	// Model is the product name e.g. NVIDIA A100-SXM4-80GB.
+	Model string
+
+	// Memory is the total device memory in MiB.
+	Memory int
+}
+
+// options are optional settings that alter how devices are discovered.
+type options struct {
+	// command is the nvidia-smi executable.
+	command string
+}
+
+// Option is passed to Devices to modify the default behaviour.
+type Option func(*options)
+
+// WithCommand sets the path to nvidia-smi, by default it is looked up in the
+// PATH.
+func WithCommand(command string) Option {
+	return func(o *options) {
+		o.command = command
+	}
+}
+
+// Devices returns every GPU on the host.
+func Devices(ctx context.Context, opts ...Option) ([]GPU, error) {
+	o := &options{
+		command: "nvidia-smi",
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	output, err := query(ctx, o.command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query GPUs: %w", err)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(output))
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]GPU, 0, len(rows))
+
+	for _, row := range rows {
+		if len(row) != 4 {
+			return nil, fmt.Errorf("unexpected nvidia-smi output %q", strings.Join(row, ","))
+		}
+
+		index, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, err
+		}
+
+		memory, err := strconv.Atoi(row[3])
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, GPU{
+			Index:  index,
+			UUID:   row[1],
+			Model:  row[2],
+			Memory: memory,
+		})
+	}
+
+	return devices, nil
+}
+
+// Capacity returns the number of GPUs as the ResourceGPU resource.
+func Capacity(devices []GPU) smtest.ResourceSet {
+	return smtest.ResourceSet{
+		ResourceGPU: len(devices),
+	}
+}
+
+// provider hands out specific devices.
+type provider struct {
+	// free are the devices not allocated to a test.
+	free []GPU
+
+	// lock serializes access to the free list.
+	lock sync.Mutex
+}
+
+// NewProvider returns a provider that gives each unit of the resource it is
+// registered against a specific free device.  Register one provider per
+// resource name, for example per model, so devices are only handed out once.
+func NewProvider(devices []GPU) smtest.Provider {
+	return &provider{
+		free: append([]GPU(nil), devices...),
+	}
+}
+
+// Acquire takes a free device.
+func (p *provider) Acquire(_ context.Context, _ string) (any, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.free) == 0 {
+		return nil, ErrNoDevice
+	}
+
+	device := p.free[0]
+	p.free = p.free[1:]
+
+	return &device, nil
+}
+
+// Release returns the device to the free list.
+func (p *provider) Release(_ context.Context, instance any) error {
+	device, ok := instance.(*GPU)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.free = append(p.free, *device)
+
+	return nil
+}
+
+// Allocated returns the devices allocated to a test for the named resource,
+// by default ResourceGPU.
+func Allocated(allocation *smtest.Allocation, names ...string) []*GPU {
+	if len(names) == 0 {
+		names = []string{ResourceGPU}
+	}
+
+	var devices []*GPU
+
+	for _, name := range names {
+		for _, instance := range allocation.Instances(name) {
+			if device, ok := instance.(*GPU); ok {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	return devices
+}
+
+// VisibleDevices formats devices for CUDA_VISIBLE_DEVICES.  UUIDs are used as
+// they are stable regardless of device ordering.
+func VisibleDevices(devices []*GPU) string {
+	ids := make([]string, len(devices))
+
+	for i, device := range devices {
+		ids[i] = device.UUID
+	}
+
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestDevices is not parallel as it replaces the query function.
+func TestDevices(t *testing.T) {
+	query = func(_ context.Context, command string) ([]byte, error) {
+		if command != "nvidia-smi" {
+			t.Fatalf("unexpected command %s", command)
+		}
+
+		return []byte("0, GPU-aaaa, NVIDIA A100-SXM4-80GB, 81920\n1, GPU-bbbb, NVIDIA A100-SXM4-80GB, 81920\n"), nil
+	}
+
+	devices, err := Devices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+
+	if devices[1].Index != 1 || devices[1].UUID != "GPU-bbbb" || devices[1].Model != "NVIDIA A100-SXM4-80GB" || devices[1].Memory != 81920 {
+		t.Fatalf("unexpected device %+v", devices[1])
+	}
+
+	if n := Capacity(devices)[ResourceGPU]; n != 2 {
+		t.Fatalf("expected 2 GPUs, got %d", n)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	p := NewProvider([]GPU{{Index: 0, UUID: "GPU-aaaa"}, {Index: 1, UUID: "GPU-bbbb"}})
+
+	first, err := p.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := p.Acquire(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if visible := VisibleDevices([]*GPU{first.(*GPU), second.(*GPU)}); visible != "GPU-aaaa,GPU-bbbb" {
+		t.Fatalf("unexpected visible devices %s", visible)
+	}
+
+	if _, err := p.Acquire(ctx, "c"); !errors.Is(err, ErrNoDevice) {
+		t.Fatalf("expected no device error, got %v", err)
+	}
+
+	if err := p.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := p.Acquire(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third.(*GPU).UUID != "GPU-aaaa" {
+		t.Fatalf("expected released device to be reused")
+	}
+}
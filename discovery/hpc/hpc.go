@@ -0,0 +1,208 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hpc reads the resources granted to an HPC batch job, so suites run
+// on shared clusters never exceed their slice of a node e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   resources, err := hpc.Capacity()
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+//
+// Slurm and PBS, including Torque, are supported.  Only the current node's
+// share of a multi-node job is reported, as that is all the tests can use.
+// Resources the scheduler doesn't advertise are omitted, for example PBS does
+// not export memory, in which case the local package can read the job's
+// cgroup instead.
+package hpc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// ResourceCPU is the number of CPUs granted on the node.
+	ResourceCPU = "cpu"
+
+	// ResourceMemory is the memory granted on the node.
+	ResourceMemory = "memory"
+
+	// ResourceGPU is the number of GPUs granted on the node.
+	ResourceGPU = "gpu"
+)
+
+var (
+	// ErrNoJob is returned when not running in a batch job.
+	ErrNoJob = errors.New("not running in a batch job")
+
+	// getenv reads the environment, it can be replaced in tests.
+	getenv = os.Getenv
+)
+
+// options are optional settings that alter how resources are converted.
+type options struct {
+	// memoryUnit is the number of bytes represented by one unit of memory.
+	memoryUnit int64
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithMemoryUnit sets the number of bytes represented by one unit of memory,
+// the default is 1<<30 e.g. GiB.
+func WithMemoryUnit(unit int64) Option {
+	return func(o *options) {
+		o.memoryUnit = unit
+	}
+}
+
+// integer returns the value of the first of the variables that is set.
+func integer(names ...string) (int, bool, error) {
+	for _, name := range names {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: %w", name, err)
+		}
+
+		return i, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// count returns the number of items in a comma separated list variable.
+func count(name string) (int, bool) {
+	value := getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	return len(strings.Split(value, ",")), true
+}
+
+// slurm reads a Slurm allocation, memory is reported in MiB.
+func slurm(o *options) (smtest.ResourceSet, error) {
+	resources := smtest.ResourceSet{}
+
+	cpus, ok, err := integer("SLURM_CPUS_ON_NODE")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		resources[ResourceCPU] = cpus
+	}
+
+	memory, ok, err := integer("SLURM_MEM_PER_NODE")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok && cpus > 0 {
+		var perCPU int
+
+		if perCPU, ok, err = integer("SLURM_MEM_PER_CPU"); err != nil {
+			return nil, err
+		}
+
+		memory = perCPU * cpus
+	}
+
+	if ok {
+		resources[ResourceMemory] = int(int64(memory) << 20 / o.memoryUnit)
+	}
+
+	gpus, ok, err := integer("SLURM_GPUS_ON_NODE")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		gpus, ok = count("SLURM_JOB_GPUS")
+	}
+
+	if ok {
+		resources[ResourceGPU] = gpus
+	}
+
+	return resources, nil
+}
+
+// pbs reads a PBS Professional or Torque allocation.
+func pbs() (smtest.ResourceSet, error) {
+	resources := smtest.ResourceSet{}
+
+	cpus, ok, err := integer("PBS_NCPUS", "NCPUS", "PBS_NUM_PPN")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		resources[ResourceCPU] = cpus
+	}
+
+	gpus, ok, err := integer("PBS_NGPUS", "NGPUS")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		gpus, ok = count("CUDA_VISIBLE_DEVICES")
+	}
+
+	if ok {
+		resources[ResourceGPU] = gpus
+	}
+
+	return resources, nil
+}
+
+// Capacity returns the CPUs, memory and GPUs granted to the batch job on this
+// node, or ErrNoJob when not running in one.
+func Capacity(opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		memoryUnit: 1 << 30,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	switch {
+	case getenv("SLURM_JOB_ID") != "":
+		return slurm(o)
+	case getenv("PBS_JOBID") != "":
+		return pbs()
+	}
+
+	return nil, ErrNoJob
+}
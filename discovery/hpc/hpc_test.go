@@ -0,0 +1,81 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hpc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// TestCapacity is not parallel as it replaces the environment.
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables map[string]string
+		expected  smtest.ResourceSet
+	}{
+		{
+			name:      "SlurmMemoryPerNode",
+			variables: map[string]string{"SLURM_JOB_ID": "1", "SLURM_CPUS_ON_NODE": "8", "SLURM_MEM_PER_NODE": "16384", "SLURM_GPUS_ON_NODE": "2"},
+			expected:  smtest.ResourceSet{ResourceCPU: 8, ResourceMemory: 16, ResourceGPU: 2},
+		},
+		{
+			name:      "SlurmMemoryPerCPU",
+			variables: map[string]string{"SLURM_JOB_ID": "1", "SLURM_CPUS_ON_NODE": "4", "SLURM_MEM_PER_CPU": "2048", "SLURM_JOB_GPUS": "0,1,3"},
+			expected:  smtest.ResourceSet{ResourceCPU: 4, ResourceMemory: 8, ResourceGPU: 3},
+		},
+		{
+			name:      "PBS",
+			variables: map[string]string{"PBS_JOBID": "1.server", "NCPUS": "16", "CUDA_VISIBLE_DEVICES": "GPU-aaaa"},
+			expected:  smtest.ResourceSet{ResourceCPU: 16, ResourceGPU: 1},
+		},
+		{
+			name:      "Torque",
+			variables: map[string]string{"PBS_JOBID": "1.server", "PBS_NUM_PPN": "12"},
+			expected:  smtest.ResourceSet{ResourceCPU: 12},
+		},
+	}
+
+	for _, test := range tests {
+		getenv = func(key string) string {
+			return test.variables[key]
+		}
+
+		resources, err := Capacity()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(resources, test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.expected, resources)
+		}
+	}
+}
+
+// TestNoJob is not parallel as it replaces the environment.
+func TestNoJob(t *testing.T) {
+	getenv = func(string) string {
+		return ""
+	}
+
+	if _, err := Capacity(); !errors.Is(err, ErrNoJob) {
+		t.Fatalf("expected no job error, got %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubernetes discovers resource limits from a Kubernetes cluster, so
+// e2e suites can initialize the scheduler without hard-coded numbers e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client := kubernetes.NewForConfigOrDie(config)
+//
+//	   resources, err := smtestkube.Quota(context.Background(), client, "e2e")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package kubernetes
+
+import (
+	"context"
+	"strings"
+
+	smtest "github.com/spjmurray/testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// options are optional settings that alter how resources are converted.
+type options struct {
+	// memoryUnit is the number of bytes represented by one unit of memory
+	// or storage.
+	memoryUnit int64
+
+	// headroom is the percentage of discovered resources to hold back.
+	headroom int
+}
+
+// Option is passed to discovery functions to modify the default behaviour.
+type Option func(*options)
+
+// WithMemoryUnit sets the number of bytes represented by one unit of memory
+// or storage, the default is 1<<30 e.g. GiB.  This should match the unit
+// used by tests and smtest.WithMemoryResource.
+func WithMemoryUnit(unit int64) Option {
+	return func(o *options) {
+		o.memoryUnit = unit
+	}
+}
+
+// WithHeadroom holds back a percentage of the discovered resources from Quota
+// and NodeCapacity, for example to leave room for system workloads or other
+// users of a shared cluster.
+func WithHeadroom(percent int) Option {
+	return func(o *options) {
+		o.headroom = percent
+	}
+}
+
+// newOptions returns the default options with any modifications applied.
+func newOptions(opts []Option) *options {
+	o := &options{
+		memoryUnit: 1 << 30,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// convert returns the integral amount of a resource.  CPU is measured in
+// whole cores and memory and storage in the configured unit, always rounding
+// down so the pool is never over-committed.  Anything else e.g. pods or object
+// counts is used as is.
+func convert(name corev1.ResourceName, q resource.Quantity, o *options) int {
+	switch {
+	case strings.HasSuffix(string(name), "cpu"):
+		return int(q.MilliValue() / 1000)
+	case strings.HasSuffix(string(name), "memory"), strings.HasSuffix(string(name), "storage"):
+		return int(q.Value() / o.memoryUnit)
+	}
+
+	return int(q.Value())
+}
+
+// applyHeadroom holds back the configured percentage of every resource.
+func applyHeadroom(resources smtest.ResourceSet, o *options) {
+	for k, v := range resources {
+		resources[k] = v * (100 - o.headroom) / 100
+	}
+}
+
+// Quota returns the remaining headroom of all ResourceQuotas in the namespace,
+// as hard limit minus what is already used.  Resource names are those used by
+// the quota e.g. requests.cpu, limits.memory or pods.  Where multiple quotas
+// limit the same resource, the most restrictive is used.
+func Quota(ctx context.Context, client kubernetes.Interface, namespace string, opts ...Option) (smtest.ResourceSet, error) {
+	o := newOptions(opts)
+
+	quotas, err := client.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for i := range quotas.Items {
+		quota := &quotas.Items[i]
+
+		for name, hard := range quota.Status.Hard {
+			free := hard.DeepCopy()
+
+			if used, ok := quota.Status.Used[name]; ok {
+				free.Sub(used)
+			}
+
+			value := max(convert(name, free, o), 0)
+
+			if current, ok := resources[string(name)]; !ok || value < current {
+				resources[string(name)] = value
+			}
+		}
+	}
+
+	applyHeadroom(resources, o)
+
+	return resources, nil
+}
+
+// LimitRange returns the maximum resources a single pod may consume in the
+// namespace, as defined by its LimitRanges.  Tests that require more than this
+// can never be satisfied by the cluster, so this can be used to validate test
+// requirements.  Where multiple limits apply, the most restrictive is used.
+func LimitRange(ctx context.Context, client kubernetes.Interface, namespace string, opts ...Option) (smtest.ResourceSet, error) {
+	o := newOptions(opts)
+
+	limitRanges, err := client.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for i := range limitRanges.Items {
+		for _, limit := range limitRanges.Items[i].Spec.Limits {
+			if limit.Type != corev1.LimitTypePod && limit.Type != corev1.LimitTypeContainer {
+				continue
+			}
+
+			for name, q := range limit.Max {
+				value := convert(name, q, o)
+
+				if current, ok := resources[string(name)]; !ok || value < current {
+					resources[string(name)] = value
+				}
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+// NodeCapacity returns the total allocatable resources of all schedulable nodes
+// that match the label selector e.g. "node-role.kubernetes.io/worker", for suites
+// that schedule real workloads onto a shared cluster.  Resource names are those
+// used by the nodes e.g. cpu, memory or nvidia.com/gpu.
+func NodeCapacity(ctx context.Context, client kubernetes.Interface, selector string, opts ...Option) (smtest.ResourceSet, error) {
+	o := newOptions(opts)
+
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sum before conversion so fractional CPUs aren't lost to rounding.
+	totals := corev1.ResourceList{}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+
+		if node.Spec.Unschedulable {
+			continue
+		}
+
+		for name, q := range node.Status.Allocatable {
+			total := totals[name]
+			total.Add(q)
+			totals[name] = total
+		}
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for name, q := range totals {
+		resources[string(name)] = convert(name, q, o)
+	}
+
+	applyHeadroom(resources, o)
+
+	return resources, nil
+}
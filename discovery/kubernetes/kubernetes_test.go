@@ -0,0 +1,164 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "e2e", Name: "compute"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{
+					corev1.ResourceRequestsCPU:    resource.MustParse("16"),
+					corev1.ResourceRequestsMemory: resource.MustParse("64Gi"),
+					corev1.ResourcePods:           resource.MustParse("10"),
+				},
+				Used: corev1.ResourceList{
+					corev1.ResourceRequestsCPU:    resource.MustParse("3500m"),
+					corev1.ResourceRequestsMemory: resource.MustParse("16Gi"),
+				},
+			},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "e2e", Name: "pods"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{
+					corev1.ResourcePods: resource.MustParse("20"),
+				},
+				Used: corev1.ResourceList{
+					corev1.ResourcePods: resource.MustParse("15"),
+				},
+			},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "compute"},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{
+					corev1.ResourceRequestsCPU: resource.MustParse("1"),
+				},
+			},
+		},
+	)
+
+	expected := smtest.ResourceSet{
+		"requests.cpu":    12,
+		"requests.memory": 48,
+		"pods":            5,
+	}
+
+	actual, err := Quota(context.Background(), client, "e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestLimitRange(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "e2e", Name: "limits"},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type: corev1.LimitTypePod,
+						Max: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("8"),
+							corev1.ResourceMemory: resource.MustParse("16Gi"),
+						},
+					},
+					{
+						Type: corev1.LimitTypeContainer,
+						Max: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("4"),
+						},
+					},
+				},
+			},
+		},
+	)
+
+	expected := smtest.ResourceSet{
+		"cpu":    4,
+		"memory": 16384,
+	}
+
+	actual, err := LimitRange(context.Background(), client, "e2e", WithMemoryUnit(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestNodeCapacity(t *testing.T) {
+	t.Parallel()
+
+	worker := func(name string, unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"role": "worker"}},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("7500m"),
+					corev1.ResourceMemory: resource.MustParse("32Gi"),
+					"nvidia.com/gpu":      resource.MustParse("1"),
+				},
+			},
+		}
+	}
+
+	control := worker("control", false)
+	control.Labels = nil
+
+	client := fake.NewSimpleClientset(worker("worker-1", false), worker("worker-2", false), worker("cordoned", true), control)
+
+	expected := smtest.ResourceSet{
+		"cpu":            13,
+		"memory":         57,
+		"nvidia.com/gpu": 1,
+	}
+
+	actual, err := NodeCapacity(context.Background(), client, "role=worker", WithHeadroom(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
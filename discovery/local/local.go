@@ -0,0 +1,111 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package local discovers the capacity of the machine, or container, running
+// the tests so the pool automatically matches the CI runner size e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   resources, err := local.Capacity()
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithCPUResource(local.ResourceCPU), smtest.WithMemoryResource(local.ResourceMemory, 1<<30))
+//	   ...
+//	}
+package local
+
+import (
+	"runtime"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// ResourceCPU is the default name of the CPU resource.
+	ResourceCPU = "cpu"
+
+	// ResourceMemory is the default name of the memory resource.
+	ResourceMemory = "memory"
+)
+
+// options are optional settings that alter how resources are named.
+type options struct {
+	// cpuResource is the name of the CPU resource.
+	cpuResource string
+
+	// memoryResource is the name of the memory resource.
+	memoryResource string
+
+	// memoryUnit is the number of bytes represented by one unit of memory.
+	memoryUnit int64
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithCPUResource names the CPU resource, the default is "cpu".
+func WithCPUResource(name string) Option {
+	return func(o *options) {
+		o.cpuResource = name
+	}
+}
+
+// WithMemoryResource names the memory resource, where a single unit is the
+// given number of bytes, the default is "memory" measured in GiB.
+func WithMemoryResource(name string, unit int64) Option {
+	return func(o *options) {
+		o.memoryResource = name
+		o.memoryUnit = unit
+	}
+}
+
+// Capacity returns the number of CPUs and the amount of free memory available
+// to the tests.  CPUs are limited by GOMAXPROCS, and both are limited by any
+// cgroup the process is running in e.g. a container.  Memory is omitted where
+// it cannot be determined.
+func Capacity(opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		cpuResource:    ResourceCPU,
+		memoryResource: ResourceMemory,
+		memoryUnit:     1 << 30,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cpus := min(runtime.NumCPU(), runtime.GOMAXPROCS(0))
+
+	if limit, ok := cgroupCPUs(); ok {
+		cpus = min(cpus, limit)
+	}
+
+	resources := smtest.ResourceSet{
+		o.cpuResource: cpus,
+	}
+
+	memory, ok, err := freeMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		resources[o.memoryResource] = int(memory / o.memoryUnit)
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,187 @@
+//go:build linux
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// root is where the filesystem is mounted, this allows tests to fake
+	// cgroups and procfs.
+	root = "/"
+)
+
+// readInt reads a single integer from a file, returning false if the file
+// doesn't exist or the value is unlimited.
+func readInt(path string) (int64, bool, error) {
+	data, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	value := strings.TrimSpace(string(data))
+
+	if value == "max" {
+		return 0, false, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return i, true, nil
+}
+
+// cgroupCPUs returns the CPU limit imposed by a cgroup, if any.  Partial CPUs
+// are rounded down, with a minimum of one.
+func cgroupCPUs() (int, bool) {
+	var quota, period int64
+
+	// cgroup v2 defines both in a single file e.g. "200000 100000".
+	if data, err := os.ReadFile(filepath.Join(root, "sys/fs/cgroup/cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+
+		var err1, err2 error
+
+		quota, err1 = strconv.ParseInt(fields[0], 10, 64)
+		period, err2 = strconv.ParseInt(fields[1], 10, 64)
+
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+	} else {
+		// cgroup v1 uses -1 to mean unlimited.
+		var ok bool
+
+		if quota, ok, _ = readInt("sys/fs/cgroup/cpu/cpu.cfs_quota_us"); !ok || quota < 0 {
+			return 0, false
+		}
+
+		if period, ok, _ = readInt("sys/fs/cgroup/cpu/cpu.cfs_period_us"); !ok {
+			return 0, false
+		}
+	}
+
+	if period <= 0 {
+		return 0, false
+	}
+
+	return max(int(quota/period), 1), true
+}
+
+// availableMemory returns MemAvailable from procfs, an estimate of how much
+// memory can be used without swapping.
+func availableMemory() (int64, bool, error) {
+	f, err := os.Open(filepath.Join(root, "proc/meminfo"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+
+		kib, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("meminfo: %w", err)
+		}
+
+		return kib << 10, true, nil
+	}
+
+	return 0, false, scanner.Err()
+}
+
+// cgroupMemory returns the memory that can be used before hitting the cgroup
+// limit, if any.
+func cgroupMemory() (int64, bool, error) {
+	paths := [][2]string{
+		{"sys/fs/cgroup/memory.max", "sys/fs/cgroup/memory.current"},
+		{"sys/fs/cgroup/memory/memory.limit_in_bytes", "sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	}
+
+	for _, p := range paths {
+		limit, ok, err := readInt(p[0])
+		if err != nil {
+			return 0, false, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		usage, _, err := readInt(p[1])
+		if err != nil {
+			return 0, false, err
+		}
+
+		return max(limit-usage, 0), true, nil
+	}
+
+	return 0, false, nil
+}
+
+// freeMemory returns the memory available to the process.
+func freeMemory() (int64, bool, error) {
+	memory, ok, err := availableMemory()
+	if err != nil {
+		return 0, false, err
+	}
+
+	limit, limited, err := cgroupMemory()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case ok && limited:
+		return min(memory, limit), true, nil
+	case limited:
+		return limit, true, nil
+	}
+
+	return memory, ok, nil
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRoot creates a fake filesystem with the given files.
+func fakeRoot(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved := root
+	root = dir
+
+	t.Cleanup(func() {
+		root = saved
+	})
+}
+
+const meminfo = `MemTotal:       65536000 kB
+MemFree:         1024000 kB
+MemAvailable:   33554432 kB
+`
+
+func TestCgroupV2(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/meminfo":                 meminfo,
+		"sys/fs/cgroup/cpu.max":        "150000 100000\n",
+		"sys/fs/cgroup/memory.max":     "8589934592\n",
+		"sys/fs/cgroup/memory.current": "1073741824\n",
+	})
+
+	if cpus, ok := cgroupCPUs(); !ok || cpus != 1 {
+		t.Fatalf("expected 1 CPU, got %d", cpus)
+	}
+
+	memory, ok, err := freeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || memory != 7<<30 {
+		t.Fatalf("expected 7GiB, got %d", memory)
+	}
+}
+
+func TestCgroupV1(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/meminfo":                               meminfo,
+		"sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "400000\n",
+		"sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "68719476736\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "0\n",
+	})
+
+	if cpus, ok := cgroupCPUs(); !ok || cpus != 4 {
+		t.Fatalf("expected 4 CPUs, got %d", cpus)
+	}
+
+	memory, ok, err := freeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cgroup limit is larger than what's available on the host.
+	if !ok || memory != 32<<30 {
+		t.Fatalf("expected 32GiB, got %d", memory)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	fakeRoot(t, map[string]string{
+		"proc/meminfo":             meminfo,
+		"sys/fs/cgroup/cpu.max":    "max 100000\n",
+		"sys/fs/cgroup/memory.max": "max\n",
+	})
+
+	if _, ok := cgroupCPUs(); ok {
+		t.Fatal("expected no CPU limit")
+	}
+
+	resources, err := Capacity(WithMemoryResource("ram", 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resources["ram"] != 32<<10 || resources[ResourceCPU] < 1 {
+		t.Fatalf("unexpected resources %v", resources)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package local
+
+// cgroupCPUs returns the CPU limit imposed by a cgroup, cgroups only exist
+// on Linux.
+func cgroupCPUs() (int, bool) {
+	return 0, false
+}
+
+// freeMemory returns the memory available to the process.  Without a portable
+// way of querying the operating system this is unknown.
+func freeMemory() (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,8 @@
+module github.com/spjmurray/testing/discovery/openstack
+
+go 1.25.0
+
+require (
+	github.com/gophercloud/gophercloud/v2 v2.15.0
+	github.com/spjmurray/testing v0.1.0
+)
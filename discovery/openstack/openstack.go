@@ -0,0 +1,164 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package openstack discovers the free quota of an OpenStack project, for
+// suites that provision real servers, networks and volumes e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   // Authenticate and create service clients with gophercloud...
+//
+//	   resources, err := smtestopenstack.Quota(context.Background(), projectID, smtestopenstack.WithCompute(compute), smtestopenstack.WithNetwork(network))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package openstack
+
+import (
+	"context"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	volumequotas "github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/quotasets"
+	computequotas "github.com/gophercloud/gophercloud/v2/openstack/compute/v2/quotasets"
+	networkquotas "github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/quotas"
+)
+
+const (
+	// ResourceCores is the number of Nova vCPUs.
+	ResourceCores = "cores"
+
+	// ResourceRAM is the amount of Nova memory in MiB.
+	ResourceRAM = "ram"
+
+	// ResourceInstances is the number of Nova servers.
+	ResourceInstances = "instances"
+
+	// ResourceNetworks is the number of Neutron networks.
+	ResourceNetworks = "networks"
+
+	// ResourcePorts is the number of Neutron ports.
+	ResourcePorts = "ports"
+
+	// ResourceRouters is the number of Neutron routers.
+	ResourceRouters = "routers"
+
+	// ResourceFloatingIPs is the number of Neutron floating IPs.
+	ResourceFloatingIPs = "floatingips"
+
+	// ResourceVolumes is the number of Cinder volumes.
+	ResourceVolumes = "volumes"
+
+	// ResourceGigabytes is the Cinder volume capacity in GiB.
+	ResourceGigabytes = "gigabytes"
+)
+
+// options are optional settings that alter which services are queried.
+type options struct {
+	// compute is the Nova client.
+	compute *gophercloud.ServiceClient
+
+	// network is the Neutron client.
+	network *gophercloud.ServiceClient
+
+	// volume is the Cinder client.
+	volume *gophercloud.ServiceClient
+}
+
+// Option is passed to Quota to select what services to query.
+type Option func(*options)
+
+// WithCompute reads Nova quotas with the client.
+func WithCompute(client *gophercloud.ServiceClient) Option {
+	return func(o *options) {
+		o.compute = client
+	}
+}
+
+// WithNetwork reads Neutron quotas with the client.
+func WithNetwork(client *gophercloud.ServiceClient) Option {
+	return func(o *options) {
+		o.network = client
+	}
+}
+
+// WithVolume reads Cinder quotas with the client.
+func WithVolume(client *gophercloud.ServiceClient) Option {
+	return func(o *options) {
+		o.volume = client
+	}
+}
+
+// add records the free headroom of a resource.  OpenStack uses a negative
+// limit to mean unlimited, these are omitted as they can't constrain tests.
+func add(resources smtest.ResourceSet, name string, limit, used int) {
+	if limit < 0 {
+		return
+	}
+
+	resources[name] = max(limit-used, 0)
+}
+
+// Quota returns the free headroom, limit minus in use and reserved resources,
+// of the project's quotas for each of the configured services.
+func Quota(ctx context.Context, projectID string, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	resources := smtest.ResourceSet{}
+
+	if o.compute != nil {
+		quota, err := computequotas.GetDetail(ctx, o.compute, projectID).Extract()
+		if err != nil {
+			return nil, err
+		}
+
+		add(resources, ResourceCores, quota.Cores.Limit, quota.Cores.InUse+quota.Cores.Reserved)
+		add(resources, ResourceRAM, quota.RAM.Limit, quota.RAM.InUse+quota.RAM.Reserved)
+		add(resources, ResourceInstances, quota.Instances.Limit, quota.Instances.InUse+quota.Instances.Reserved)
+	}
+
+	if o.network != nil {
+		quota, err := networkquotas.GetDetail(ctx, o.network, projectID).Extract()
+		if err != nil {
+			return nil, err
+		}
+
+		add(resources, ResourceNetworks, quota.Network.Limit, quota.Network.Used+quota.Network.Reserved)
+		add(resources, ResourcePorts, quota.Port.Limit, quota.Port.Used+quota.Port.Reserved)
+		add(resources, ResourceRouters, quota.Router.Limit, quota.Router.Used+quota.Router.Reserved)
+		add(resources, ResourceFloatingIPs, quota.FloatingIP.Limit, quota.FloatingIP.Used+quota.FloatingIP.Reserved)
+	}
+
+	if o.volume != nil {
+		quota, err := volumequotas.GetUsage(ctx, o.volume, projectID).Extract()
+		if err != nil {
+			return nil, err
+		}
+
+		add(resources, ResourceVolumes, quota.Volumes.Limit, quota.Volumes.InUse+quota.Volumes.Reserved)
+		add(resources, ResourceGigabytes, quota.Gigabytes.Limit, quota.Gigabytes.InUse+quota.Gigabytes.Reserved)
+	}
+
+	return resources, nil
+}
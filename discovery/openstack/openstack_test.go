@@ -0,0 +1,72 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/compute/os-quota-sets/test/detail", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"quota_set":{"cores":{"limit":32,"in_use":8,"reserved":2},"ram":{"limit":-1,"in_use":4096,"reserved":0},"instances":{"limit":10,"in_use":12,"reserved":0}}}`)
+	})
+
+	mux.HandleFunc("/network/quotas/test/details.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"quota":{"network":{"limit":10,"used":3,"reserved":0},"port":{"limit":500,"used":20,"reserved":0},"router":{"limit":5,"used":1,"reserved":0},"floatingip":{"limit":20,"used":4,"reserved":1}}}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := func(path string) *gophercloud.ServiceClient {
+		return &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{},
+			Endpoint:       server.URL + path,
+		}
+	}
+
+	expected := smtest.ResourceSet{
+		ResourceCores:       22,
+		ResourceInstances:   0,
+		ResourceNetworks:    7,
+		ResourcePorts:       480,
+		ResourceRouters:     4,
+		ResourceFloatingIPs: 15,
+	}
+
+	actual, err := Quota(context.Background(), "test", WithCompute(client("/compute/")), WithNetwork(client("/network/")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
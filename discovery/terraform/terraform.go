@@ -0,0 +1,248 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package terraform builds the pool from Terraform outputs, so it stays in
+// sync with the infrastructure as code that created the lab.  The lab exports
+// an output, by default named smtest_pool, that maps resource names to a list
+// of instances with arbitrary attributes e.g.
+//
+//	output "smtest_pool" {
+//	  value = {
+//	    vm = [for vm in vsphere_virtual_machine.lab : {
+//	      name = vm.name
+//	      ip   = vm.default_ip_address
+//	      cpus = vm.num_cpus
+//	    }]
+//	  }
+//	}
+//
+// Each test is then handed specific instances e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   pool, err := terraform.Read("terraform.tfstate")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(pool.Resources(), smtest.WithProvider("vm", pool.Provider("vm")))
+//	   ...
+//	}
+//
+//	func TestInstall(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"vm": 1})
+//	   defer allocation.Release()
+//
+//	   ip := terraform.Instances(allocation, "vm")[0].String("ip")
+//	   ...
+//	}
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// defaultOutput is the output that describes the pool.
+	defaultOutput = "smtest_pool"
+)
+
+var (
+	// ErrOutputNotFound is returned when the pool output doesn't exist.
+	ErrOutputNotFound = errors.New("terraform output not found")
+
+	// ErrNoInstance is returned when there is no free instance, which implies
+	// the provider is registered against more instances than exist.
+	ErrNoInstance = errors.New("no free instance")
+)
+
+// Instance is a single instance of a resource, with the attributes given to it
+// by the output.
+type Instance map[string]any
+
+// String returns an attribute as a string, or an empty string if it isn't one.
+func (i Instance) String(key string) string {
+	s, _ := i[key].(string)
+
+	return s
+}
+
+// Int returns an attribute as an integer, or zero if it isn't a number.
+func (i Instance) Int(key string) int {
+	f, _ := i[key].(float64)
+
+	return int(f)
+}
+
+// output is a Terraform output, in both state and terraform output -json.
+type output struct {
+	// Value is the output's value.
+	Value json.RawMessage `json:"value"`
+}
+
+// document is either a state file, or terraform output -json.  State files
+// have a version and nest outputs, otherwise outputs are at the top level.
+type document struct {
+	// Version is the state file format version.
+	Version int `json:"version"`
+
+	// Outputs are the state file's outputs.
+	Outputs map[string]output `json:"outputs"`
+}
+
+// options are optional settings that alter how the pool is read.
+type options struct {
+	// output is the output that describes the pool.
+	output string
+}
+
+// Option is passed to Read to modify the default behaviour.
+type Option func(*options)
+
+// WithOutput sets the output that describes the pool, the default is
+// smtest_pool.
+func WithOutput(name string) Option {
+	return func(o *options) {
+		o.output = name
+	}
+}
+
+// Pool is the set of instances described by the output.
+type Pool struct {
+	// instances are keyed by resource name.
+	instances map[string][]Instance
+}
+
+// Read returns the pool from a Terraform state file, or the output of
+// terraform output -json.
+func Read(path string, opts ...Option) (*Pool, error) {
+	o := &options{
+		output: defaultOutput,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var d document
+
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+
+	outputs := d.Outputs
+
+	if d.Version == 0 {
+		if err := json.Unmarshal(data, &outputs); err != nil {
+			return nil, err
+		}
+	}
+
+	out, ok := outputs[o.output]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrOutputNotFound, o.output)
+	}
+
+	p := &Pool{}
+
+	if err := json.Unmarshal(out.Value, &p.instances); err != nil {
+		return nil, fmt.Errorf("output %s is not a map of lists of objects: %w", o.output, err)
+	}
+
+	return p, nil
+}
+
+// Resources returns the number of instances of each resource.
+func (p *Pool) Resources() smtest.ResourceSet {
+	resources := smtest.ResourceSet{}
+
+	for name, instances := range p.instances {
+		resources[name] = len(instances)
+	}
+
+	return resources
+}
+
+// provider hands out specific instances.
+type provider struct {
+	// free are the instances not allocated to a test.
+	free []Instance
+
+	// lock serializes access to the free list.
+	lock sync.Mutex
+}
+
+// Provider returns a provider that gives each unit of the resource a specific
+// free instance.  Instances are of type Instance.
+func (p *Pool) Provider(name string) smtest.Provider {
+	return &provider{
+		free: append([]Instance(nil), p.instances[name]...),
+	}
+}
+
+// Acquire takes a free instance.
+func (p *provider) Acquire(_ context.Context, _ string) (any, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.free) == 0 {
+		return nil, ErrNoInstance
+	}
+
+	instance := p.free[0]
+	p.free = p.free[1:]
+
+	return instance, nil
+}
+
+// Release returns the instance to the free list.
+func (p *provider) Release(_ context.Context, instance any) error {
+	i, ok := instance.(Instance)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.free = append(p.free, i)
+
+	return nil
+}
+
+// Instances returns the instances allocated to a test for the named resource.
+func Instances(allocation *smtest.Allocation, name string) []Instance {
+	var instances []Instance
+
+	for _, instance := range allocation.Instances(name) {
+		if i, ok := instance.(Instance); ok {
+			instances = append(instances, i)
+		}
+	}
+
+	return instances
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const pool = `{
+  "sensitive": false,
+  "type": ["object", {}],
+  "value": {
+    "vm": [
+      {"name": "lab-0", "ip": "10.0.0.10", "cpus": 4},
+      {"name": "lab-1", "ip": "10.0.0.11", "cpus": 4}
+    ],
+    "subnet": [
+      {"cidr": "10.1.0.0/24"}
+    ]
+  }
+}`
+
+// write writes the document to a temporary file.
+func write(t *testing.T, document string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "terraform.json")
+
+	if err := os.WriteFile(path, []byte(document), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	documents := map[string]string{
+		"state":   `{"version": 4, "terraform_version": "1.9.0", "outputs": {"smtest_pool": ` + pool + `}, "resources": []}`,
+		"outputs": `{"smtest_pool": ` + pool + `}`,
+	}
+
+	for name, document := range documents {
+		p, err := Read(write(t, document))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if resources := p.Resources(); resources["vm"] != 2 || resources["subnet"] != 1 {
+			t.Fatalf("%s: unexpected resources %v", name, resources)
+		}
+	}
+}
+
+func TestReadOutputNotFound(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Read(write(t, `{"smtest_pool": `+pool+`}`), WithOutput("missing")); !errors.Is(err, ErrOutputNotFound) {
+		t.Fatalf("expected output not found, got %v", err)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	p, err := Read(write(t, `{"smtest_pool": `+pool+`}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := p.Provider("vm")
+
+	first, err := provider.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i := first.(Instance); i.String("ip") != "10.0.0.10" || i.Int("cpus") != 4 {
+		t.Fatalf("unexpected instance %v", i)
+	}
+
+	if _, err := provider.Acquire(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Acquire(ctx, "c"); !errors.Is(err, ErrNoInstance) {
+		t.Fatalf("expected no free instance, got %v", err)
+	}
+
+	if err := provider.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Acquire(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/spjmurray/testing/discovery/vsphere
+
+go 1.23.0
+
+require (
+	github.com/spjmurray/testing v0.1.0
+	github.com/vmware/govmomi v0.52.0
+)
+
+require github.com/google/uuid v1.6.0 // indirect
@@ -0,0 +1,175 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vsphere discovers the free CPU, memory and datastore space of a
+// vSphere cluster, for on-premise lab suites e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client, err := govmomi.NewClient(context.Background(), url, true)
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   resources, err := vsphere.Capacity(context.Background(), client.Client, "/lab/host/e2e")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources)
+//	   ...
+//	}
+package vsphere
+
+import (
+	"context"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// ResourceCPU is the number of free physical cores.
+	ResourceCPU = "cpu"
+
+	// ResourceMemory is the free memory.
+	ResourceMemory = "memory"
+
+	// ResourceStorage is the free space of the cluster's datastores.
+	ResourceStorage = "storage"
+)
+
+// options are optional settings that alter how resources are converted.
+type options struct {
+	// memoryUnit is the number of bytes represented by one unit of memory
+	// or storage.
+	memoryUnit int64
+
+	// headroom is the percentage of discovered resources to hold back.
+	headroom int
+}
+
+// Option is passed to Capacity to modify the default behaviour.
+type Option func(*options)
+
+// WithMemoryUnit sets the number of bytes represented by one unit of memory
+// or storage, the default is 1<<30 e.g. GiB.
+func WithMemoryUnit(unit int64) Option {
+	return func(o *options) {
+		o.memoryUnit = unit
+	}
+}
+
+// WithHeadroom holds back a percentage of the discovered resources, for example
+// to leave room for infrastructure VMs or other users of a shared lab.
+func WithHeadroom(percent int) Option {
+	return func(o *options) {
+		o.headroom = percent
+	}
+}
+
+// Capacity returns the free resources of the cluster at the inventory path
+// e.g. /datacenter/host/cluster.  CPU and memory are what the cluster's hosts
+// have minus what they are currently using, ignoring hosts that are in
+// maintenance mode or disconnected.  CPU is counted in cores, converted from
+// MHz using each host's core speed, and rounded down.
+func Capacity(ctx context.Context, client *vim25.Client, path string, opts ...Option) (smtest.ResourceSet, error) {
+	o := &options{
+		memoryUnit: 1 << 30,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cluster, err := find.NewFinder(client).ClusterComputeResource(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c mo.ClusterComputeResource
+
+	collector := property.DefaultCollector(client)
+
+	if err := collector.RetrieveOne(ctx, cluster.Reference(), []string{"host", "datastore"}, &c); err != nil {
+		return nil, err
+	}
+
+	var hosts []mo.HostSystem
+
+	if len(c.Host) > 0 {
+		if err := collector.Retrieve(ctx, c.Host, []string{"summary", "runtime"}, &hosts); err != nil {
+			return nil, err
+		}
+	}
+
+	var datastores []mo.Datastore
+
+	if len(c.Datastore) > 0 {
+		if err := collector.Retrieve(ctx, c.Datastore, []string{"summary"}, &datastores); err != nil {
+			return nil, err
+		}
+	}
+
+	var cores float64
+
+	var memory int64
+
+	for i := range hosts {
+		host := &hosts[i]
+
+		if host.Runtime.InMaintenanceMode || host.Runtime.ConnectionState != types.HostSystemConnectionStateConnected {
+			continue
+		}
+
+		hardware := host.Summary.Hardware
+		stats := host.Summary.QuickStats
+
+		if hardware == nil || hardware.CpuMhz == 0 {
+			continue
+		}
+
+		freeMHz := int64(hardware.CpuMhz)*int64(hardware.NumCpuCores) - int64(stats.OverallCpuUsage)
+		cores += float64(max(freeMHz, 0)) / float64(hardware.CpuMhz)
+
+		// Memory usage is reported in MB.
+		memory += max(hardware.MemorySize-int64(stats.OverallMemoryUsage)<<20, 0)
+	}
+
+	var storage int64
+
+	for i := range datastores {
+		if summary := datastores[i].Summary; summary.Accessible {
+			storage += summary.FreeSpace
+		}
+	}
+
+	resources := smtest.ResourceSet{
+		ResourceCPU:     int(cores),
+		ResourceMemory:  int(memory / o.memoryUnit),
+		ResourceStorage: int(storage / o.memoryUnit),
+	}
+
+	for k, v := range resources {
+		resources[k] = v * (100 - o.headroom) / 100
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+)
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	simulator.Test(func(ctx context.Context, client *vim25.Client) {
+		resources, err := Capacity(ctx, client, "/DC0/host/DC0_C0", WithMemoryUnit(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{ResourceCPU, ResourceMemory, ResourceStorage} {
+			if resources[name] <= 0 {
+				t.Fatalf("expected free %s, got %v", name, resources)
+			}
+		}
+
+		held, err := Capacity(ctx, client, "/DC0/host/DC0_C0", WithMemoryUnit(1<<20), WithHeadroom(50))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if held[ResourceMemory] != resources[ResourceMemory]/2 {
+			t.Fatalf("expected headroom to be held back, got %v", held)
+		}
+	})
+}
+
+func TestCapacityNotFound(t *testing.T) {
+	t.Parallel()
+
+	simulator.Test(func(ctx context.Context, client *vim25.Client) {
+		if _, err := Capacity(ctx, client, "/DC0/host/missing"); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
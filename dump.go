@@ -0,0 +1,220 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// state is a point in time copy of the scheduler's internal state.
+type state struct {
+	// unallocated is the set of free resources.
+	unallocated ResourceSet
+
+	// waiting are the queued tests, in the order they joined the queue.
+	waiting []waiting
+}
+
+// waiting is a queued test.
+type waiting struct {
+	// id uniquely identifies the allocation.
+	id string
+
+	// name is the test name, which may not be unique.
+	name string
+
+	// required are the resources the test is waiting for.
+	required ResourceSet
+
+	// enqueued is when the test joined the queue.
+	enqueued time.Time
+}
+
+var (
+	// snapshot is used to request a copy of the scheduler state.
+	snapshot chan chan *state
+
+	// started is when Start was called.
+	started time.Time
+
+	// timeoutDumpLock protects timeoutDumpArmed and timeoutDump.
+	timeoutDumpLock sync.Mutex
+
+	// timeoutDumpArmed ensures the timeout dump is only armed once.
+	timeoutDumpArmed bool
+
+	// timeoutDump, if set, dumps the state before the test timeout expires.
+	timeoutDump *time.Timer
+)
+
+// getState asks the scheduler for a consistent copy of its state.  Once
+// stopped nothing is queued or free.
+func getState() *state {
+	reply := make(chan *state)
+
+	select {
+	case snapshot <- reply:
+	case <-stop:
+		return &state{
+			unallocated: ResourceSet{},
+		}
+	}
+
+	return <-reply
+}
+
+// copyState is called by the scheduler to service getState.
+func copyState() *state {
+	s := &state{
+		unallocated: local.Free(),
+	}
+
+	for id, item := range queue.items {
+		s.waiting = append(s.waiting, waiting{
+			id:       id,
+			name:     item.record.name,
+			required: item.required,
+			enqueued: item.enqueued,
+		})
+	}
+
+	sort.Slice(s.waiting, func(i, j int) bool {
+		return s.waiting[i].enqueued.Before(s.waiting[j].enqueued)
+	})
+
+	return s
+}
+
+// dump prints the full scheduler state, what tests are waiting and for what,
+// and which are holding resources and for how long.
+func dump() {
+	dumpState(getState())
+}
+
+// dumpState prints the scheduler state.
+func dumpState(s *state) {
+	now := time.Now()
+
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("unallocated %v", s.unallocated))
+
+	recordsLock.Lock()
+
+	var holders []*record
+
+	for r := range held {
+		holders = append(holders, r)
+	}
+
+	recordsLock.Unlock()
+
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].scheduled.Before(holders[j].scheduled)
+	})
+
+	for _, r := range holders {
+		lines = append(lines, fmt.Sprintf("holding %s %v for %.2fs", r.name, r.required, now.Sub(r.scheduled).Seconds()))
+	}
+
+	for _, w := range s.waiting {
+		unmet := ResourceSet{}
+
+		for k, v := range w.required {
+			if short := v - s.unallocated[k]; short > 0 {
+				unmet[k] = short
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("waiting %s for %.2fs, short of %v", w.name, now.Sub(w.enqueued).Seconds(), unmet))
+	}
+
+	for _, line := range lines {
+		emit(nil, event{
+			Action:  "dump",
+			Message: line,
+		})
+	}
+}
+
+// dumpOnSignal dumps the scheduler state whenever one of the configured
+// signals is received.
+func dumpOnSignal(signals []os.Signal, stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ch:
+			dump()
+		}
+	}
+}
+
+// startTimeoutDump arms a timer that dumps the scheduler state shortly before
+// go test's -timeout would kill the process.  This must be called after
+// flags have been parsed.
+func startTimeoutDump() {
+	timeoutDumpLock.Lock()
+	defer timeoutDumpLock.Unlock()
+
+	if timeoutDumpArmed {
+		return
+	}
+
+	timeoutDumpArmed = true
+
+	f := flag.Lookup("test.timeout")
+	if f == nil {
+		return
+	}
+
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return
+	}
+
+	timeout, ok := getter.Get().(time.Duration)
+	if !ok || timeout <= 0 {
+		return
+	}
+
+	timeoutDump = time.AfterFunc(time.Until(started.Add(timeout-config.timeoutDumpMargin)), dump)
+}
+
+// stopTimeoutDump disarms the timeout dump, so it may be armed again.
+func stopTimeoutDump() {
+	timeoutDumpLock.Lock()
+	defer timeoutDumpLock.Unlock()
+
+	if timeoutDump != nil {
+		timeoutDump.Stop()
+	}
+
+	timeoutDumpArmed = false
+	timeoutDump = nil
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+// TestDump checks the scheduler, as started by TestMain, services state
+// requests, and that dumping doesn't deadlock with running tests.
+func TestDump(t *testing.T) {
+	t.Parallel()
+
+	s := getState()
+
+	for k, v := range s.unallocated {
+		if v > available[k] {
+			t.Fatalf("unallocated %s %d exceeds available %d", k, v, available[k])
+		}
+	}
+
+	dump()
+}
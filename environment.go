@@ -0,0 +1,125 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// resourcesEnvironmentVariable overrides resources passed to Start e.g.
+	// "cpu=16,memory=64Gi".
+	resourcesEnvironmentVariable = "SMTEST_RESOURCES"
+)
+
+// binarySuffixes are the byte multipliers a memory quantity may be given with.
+var binarySuffixes = map[string]int64{
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// parseQuantity parses the value of a resource.  The memory resource may have a
+// binary suffix e.g. "64Gi", which is converted into its unit as set with
+// WithMemoryResource.
+func parseQuantity(name, value string, o *options) (int, error) {
+	for suffix, multiplier := range binarySuffixes {
+		number, ok := strings.CutSuffix(value, suffix)
+		if !ok {
+			continue
+		}
+
+		if name != o.memoryResource || o.memoryUnit <= 0 {
+			return 0, fmt.Errorf("%w: %s has a unit suffix, only the memory resource may", ErrInvalidResourceSet, name)
+		}
+
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s value: %w", ErrInvalidResourceSet, name, err)
+		}
+
+		return int(n * multiplier / o.memoryUnit), nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s value: %w", ErrInvalidResourceSet, name, err)
+	}
+
+	return n, nil
+}
+
+// parseResources parses a resource set in the form "cpu=16,memory=64Gi".
+func parseResources(s string, o *options) (ResourceSet, error) {
+	r := ResourceSet{}
+
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%w: resource %q not of the form name=value", ErrInvalidResourceSet, part)
+		}
+
+		value, err := parseQuantity(k, v, o)
+		if err != nil {
+			return nil, err
+		}
+
+		r[k] = value
+	}
+
+	return r, nil
+}
+
+// overrideResources returns a copy of the resources, with any overrides from
+// the environment, then the command line, merged on top, so the pool can be
+// sized per CI runner or invocation without changing code.
+func overrideResources(resources ResourceSet, o *options) (ResourceSet, error) {
+	result := ResourceSet{}
+
+	for k, v := range resources {
+		result[k] = v
+	}
+
+	sources := []struct {
+		name  string
+		value string
+	}{
+		{name: resourcesEnvironmentVariable, value: os.Getenv(resourcesEnvironmentVariable)},
+		{name: "-" + resourcesFlagName, value: resourcesFlag},
+	}
+
+	for _, source := range sources {
+		if source.value == "" {
+			continue
+		}
+
+		overrides, err := parseResources(source.value, o)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.name, err)
+		}
+
+		for k, v := range overrides {
+			result[k] = v
+		}
+	}
+
+	return result, nil
+}
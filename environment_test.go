@@ -0,0 +1,91 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseResources(t *testing.T) {
+	t.Parallel()
+
+	o := &options{
+		memoryResource: "memory",
+		memoryUnit:     1 << 30,
+	}
+
+	r, err := parseResources("cpu=16, memory=64Gi,gpu=2", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.String() != "cpu=16,gpu=2,memory=64" {
+		t.Fatalf("unexpected resources %v", r)
+	}
+
+	r, err = parseResources("memory=512Mi", o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r["memory"] != 0 {
+		t.Fatalf("expected partial units to round down, got %v", r)
+	}
+
+	for _, s := range []string{"cpu", "=1", "cpu=lots", "cpu=4Gi", "memory=xGi"} {
+		if _, err := parseResources(s, o); !errors.Is(err, ErrInvalidResourceSet) {
+			t.Fatalf("expected %q to be invalid, got %v", s, err)
+		}
+	}
+}
+
+// TestOverrideResources is not parallel as it replaces the environment.
+func TestOverrideResources(t *testing.T) {
+	resources := ResourceSet{"cpu": 4, "memory": 8}
+
+	t.Setenv(resourcesEnvironmentVariable, "")
+
+	r, err := overrideResources(resources, &options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is always a copy.
+	r["cpu"] = 1
+
+	if resources["cpu"] != 4 {
+		t.Fatal("expected resources to be copied")
+	}
+
+	t.Setenv(resourcesEnvironmentVariable, "cpu=16,gpu=1")
+
+	r, err = overrideResources(resources, &options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.String() != "cpu=16,gpu=1,memory=8" {
+		t.Fatalf("expected overrides to be merged, got %v", r)
+	}
+
+	t.Setenv(resourcesEnvironmentVariable, "cpu")
+
+	if _, err := overrideResources(resources, &options{}); !errors.Is(err, ErrInvalidResourceSet) {
+		t.Fatalf("expected invalid resource set error, got %v", err)
+	}
+}
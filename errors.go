@@ -0,0 +1,39 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidResourceSet is returned when a resource set cannot be parsed.
+	ErrInvalidResourceSet = errors.New("invalid resource set")
+
+	// ErrInvalidRecord is returned when an allocation record cannot be parsed.
+	ErrInvalidRecord = errors.New("invalid record")
+
+	// ErrInvalidPolicy is returned when a policy name is not recognized.
+	ErrInvalidPolicy = errors.New("invalid policy")
+
+	// ErrInvalidScale is returned when a scale is not a positive number.
+	ErrInvalidScale = errors.New("invalid scale")
+
+	// ErrInvalidRequirements is returned when a requirements file cannot be
+	// parsed.
+	ErrInvalidRequirements = errors.New("invalid requirements")
+)
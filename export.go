@@ -0,0 +1,329 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// exportEnvironmentVariable names a file that allocation records are
+	// written to as they are released.  Files with a .csv extension are
+	// written as CSV, anything else as JSON lines.
+	exportEnvironmentVariable = "SMTEST_EXPORT"
+)
+
+// Record is the machine readable history of a single allocation, as written
+// to the file named by the SMTEST_EXPORT environment variable.
+type Record struct {
+	// ID uniquely identifies the allocation.
+	ID string `json:"id"`
+
+	// Package is the import path of the package the test is in, empty in
+	// records exported before packages were recorded.
+	Package string `json:"package,omitempty"`
+
+	// Test is the test name.
+	Test string `json:"test"`
+
+	// Resources are the resources the test required.
+	Resources ResourceSet `json:"resources"`
+
+	// Enqueued is when the test joined the queue.
+	Enqueued time.Time `json:"enqueued"`
+
+	// Scheduled is when the test was granted its resources.
+	Scheduled time.Time `json:"scheduled"`
+
+	// Released is when the test gave its resources back.
+	Released time.Time `json:"released"`
+}
+
+// csvHeader is the first line of a CSV export.
+var csvHeader = []string{"test", "resources", "enqueued", "scheduled", "released", "id", "package"}
+
+// csvColumnsWithoutPackage is how many columns are in CSV exports written
+// before packages were recorded.
+const csvColumnsWithoutPackage = 6
+
+// testPackage returns the import path of the package under test, go test
+// names the test binary after it.
+var testPackage = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimSuffix(info.Path, ".test")
+})
+
+// recordWriter writes records in a specific format.
+type recordWriter interface {
+	write(r *Record) error
+}
+
+// jsonRecordWriter writes records as JSON lines.
+type jsonRecordWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonRecordWriter) write(r *Record) error {
+	return w.encoder.Encode(r)
+}
+
+// csvRecordWriter writes records as CSV.
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvRecordWriter) write(r *Record) error {
+	row := []string{
+		r.Test,
+		r.Resources.String(),
+		r.Enqueued.Format(time.RFC3339Nano),
+		r.Scheduled.Format(time.RFC3339Nano),
+		r.Released.Format(time.RFC3339Nano),
+		r.ID,
+		r.Package,
+	}
+
+	if err := w.writer.Write(row); err != nil {
+		return err
+	}
+
+	w.writer.Flush()
+
+	return w.writer.Error()
+}
+
+// newRecordWriter returns a writer for the format implied by the path.  A CSV
+// header is written if requested, when the file is new.
+func newRecordWriter(path string, w io.Writer, header bool) (recordWriter, error) {
+	if filepath.Ext(path) == ".csv" {
+		writer := &csvRecordWriter{
+			writer: csv.NewWriter(w),
+		}
+
+		if !header {
+			return writer, nil
+		}
+
+		if err := writer.writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+
+		writer.writer.Flush()
+
+		return writer, writer.writer.Error()
+	}
+
+	return &jsonRecordWriter{encoder: json.NewEncoder(w)}, nil
+}
+
+var (
+	// exportLock serializes writes to the exporter.
+	exportLock sync.Mutex
+
+	// exportFile is the file the exporter writes to.
+	exportFile *os.File
+
+	// exporter, if set, has allocation records written to it.
+	exporter recordWriter
+)
+
+// openExport opens the export file for appending, so the binaries of every
+// package run by go test ./... add to the same file rather than replacing
+// each other's records.
+func openExport(path string) (*os.File, recordWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return nil, nil, err
+	}
+
+	writer, err := newRecordWriter(path, f, info.Size() == 0)
+	if err != nil {
+		f.Close()
+
+		return nil, nil, err
+	}
+
+	return f, writer, nil
+}
+
+// startExport opens the export file, if one is configured.
+func startExport() {
+	path := os.Getenv(exportEnvironmentVariable)
+	if path == "" {
+		return
+	}
+
+	f, writer, err := openExport(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to open export file: %v\n", err)
+		return
+	}
+
+	exportLock.Lock()
+	defer exportLock.Unlock()
+
+	exportFile = f
+	exporter = writer
+}
+
+// stopExport closes the export file, so a later Start only exports if it is
+// still configured to.
+func stopExport() {
+	exportLock.Lock()
+	defer exportLock.Unlock()
+
+	if exportFile != nil {
+		if err := exportFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "smtest: failed to close export file: %v\n", err)
+		}
+	}
+
+	exportFile = nil
+	exporter = nil
+}
+
+// export writes the record, if exporting is enabled.
+func export(r *record) {
+	exportLock.Lock()
+	defer exportLock.Unlock()
+
+	if exporter == nil {
+		return
+	}
+
+	if err := exporter.write(r.export()); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to export record: %v\n", err)
+	}
+}
+
+// ReadRecords reads allocation records from a file written via SMTEST_EXPORT.
+func ReadRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if filepath.Ext(path) == ".csv" {
+		return readCSVRecords(f)
+	}
+
+	return readJSONRecords(f)
+}
+
+// readJSONRecords reads JSON line records.
+func readJSONRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// readCSVRecords reads CSV records.
+func readCSVRecords(r io.Reader) ([]Record, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	records := make([]Record, 0, len(rows)-1)
+
+	for _, row := range rows[1:] {
+		// Binaries that start at the same time may both find the file
+		// empty, so headers can appear again part way through.
+		if len(row) <= len(csvHeader) && slices.Equal(row, csvHeader[:len(row)]) {
+			continue
+		}
+
+		if len(row) != len(csvHeader) && len(row) != csvColumnsWithoutPackage {
+			return nil, fmt.Errorf("%w: expected %d columns, got %d", ErrInvalidRecord, len(csvHeader), len(row))
+		}
+
+		resources, err := ParseResourceSet(row[1])
+		if err != nil {
+			return nil, err
+		}
+
+		record := Record{
+			ID:        row[5],
+			Test:      row[0],
+			Resources: resources,
+		}
+
+		if len(row) > csvColumnsWithoutPackage {
+			record.Package = row[6]
+		}
+
+		times := []*time.Time{&record.Enqueued, &record.Scheduled, &record.Released}
+
+		for i, t := range times {
+			if *t, err = time.Parse(time.RFC3339Nano, row[i+2]); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
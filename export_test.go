@@ -0,0 +1,155 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResourceSetString(t *testing.T) {
+	t.Parallel()
+
+	r := ResourceSet{"memory": 32, "cpu": 8}
+
+	if s := r.String(); s != "cpu=8,memory=32" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	parsed, err := ParseResourceSet(r.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, r) {
+		t.Fatalf("expected %v, got %v", r, parsed)
+	}
+
+	if _, err := ParseResourceSet("cpu"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+
+	expected := []Record{
+		{
+			ID:        "0123456789abcdef",
+			Package:   "example.com/e2e",
+			Test:      "TestFoo",
+			Resources: ResourceSet{"cpu": 8, "memory": 32},
+			Enqueued:  now,
+			Scheduled: now.Add(time.Second),
+			Released:  now.Add(2 * time.Second),
+		},
+		{
+			ID:        "fedcba9876543210",
+			Test:      "TestBar/baz",
+			Resources: ResourceSet{"cpu": 1},
+			Enqueued:  now,
+			Scheduled: now,
+			Released:  now.Add(time.Second),
+		},
+	}
+
+	for _, name := range []string{"export.csv", "export.json"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writer, err := newRecordWriter(path, f, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range expected {
+			if err := writer.write(&expected[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := ReadRecords(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+}
+
+func TestExportAppend(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+
+	for _, name := range []string{"export.csv", "export.json"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		var expected []Record
+
+		// Each package's test binary opens the file in turn.
+		for _, test := range []string{"TestFoo", "TestBar"} {
+			f, writer, err := openExport(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			record := Record{
+				ID:        test,
+				Test:      test,
+				Resources: ResourceSet{"cpu": 1},
+				Enqueued:  now,
+				Scheduled: now,
+				Released:  now.Add(time.Second),
+			}
+
+			if err := writer.write(&record); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			expected = append(expected, record)
+		}
+
+		actual, err := ReadRecords(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+}
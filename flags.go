@@ -0,0 +1,108 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	// resourcesFlagName overrides resources passed to Start, and any from the
+	// environment, e.g. -smtest.resources=cpu=8.
+	resourcesFlagName = "smtest.resources"
+
+	// policyFlagName overrides the policy set with WithPolicy e.g.
+	// -smtest.policy=fifo.
+	policyFlagName = "smtest.policy"
+
+	// chaosFlagName overrides chaos mode set with WithChaos e.g.
+	// -smtest.chaos=on.
+	chaosFlagName = "smtest.chaos"
+)
+
+var (
+	// resourcesFlag is the value of -smtest.resources.
+	resourcesFlag string
+
+	// policyFlag is the value of -smtest.policy.
+	policyFlag string
+
+	// chaosFlag is the value of -smtest.chaos.
+	chaosFlag string
+)
+
+// init registers flags alongside go test's own, but only in test binaries, so
+// programs that import this package don't have them forced on them.
+func init() {
+	if !testing.Testing() {
+		return
+	}
+
+	flag.StringVar(&resourcesFlag, resourcesFlagName, "", "override resources passed to smtest.Start e.g. cpu=8,memory=16Gi")
+	flag.StringVar(&policyFlag, policyFlagName, "", "override the smtest scheduling policy, firstfit or fifo")
+	flag.StringVar(&chaosFlag, chaosFlagName, "", "randomly perturb smtest grants: off, on, or a seed to reproduce a run")
+}
+
+// parseFlags parses the command line, as Start is typically called from
+// TestMain before testing.M.Run would do so.
+func parseFlags() {
+	if testing.Testing() && !flag.Parsed() {
+		flag.Parse()
+	}
+}
+
+// overridePolicy sets the policy from the command line, if given.
+func overridePolicy(o *options) error {
+	if policyFlag == "" {
+		return nil
+	}
+
+	policy, err := ParsePolicy(policyFlag)
+	if err != nil {
+		return fmt.Errorf("-%s: %w", policyFlagName, err)
+	}
+
+	o.policy = policy
+
+	return nil
+}
+
+// overrideChaos sets chaos mode from the command line, if given.
+func overrideChaos(o *options) error {
+	switch chaosFlag {
+	case "":
+	case "off":
+		o.chaos = false
+	case "on":
+		o.chaos = true
+		o.chaosSeed = time.Now().UnixNano()
+	default:
+		seed, err := strconv.ParseInt(chaosFlag, 10, 64)
+		if err != nil {
+			return fmt.Errorf("-%s: %w: %q", chaosFlagName, ErrInvalidChaos, chaosFlag)
+		}
+
+		o.chaos = true
+		o.chaosSeed = seed
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"flag"
+	"testing"
+)
+
+func TestFlagsRegistered(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{resourcesFlagName, policyFlagName, chaosFlagName} {
+		if flag.Lookup(name) == nil {
+			t.Fatalf("expected flag %s to be registered", name)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []Policy{PolicyFirstFit, PolicyFIFO} {
+		parsed, err := ParsePolicy(policy.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed != policy {
+			t.Fatalf("expected %v, got %v", policy, parsed)
+		}
+	}
+
+	if _, err := ParsePolicy("lifo"); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected invalid policy, got %v", err)
+	}
+}
+
+// TestOverrideFromFlags is not parallel as it modifies flag values and the
+// environment.
+func TestOverrideFromFlags(t *testing.T) {
+	t.Cleanup(func() {
+		resourcesFlag = ""
+		policyFlag = ""
+	})
+
+	t.Setenv(resourcesEnvironmentVariable, "cpu=16,gpu=1")
+
+	resourcesFlag = "cpu=8"
+
+	r, err := overrideResources(ResourceSet{"cpu": 4, "memory": 8}, &options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags take precedence over the environment.
+	if r.String() != "cpu=8,gpu=1,memory=8" {
+		t.Fatalf("unexpected resources %v", r)
+	}
+
+	resourcesFlag = "cpu"
+
+	if _, err := overrideResources(ResourceSet{"cpu": 4}, &options{}); !errors.Is(err, ErrInvalidResourceSet) {
+		t.Fatalf("expected invalid resources, got %v", err)
+	}
+
+	o := &options{policy: PolicyFIFO}
+
+	if err := overridePolicy(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.policy != PolicyFIFO {
+		t.Fatalf("expected policy to be unchanged, got %v", o.policy)
+	}
+
+	policyFlag = "firstfit"
+
+	if err := overridePolicy(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.policy != PolicyFirstFit {
+		t.Fatalf("expected policy to be overridden, got %v", o.policy)
+	}
+
+	policyFlag = "lifo"
+
+	if err := overridePolicy(o); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected invalid policy, got %v", err)
+	}
+}
+
+// TestOverrideChaos is not parallel as it modifies flag values.
+func TestOverrideChaos(t *testing.T) {
+	original := chaosFlag
+
+	t.Cleanup(func() {
+		chaosFlag = original
+	})
+
+	chaosFlag = ""
+
+	o := &options{chaos: true, chaosSeed: 1}
+
+	if err := overrideChaos(o); err != nil || !o.chaos || o.chaosSeed != 1 {
+		t.Fatalf("expected chaos to be unchanged, got %v %d", err, o.chaosSeed)
+	}
+
+	chaosFlag = "42"
+
+	if err := overrideChaos(o); err != nil || o.chaosSeed != 42 {
+		t.Fatalf("expected the seed to be overridden, got %v %d", err, o.chaosSeed)
+	}
+
+	chaosFlag = "off"
+
+	if err := overrideChaos(o); err != nil || o.chaos {
+		t.Fatalf("expected chaos to be disabled, got %v", err)
+	}
+
+	chaosFlag = "on"
+
+	if err := overrideChaos(o); err != nil || !o.chaos {
+		t.Fatalf("expected chaos to be enabled, got %v", err)
+	}
+
+	chaosFlag = "sometimes"
+
+	if err := overrideChaos(o); !errors.Is(err, ErrInvalidChaos) {
+		t.Fatalf("expected invalid chaos, got %v", err)
+	}
+}
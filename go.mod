@@ -1,3 +1,5 @@
 module github.com/spjmurray/testing
 
 go 1.21.1
+
+require go.uber.org/goleak v1.3.0
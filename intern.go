@@ -0,0 +1,94 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// internLock protects internedResources, tests intern concurrently.
+	internLock sync.Mutex
+
+	// internedResources are the distinct resource sets seen so far, keyed
+	// by resourcesKey.
+	internedResources = map[string]ResourceSet{}
+)
+
+// internResources returns a shared copy of the resource set, which must not be
+// modified.  Table driven tests typically build the same small map, with the
+// same resource names, for every case, and every allocation record retains
+// one, so sharing them keeps memory proportional to the distinct requests
+// rather than the number of tests.
+func internResources(resources ResourceSet) ResourceSet {
+	if resources == nil {
+		return nil
+	}
+
+	key := resourcesKey(resources)
+
+	internLock.Lock()
+	defer internLock.Unlock()
+
+	if interned, ok := internedResources[key]; ok {
+		return interned
+	}
+
+	interned := make(ResourceSet, len(resources))
+
+	for k, v := range resources {
+		interned[k] = v
+	}
+
+	internedResources[key] = interned
+
+	return interned
+}
+
+// resourcesKey returns a canonical form of the resource set.
+func resourcesKey(resources ResourceSet) string {
+	names := make([]string, 0, len(resources))
+
+	for name := range resources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(strconv.Itoa(resources[name]))
+		b.WriteByte(0)
+	}
+
+	return b.String()
+}
+
+// resetInterned forgets everything interned, anything already shared remains
+// valid.
+func resetInterned() {
+	internLock.Lock()
+	defer internLock.Unlock()
+
+	internedResources = map[string]ResourceSet{}
+}
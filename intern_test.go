@@ -0,0 +1,51 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInternResources(t *testing.T) {
+	t.Parallel()
+
+	a := internResources(ResourceSet{"cpu": 2, "memory": 4})
+	b := internResources(ResourceSet{"memory": 4, "cpu": 2})
+	c := internResources(ResourceSet{"cpu": 2, "memory": 8})
+
+	if reflect.ValueOf(a).Pointer() != reflect.ValueOf(b).Pointer() {
+		t.Fatal("expected equal resource sets to be shared")
+	}
+
+	if reflect.ValueOf(a).Pointer() == reflect.ValueOf(c).Pointer() || c["memory"] != 8 {
+		t.Fatal("expected different resource sets to be distinct")
+	}
+
+	if internResources(nil) != nil {
+		t.Fatal("expected nil to remain nil")
+	}
+}
+
+func TestResourcesKey(t *testing.T) {
+	t.Parallel()
+
+	// Names and values must not run together.
+	if resourcesKey(ResourceSet{"cpu1": 2}) == resourcesKey(ResourceSet{"cpu": 12}) {
+		t.Fatal("expected distinct keys")
+	}
+}
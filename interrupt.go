@@ -0,0 +1,179 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// journalPattern names the journal allocations that could not be cleaned up on
+// interrupt are written to when none is configured.
+const journalPattern = "smtest-journal-*.json"
+
+var (
+	// liveLock protects live.
+	liveLock sync.Mutex
+
+	// live are allocations granted to tests that haven't been released.
+	live = map[*Allocation]interface{}{}
+)
+
+// track remembers an allocation until it's released, so it can be cleaned up
+// if the run is interrupted.
+func track(a *Allocation) {
+	liveLock.Lock()
+	defer liveLock.Unlock()
+
+	live[a] = nil
+}
+
+// untrack forgets a released allocation.
+func untrack(a *Allocation) {
+	liveLock.Lock()
+	defer liveLock.Unlock()
+
+	delete(live, a)
+}
+
+// interrupt returns the allocation's instances to their providers, and its
+// resources to the backend, without its test, which is still running.  If the
+// instances can't be returned then the allocation is left in the journal, so
+// it can be recovered by the next run or a sweeper.
+func (a *Allocation) interrupt() error {
+	if !a.markReleased() {
+		return nil
+	}
+
+	defer close(a.done)
+	defer untrack(a)
+
+	r := a.record
+
+	if err := a.deprovision(); err != nil {
+		return err
+	}
+
+	releaseRecord(r)
+	journal(journalRelease, r)
+
+	if config.backend != nil {
+		backendRelease(r)
+	}
+
+	return nil
+}
+
+// cleanupInterrupted cleans up every live allocation, returning those that
+// could not be.
+func cleanupInterrupted() []*Allocation {
+	liveLock.Lock()
+
+	allocations := make([]*Allocation, 0, len(live))
+
+	for a := range live {
+		allocations = append(allocations, a)
+	}
+
+	liveLock.Unlock()
+
+	var failed []*Allocation
+
+	for _, a := range allocations {
+		if err := a.interrupt(); err != nil {
+			emit(nil, event{
+				Action:  "warn",
+				Test:    a.record.name,
+				ID:      a.record.id,
+				Message: fmt.Sprintf("failed to clean up allocation %s for %s: %v", a.record.id, a.record.name, err),
+			})
+
+			failed = append(failed, a)
+		}
+	}
+
+	return failed
+}
+
+// journalFailed returns the journal the allocations that could not be cleaned
+// up remain in.  With no journal configured they are written to a new one
+// under the temporary directory, which can be passed to WithJournal to recover
+// them on the next run.
+func journalFailed(failed []*Allocation) (string, error) {
+	journalLock.Lock()
+	configured := journalFile != nil
+	journalLock.Unlock()
+
+	if configured {
+		return config.journal, nil
+	}
+
+	f, err := os.CreateTemp("", journalPattern)
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range failed {
+		if err := writeJournal(f, journalSchedule, a.record.export()); err != nil {
+			f.Close()
+
+			return "", err
+		}
+	}
+
+	return f.Name(), f.Close()
+}
+
+// releaseOnInterrupt waits for one of the signals, then cleans up every live
+// allocation before exiting, as test cleanups won't run.
+func releaseOnInterrupt(signals []os.Signal, stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	var sig os.Signal
+
+	select {
+	case <-stop:
+		return
+	case sig = <-ch:
+	}
+
+	failed := cleanupInterrupted()
+
+	message := fmt.Sprintf("interrupted by %v, cleaned up held allocations", sig)
+
+	if len(failed) > 0 {
+		message = fmt.Sprintf("interrupted by %v, %d allocations could not be cleaned up", sig, len(failed))
+
+		if path, err := journalFailed(failed); err != nil {
+			message += fmt.Sprintf(", failed to journal them: %v", err)
+		} else {
+			message += ", they remain in the journal " + path
+		}
+	}
+
+	emit(nil, event{
+		Action:  "warn",
+		Message: message,
+	})
+
+	os.Exit(1)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"sort"
+)
+
+// checkInvariants verifies the scheduler's accounting is consistent, that
+// nothing is over allocated, every allocated resource is held by exactly one
+// lease, and nothing queued holds resources.  Free resources may be negative
+// once the pool is shrunk below what is held.
+func checkInvariants(pool ResourceSet, b *MemoryBackend, queue map[string]*queueItem) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	held := ResourceSet{}
+
+	for _, required := range b.leases {
+		for k, v := range required {
+			held[k] += v
+		}
+	}
+
+	names := make([]string, 0, len(pool))
+
+	for k := range pool {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	for _, k := range names {
+		free := b.free[k]
+
+		switch {
+		case free > pool[k]:
+			return fmt.Errorf("%s exceeds the pool: %d > %d", k, free, pool[k])
+		case free+held[k] != pool[k]:
+			return fmt.Errorf("%s is not conserved: %d free and %d held of %d", k, free, held[k], pool[k])
+		}
+	}
+
+	for id, item := range queue {
+		if id != item.record.id {
+			return fmt.Errorf("allocation %s for %s is queued as %s", item.record.id, item.record.name, id)
+		}
+
+		if _, ok := b.leases[id]; ok {
+			return fmt.Errorf("allocation %s for %s is queued but holds resources", id, item.record.name)
+		}
+	}
+
+	return nil
+}
+
+// selfCheck fails loudly if the scheduler's invariants don't hold, this is
+// called by the scheduler after every transition when enabled.
+func selfCheck() {
+	if err := checkInvariants(capacity(), local, queue.items); err != nil {
+		panic("smtest: scheduler invariant violated: " + err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckInvariants(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 8}
+
+	b := NewMemoryBackend(pool)
+
+	if ok, _ := b.Acquire(context.Background(), "a", ResourceSet{"cpu": 6}); !ok {
+		t.Fatal("expected acquire to succeed")
+	}
+
+	queue := map[string]*queueItem{
+		"b": {record: &record{id: "b", name: "TestB"}},
+	}
+
+	if err := checkInvariants(pool, b, queue); err != nil {
+		t.Fatal(err)
+	}
+
+	// A queued test holding resources...
+	queue["a"] = &queueItem{record: &record{id: "a", name: "TestA"}}
+
+	if err := checkInvariants(pool, b, queue); err == nil {
+		t.Fatal("expected queued allocation holding resources to be detected")
+	}
+
+	delete(queue, "a")
+
+	// ... and a double release.
+	b.free["cpu"] += 6
+
+	if err := checkInvariants(pool, b, queue); err == nil {
+		t.Fatal("expected over allocation to be detected")
+	}
+}
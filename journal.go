@@ -0,0 +1,193 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// journalSchedule records that an allocation was granted.
+	journalSchedule = "sched"
+
+	// journalRelease records that an allocation was returned.
+	journalRelease = "release"
+)
+
+// journalEntry is a line in the journal.
+type journalEntry struct {
+	// Action is either journalSchedule or journalRelease.
+	Action string `json:"action"`
+
+	// Record is the allocation, only the ID is set on release.
+	Record
+}
+
+var (
+	// journalLock serializes writes to the journal.
+	journalLock sync.Mutex
+
+	// journalFile, if set, has allocations written to it as they are
+	// granted and returned.
+	journalFile *os.File
+)
+
+// readJournal returns the allocations that were granted but never returned,
+// in the order they were granted.  A truncated final line, from being killed
+// mid-write, is ignored.
+func readJournal(r io.Reader) ([]Record, error) {
+	pending := map[string]Record{}
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		var entry journalEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		switch entry.Action {
+		case journalSchedule:
+			pending[entry.ID] = entry.Record
+		case journalRelease:
+			delete(pending, entry.ID)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	stale := make([]Record, 0, len(pending))
+
+	for _, r := range pending {
+		stale = append(stale, r)
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Scheduled.Before(stale[j].Scheduled)
+	})
+
+	return stale, nil
+}
+
+// openJournal reads allocations left behind by a previous run, for example one
+// that was killed by CI, and passes them to the recovery function to clean up.
+// The journal is then restarted, keeping any stale allocations if recovery
+// failed, so it's retried next time.
+func openJournal(path string, recovery func([]Record) error) (*os.File, error) {
+	var stale []Record
+
+	f, err := os.Open(path)
+	if err == nil {
+		stale, err = readJournal(f)
+
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(stale) > 0 && recovery != nil {
+		if err := recovery(stale); err != nil {
+			emit(nil, event{
+				Action:  "warn",
+				Message: fmt.Sprintf("failed to recover %d allocations from a previous run: %v", len(stale), err),
+			})
+		} else {
+			stale = nil
+		}
+	}
+
+	f, err = os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stale {
+		if err := writeJournal(f, journalSchedule, &stale[i]); err != nil {
+			f.Close()
+
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// writeJournal appends an entry, and flushes it to disk so it survives the
+// process being killed.
+func writeJournal(f *os.File, action string, r *Record) error {
+	data, err := json.Marshal(&journalEntry{Action: action, Record: *r})
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// startJournal recovers from any previous run and opens the journal, if one is
+// configured.  This happens before any tests are admitted.
+func startJournal() {
+	if config.journal == "" {
+		return
+	}
+
+	f, err := openJournal(config.journal, config.recovery)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to open journal: %v\n", err)
+		return
+	}
+
+	journalFile = f
+}
+
+// journal writes the allocation to the journal, if journaling is enabled.
+func journal(action string, r *record) {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+
+	if journalFile == nil {
+		return
+	}
+
+	entry := &Record{
+		ID: r.id,
+	}
+
+	if action == journalSchedule {
+		entry = r.export()
+	}
+
+	if err := writeJournal(journalFile, action, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to write journal: %v\n", err)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "journal")
+
+	f, err := openJournal(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	released := &Record{ID: "released", Test: "TestReleased", Scheduled: now}
+	killed := &Record{ID: "killed", Test: "TestKilled", Resources: ResourceSet{"cpu": 2}, Scheduled: now.Add(time.Second)}
+
+	for _, r := range []*Record{released, killed} {
+		if err := writeJournal(f, journalSchedule, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writeJournal(f, journalRelease, &Record{ID: released.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Killed mid-write...
+	if _, err := f.WriteString(`{"action":"rel`); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	// A failed recovery keeps the stale allocations for next time.
+	f, err = openJournal(path, func([]Record) error {
+		return errors.New("cloud unreachable")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	var stale []Record
+
+	f, err = openJournal(path, func(records []Record) error {
+		stale = records
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	if len(stale) != 1 || stale[0].ID != killed.ID || stale[0].Test != killed.Test || stale[0].Resources["cpu"] != 2 {
+		t.Fatalf("expected the killed allocation to be recovered, got %v", stale)
+	}
+
+	// Once recovered, the journal is empty.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 0 {
+		t.Fatalf("expected an empty journal, got %q", data)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBackend holds a pool in memory, it's what the scheduler uses to account
+// for resources within a single process, and may be used to share a pool
+// between schedulers, or as a reference for implementing new backends.
+type MemoryBackend struct {
+	// lock protects the pool.
+	lock sync.Mutex
+
+	// free are the resources that are not allocated.
+	free ResourceSet
+
+	// leases are the resources held by each allocation keyed by ID.
+	leases map[string]ResourceSet
+}
+
+// Ensure the interface is implemented.
+var _ Backend = &MemoryBackend{}
+
+// NewMemoryBackend returns a backend holding the resources.
+func NewMemoryBackend(resources ResourceSet) *MemoryBackend {
+	b := &MemoryBackend{
+		free:   ResourceSet{},
+		leases: map[string]ResourceSet{},
+	}
+
+	for k, v := range resources {
+		b.free[k] = v
+	}
+
+	return b
+}
+
+// Acquire takes the resources if they are all free.  Acquiring is idempotent.
+func (b *MemoryBackend) Acquire(_ context.Context, id string, required ResourceSet) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.leases[id]; ok {
+		return true, nil
+	}
+
+	for k, v := range required {
+		if b.free[k] < v {
+			return false, nil
+		}
+	}
+
+	for k, v := range required {
+		b.free[k] -= v
+	}
+
+	b.leases[id] = required
+
+	return true, nil
+}
+
+// Renew does nothing, allocations within a process never expire.
+func (b *MemoryBackend) Renew(_ context.Context, _ string) error {
+	return nil
+}
+
+// Release returns the resources to the pool.  Releasing an unknown allocation
+// is not an error.
+func (b *MemoryBackend) Release(_ context.Context, id string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for k, v := range b.leases[id] {
+		b.free[k] += v
+	}
+
+	delete(b.leases, id)
+
+	return nil
+}
+
+// Free returns a copy of the resources that are not allocated.
+func (b *MemoryBackend) Free() ResourceSet {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	free := ResourceSet{}
+
+	for k, v := range b.free {
+		free[k] = v
+	}
+
+	return free
+}
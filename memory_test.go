@@ -0,0 +1,61 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	b := NewMemoryBackend(ResourceSet{"cpu": 8})
+
+	if ok, err := b.Acquire(ctx, "a", ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	// Acquiring is idempotent.
+	if ok, err := b.Acquire(ctx, "a", ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if ok, err := b.Acquire(ctx, "b", ResourceSet{"cpu": 4}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if free := b.Free(); free["cpu"] != 2 {
+		t.Fatalf("expected 2 free, got %v", free)
+	}
+
+	if err := b.Renew(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Release(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if free := b.Free(); free["cpu"] != 8 {
+		t.Fatalf("expected all resources to be free, got %v", free)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// model drives the scheduler core with a sequence of operations, checking its
+// invariants after every transition.
+type model struct {
+	t *testing.T
+
+	// pool is the total set of resources.
+	pool ResourceSet
+
+	// policy is the order queued tests are granted in.
+	policy Policy
+
+	// b accounts for granted resources.
+	b *MemoryBackend
+
+	// queue is the set of waiting tests.
+	queue *waitQueue
+
+	// granted are the items that hold resources, in the order they were
+	// granted.
+	granted []*queueItem
+
+	// next is used to generate allocation IDs.
+	next int
+}
+
+func newModel(t *testing.T, pool ResourceSet, policy Policy) *model {
+	t.Helper()
+
+	return &model{
+		t:      t,
+		pool:   pool,
+		policy: policy,
+		b:      NewMemoryBackend(pool),
+		queue:  newWaitQueue(),
+	}
+}
+
+// enqueue adds a test to the queue.
+func (m *model) enqueue(required ResourceSet) {
+	id := fmt.Sprint(m.next)
+
+	m.next++
+
+	m.queue.add(&queueItem{
+		wait:     make(chan interface{}),
+		required: required,
+		enqueued: time.Unix(int64(m.next), 0),
+		record:   &record{id: id, name: "Test" + id, required: required, charged: required},
+	})
+}
+
+// release returns a granted test's resources.
+func (m *model) release(i int) *record {
+	item := m.granted[i]
+
+	m.granted = append(m.granted[:i], m.granted[i+1:]...)
+
+	_ = m.b.Release(context.Background(), item.record.id)
+
+	return item.record
+}
+
+// withdraw removes a queued test, as happens when it's skipped.
+func (m *model) withdraw(i int) {
+	for id := range m.queue.items {
+		if i == 0 {
+			if withdrawFrom(m.queue, m.b, id) {
+				m.t.Fatalf("queued allocation %s was granted", id)
+			}
+
+			return
+		}
+
+		i--
+	}
+}
+
+// grant runs the scheduler and checks what it granted.
+func (m *model) grant(released *record) {
+	m.t.Helper()
+
+	free := m.b.Free()
+
+	waiting := map[string]*queueItem{}
+
+	for id, item := range m.queue.items {
+		waiting[id] = item
+	}
+
+	// The scheduler skips the pass when nothing is pending, so it must not
+	// have been able to grant anything.
+	skippable := !m.queue.pending(free, m.policy)
+
+	grant(m.queue, m.b, released, m.policy)
+
+	if skippable && m.queue.len() != len(waiting) {
+		m.t.Fatal("pass granted tests when nothing was pending")
+	}
+
+	if err := checkInvariants(m.pool, m.b, m.queue.items); err != nil {
+		m.t.Fatal(err)
+	}
+
+	used := ResourceSet{}
+
+	for id, item := range waiting {
+		if _, ok := m.queue.items[id]; ok {
+			continue
+		}
+
+		select {
+		case <-item.wait:
+		default:
+			m.t.Fatalf("allocation %s was dequeued but not released", id)
+		}
+
+		for k, v := range item.required {
+			used[k] += v
+		}
+
+		m.granted = append(m.granted, item)
+	}
+
+	// Nothing may be granted that wasn't free...
+	for k, v := range used {
+		if v > free[k] {
+			m.t.Fatalf("granted %d %s with only %d free", v, k, free[k])
+		}
+	}
+
+	// ... and nothing may be left waiting that could run, unless FIFO
+	// ordering prevents it overtaking the oldest waiting test, which must
+	// not fit.
+	free = m.b.Free()
+
+	items := m.queue.ordered()
+
+	if m.policy == PolicyFIFO && len(items) > 0 {
+		items = items[:1]
+	}
+
+	for _, item := range items {
+		if fits(free, item.required) {
+			m.t.Fatalf("allocation %s requiring %v was not granted with %v free", item.record.id, item.required, free)
+		}
+	}
+}
+
+// drain releases everything, checking that every queued test eventually runs
+// and every resource is returned.
+func (m *model) drain() {
+	m.t.Helper()
+
+	for len(m.granted) > 0 {
+		m.grant(m.release(0))
+	}
+
+	if m.queue.len() != 0 {
+		m.t.Fatalf("%d allocations never granted", m.queue.len())
+	}
+
+	if free := m.b.Free(); free.String() != m.pool.String() {
+		m.t.Fatalf("expected %v free, got %v", m.pool, free)
+	}
+}
+
+// FuzzScheduler interprets the input as pairs of operation and argument bytes
+// that enqueue, release and withdraw tests, under the selected policy.
+func FuzzScheduler(f *testing.F) {
+	f.Add(false, []byte{0, 7, 0, 8, 0, 200, 1, 0, 2, 0})
+	f.Add(false, []byte{0, 255, 0, 255, 0, 1, 1, 1, 0, 3, 1, 0})
+	f.Add(false, []byte{0, 0, 2, 0, 0, 17, 0, 34, 1, 5, 2, 1})
+	f.Add(true, []byte{0, 7, 0, 8, 0, 200, 1, 0, 2, 0})
+	f.Add(true, []byte{0, 255, 0, 255, 0, 1, 1, 1, 0, 3, 1, 0})
+	f.Add(true, []byte{0, 80, 0, 8, 0, 8, 1, 0, 0, 1, 2, 0})
+
+	f.Fuzz(func(t *testing.T, fifo bool, ops []byte) {
+		policy := PolicyFirstFit
+
+		if fifo {
+			policy = PolicyFIFO
+		}
+
+		m := newModel(t, ResourceSet{"cpu": 8, "memory": 16}, policy)
+
+		for i := 0; i+1 < len(ops); i += 2 {
+			arg := int(ops[i+1])
+
+			var released *record
+
+			switch ops[i] % 3 {
+			case 0:
+				m.enqueue(ResourceSet{"cpu": arg % 9, "memory": arg / 9 % 17})
+			case 1:
+				if len(m.granted) > 0 {
+					released = m.release(arg % len(m.granted))
+				}
+			case 2:
+				if m.queue.len() > 0 {
+					m.withdraw(arg % m.queue.len())
+				}
+			}
+
+			m.grant(released)
+		}
+
+		m.drain()
+	})
+}
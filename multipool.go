@@ -0,0 +1,183 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Pool is a backend that holds some of the resources, for use with
+// MultiBackend.
+type Pool struct {
+	// Name identifies the pool, and decides the order pools are acquired
+	// from, so must be the same in every process.
+	Name string
+
+	// Backend holds the pool's accounting.
+	Backend Backend
+
+	// Resources are the names of the resources the pool holds.
+	Resources []string
+}
+
+// multiBackend acquires resources from several pools atomically.
+type multiBackend struct {
+	// pools are sorted by name.
+	pools []Pool
+
+	// lock protects granted.
+	lock sync.Mutex
+
+	// granted are the pools each allocation holds resources from, in the
+	// order they were acquired.
+	granted map[string][]Pool
+
+	// notify forwards notifications from any pool.
+	notify chan struct{}
+}
+
+// MultiBackend combines pools that each hold some of the resources, for example
+// GPUs in a file backend shared by the processes on this machine and cloud
+// quota in a remote broker, into a single backend.  Resources not held by any
+// pool are only accounted for in process.
+//
+// Acquisition is two-phase.  Pools are acquired from in name order and, if any
+// refuses or fails, those already acquired are released in reverse order, so
+// partial grants are never leaked.  As no allocation waits on one pool while
+// holding another, and every process contends for pools in the same order,
+// allocations can't deadlock across pools.
+func MultiBackend(pools ...Pool) Backend {
+	b := &multiBackend{
+		pools:   append([]Pool(nil), pools...),
+		granted: map[string][]Pool{},
+		notify:  make(chan struct{}, 1),
+	}
+
+	sort.Slice(b.pools, func(i, j int) bool {
+		return b.pools[i].Name < b.pools[j].Name
+	})
+
+	for _, p := range b.pools {
+		if notifier, ok := p.Backend.(Notifier); ok {
+			go b.forward(notifier.Notify())
+		}
+	}
+
+	return b
+}
+
+// forward passes on notifications from a pool.
+func (b *multiBackend) forward(ch <-chan struct{}) {
+	for range ch {
+		select {
+		case b.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Notify implements Notifier.
+func (b *multiBackend) Notify() <-chan struct{} {
+	return b.notify
+}
+
+// split returns the resources required from a pool.
+func split(p Pool, required ResourceSet) ResourceSet {
+	resources := ResourceSet{}
+
+	for _, name := range p.Resources {
+		if v, ok := required[name]; ok && v > 0 {
+			resources[name] = v
+		}
+	}
+
+	return resources
+}
+
+// rollback releases pools that were acquired in reverse order.
+func rollback(ctx context.Context, id string, pools []Pool) error {
+	var errs []error
+
+	for i := len(pools) - 1; i >= 0; i-- {
+		if err := pools[i].Backend.Release(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", pools[i].Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *multiBackend) Acquire(ctx context.Context, id string, required ResourceSet) (bool, error) {
+	var acquired []Pool
+
+	for _, p := range b.pools {
+		resources := split(p, required)
+		if len(resources) == 0 {
+			continue
+		}
+
+		ok, err := p.Backend.Acquire(ctx, id, resources)
+		if err != nil || !ok {
+			if rollbackErr := rollback(ctx, id, acquired); rollbackErr != nil {
+				err = errors.Join(err, rollbackErr)
+			}
+
+			if err != nil {
+				return false, fmt.Errorf("pool %s: %w", p.Name, err)
+			}
+
+			return false, nil
+		}
+
+		acquired = append(acquired, p)
+	}
+
+	b.lock.Lock()
+	b.granted[id] = acquired
+	b.lock.Unlock()
+
+	return true, nil
+}
+
+func (b *multiBackend) Renew(ctx context.Context, id string) error {
+	b.lock.Lock()
+	pools := b.granted[id]
+	b.lock.Unlock()
+
+	var errs []error
+
+	for _, p := range pools {
+		if err := p.Backend.Renew(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("pool %s: %w", p.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *multiBackend) Release(ctx context.Context, id string) error {
+	b.lock.Lock()
+	pools := b.granted[id]
+	delete(b.granted, id)
+	b.lock.Unlock()
+
+	return rollback(ctx, id, pools)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMultiBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	local := &fakeBackend{free: 4, leases: map[string]int{}}
+	remote := &fakeBackend{free: 2, leases: map[string]int{}}
+
+	backend := MultiBackend(
+		Pool{Name: "remote", Backend: remote, Resources: []string{"gpu"}},
+		Pool{Name: "local", Backend: local, Resources: []string{"cpu"}},
+	)
+
+	// The local pool is acquired first, so must be rolled back when the
+	// remote one refuses.
+	if ok, err := backend.Acquire(ctx, "a", ResourceSet{"cpu": 2, "gpu": 3}); err != nil || ok {
+		t.Fatalf("expected acquire to be refused: %v", err)
+	}
+
+	if local.free != 4 || remote.free != 2 {
+		t.Fatalf("expected partial grant to be rolled back, got %d local and %d remote", local.free, remote.free)
+	}
+
+	// Resources not in any pool are ignored.
+	if ok, err := backend.Acquire(ctx, "b", ResourceSet{"cpu": 2, "gpu": 1, "memory": 16}); err != nil || !ok {
+		t.Fatalf("expected acquire to succeed: %v", err)
+	}
+
+	if local.free != 2 || remote.free != 1 {
+		t.Fatalf("expected both pools to be acquired from, got %d local and %d remote", local.free, remote.free)
+	}
+
+	if err := backend.Renew(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if local.free != 4 || remote.free != 2 {
+		t.Fatalf("expected both pools to be released, got %d local and %d remote", local.free, remote.free)
+	}
+}
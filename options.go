@@ -0,0 +1,472 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// options are optional settings that alter the scheduler's behaviour.
+type options struct {
+	// cpuResource, if set, is the resource name that maps to CPU cores,
+	// and enables sampling of process CPU usage.
+	cpuResource string
+
+	// memoryResource, if set, is the resource name that maps to memory,
+	// and enables sampling of process memory usage.
+	memoryResource string
+
+	// memoryUnit is the number of bytes represented by one unit of
+	// memoryResource.
+	memoryUnit int64
+
+	// waitPercentile is the percentile of queue wait times that is checked
+	// against waitThreshold.
+	waitPercentile float64
+
+	// waitThreshold, if set, raises an alert when the waitPercentile
+	// wait time exceeds it.
+	waitThreshold time.Duration
+
+	// alertHooks are called whenever an alert is raised.
+	alertHooks []func(Alert)
+
+	// output defines how events are emitted.
+	output Output
+
+	// dumpSignals, when received, cause the scheduler state to be dumped.
+	dumpSignals []os.Signal
+
+	// timeoutDumpMargin, if set, dumps the scheduler state this long before
+	// the test timeout expires.
+	timeoutDumpMargin time.Duration
+
+	// starvationThreshold, if set, raises an alert when a test has been
+	// queued for longer than this.
+	starvationThreshold time.Duration
+
+	// baseline, if set, is an allocation export from a previous run that
+	// this run is compared against.
+	baseline string
+
+	// baselineThreshold is how much slower a test can get before it is
+	// considered a regression.
+	baselineThreshold time.Duration
+
+	// providers manage instances of resources, keyed by resource name.
+	providers map[string]Provider
+
+	// backend, if set, shares pool accounting with other processes.
+	backend Backend
+
+	// prices, if set, enables cost accounting.
+	prices Prices
+
+	// budget, if set, raises an alert when the run costs more.
+	budget float64
+
+	// journal, if set, is where granted allocations are recorded so they
+	// can be recovered after a crash.
+	journal string
+
+	// recovery is called with allocations left over from a previous run.
+	recovery func(stale []Record) error
+
+	// progressInterval, if set, is how often waiting tests report their
+	// position in the queue.
+	progressInterval time.Duration
+
+	// selfCheck, if set, verifies the scheduler's accounting after every
+	// transition.
+	selfCheck bool
+
+	// enqueueTimeout, if set, is how long a request to the scheduler may
+	// block for before the test fails.
+	enqueueTimeout time.Duration
+
+	// shutdownGrace, if set, is how long Stop waits for releases that are
+	// in progress.
+	shutdownGrace time.Duration
+
+	// ordering defines when resources are granted relative to the test
+	// running in parallel.
+	ordering Ordering
+
+	// hostLimits, if set, are what the host running the tests can provide.
+	hostLimits ResourceSet
+
+	// hostLimitFactor is how many times larger than hostLimits the pool may
+	// be before an alert is raised.
+	hostLimitFactor float64
+
+	// interruptSignals, when received, cause held allocations to be cleaned
+	// up before exiting.
+	interruptSignals []os.Signal
+
+	// policy defines the order queued tests are granted resources in.
+	policy Policy
+
+	// requirements, if set, is a file mapping tests to the resources they
+	// require when they don't declare them.
+	requirements string
+
+	// scale, if set, divides what tests require.
+	scale float64
+
+	// passthrough, if set, runs tests in parallel without accounting for
+	// their resources.
+	passthrough bool
+
+	// chaos, if set, randomly perturbs the order of, and delays, grants.
+	chaos bool
+
+	// chaosSeed seeds the perturbation, so a run can be reproduced.
+	chaosSeed int64
+
+	// strict, if set, fails tests that require resources not passed to
+	// Start.
+	strict bool
+
+	// skipBudget, if set, fails Verify when too many tests are skipped for
+	// lack of resources.
+	skipBudget bool
+
+	// skipLimit, if not negative, is how many tests may be skipped for lack
+	// of resources before Verify fails.
+	skipLimit int
+
+	// skipFraction, if not negative, is the fraction of tests that may be
+	// skipped for lack of resources before Verify fails.
+	skipFraction float64
+
+	// team maps test names to the team that owns them for cost accounting.
+	team func(test string) string
+}
+
+// Option is passed to Start to modify the default behaviour.
+type Option func(*options)
+
+// WithCPUResource tells the scheduler that the named resource represents
+// CPU cores.  Process CPU usage is then sampled while tests hold resources
+// and used to make right-sizing recommendations in Report.
+func WithCPUResource(name string) Option {
+	return func(o *options) {
+		o.cpuResource = name
+	}
+}
+
+// WithMemoryResource tells the scheduler that the named resource represents
+// memory, where a single unit is the given number of bytes e.g. 1<<30 for
+// GiB.  Process memory usage is then sampled while tests hold resources and
+// used to make right-sizing recommendations in Report.
+func WithMemoryResource(name string, unit int64) Option {
+	return func(o *options) {
+		o.memoryResource = name
+		o.memoryUnit = unit
+	}
+}
+
+// WithWaitThreshold raises an AlertWaitThreshold alert in Report when the
+// given percentile (e.g. 90 for p90) of queue wait times exceeds the threshold.
+// This allows a contention SLO to be defined and enforced.
+func WithWaitThreshold(percentile float64, threshold time.Duration) Option {
+	return func(o *options) {
+		o.waitPercentile = percentile
+		o.waitThreshold = threshold
+	}
+}
+
+// WithAlertHook registers a function that is called whenever an alert is
+// raised, for example to fail the run from TestMain.  Hooks may be called
+// from the scheduler so must not block.
+func WithAlertHook(hook func(Alert)) Option {
+	return func(o *options) {
+		o.alertHooks = append(o.alertHooks, hook)
+	}
+}
+
+// WithOutput selects how scheduler events are emitted, by default they are
+// printed to standard output.
+func WithOutput(output Output) Option {
+	return func(o *options) {
+		o.output = output
+	}
+}
+
+// WithDumpSignal dumps the scheduler state, what tests are waiting for and
+// what tests are holding, when any of the signals are received e.g.
+// syscall.SIGUSR1.
+func WithDumpSignal(signals ...os.Signal) Option {
+	return func(o *options) {
+		o.dumpSignals = append(o.dumpSignals, signals...)
+	}
+}
+
+// WithTimeoutDump dumps the scheduler state the given margin before go test's
+// -timeout expires, so hangs can be diagnosed from the logs of a killed run.
+func WithTimeoutDump(margin time.Duration) Option {
+	return func(o *options) {
+		o.timeoutDumpMargin = margin
+	}
+}
+
+// WithStarvationWarning raises an AlertStarvation alert when any test has been
+// queued for longer than the threshold, naming the tests that are holding the
+// resources it's waiting for.
+func WithStarvationWarning(threshold time.Duration) Option {
+	return func(o *options) {
+		o.starvationThreshold = threshold
+	}
+}
+
+// WithBaseline compares wait and hold times in Report against a previous run's
+// SMTEST_EXPORT file, raising an AlertRegression alert for every test that
+// got more than threshold slower.
+func WithBaseline(path string, threshold time.Duration) Option {
+	return func(o *options) {
+		o.baseline = path
+		o.baselineThreshold = threshold
+	}
+}
+
+// WithWebhook posts every alert as JSON to the URL, for example a Slack
+// incoming webhook, so problems in long unattended runs are surfaced
+// immediately.  Report waits for any outstanding requests to complete.
+func WithWebhook(url string) Option {
+	return WithAlertHook(webhook(url))
+}
+
+// WithProvider registers a provider for the named resource.  When a test is
+// allocated the resource, an instance is acquired from the provider for every
+// unit, and made available via Allocation.Instances.  Instances are returned to
+// the provider when the allocation is released.
+func WithProvider(name string, provider Provider) Option {
+	return func(o *options) {
+		if o.providers == nil {
+			o.providers = map[string]Provider{}
+		}
+
+		o.providers[name] = provider
+	}
+}
+
+// WithBackend shares the pool with other test processes via the backend, for
+// example a central broker, so they collectively respect a single pool.
+func WithBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithPrices enables cost accounting, Report prints what the run cost, broken
+// down by team and by test, from how long each test held its resources.
+func WithPrices(prices Prices) Option {
+	return func(o *options) {
+		o.prices = prices
+	}
+}
+
+// WithBudget raises an AlertBudget alert from Report when the run cost more
+// than the budget.  This requires prices set with WithPrices.
+func WithBudget(budget float64) Option {
+	return func(o *options) {
+		o.budget = budget
+	}
+}
+
+// WithTeams attributes the cost of each test to the team returned by the
+// function, for example by looking up the test's package or name prefix.
+func WithTeams(team func(test string) string) Option {
+	return func(o *options) {
+		o.team = team
+	}
+}
+
+// WithJournal records every granted allocation in a journal file, flushed to
+// disk, until it's returned.  If the test binary is killed, and re-run for
+// example by a CI retry, Start passes the allocations that were never returned
+// to the recovery function before admitting any tests, so fixtures tagged with
+// the allocation ID by the previous attempt can be cleaned up.  If recovery
+// fails the allocations are kept, and retried on the next run.
+func WithJournal(path string, recovery func(stale []Record) error) Option {
+	return func(o *options) {
+		o.journal = path
+		o.recovery = recovery
+	}
+}
+
+// WithProgress has tests that are waiting for resources periodically report
+// their position in the queue, what resources they are blocked on and, based
+// on how long holders have previously taken, when they expect to run.  With
+// OutputLog this is logged against the test, so long waits in CI look alive
+// rather than hung.
+func WithProgress(interval time.Duration) Option {
+	return func(o *options) {
+		o.progressInterval = interval
+	}
+}
+
+// WithSelfCheck verifies the scheduler's internal accounting after every
+// transition, that resources are conserved and never negative or over
+// allocated, and that queued tests hold nothing, panicking on violation.  This
+// is intended for debugging the scheduler and backends, and has a cost.
+func WithSelfCheck() Option {
+	return func(o *options) {
+		o.selfCheck = true
+	}
+}
+
+// WithEnqueueTimeout sets how long a test waits for the scheduler to accept its
+// request, to queue or to release resources, before failing with
+// ErrSchedulerBlocked, by default a minute.  This surfaces a wedged scheduler
+// at the test that hit it, rather than as a hang until the test timeout.  It
+// does not limit how long a test is queued for.
+func WithEnqueueTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.enqueueTimeout = timeout
+	}
+}
+
+// WithStrict fails any test that requires a resource that wasn't passed to
+// Start, with ErrUnknownResource, rather than skipping it, so typos in resource
+// names are caught immediately.  Declare resources that may legitimately be
+// absent with a quantity of zero.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithShutdownGrace sets how long Stop waits for releases that are still in
+// progress, for example from goroutines that outlived their test, before
+// stopping the scheduler anyway, by default ten seconds.
+func WithShutdownGrace(grace time.Duration) Option {
+	return func(o *options) {
+		o.shutdownGrace = grace
+	}
+}
+
+// WithOrdering defines whether tests wait for resources after they are allowed
+// to run in parallel, the default, or before.  See Ordering for the trade offs.
+func WithOrdering(ordering Ordering) Option {
+	return func(o *options) {
+		o.ordering = ordering
+	}
+}
+
+// WithHostLimits raises an AlertOvercommit alert from Start for every resource
+// in the pool that is more than factor times larger than what the host running
+// the tests can provide, for example as read by discovery/local.Capacity.  A
+// pool copied from a larger machine otherwise leads to tests being killed for
+// running out of memory.  A factor above 1 allows for tests that rarely use all
+// they ask for.
+func WithHostLimits(limits ResourceSet, factor float64) Option {
+	return func(o *options) {
+		o.hostLimits = limits
+		o.hostLimitFactor = factor
+	}
+}
+
+// WithInterruptCleanup cleans up held allocations when the run is interrupted
+// by any of the signals, by default SIGINT and SIGTERM, then exits.  Test
+// cleanups don't run when a test binary is interrupted, so without this any
+// instances created by providers, for example real infrastructure, would be
+// leaked.  Allocations that could not be cleaned up remain in the journal, set
+// with WithJournal or otherwise created under the temporary directory, for the
+// next run, or a sweeper, to finish the job.
+func WithInterruptCleanup(signals ...os.Signal) Option {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	return func(o *options) {
+		o.interruptSignals = signals
+	}
+}
+
+// WithPolicy sets the order queued tests are granted resources in, by default
+// PolicyFirstFit.
+func WithPolicy(policy Policy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// WithRequirements reads a file that maps tests to the resources they require,
+// which is consulted when Parallel or Acquire is passed nil requirements.  This
+// allows operators to tune what tests require for their environment without
+// changing the tests.  The file is a JSON array of Requirement, the first whose
+// pattern matches the test's name applies, and tests that match none require
+// nothing.  The SMTEST_REQUIREMENTS environment variable overrides the path.
+func WithRequirements(path string) Option {
+	return func(o *options) {
+		o.requirements = path
+	}
+}
+
+// WithScale divides what every test requires by the factor, rounding up and
+// capped at the pool, so a factor of 0.5 halves the number of tests that run in
+// parallel, for example to reduce load on shared infrastructure during an
+// incident, without changing every test.  Only what is taken from the pool is
+// scaled, allocations, their instances and reports keep what the test declared.
+// The SMTEST_SCALE environment variable overrides the factor.
+func WithScale(factor float64) Option {
+	return func(o *options) {
+		o.scale = factor
+	}
+}
+
+// WithPassthrough makes Parallel and Acquire behave like t.Parallel, tests run
+// immediately with no queueing, skipping or accounting of resources, although
+// instances are still provisioned.  This is intended for quick local iteration
+// on a few tests, where resource gating is just friction, without changing any
+// code.  Setting the SMTEST_PASSTHROUGH environment variable to a non-empty
+// value has the same effect.
+func WithPassthrough() Option {
+	return func(o *options) {
+		o.passthrough = true
+	}
+}
+
+// WithSkipBudget fails Verify when more than limit tests, or more than fraction
+// of the tests that required resources, are skipped because they require more
+// than the pool has, so a misconfigured quota can't quietly skip most of the
+// suite.  Either may be negative to not check it e.g. WithSkipBudget(-1, 0.1)
+// allows a tenth of tests to be skipped.
+func WithSkipBudget(limit int, fraction float64) Option {
+	return func(o *options) {
+		o.skipBudget = true
+		o.skipLimit = limit
+		o.skipFraction = fraction
+	}
+}
+
+// WithChaos grants queued tests in a random order, and delays each grant by a
+// random amount, to flush out hidden dependencies between tests that only hold
+// for the usual schedule, much like -test.shuffle does for the order tests
+// start in.  Tests are never granted inline.  The seed is printed when the
+// scheduler starts, and the same decisions are made for the same seed.  The
+// -smtest.chaos flag overrides this, and is either off, on for a random seed,
+// or a seed.
+func WithChaos(seed int64) Option {
+	return func(o *options) {
+		o.chaos = true
+		o.chaosSeed = seed
+	}
+}
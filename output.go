@@ -0,0 +1,129 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Output defines how scheduler events are emitted.
+type Output int
+
+const (
+	// OutputText prints events to standard output, this is the default.
+	OutputText Output = iota
+
+	// OutputLog logs events against the owning test with t.Log, so they
+	// are correctly attributed by go test -json and tools like gotestsum.
+	// Events that don't belong to a test are printed as per OutputText.
+	OutputLog
+
+	// OutputJSON prints events to standard output as JSON lines that can
+	// be easily consumed by other tools.
+	OutputJSON
+)
+
+// event is something that happened during the run.
+type event struct {
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+
+	// Action is the type of event e.g. alloc, sched or end.
+	Action string `json:"action"`
+
+	// Test is the test that the event relates to, if any.
+	Test string `json:"test,omitempty"`
+
+	// ID is the allocation the event relates to, if any.
+	ID string `json:"id,omitempty"`
+
+	// Resources are the resources that the event relates to, if any.
+	Resources ResourceSet `json:"resources,omitempty"`
+
+	// Elapsed is the time resources were held for, in seconds.
+	Elapsed float64 `json:"elapsed,omitempty"`
+
+	// Message is a human readable description of the event, if any.
+	Message string `json:"message,omitempty"`
+}
+
+// text returns the human readable version of an event.
+func (e *event) text() string {
+	body := e.Message
+
+	if body == "" {
+		body = e.Test
+
+		if e.ID != "" {
+			body += " id=" + e.ID
+		}
+
+		if e.Elapsed != 0 {
+			body += fmt.Sprintf(" (%.2fs)", e.Elapsed)
+		}
+	}
+
+	return fmt.Sprintf("+++ %-5s %s", strings.ToUpper(e.Action), body)
+}
+
+var (
+	// outputLock serializes JSON output so lines don't interleave.
+	outputLock sync.Mutex
+)
+
+// emit outputs an event, t may be nil if the event isn't associated with
+// a specific test.
+func emit(t *testing.T, e event) {
+	e.Time = time.Now()
+
+	// The live terminal view shows test progress, so don't scroll it away
+	// with text output.
+	if t != nil && config.output == OutputText && tuiActive() {
+		return
+	}
+
+	switch config.output {
+	case OutputLog:
+		if t != nil {
+			t.Helper()
+			t.Log(e.text())
+
+			return
+		}
+	case OutputJSON:
+		data, err := json.Marshal(&e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "smtest: failed to marshal event: %v\n", err)
+			return
+		}
+
+		outputLock.Lock()
+		defer outputLock.Unlock()
+
+		fmt.Println(string(data))
+
+		return
+	}
+
+	fmt.Println(e.text())
+}
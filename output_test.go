@@ -0,0 +1,51 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+func TestEventText(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		event    event
+		expected string
+	}{
+		{
+			event:    event{Action: "alloc", Test: "TestFoo"},
+			expected: "+++ ALLOC TestFoo",
+		},
+		{
+			event:    event{Action: "end", Test: "TestFoo", Elapsed: 1.5},
+			expected: "+++ END   TestFoo (1.50s)",
+		},
+		{
+			event:    event{Action: "sched", Test: "TestFoo", ID: "0123456789abcdef"},
+			expected: "+++ SCHED TestFoo id=0123456789abcdef",
+		},
+		{
+			event:    event{Action: "warn", Message: "uh oh"},
+			expected: "+++ WARN  uh oh",
+		},
+	} {
+		if actual := test.event.text(); actual != test.expected {
+			t.Fatalf("expected %q, got %q", test.expected, actual)
+		}
+	}
+}
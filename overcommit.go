@@ -0,0 +1,64 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"sort"
+)
+
+// overcommitted returns a description of every resource in the pool that
+// exceeds the host's limit by more than the factor.
+func overcommitted(pool, limits ResourceSet, factor float64) []string {
+	names := make([]string, 0, len(limits))
+
+	for k := range limits {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var result []string
+
+	for _, k := range names {
+		declared, ok := pool[k]
+		if !ok {
+			continue
+		}
+
+		if limit := limits[k]; float64(declared) > float64(limit)*factor {
+			result = append(result, fmt.Sprintf("pool declares %d %s but the host only has %d, tests may be killed for exceeding it", declared, k, limit))
+		}
+	}
+
+	return result
+}
+
+// checkHostLimits raises an alert for every resource in the pool that is far
+// larger than the host can provide.
+func checkHostLimits(pool ResourceSet) {
+	if config.hostLimits == nil {
+		return
+	}
+
+	for _, message := range overcommitted(pool, config.hostLimits, config.hostLimitFactor) {
+		raise(Alert{
+			Kind:    AlertOvercommit,
+			Message: message,
+		})
+	}
+}
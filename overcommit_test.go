@@ -0,0 +1,40 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+func TestOvercommitted(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 16, "memory": 64, "gpu": 2}
+	limits := ResourceSet{"cpu": 8, "memory": 16, "disk": 100}
+
+	// Some overcommitment is fine, tests rarely use everything they ask for...
+	if result := overcommitted(pool, limits, 2); len(result) != 1 {
+		t.Fatalf("expected only memory to be overcommitted, got %v", result)
+	}
+
+	// ... but not by this much.
+	expected := "pool declares 64 memory but the host only has 16, tests may be killed for exceeding it"
+
+	if result := overcommitted(pool, limits, 1); len(result) != 2 || result[1] != expected {
+		t.Fatalf("expected cpu and memory to be overcommitted, got %v", result)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var (
+	// ErrSchedulerPanic is raised to waiting tests when the scheduler has
+	// panicked, and can no longer grant resources.
+	ErrSchedulerPanic = errors.New("scheduler panicked")
+)
+
+// protect calls the function, returning an error if it panics, with the stack
+// trace of the panic logged.
+func protect(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrSchedulerPanic, r)
+
+			emit(nil, event{
+				Action:  "warn",
+				Message: fmt.Sprintf("%v\n%s", err, debug.Stack()),
+			})
+		}
+	}()
+
+	f()
+
+	return nil
+}
+
+// failScheduler is called by the scheduler when it has panicked.  The state is
+// logged, and every waiting test is failed with the error, as are any that try
+// to queue later, until the scheduler is stopped.  Releases are still accounted
+// for so Verify remains accurate.
+func failScheduler(err error) {
+	uncontended.Store(false)
+
+	// The state may be what caused the panic.
+	_ = protect(func() {
+		dumpState(copyState())
+	})
+
+	raise(Alert{
+		Kind:    AlertSchedulerPanic,
+		Message: err.Error(),
+	})
+
+	for id, item := range queue.items {
+		item.err = err
+
+		queue.remove(id)
+		close(item.wait)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case item := <-enqueue:
+			submitting.Add(-1)
+
+			item.err = err
+			close(item.wait)
+		case released := <-release:
+			releaseLocal(local, released)
+			publishStats(local)
+		case w := <-withdraw:
+			w.granted <- withdrawFrom(queue, local, w.id)
+		case r := <-resize:
+			close(r.done)
+		case reply := <-snapshot:
+			reply <- &state{
+				unallocated: local.Free(),
+			}
+		}
+	}
+}
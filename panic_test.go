@@ -0,0 +1,39 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	t.Parallel()
+
+	if err := protect(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := protect(func() {
+		panic("policy exploded")
+	})
+
+	if !errors.Is(err, ErrSchedulerPanic) || !strings.Contains(err.Error(), "policy exploded") {
+		t.Fatalf("expected a scheduler panic error, got %v", err)
+	}
+}
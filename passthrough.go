@@ -0,0 +1,61 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"os"
+	"testing"
+)
+
+const (
+	// passthroughEnvironmentVariable enables passthrough mode when set to a
+	// non-empty value.
+	passthroughEnvironmentVariable = "SMTEST_PASSTHROUGH"
+)
+
+// overridePassthrough enables passthrough mode from the environment.
+func overridePassthrough(o *options) {
+	if os.Getenv(passthroughEnvironmentVariable) != "" {
+		o.passthrough = true
+	}
+}
+
+// passthrough runs the test in parallel immediately, without queueing for or
+// accounting its resources.  Instances are still provisioned, so tests that use
+// them work unchanged.
+func passthrough(t *testing.T, required ResourceSet) *Allocation {
+	t.Helper()
+
+	t.Parallel()
+
+	allocation := newAllocation(t, &record{
+		id:       newAllocationID(),
+		name:     t.Name(),
+		required: required,
+	})
+
+	allocation.passthrough = true
+
+	t.Cleanup(allocation.cleanup)
+
+	if err := allocation.provision(); err != nil {
+		allocation.release()
+		t.Fatalf("failed to acquire resource instances: %v", err)
+	}
+
+	return allocation
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+func TestPassthrough(t *testing.T) {
+	t.Parallel()
+
+	var allocation *Allocation
+
+	ran := false
+
+	// Passthrough makes the test parallel, so group it to wait for it to end.
+	t.Run("Group", func(t *testing.T) {
+		t.Run("Huge", func(t *testing.T) {
+			// This is more than the pool, so would normally be
+			// skipped.
+			allocation = passthrough(t, ResourceSet{"cpu": 1 << 20})
+
+			if allocation.Resources()["cpu"] != 1<<20 {
+				t.Errorf("unexpected resources %v", allocation.Resources())
+			}
+
+			ran = true
+		})
+	})
+
+	if !ran {
+		t.Fatal("expected test to run")
+	}
+
+	if !allocation.hasEnded() {
+		t.Fatal("expected allocation to end with the test")
+	}
+}
+
+// TestOverridePassthrough is not parallel as it modifies the environment.
+func TestOverridePassthrough(t *testing.T) {
+	t.Setenv(passthroughEnvironmentVariable, "")
+
+	o := &options{}
+
+	overridePassthrough(o)
+
+	if o.passthrough {
+		t.Fatal("expected passthrough to be disabled")
+	}
+
+	t.Setenv(passthroughEnvironmentVariable, "1")
+
+	overridePassthrough(o)
+
+	if !o.passthrough {
+		t.Fatal("expected passthrough to be enabled")
+	}
+}
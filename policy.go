@@ -0,0 +1,63 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+)
+
+// Policy defines the order queued tests are granted resources in.
+type Policy int
+
+const (
+	// PolicyFirstFit grants queued tests in the order they arrived, skipping
+	// any that don't fit, so small tests may overtake large ones.  This keeps
+	// resources busy, but a large test may wait a long time.  This is the
+	// default.
+	PolicyFirstFit Policy = iota
+
+	// PolicyFIFO grants queued tests strictly in the order they arrived,
+	// nothing overtakes a test that doesn't fit.  Large tests are never
+	// starved, at the cost of resources sitting idle while they wait.
+	PolicyFIFO
+)
+
+// policyNames are the names of policies, as used by flags.
+var policyNames = map[Policy]string{
+	PolicyFirstFit: "firstfit",
+	PolicyFIFO:     "fifo",
+}
+
+// String returns the policy's name.
+func (p Policy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy returns the policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	for p, n := range policyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidPolicy, name)
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+const (
+	// labelTest is the pprof label that holds the test name.
+	labelTest = "smtest.test"
+
+	// labelResources is the pprof label that holds the resources held.
+	labelResources = "smtest.resources"
+)
+
+// setProfileLabels labels the calling goroutine, and any goroutines it
+// subsequently starts, with the allocation so CPU and heap profiles can be
+// sliced by resource consumer e.g. go tool pprof -tagfocus smtest.test=TestFoo.
+func setProfileLabels(r *record) {
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(labelTest, r.name, labelResources, r.required.String())))
+}
+
+// clearProfileLabels removes allocation labels from the calling goroutine.
+func clearProfileLabels() {
+	pprof.SetGoroutineLabels(context.Background())
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+// profileContains returns whether the goroutine profile contains the label.
+func profileContains(t *testing.T, key, value string) bool {
+	t.Helper()
+
+	var b strings.Builder
+
+	if err := pprof.Lookup("goroutine").WriteTo(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Contains(b.String(), fmt.Sprintf("%q:%q", key, value))
+}
+
+func TestProfileLabels(t *testing.T) {
+	resources := ResourceSet{"cpu": 1}
+
+	release := Parallel(t, resources)
+
+	labelled := profileContains(t, labelTest, t.Name())
+
+	release()
+
+	if !labelled {
+		t.Fatal("expected goroutine to be labelled")
+	}
+
+	if profileContains(t, labelTest, t.Name()) {
+		t.Fatal("expected labels to be cleared")
+	}
+}
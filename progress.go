@@ -0,0 +1,246 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Positioner may be implemented by a Backend that queues allocations, so the
+// position in its queue can be reported to waiting tests.
+type Positioner interface {
+	// Position returns the allocation's position in the backend's queue,
+	// starting at 1, or false if it isn't queued there.
+	Position(id string) (int, bool)
+}
+
+// history is how long allocations that have been released were held for, used
+// to estimate when held resources will be returned.
+type history struct {
+	// byTest is the mean hold time for each test.
+	byTest map[string]time.Duration
+
+	// overall is the mean hold time of all tests.
+	overall time.Duration
+}
+
+// expected returns how long a test is expected to hold its resources for, and
+// false if there is no history to base it on.
+func (h *history) expected(name string) (time.Duration, bool) {
+	if d, ok := h.byTest[name]; ok {
+		return d, true
+	}
+
+	return h.overall, h.overall > 0
+}
+
+// newHistory averages the hold times of released allocations.  This must be
+// called with the records lock held.
+func newHistory(summaries map[string]*summary) *history {
+	h := &history{
+		byTest: map[string]time.Duration{},
+	}
+
+	var total time.Duration
+
+	var count int
+
+	for name, s := range summaries {
+		h.byTest[name] = s.hold / time.Duration(s.count)
+
+		total += s.hold
+		count += s.count
+	}
+
+	if count > 0 {
+		h.overall = total / time.Duration(count)
+	}
+
+	return h
+}
+
+// estimate returns how long until the required resources are expected to be
+// free, assuming held allocations take as long as they have historically.  It
+// returns false if there is no history or holders won't free enough, which is
+// the case when earlier tests in the queue are blocked too.
+func estimate(required, unallocated ResourceSet, holders []*record, h *history, now time.Time) (time.Duration, bool) {
+	type finish struct {
+		at        time.Time
+		resources ResourceSet
+	}
+
+	finishes := make([]finish, 0, len(holders))
+
+	for _, r := range holders {
+		d, ok := h.expected(r.name)
+		if !ok {
+			return 0, false
+		}
+
+		at := r.scheduled.Add(d)
+
+		if at.Before(now) {
+			at = now
+		}
+
+		finishes = append(finishes, finish{at: at, resources: r.charged})
+	}
+
+	sort.Slice(finishes, func(i, j int) bool {
+		return finishes[i].at.Before(finishes[j].at)
+	})
+
+	free := ResourceSet{}
+
+	for k, v := range unallocated {
+		free[k] = v
+	}
+
+	for _, f := range finishes {
+		for k, v := range f.resources {
+			free[k] += v
+		}
+
+		ok := true
+
+		for k, v := range required {
+			if free[k] < v {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return f.at.Sub(now), true
+		}
+	}
+
+	return 0, false
+}
+
+// describeProgress summarizes why a test is still waiting e.g. "position 3 in
+// queue, blocked on memory, ETA 4m0s".  It returns false if the test is not
+// queued.
+func describeProgress(r *record, s *state, holders []*record, h *history, now time.Time) (string, bool) {
+	position := 0
+
+	for i, w := range s.waiting {
+		if w.id == r.id {
+			position = i + 1
+			break
+		}
+	}
+
+	if position == 0 {
+		return "", false
+	}
+
+	parts := []string{
+		fmt.Sprintf("position %d in queue", position),
+	}
+
+	var blocked []string
+
+	for k, v := range r.charged {
+		if v > s.unallocated[k] {
+			blocked = append(blocked, k)
+		}
+	}
+
+	sort.Strings(blocked)
+
+	if len(blocked) > 0 {
+		parts = append(parts, "blocked on "+strings.Join(blocked, ", "))
+	}
+
+	if eta, ok := estimate(r.charged, s.unallocated, holders, h, now); ok {
+		parts = append(parts, "ETA "+eta.Round(time.Second).String())
+	}
+
+	return strings.Join(parts, ", "), true
+}
+
+// progress describes the current state of a waiting test.
+func progress(r *record) (string, bool) {
+	s := getState()
+
+	recordsLock.Lock()
+
+	holders := make([]*record, 0, len(held))
+
+	for h := range held {
+		holders = append(holders, h)
+	}
+
+	h := newHistory(summaries)
+
+	recordsLock.Unlock()
+
+	message, ok := describeProgress(r, s, holders, h, time.Now())
+
+	// When the local pool isn't the bottleneck, a shared backend might be.
+	if positioner, isPositioner := config.backend.(Positioner); isPositioner {
+		if position, queued := positioner.Position(r.id); queued {
+			if message != "" {
+				message += ", "
+			}
+
+			message += fmt.Sprintf("position %d in backend queue", position)
+			ok = true
+		}
+	}
+
+	return message, ok
+}
+
+// waitForGrant blocks until the test is granted its resources, periodically
+// reporting its progress if configured to, so long waits in CI logs look alive
+// rather than hung.
+func waitForGrant(t *testing.T, r *record, wait chan interface{}) {
+	t.Helper()
+
+	if config.progressInterval <= 0 {
+		<-wait
+		return
+	}
+
+	ticker := time.NewTicker(config.progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wait:
+			return
+		case <-ticker.C:
+			message, ok := progress(r)
+			if !ok {
+				continue
+			}
+
+			emit(t, event{
+				Action:  "wait",
+				Test:    t.Name(),
+				ID:      r.id,
+				Message: t.Name() + " " + message,
+			})
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescribeProgress(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	records := []*record{
+		{name: "TestA", required: ResourceSet{"memory": 4}, charged: ResourceSet{"memory": 4}, scheduled: now.Add(-10 * time.Minute), released: now.Add(-6 * time.Minute)},
+		{name: "TestB", required: ResourceSet{"memory": 4}, charged: ResourceSet{"memory": 4}, scheduled: now.Add(-3 * time.Minute)},
+		{name: "TestC", required: ResourceSet{"memory": 4}, charged: ResourceSet{"memory": 4}, scheduled: now.Add(-time.Minute)},
+	}
+
+	r := &record{id: "d", name: "TestD", required: ResourceSet{"cpu": 1, "memory": 6}, charged: ResourceSet{"cpu": 1, "memory": 6}, enqueued: now.Add(-time.Minute)}
+
+	s := &state{
+		unallocated: ResourceSet{"cpu": 4, "memory": 2},
+		waiting: []waiting{
+			{id: "e", name: "TestE", required: ResourceSet{"memory": 2}, enqueued: now.Add(-2 * time.Minute)},
+			{id: r.id, name: r.name, required: r.required, enqueued: r.enqueued},
+			{id: "f", name: "TestF", required: ResourceSet{"memory": 2}, enqueued: now},
+		},
+	}
+
+	// TestB is expected to finish in a minute, based on TestA's history,
+	// freeing enough memory.
+	message, ok := describeProgress(r, s, records[1:], newHistory(summarize(records)), now)
+	if !ok {
+		t.Fatal("expected test to be queued")
+	}
+
+	if expected := "position 2 in queue, blocked on memory, ETA 1m0s"; message != expected {
+		t.Fatalf("expected %q, got %q", expected, message)
+	}
+
+	// Without history there's no estimate.
+	message, _ = describeProgress(r, s, records[1:], newHistory(nil), now)
+
+	if expected := "position 2 in queue, blocked on memory"; message != expected {
+		t.Fatalf("expected %q, got %q", expected, message)
+	}
+
+	if _, ok := describeProgress(&record{id: "g", name: "TestG"}, s, nil, newHistory(nil), now); ok {
+		t.Fatal("expected test not to be queued")
+	}
+}
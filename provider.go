@@ -0,0 +1,94 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Provider manages real instances of a resource e.g. containers or clusters,
+// that are handed to tests when they are allocated.  The amount of the
+// resource passed to Start bounds how many instances exist at once.
+type Provider interface {
+	// Acquire returns an instance for a test to use, creating one or reusing
+	// one that has been released.
+	Acquire(ctx context.Context, id string) (any, error)
+
+	// Release is called when a test is done with an instance, the provider
+	// may reset it for reuse or destroy it.
+	Release(ctx context.Context, instance any) error
+}
+
+// provision acquires an instance from the provider of every resource that
+// has one, one for every unit allocated.
+func (a *Allocation) provision() error {
+	names := make([]string, 0, len(a.record.required))
+
+	for name := range a.record.required {
+		if _, ok := config.providers[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		provider := config.providers[name]
+
+		for i := 0; i < a.record.required[name]; i++ {
+			instance, err := provider.Acquire(context.Background(), a.ID())
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			if a.instances == nil {
+				a.instances = map[string][]any{}
+			}
+
+			a.instances[name] = append(a.instances[name], instance)
+		}
+	}
+
+	return nil
+}
+
+// deprovision returns all instances to their providers.
+func (a *Allocation) deprovision() error {
+	var errs []error
+
+	for name, instances := range a.instances {
+		for _, instance := range instances {
+			if err := config.providers[name].Release(context.Background(), instance); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	a.instances = nil
+
+	return errors.Join(errs...)
+}
+
+// Instances returns the instances of the named resource acquired from its
+// provider, as registered with WithProvider.  These contain the connection
+// details needed by the test.
+func (a *Allocation) Instances(name string) []any {
+	return a.instances[name]
+}
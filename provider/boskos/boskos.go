@@ -0,0 +1,315 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package boskos leases resources from a Boskos server, as used by Kubernetes
+// test-infra and Prow, so existing janitor infrastructure can be shared e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client := boskos.NewClient("http://boskos.test-pods.svc.cluster.local", "my-job")
+//
+//	   resources, err := client.Capacity(context.Background(), "gce-project")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithProvider("gce-project", client.Provider("gce-project")))
+//	   ...
+//	}
+//
+// Tests get the leased resource's name and user data with Resources.
+package boskos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// stateFree is a resource that can be acquired.
+	stateFree = "free"
+
+	// stateBusy is a resource that is leased.
+	stateBusy = "busy"
+
+	// stateDirty is a released resource awaiting cleanup by the janitor.
+	stateDirty = "dirty"
+
+	// defaultHeartbeatInterval is how often leases are renewed so that the
+	// reaper doesn't reclaim resources that are still in use.
+	defaultHeartbeatInterval = 30 * time.Second
+
+	// defaultRetryInterval is how long to wait before retrying when no
+	// resources are free.
+	defaultRetryInterval = 5 * time.Second
+)
+
+var (
+	// ErrNotFound is returned when no resources of a type are free.
+	ErrNotFound = errors.New("no free resources")
+)
+
+// Resource is a leased Boskos resource.
+type Resource struct {
+	// Name uniquely identifies the resource e.g. a project name.
+	Name string `json:"name"`
+
+	// Type is the resource type.
+	Type string `json:"type"`
+
+	// State is the resource's state.
+	State string `json:"state"`
+
+	// Owner is who holds the lease.
+	Owner string `json:"owner"`
+
+	// UserData is arbitrary data attached to the resource.
+	UserData map[string]string `json:"userdata,omitempty"`
+}
+
+// metric is the response to a metric request.
+type metric struct {
+	// Current maps states to the number of resources in that state.
+	Current map[string]int `json:"current"`
+}
+
+// Client talks to a Boskos server.
+type Client struct {
+	// url is the server's base URL.
+	url string
+
+	// owner identifies the leaseholder.
+	owner string
+
+	// client is the HTTP client.
+	client *http.Client
+
+	// heartbeatInterval is how often leases are renewed.
+	heartbeatInterval time.Duration
+
+	// retryInterval is how long to wait when no resources are free.
+	retryInterval time.Duration
+
+	// lock protects heartbeats.
+	lock sync.Mutex
+
+	// heartbeats stop the lease renewal of held resources, keyed by name.
+	heartbeats map[string]chan interface{}
+}
+
+// NewClient returns a client for the Boskos server, owner identifies who holds
+// leases, typically the CI job name.
+func NewClient(url, owner string) *Client {
+	return &Client{
+		url:               strings.TrimSuffix(url, "/"),
+		owner:             owner,
+		client:            http.DefaultClient,
+		heartbeatInterval: defaultHeartbeatInterval,
+		retryInterval:     defaultRetryInterval,
+		heartbeats:        map[string]chan interface{}{},
+	}
+}
+
+// do makes a request and optionally decodes the response.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, result any) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.url+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("boskos %s: unexpected status %s", path, response.Status)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Capacity returns the total number of resources of each type, regardless of
+// state, for use with smtest.Start.
+func (c *Client) Capacity(ctx context.Context, types ...string) (smtest.ResourceSet, error) {
+	resources := smtest.ResourceSet{}
+
+	for _, t := range types {
+		var m metric
+
+		if err := c.do(ctx, http.MethodGet, "/metric", url.Values{"type": {t}}, &m); err != nil {
+			return nil, err
+		}
+
+		for _, count := range m.Current {
+			resources[t] += count
+		}
+	}
+
+	return resources, nil
+}
+
+// Acquire leases a free resource of the type, waiting until one is available
+// or the context is cancelled.  The lease is renewed until it is released.
+func (c *Client) Acquire(ctx context.Context, resourceType string) (*Resource, error) {
+	query := url.Values{
+		"type":  {resourceType},
+		"state": {stateFree},
+		"dest":  {stateBusy},
+		"owner": {c.owner},
+	}
+
+	for {
+		var resource Resource
+
+		err := c.do(ctx, http.MethodPost, "/acquire", query, &resource)
+		if err == nil {
+			c.startHeartbeat(&resource)
+
+			return &resource, nil
+		}
+
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+// Release returns a resource to Boskos as dirty, for the janitor to clean up.
+func (c *Client) Release(ctx context.Context, resource *Resource) error {
+	c.stopHeartbeat(resource)
+
+	query := url.Values{
+		"name":  {resource.Name},
+		"dest":  {stateDirty},
+		"owner": {c.owner},
+	}
+
+	return c.do(ctx, http.MethodPost, "/release", query, nil)
+}
+
+// startHeartbeat periodically renews a lease.
+func (c *Client) startHeartbeat(resource *Resource) {
+	done := make(chan interface{})
+
+	c.lock.Lock()
+	c.heartbeats[resource.Name] = done
+	c.lock.Unlock()
+
+	query := url.Values{
+		"name":  {resource.Name},
+		"state": {stateBusy},
+		"owner": {c.owner},
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// Failures will be retried on the next tick, and the lease
+				// only expires after several have been missed.
+				_ = c.do(context.Background(), http.MethodPost, "/update", query, nil)
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops renewing a lease.
+func (c *Client) stopHeartbeat(resource *Resource) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if done, ok := c.heartbeats[resource.Name]; ok {
+		close(done)
+		delete(c.heartbeats, resource.Name)
+	}
+}
+
+// provider adapts the client to smtest.Provider for a single resource type.
+type provider struct {
+	// client is the Boskos client.
+	client *Client
+
+	// resourceType is the Boskos resource type to lease.
+	resourceType string
+}
+
+// Provider returns a smtest.Provider that leases resources of the type for
+// each allocation.  Instances are of type *Resource.
+func (c *Client) Provider(resourceType string) smtest.Provider {
+	return &provider{
+		client:       c,
+		resourceType: resourceType,
+	}
+}
+
+func (p *provider) Acquire(ctx context.Context, _ string) (any, error) {
+	return p.client.Acquire(ctx, p.resourceType)
+}
+
+func (p *provider) Release(ctx context.Context, instance any) error {
+	resource, ok := instance.(*Resource)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	return p.client.Release(ctx, resource)
+}
+
+// Resources returns the Boskos resources allocated to a test for the named
+// resource.
+func Resources(allocation *smtest.Allocation, name string) []*Resource {
+	instances := allocation.Instances(name)
+
+	resources := make([]*Resource, 0, len(instances))
+
+	for _, instance := range instances {
+		if resource, ok := instance.(*Resource); ok {
+			resources = append(resources, resource)
+		}
+	}
+
+	return resources
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package boskos
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBoskos is a minimal in-memory Boskos server.
+type fakeBoskos struct {
+	lock      sync.Mutex
+	resources []*Resource
+	updates   int
+}
+
+func (f *fakeBoskos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	q := r.URL.Query()
+
+	switch r.URL.Path {
+	case "/metric":
+		current := map[string]int{}
+
+		for _, resource := range f.resources {
+			if resource.Type == q.Get("type") {
+				current[resource.State]++
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"type": q.Get("type"), "current": current})
+	case "/acquire":
+		for _, resource := range f.resources {
+			if resource.Type == q.Get("type") && resource.State == q.Get("state") {
+				resource.State = q.Get("dest")
+				resource.Owner = q.Get("owner")
+
+				_ = json.NewEncoder(w).Encode(resource)
+
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	case "/release":
+		for _, resource := range f.resources {
+			if resource.Name == q.Get("name") && resource.Owner == q.Get("owner") {
+				resource.State = q.Get("dest")
+				resource.Owner = ""
+
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	case "/update":
+		f.updates++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBoskos(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fake := &fakeBoskos{
+		resources: []*Resource{
+			{Name: "project-1", Type: "gce-project", State: stateFree, UserData: map[string]string{"zone": "a"}},
+			{Name: "project-2", Type: "gce-project", State: stateDirty},
+			{Name: "cluster-1", Type: "gke-cluster", State: stateFree},
+		},
+	}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewClient(server.URL, "test")
+	client.heartbeatInterval = 10 * time.Millisecond
+	client.retryInterval = 10 * time.Millisecond
+
+	capacity, err := client.Capacity(ctx, "gce-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capacity["gce-project"] != 2 {
+		t.Fatalf("unexpected capacity %v", capacity)
+	}
+
+	provider := client.Provider("gce-project")
+
+	instance, err := provider.Acquire(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource := instance.(*Resource)
+
+	if resource.Name != "project-1" || resource.UserData["zone"] != "a" {
+		t.Fatalf("unexpected resource %v", resource)
+	}
+
+	// Nothing else is free, so this should retry until timeout.
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := provider.Acquire(timeout, "id"); err == nil {
+		t.Fatal("expected acquire to time out")
+	}
+
+	if err := provider.Release(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if fake.resources[0].State != stateDirty {
+		t.Fatalf("expected released resource to be dirty, got %s", fake.resources[0].State)
+	}
+
+	if fake.updates == 0 {
+		t.Fatal("expected lease to be renewed")
+	}
+
+}
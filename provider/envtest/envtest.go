@@ -0,0 +1,318 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package envtest provides a pool of envtest control planes, a kube-apiserver
+// and etcd, as schedulable resources.  Each controller test is leased its own
+// API server, which is reset and reused on release, so startup cost is paid
+// once per control plane rather than once per test e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   controlPlanes := smtestenvtest.New(func() *envtest.Environment {
+//	     return &envtest.Environment{
+//	       CRDDirectoryPaths: []string{"../config/crd"},
+//	     }
+//	   }, smtestenvtest.WithReset(smtestenvtest.DeleteNamespaces))
+//
+//	   smtest.Start(smtest.ResourceSet{"envtest": 4, "memory": 8}, smtest.WithProvider("envtest", controlPlanes))
+//
+//	   code := m.Run()
+//
+//	   smtest.Report()
+//	   controlPlanes.Close()
+//
+//	   os.Exit(code)
+//	}
+//
+//	func TestController(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"envtest": 1, "memory": 1})
+//	   defer allocation.Release()
+//
+//	   client, err := client.New(smtestenvtest.ControlPlanes(allocation, "envtest")[0].Config, client.Options{})
+//	   ...
+//	}
+//
+// Requiring memory alongside each control plane bounds parallelism by what
+// the machine can actually run.
+package envtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var (
+	// systemNamespaces are created by the API server and never deleted.
+	systemNamespaces = map[string]bool{
+		metav1.NamespaceDefault: true,
+		metav1.NamespaceSystem:  true,
+		metav1.NamespacePublic:  true,
+		"kube-node-lease":       true,
+	}
+)
+
+// ControlPlane is a running API server leased to a test.
+type ControlPlane struct {
+	// Environment is the envtest environment.
+	Environment *envtest.Environment
+
+	// Config connects to the API server as an administrator.
+	Config *rest.Config
+}
+
+// ResetFunc returns a control plane to a clean state so it can be reused.
+type ResetFunc func(ctx context.Context, controlPlane *ControlPlane) error
+
+// Provider starts control planes from a template when a test is allocated the
+// resource, and stops or resets them when it is released.
+type Provider struct {
+	// template returns a new environment to start.
+	template func() *envtest.Environment
+
+	// reset, if set, allows control planes to be reused.
+	reset ResetFunc
+
+	// start starts an environment.
+	start func(environment *envtest.Environment) (*rest.Config, error)
+
+	// stop stops an environment.
+	stop func(environment *envtest.Environment) error
+
+	// lock protects idle.
+	lock sync.Mutex
+
+	// idle are reset control planes waiting to be reused.
+	idle []*ControlPlane
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Provider = &Provider{}
+
+// Option modifies the default behaviour of the provider.
+type Option func(*Provider)
+
+// WithReset enables control plane reuse, rather than stopping a control plane
+// on release, it is reset with the function and handed to the next test.  If
+// the reset fails, the control plane is stopped.
+func WithReset(reset ResetFunc) Option {
+	return func(p *Provider) {
+		p.reset = reset
+	}
+}
+
+// New returns a provider that starts control planes from the template, which
+// must return a new environment each time it is called.
+func New(template func() *envtest.Environment, opts ...Option) *Provider {
+	p := &Provider{
+		template: template,
+		start:    (*envtest.Environment).Start,
+		stop:     (*envtest.Environment).Stop,
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Acquire returns an idle control plane if there is one, otherwise starts a
+// new one.
+func (p *Provider) Acquire(_ context.Context, _ string) (any, error) {
+	p.lock.Lock()
+
+	if n := len(p.idle); n > 0 {
+		controlPlane := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+
+		p.lock.Unlock()
+
+		return controlPlane, nil
+	}
+
+	p.lock.Unlock()
+
+	environment := p.template()
+
+	config, err := p.start(environment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ControlPlane{
+		Environment: environment,
+		Config:      config,
+	}, nil
+}
+
+// Release resets the control plane for reuse if enabled, otherwise stops it.
+func (p *Provider) Release(ctx context.Context, instance any) error {
+	controlPlane, ok := instance.(*ControlPlane)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	if p.reset != nil {
+		if err := p.reset(ctx, controlPlane); err == nil {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			p.idle = append(p.idle, controlPlane)
+
+			return nil
+		}
+	}
+
+	return p.stop(controlPlane.Environment)
+}
+
+// Close stops all idle control planes, this should be called once all tests
+// have completed.
+func (p *Provider) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+
+	for _, controlPlane := range p.idle {
+		if err := p.stop(controlPlane.Environment); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	p.idle = nil
+
+	return errors.Join(errs...)
+}
+
+// DeleteNamespaces is a ResetFunc that deletes every namespace the test created
+// along with its contents.  Envtest runs no namespace controller, so this does
+// its job, deleting all namespaced resources then finalizing the namespaces.
+// Cluster scoped resources e.g. CRDs installed by the template are retained.
+func DeleteNamespaces(ctx context.Context, controlPlane *ControlPlane) error {
+	client, err := kubernetes.NewForConfig(controlPlane.Config)
+	if err != nil {
+		return err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(controlPlane.Config)
+	if err != nil {
+		return err
+	}
+
+	return deleteNamespaces(ctx, client, dynamicClient)
+}
+
+// deleteNamespaces deletes and finalizes all non-system namespaces.
+func deleteNamespaces(ctx context.Context, client kubernetes.Interface, dynamicClient dynamic.Interface) error {
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	resources, err := deletableResources(client.Discovery())
+	if err != nil {
+		return err
+	}
+
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+
+		if systemNamespaces[namespace.Name] {
+			continue
+		}
+
+		for _, resource := range resources {
+			if err := dynamicClient.Resource(resource).Namespace(namespace.Name).DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{}); err != nil {
+				return fmt.Errorf("failed to delete %s in namespace %s: %w", resource.String(), namespace.Name, err)
+			}
+		}
+
+		if err := client.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+
+		// Without finalizers the namespace is removed immediately, it may
+		// already be gone if nothing held it.
+		namespace.Spec.Finalizers = nil
+
+		if _, err := client.CoreV1().Namespaces().Finalize(ctx, namespace, metav1.UpdateOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deletableResources returns every namespaced resource that can be deleted
+// as a collection.  Resources served at multiple versions are returned for
+// each, which is harmless as subsequent deletes find nothing.
+func deletableResources(client discovery.DiscoveryInterface) ([]schema.GroupVersionResource, error) {
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+
+	namespaced := discovery.ResourcePredicateFunc(func(_ string, r *metav1.APIResource) bool {
+		return r.Namespaced && !strings.Contains(r.Name, "/")
+	})
+
+	lists = discovery.FilteredBy(namespaced, discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"deletecollection"}}, lists))
+
+	var resources []schema.GroupVersionResource
+
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range list.APIResources {
+			resources = append(resources, gv.WithResource(resource.Name))
+		}
+	}
+
+	return resources, nil
+}
+
+// ControlPlanes returns the control planes allocated to a test for the named
+// resource.
+func ControlPlanes(allocation *smtest.Allocation, name string) []*ControlPlane {
+	instances := allocation.Instances(name)
+
+	controlPlanes := make([]*ControlPlane, 0, len(instances))
+
+	for _, instance := range instances {
+		if controlPlane, ok := instance.(*ControlPlane); ok {
+			controlPlanes = append(controlPlanes, controlPlane)
+		}
+	}
+
+	return controlPlanes
+}
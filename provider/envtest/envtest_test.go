@@ -0,0 +1,180 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package envtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// fakeProvider returns a provider that doesn't start real control planes, and
+// records which were stopped.
+func fakeProvider(stopped map[*envtest.Environment]bool, opts ...Option) *Provider {
+	p := New(func() *envtest.Environment {
+		return &envtest.Environment{}
+	}, opts...)
+
+	p.start = func(*envtest.Environment) (*rest.Config, error) {
+		return &rest.Config{}, nil
+	}
+
+	p.stop = func(environment *envtest.Environment) error {
+		stopped[environment] = true
+
+		return nil
+	}
+
+	return p
+}
+
+func TestReuse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var resets int
+
+	stopped := map[*envtest.Environment]bool{}
+
+	p := fakeProvider(stopped, WithReset(func(context.Context, *ControlPlane) error {
+		resets++
+
+		if resets > 1 {
+			return errors.New("reset failed")
+		}
+
+		return nil
+	}))
+
+	first, err := p.Acquire(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := p.Acquire(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second != first {
+		t.Fatal("expected control plane to be reused")
+	}
+
+	// The second reset fails, so the control plane is stopped.
+	if err := p.Release(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !stopped[second.(*ControlPlane).Environment] {
+		t.Fatal("expected control plane to be stopped")
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	stopped := map[*envtest.Environment]bool{}
+
+	p := fakeProvider(stopped, WithReset(func(context.Context, *ControlPlane) error {
+		return nil
+	}))
+
+	instance, err := p.Acquire(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Release(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !stopped[instance.(*ControlPlane).Environment] {
+		t.Fatal("expected idle control plane to be stopped")
+	}
+}
+
+func TestDeleteNamespaces(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+	)
+
+	client.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: metav1.Verbs{"delete", "deletecollection", "list"}},
+				{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"delete", "list"}},
+			},
+		},
+	}
+
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMaps: "ConfigMapList",
+	})
+
+	if err := deleteNamespaces(ctx, client, dynamicClient); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+
+	for _, action := range dynamicClient.Actions() {
+		if action.GetVerb() == "delete-collection" && action.GetResource() == configMaps {
+			deleted = append(deleted, action.GetNamespace())
+		}
+	}
+
+	if len(deleted) != 1 || deleted[0] != "test" {
+		t.Fatalf("expected config maps in test to be deleted, got %v", deleted)
+	}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(namespaces.Items) != 1 || namespaces.Items[0].Name != metav1.NamespaceSystem {
+		t.Fatalf("expected only system namespaces to remain, got %v", namespaces.Items)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kafka leases pre-created Kafka topics to tests, and purges them on
+// release, so parallel tests get isolated messaging without topic name
+// collisions e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   admin, err := kadm.NewOptClient(kgo.SeedBrokers("localhost:9092"))
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   topics := []string{"e2e-0", "e2e-1", "e2e-2"}
+//
+//	   smtest.Start(smtest.ResourceSet{"topic": len(topics)}, smtest.WithProvider("topic", smtestkafka.New(admin, topics...)))
+//	   ...
+//	}
+//
+//	func TestProducer(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"topic": 1})
+//	   defer allocation.Release()
+//
+//	   topic := smtestkafka.Topics(allocation, "topic")[0]
+//	   ...
+//	}
+//
+// Committed consumer group offsets are not purged, so tests should use a
+// unique group, for example named after the allocation ID.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+)
+
+var (
+	// ErrNoTopic is returned when there is no free topic, which implies the
+	// provider is registered against more topics than it manages.
+	ErrNoTopic = errors.New("no free topic")
+)
+
+// admin is the subset of the admin client that is required.
+type admin interface {
+	ListEndOffsets(ctx context.Context, topics ...string) (kadm.ListedOffsets, error)
+	DeleteRecords(ctx context.Context, offsets kadm.Offsets) (kadm.DeleteRecordsResponses, error)
+}
+
+// Provider hands out topics from a pool.
+type Provider struct {
+	// admin purges topics.
+	admin admin
+
+	// lock protects free.
+	lock sync.Mutex
+
+	// free are the topics not leased to a test.
+	free []string
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Provider = &Provider{}
+
+// New returns a provider that leases the topics, which must already exist.
+// Instances are the topic names.
+func New(client *kadm.Client, topics ...string) *Provider {
+	return &Provider{
+		admin: client,
+		free:  append([]string(nil), topics...),
+	}
+}
+
+// Acquire takes a free topic.
+func (p *Provider) Acquire(_ context.Context, _ string) (any, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(p.free) == 0 {
+		return nil, ErrNoTopic
+	}
+
+	topic := p.free[0]
+	p.free = p.free[1:]
+
+	return topic, nil
+}
+
+// Release deletes every record in the topic and returns it to the pool.  If the
+// topic cannot be purged, it is removed from the pool.
+func (p *Provider) Release(ctx context.Context, instance any) error {
+	topic, ok := instance.(string)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	if err := p.purge(ctx, topic); err != nil {
+		return fmt.Errorf("failed to purge topic %s, removing from the pool: %w", topic, err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.free = append(p.free, topic)
+
+	return nil
+}
+
+// purge deletes all records up to the end of every partition.
+func (p *Provider) purge(ctx context.Context, topic string) error {
+	offsets, err := p.admin.ListEndOffsets(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	if err := offsets.Error(); err != nil {
+		return err
+	}
+
+	responses, err := p.admin.DeleteRecords(ctx, offsets.Offsets())
+	if err != nil {
+		return err
+	}
+
+	return responses.Error()
+}
+
+// Topics returns the topics allocated to a test for the named resource.
+func Topics(allocation *smtest.Allocation, name string) []string {
+	var topics []string
+
+	for _, instance := range allocation.Instances(name) {
+		if topic, ok := instance.(string); ok {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
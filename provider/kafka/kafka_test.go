@@ -0,0 +1,103 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+)
+
+// fakeAdmin records which partitions were purged, and to where.
+type fakeAdmin struct {
+	purged kadm.Offsets
+	err    error
+}
+
+func (a *fakeAdmin) ListEndOffsets(_ context.Context, topics ...string) (kadm.ListedOffsets, error) {
+	offsets := kadm.ListedOffsets{}
+
+	for _, topic := range topics {
+		offsets[topic] = map[int32]kadm.ListedOffset{
+			0: {Topic: topic, Partition: 0, Offset: 10},
+			1: {Topic: topic, Partition: 1, Offset: 5},
+		}
+	}
+
+	return offsets, nil
+}
+
+func (a *fakeAdmin) DeleteRecords(_ context.Context, offsets kadm.Offsets) (kadm.DeleteRecordsResponses, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	a.purged = offsets
+
+	return kadm.DeleteRecordsResponses{}, nil
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	admin := &fakeAdmin{}
+
+	p := &Provider{
+		admin: admin,
+		free:  []string{"e2e-0"},
+	}
+
+	topic, err := p.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Acquire(ctx, "b"); !errors.Is(err, ErrNoTopic) {
+		t.Fatalf("expected no free topic, got %v", err)
+	}
+
+	if err := p.Release(ctx, topic); err != nil {
+		t.Fatal(err)
+	}
+
+	if o, ok := admin.purged.Lookup("e2e-0", 0); !ok || o.At != 10 {
+		t.Fatalf("expected partition 0 to be purged to its end, got %v", admin.purged)
+	}
+
+	if o, ok := admin.purged.Lookup("e2e-0", 1); !ok || o.At != 5 {
+		t.Fatalf("expected partition 1 to be purged to its end, got %v", admin.purged)
+	}
+
+	// A topic that can't be purged is taken out of the pool.
+	if _, err := p.Acquire(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	admin.err = errors.New("broker unavailable")
+
+	if err := p.Release(ctx, topic); err == nil {
+		t.Fatal("expected purge to fail")
+	}
+
+	if _, err := p.Acquire(ctx, "c"); !errors.Is(err, ErrNoTopic) {
+		t.Fatalf("expected topic to be removed from the pool, got %v", err)
+	}
+}
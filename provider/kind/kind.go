@@ -0,0 +1,421 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kind provides a pool of kind clusters as schedulable resources, each
+// test is leased a whole cluster and given its kubeconfig, and the cluster is
+// cleaned up and reused on release e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   clusters := smtestkind.New(
+//	     smtestkind.WithClusters("e2e-1", "e2e-2"),
+//	     smtestkind.WithGrowth("e2e-extra", 2),
+//	   )
+//
+//	   smtest.Start(smtest.ResourceSet{"kind": 4}, smtest.WithProvider("kind", clusters))
+//
+//	   code := m.Run()
+//
+//	   smtest.Report()
+//	   clusters.Close()
+//
+//	   os.Exit(code)
+//	}
+//
+//	func TestOperator(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"kind": 1})
+//	   defer allocation.Release()
+//
+//	   config, err := smtestkind.Clusters(allocation, "kind")[0].RESTConfig()
+//	   ...
+//	}
+package kind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/kind/pkg/cluster"
+)
+
+const (
+	// cleanupTimeout bounds how long namespaces may take to terminate.
+	cleanupTimeout = 5 * time.Minute
+
+	// cleanupPollInterval is how often terminating namespaces are checked.
+	cleanupPollInterval = time.Second
+)
+
+var (
+	// ErrExhausted is returned when every cluster is in use and the pool may
+	// not grow, which implies the resource is registered with more capacity
+	// than the pool has.
+	ErrExhausted = errors.New("no free kind cluster")
+
+	// newClient returns a Kubernetes client for the cluster, it can be
+	// replaced in tests.
+	newClient = func(c *Cluster) (kubernetes.Interface, error) {
+		config, err := c.RESTConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		return kubernetes.NewForConfig(config)
+	}
+)
+
+// Cluster is a kind cluster leased to a test.
+type Cluster struct {
+	// Name is the kind cluster name.
+	Name string
+
+	// Kubeconfig connects to the cluster as an administrator.
+	Kubeconfig string
+
+	// baseline are the namespaces that existed when the cluster joined
+	// the pool, everything else is deleted by cleanup.
+	baseline map[string]bool
+}
+
+// RESTConfig returns a client configuration for the cluster.
+func (c *Cluster) RESTConfig() (*rest.Config, error) {
+	return clientcmd.RESTConfigFromKubeConfig([]byte(c.Kubeconfig))
+}
+
+// CleanupFunc returns a cluster to a clean state so it can be reused.
+type CleanupFunc func(ctx context.Context, cluster *Cluster) error
+
+// kind is the subset of the kind cluster provider that is required.
+type kind interface {
+	Create(name string, options ...cluster.CreateOption) error
+	Delete(name, explicitKubeconfigPath string) error
+	KubeConfig(name string, internal bool) (string, error)
+}
+
+// Provider hands out clusters from a pool, optionally creating more on demand.
+type Provider struct {
+	// kind manages clusters.
+	kind kind
+
+	// cleanup resets clusters on release.
+	cleanup CleanupFunc
+
+	// prefix names clusters created on demand.
+	prefix string
+
+	// limit is the number of clusters that may be created on demand.
+	limit int
+
+	// createOptions are passed to kind when creating clusters.
+	createOptions []cluster.CreateOption
+
+	// lock protects the fields below.
+	lock sync.Mutex
+
+	// pending are pre-created clusters that have not yet been used.
+	pending []string
+
+	// idle are cleaned clusters waiting to be reused.
+	idle []*Cluster
+
+	// created are the clusters created on demand.
+	created []*Cluster
+
+	// next numbers the next cluster to be created.
+	next int
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Provider = &Provider{}
+
+// Option modifies the default behaviour of the provider.
+type Option func(*Provider)
+
+// WithClusters adds existing clusters to the pool, these are never deleted.
+func WithClusters(names ...string) Option {
+	return func(p *Provider) {
+		p.pending = append(p.pending, names...)
+	}
+}
+
+// WithGrowth allows up to limit clusters, named with the prefix, to be created
+// when the pool is empty.  These are deleted by Close.
+func WithGrowth(prefix string, limit int, opts ...cluster.CreateOption) Option {
+	return func(p *Provider) {
+		p.prefix = prefix
+		p.limit = limit
+		p.createOptions = opts
+	}
+}
+
+// WithCleanup replaces the cleanup routine, by default any namespaces created
+// since the cluster joined the pool are deleted.  If cleanup fails the cluster
+// is removed from the pool.
+func WithCleanup(cleanup CleanupFunc) Option {
+	return func(p *Provider) {
+		p.cleanup = cleanup
+	}
+}
+
+// New returns a provider that hands out kind clusters.
+func New(opts ...Option) *Provider {
+	p := &Provider{
+		kind:    cluster.NewProvider(),
+		cleanup: DeleteNamespaces,
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// join prepares a cluster for use by the pool.
+func (p *Provider) join(ctx context.Context, name string) (*Cluster, error) {
+	kubeconfig, err := p.kind.KubeConfig(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		Name:       name,
+		Kubeconfig: kubeconfig,
+		baseline:   map[string]bool{},
+	}
+
+	client, err := newClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range namespaces.Items {
+		c.baseline[namespaces.Items[i].Name] = true
+	}
+
+	return c, nil
+}
+
+// Acquire returns an idle cluster, then an unused pre-created one, and finally
+// creates a new one if growth allows.
+func (p *Provider) Acquire(ctx context.Context, _ string) (any, error) {
+	p.lock.Lock()
+
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+
+		p.lock.Unlock()
+
+		return c, nil
+	}
+
+	if len(p.pending) > 0 {
+		name := p.pending[0]
+		p.pending = p.pending[1:]
+
+		p.lock.Unlock()
+
+		return p.join(ctx, name)
+	}
+
+	if len(p.created) >= p.limit {
+		p.lock.Unlock()
+
+		return nil, ErrExhausted
+	}
+
+	name := fmt.Sprintf("%s-%d", p.prefix, p.next)
+
+	p.next++
+
+	// Reserve the slot while the cluster is created.
+	placeholder := &Cluster{Name: name}
+
+	p.created = append(p.created, placeholder)
+
+	p.lock.Unlock()
+
+	// Keep kind away from the user's kubeconfig, the cluster's is read back
+	// directly.
+	options := append([]cluster.CreateOption{
+		cluster.CreateWithKubeconfigPath(p.kubeconfigPath()),
+	}, p.createOptions...)
+
+	if err := p.kind.Create(name, options...); err != nil {
+		p.forget(placeholder)
+
+		return nil, err
+	}
+
+	c, err := p.join(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	*placeholder = *c
+
+	return placeholder, nil
+}
+
+// forget gives up a reservation for a cluster that failed to be created.
+func (p *Provider) forget(c *Cluster) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i := range p.created {
+		if p.created[i] == c {
+			p.created = append(p.created[:i], p.created[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// kubeconfigPath is where kind writes kubeconfigs for created clusters.
+func (p *Provider) kubeconfigPath() string {
+	return filepath.Join(os.TempDir(), "smtest-kind.kubeconfig")
+}
+
+// Release cleans up the cluster and returns it to the pool.
+func (p *Provider) Release(ctx context.Context, instance any) error {
+	c, ok := instance.(*Cluster)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	if err := p.cleanup(ctx, c); err != nil {
+		return fmt.Errorf("failed to clean up cluster %s, removing from the pool: %w", c.Name, err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.idle = append(p.idle, c)
+
+	return nil
+}
+
+// Close deletes all clusters created on demand, this should be called once all
+// tests have completed.
+func (p *Provider) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+
+	for _, c := range p.created {
+		if err := p.kind.Delete(c.Name, p.kubeconfigPath()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	p.created = nil
+	p.idle = nil
+
+	return errors.Join(errs...)
+}
+
+// DeleteNamespaces is the default CleanupFunc, it deletes every namespace that
+// didn't exist when the cluster joined the pool, and waits for them to be
+// removed so the next test can reuse the names.
+func DeleteNamespaces(ctx context.Context, c *Cluster) error {
+	ctx, cancel := context.WithTimeout(ctx, cleanupTimeout)
+	defer cancel()
+
+	client, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	var terminating []string
+
+	for i := range namespaces.Items {
+		name := namespaces.Items[i].Name
+
+		if c.baseline[name] {
+			continue
+		}
+
+		if err := client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		terminating = append(terminating, name)
+	}
+
+	ticker := time.NewTicker(cleanupPollInterval)
+	defer ticker.Stop()
+
+	for len(terminating) > 0 {
+		if _, err := client.CoreV1().Namespaces().Get(ctx, terminating[0], metav1.GetOptions{}); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			terminating = terminating[1:]
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Clusters returns the clusters allocated to a test for the named resource.
+func Clusters(allocation *smtest.Allocation, name string) []*Cluster {
+	instances := allocation.Instances(name)
+
+	clusters := make([]*Cluster, 0, len(instances))
+
+	for _, instance := range instances {
+		if c, ok := instance.(*Cluster); ok {
+			clusters = append(clusters, c)
+		}
+	}
+
+	return clusters
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kind
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/kind/pkg/cluster"
+)
+
+// fakeKind records created and deleted clusters.
+type fakeKind struct {
+	lock    sync.Mutex
+	created []string
+	deleted []string
+}
+
+func (k *fakeKind) Create(name string, _ ...cluster.CreateOption) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.created = append(k.created, name)
+
+	return nil
+}
+
+func (k *fakeKind) Delete(name, _ string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.deleted = append(k.deleted, name)
+
+	return nil
+}
+
+func (k *fakeKind) KubeConfig(name string, _ bool) (string, error) {
+	return name, nil
+}
+
+// TestPool is not parallel as it replaces the client constructor.
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	// Every cluster has the same fake API, keyed by its kubeconfig which
+	// is the cluster name.
+	clients := map[string]kubernetes.Interface{}
+
+	newClient = func(c *Cluster) (kubernetes.Interface, error) {
+		if _, ok := clients[c.Kubeconfig]; !ok {
+			clients[c.Kubeconfig] = fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem}})
+		}
+
+		return clients[c.Kubeconfig], nil
+	}
+
+	k := &fakeKind{}
+
+	p := New(WithClusters("existing"), WithGrowth("extra", 1))
+	p.kind = k
+
+	first, err := p.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := first.(*Cluster).Name; name != "existing" {
+		t.Fatalf("expected existing cluster first, got %s", name)
+	}
+
+	second, err := p.Acquire(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := second.(*Cluster).Name; name != "extra-0" || len(k.created) != 1 {
+		t.Fatalf("expected a cluster to be created, got %s", name)
+	}
+
+	if _, err := p.Acquire(ctx, "c"); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("expected pool to be exhausted, got %v", err)
+	}
+
+	// Tests create namespaces that must be cleaned up.
+	if _, err := clients["existing"].CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces, err := clients["existing"].CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(namespaces.Items) != 1 || namespaces.Items[0].Name != metav1.NamespaceSystem {
+		t.Fatalf("expected test namespaces to be deleted, got %v", namespaces.Items)
+	}
+
+	third, err := p.Acquire(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third != first {
+		t.Fatal("expected cluster to be reused")
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k.deleted) != 1 || k.deleted[0] != "extra-0" {
+		t.Fatalf("expected only created clusters to be deleted, got %v", k.deleted)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package license checks out counted licenses for tests that drive commercial
+// tools, so they queue for a license rather than failing with "no licenses
+// available" e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   client := license.NewClient("https://licenses.example.com", "my-job")
+//
+//	   resources, err := client.Capacity(context.Background(), "simulator")
+//	   if err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(resources, smtest.WithProvider("simulator", client.Provider("simulator")))
+//	   ...
+//	}
+//
+// License servers that expose a simple HTTP API are driven directly, the
+// protocol is:
+//
+//	GET  /v1/features  -> 200 {"features": {"<feature>": {"total": 10, "used": 3}}}
+//	POST /v1/checkout  {"feature": "...", "owner": "...", "allocation": "..."}
+//	                   -> 200 {"id": "..."}, 409 when none are available
+//	POST /v1/return    {"id": "..."} -> 200
+//
+// FlexLM licenses are checked out by the tools themselves, so for those
+// FlexLM reads how many are free with lmstat, and the pool alone limits how
+// many tests use them at once.
+package license
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	// defaultRetryInterval is how long to wait before retrying when no
+	// licenses are free, for example when used by people outside the pool.
+	defaultRetryInterval = 5 * time.Second
+)
+
+var (
+	// ErrUnavailable is returned when no licenses for a feature are free.
+	ErrUnavailable = errors.New("no licenses available")
+
+	// lmstatUsers matches the per-feature summary line of lmstat.
+	lmstatUsers = regexp.MustCompile(`^Users of (\S+):\s+\(Total of (\d+) licenses? issued;\s+Total of (\d+) licenses? in use\)`)
+
+	// lmstat runs lmutil lmstat, it can be replaced in tests.
+	lmstat = func(ctx context.Context, server string, features []string) ([]byte, error) {
+		args := []string{"lmstat", "-c", server}
+
+		if len(features) == 1 {
+			args = append(args, "-f", features[0])
+		} else {
+			args = append(args, "-a")
+		}
+
+		return exec.CommandContext(ctx, "lmutil", args...).Output()
+	}
+)
+
+// License is a checked out license.
+type License struct {
+	// ID identifies the checkout.
+	ID string `json:"id"`
+
+	// Feature is the licensed feature.
+	Feature string `json:"-"`
+}
+
+// usage is how many licenses of a feature exist and are in use.
+type usage struct {
+	// Total is the number of licenses.
+	Total int `json:"total"`
+
+	// Used is the number of licenses checked out.
+	Used int `json:"used"`
+}
+
+// featuresResponse is the response to a features request.
+type featuresResponse struct {
+	// Features maps features to their usage.
+	Features map[string]usage `json:"features"`
+}
+
+// checkoutRequest is the body of a checkout request.
+type checkoutRequest struct {
+	// Feature is the licensed feature.
+	Feature string `json:"feature"`
+
+	// Owner identifies who holds the license.
+	Owner string `json:"owner"`
+
+	// Allocation is the allocation ID the license is for.
+	Allocation string `json:"allocation"`
+}
+
+// Client talks to a license server.
+type Client struct {
+	// url is the server's base URL.
+	url string
+
+	// owner identifies who holds licenses.
+	owner string
+
+	// client is the HTTP client.
+	client *http.Client
+
+	// retryInterval is how long to wait when no licenses are free.
+	retryInterval time.Duration
+}
+
+// NewClient returns a client for the license server, owner identifies who
+// holds licenses, typically the CI job name.
+func NewClient(url, owner string) *Client {
+	return &Client{
+		url:           strings.TrimSuffix(url, "/"),
+		owner:         owner,
+		client:        http.DefaultClient,
+		retryInterval: defaultRetryInterval,
+	}
+}
+
+// do makes a request and optionally decodes the response.
+func (c *Client) do(ctx context.Context, method, path string, body, result any) error {
+	var data []byte
+
+	if body != nil {
+		var err error
+
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		return ErrUnavailable
+	default:
+		return fmt.Errorf("license server %s: unexpected status %s", path, response.Status)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Capacity returns the number of free licenses of each feature, for use with
+// smtest.Start.
+func (c *Client) Capacity(ctx context.Context, features ...string) (smtest.ResourceSet, error) {
+	var response featuresResponse
+
+	if err := c.do(ctx, http.MethodGet, "/v1/features", nil, &response); err != nil {
+		return nil, err
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for _, feature := range features {
+		u := response.Features[feature]
+
+		resources[feature] = max(u.Total-u.Used, 0)
+	}
+
+	return resources, nil
+}
+
+// Checkout checks out a license for the feature, waiting until one is
+// available or the context is cancelled.
+func (c *Client) Checkout(ctx context.Context, feature, allocation string) (*License, error) {
+	request := &checkoutRequest{
+		Feature:    feature,
+		Owner:      c.owner,
+		Allocation: allocation,
+	}
+
+	for {
+		var license License
+
+		err := c.do(ctx, http.MethodPost, "/v1/checkout", request, &license)
+		if err == nil {
+			license.Feature = feature
+
+			return &license, nil
+		}
+
+		if !errors.Is(err, ErrUnavailable) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+// Return gives a license back to the server.
+func (c *Client) Return(ctx context.Context, license *License) error {
+	return c.do(ctx, http.MethodPost, "/v1/return", license, nil)
+}
+
+// provider adapts the client to smtest.Provider for a single feature.
+type provider struct {
+	// client is the license client.
+	client *Client
+
+	// feature is the licensed feature.
+	feature string
+}
+
+// Provider returns a smtest.Provider that checks out a license of the feature
+// for each unit of the resource.  Instances are of type *License.
+func (c *Client) Provider(feature string) smtest.Provider {
+	return &provider{
+		client:  c,
+		feature: feature,
+	}
+}
+
+func (p *provider) Acquire(ctx context.Context, id string) (any, error) {
+	return p.client.Checkout(ctx, p.feature, id)
+}
+
+func (p *provider) Release(ctx context.Context, instance any) error {
+	license, ok := instance.(*License)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	return p.client.Return(ctx, license)
+}
+
+// Licenses returns the licenses checked out for a test for the named resource.
+func Licenses(allocation *smtest.Allocation, name string) []*License {
+	instances := allocation.Instances(name)
+
+	licenses := make([]*License, 0, len(instances))
+
+	for _, instance := range instances {
+		if license, ok := instance.(*License); ok {
+			licenses = append(licenses, license)
+		}
+	}
+
+	return licenses
+}
+
+// FlexLM returns the number of free licenses of each feature served by a FlexLM
+// license server e.g. 27000@licenses.example.com, as reported by lmutil lmstat.
+// Features the server doesn't serve are reported as having none free.
+func FlexLM(ctx context.Context, server string, features ...string) (smtest.ResourceSet, error) {
+	output, err := lmstat(ctx, server, features)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run lmstat: %w", err)
+	}
+
+	resources := smtest.ResourceSet{}
+
+	for _, feature := range features {
+		resources[feature] = 0
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		match := lmstatUsers.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+
+		if _, ok := resources[match[1]]; !ok {
+			continue
+		}
+
+		// These can't fail to parse as the expression only matches digits.
+		issued, _ := strconv.Atoi(match[2])
+		used, _ := strconv.Atoi(match[3])
+
+		resources[match[1]] = max(issued-used, 0)
+	}
+
+	return resources, scanner.Err()
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package license
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a fake license server with a single feature.
+type server struct {
+	lock  sync.Mutex
+	total int
+	used  map[string]string
+	next  int
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/features":
+		_ = json.NewEncoder(w).Encode(&featuresResponse{
+			Features: map[string]usage{"simulator": {Total: s.total, Used: len(s.used)}},
+		})
+	case "/v1/checkout":
+		var request checkoutRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if len(s.used) >= s.total {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		s.next++
+
+		id := request.Allocation + "-" + strconv.Itoa(s.next)
+
+		s.used[id] = request.Owner
+
+		_ = json.NewEncoder(w).Encode(&License{ID: id})
+	case "/v1/return":
+		var license License
+
+		if err := json.NewDecoder(r.Body).Decode(&license); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		delete(s.used, license.ID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	s := httptest.NewServer(&server{total: 2, used: map[string]string{"other": "someone"}})
+	defer s.Close()
+
+	client := NewClient(s.URL, "test")
+	client.retryInterval = 10 * time.Millisecond
+
+	resources, err := client.Capacity(ctx, "simulator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resources["simulator"] != 1 {
+		t.Fatalf("expected 1 free license, got %v", resources)
+	}
+
+	p := client.Provider("simulator")
+
+	instance, err := p.Acquire(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if license := instance.(*License); license.ID != "abc-1" || license.Feature != "simulator" {
+		t.Fatalf("unexpected license %+v", license)
+	}
+
+	// The server is exhausted so this waits until the context expires.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.Acquire(timeoutCtx, "def"); err == nil {
+		t.Fatal("expected checkout to wait for a license")
+	}
+
+	if err := p.Release(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Acquire(ctx, "def"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFlexLM is not parallel as it replaces lmstat.
+func TestFlexLM(t *testing.T) {
+	lmstat = func(context.Context, string, []string) ([]byte, error) {
+		return []byte(`lmutil - Copyright (c) 1989-2020 Flexera. All Rights Reserved.
+Flexible License Manager status on Mon 1/1/2024 00:00
+
+Users of simulator:  (Total of 10 licenses issued;  Total of 7 licenses in use)
+
+  "simulator" v2024.1, vendor: vendord, expiry: 31-dec-2024
+
+Users of compiler:  (Total of 1 license issued;  Total of 1 license in use)
+`), nil
+	}
+
+	resources, err := FlexLM(context.Background(), "27000@licenses", "simulator", "compiler", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resources["simulator"] != 3 || resources["compiler"] != 0 || resources["missing"] != 0 {
+		t.Fatalf("unexpected free licenses %v", resources)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var lock sync.Mutex
+
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		lock.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	p := New(server.URL, "admin", "secret", []string{"e2e/0"}, WithUsers("tester"))
+
+	vhost, err := p.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Acquire(ctx, "b"); !errors.Is(err, ErrNoVirtualHost) {
+		t.Fatalf("expected no free virtual host, got %v", err)
+	}
+
+	if err := p.Release(ctx, vhost); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"DELETE /api/vhosts/e2e%2F0",
+		"PUT /api/vhosts/e2e%2F0",
+		"PUT /api/permissions/e2e%2F0/tester",
+	}
+
+	if len(requests) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, requests)
+	}
+
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, requests)
+		}
+	}
+
+	if _, err := p.Acquire(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	p := New(server.URL, "admin", "secret", []string{"e2e"})
+
+	vhost, err := p.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Release(ctx, vhost); err == nil {
+		t.Fatal("expected purge to fail")
+	}
+
+	if _, err := p.Acquire(ctx, "b"); !errors.Is(err, ErrNoVirtualHost) {
+		t.Fatalf("expected virtual host to be removed from the pool, got %v", err)
+	}
+}
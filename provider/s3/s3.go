@@ -0,0 +1,282 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 leases pre-created object storage buckets, or prefixes within a
+// bucket, to tests and empties them on release e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   buckets := smtests3.New(s3.NewFromConfig(cfg), smtests3.Buckets("e2e-0", "e2e-1")...)
+//
+//	   // Clean up after any runs that crashed.
+//	   if _, err := buckets.Sweep(context.Background()); err != nil {
+//	     log.Fatal(err)
+//	   }
+//
+//	   smtest.Start(smtest.ResourceSet{"bucket": 2}, smtest.WithProvider("bucket", buckets))
+//	   ...
+//	}
+//
+//	func TestUpload(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"bucket": 1})
+//	   defer allocation.Release()
+//
+//	   location := smtests3.Locations(allocation, "bucket")[0]
+//	   ...
+//	}
+//
+// Leased locations are tagged with a marker object holding the allocation ID,
+// so those left behind by crashed runs can be found and emptied.  Only current
+// object versions are deleted, so versioning should be disabled.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// marker is the object, relative to the location's prefix, that holds
+	// the ID of the allocation using it.
+	marker = ".smtest-allocation"
+)
+
+var (
+	// ErrNoLocation is returned when there is no free location, which implies
+	// the provider is registered against more than it manages.
+	ErrNoLocation = errors.New("no free location")
+)
+
+// Location is a bucket, or a prefix within one, leased to a test.
+type Location struct {
+	// Bucket is the bucket name.
+	Bucket string
+
+	// Prefix is prepended to all object keys, it is empty when the test
+	// has the whole bucket.
+	Prefix string
+}
+
+// Buckets returns locations that are whole buckets.
+func Buckets(names ...string) []Location {
+	locations := make([]Location, len(names))
+
+	for i, name := range names {
+		locations[i] = Location{Bucket: name}
+	}
+
+	return locations
+}
+
+// Prefixes returns locations that are prefixes of a single bucket.  A trailing
+// slash is added to prefixes so they don't overlap.
+func Prefixes(bucket string, prefixes ...string) []Location {
+	locations := make([]Location, len(prefixes))
+
+	for i, prefix := range prefixes {
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		locations[i] = Location{Bucket: bucket, Prefix: prefix}
+	}
+
+	return locations
+}
+
+// API is the subset of the S3 client that is required.
+type API interface {
+	s3.ListObjectsV2APIClient
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// Provider hands out locations from a pool.
+type Provider struct {
+	// client talks to object storage.
+	client API
+
+	// lock protects free.
+	lock sync.Mutex
+
+	// free are the locations not leased to a test.
+	free []Location
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Provider = &Provider{}
+
+// New returns a provider that leases the locations, whose buckets must already
+// exist.  Instances are of type *Location.
+func New(client API, locations ...Location) *Provider {
+	return &Provider{
+		client: client,
+		free:   append([]Location(nil), locations...),
+	}
+}
+
+// Acquire takes a free location and tags it with the allocation ID.
+func (p *Provider) Acquire(ctx context.Context, id string) (any, error) {
+	p.lock.Lock()
+
+	if len(p.free) == 0 {
+		p.lock.Unlock()
+
+		return nil, ErrNoLocation
+	}
+
+	location := p.free[0]
+	p.free = p.free[1:]
+
+	p.lock.Unlock()
+
+	_, err := p.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(location.Bucket),
+		Key:    aws.String(location.Prefix + marker),
+		Body:   strings.NewReader(id),
+	})
+	if err != nil {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		p.free = append(p.free, location)
+
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// Release empties the location and returns it to the pool.  If the location
+// cannot be emptied, it is removed from the pool.
+func (p *Provider) Release(ctx context.Context, instance any) error {
+	location, ok := instance.(*Location)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	if err := p.empty(ctx, location); err != nil {
+		return fmt.Errorf("failed to empty %s/%s, removing from the pool: %w", location.Bucket, location.Prefix, err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.free = append(p.free, *location)
+
+	return nil
+}
+
+// empty deletes every object in the location, including the marker.
+func (p *Provider) empty(ctx context.Context, location *Location) error {
+	paginator := s3.NewListObjectsV2Paginator(p.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(location.Bucket),
+		Prefix: aws.String(location.Prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		// Pages are at most 1000 objects, which is also the limit of a
+		// single delete.
+		objects := make([]types.ObjectIdentifier, len(page.Contents))
+
+		for i, object := range page.Contents {
+			objects[i] = types.ObjectIdentifier{Key: object.Key}
+		}
+
+		output, err := p.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(location.Bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(output.Errors) > 0 {
+			return fmt.Errorf("failed to delete %s: %s", aws.ToString(output.Errors[0].Key), aws.ToString(output.Errors[0].Message))
+		}
+	}
+
+	return nil
+}
+
+// Sweep empties any free location that is still tagged by an allocation, left
+// behind by a run that crashed, and returns them.  Call it before the pool is
+// used, and only when no other process is using the same locations.
+func (p *Provider) Sweep(ctx context.Context) ([]Location, error) {
+	p.lock.Lock()
+	free := append([]Location(nil), p.free...)
+	p.lock.Unlock()
+
+	var swept []Location
+
+	for i := range free {
+		location := &free[i]
+
+		output, err := p.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(location.Bucket),
+			Prefix:  aws.String(location.Prefix + marker),
+			MaxKeys: aws.Int32(1),
+		})
+		if err != nil {
+			return swept, err
+		}
+
+		if len(output.Contents) == 0 {
+			continue
+		}
+
+		if err := p.empty(ctx, location); err != nil {
+			return swept, err
+		}
+
+		swept = append(swept, *location)
+	}
+
+	return swept, nil
+}
+
+// Locations returns the locations allocated to a test for the named resource.
+func Locations(allocation *smtest.Allocation, name string) []*Location {
+	var locations []*Location
+
+	for _, instance := range allocation.Instances(name) {
+		if location, ok := instance.(*Location); ok {
+			locations = append(locations, location)
+		}
+	}
+
+	return locations
+}
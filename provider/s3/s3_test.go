@@ -0,0 +1,158 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is an in-memory object store keyed by bucket then key.
+type fakeS3 struct {
+	lock    sync.Mutex
+	buckets map[string]map[string]string
+}
+
+func (f *fakeS3) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var keys []string
+
+	for key := range f.buckets[aws.ToString(params.Bucket)] {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	output := &s3.ListObjectsV2Output{}
+
+	for _, key := range keys {
+		output.Contents = append(output.Contents, types.Object{Key: aws.String(key)})
+	}
+
+	return output, nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	bucket, ok := f.buckets[aws.ToString(params.Bucket)]
+	if !ok {
+		return nil, errors.New("no such bucket")
+	}
+
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket[aws.ToString(params.Key)] = string(data)
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(_ context.Context, params *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, object := range params.Delete.Objects {
+		delete(f.buckets[aws.ToString(params.Bucket)], aws.ToString(object.Key))
+	}
+
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	client := &fakeS3{
+		buckets: map[string]map[string]string{
+			"shared": {"other/data": "keep"},
+		},
+	}
+
+	p := New(client, Prefixes("shared", "e2e")...)
+
+	instance, err := p.Acquire(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location := instance.(*Location)
+
+	if id := client.buckets["shared"]["e2e/"+marker]; id != "abc" {
+		t.Fatalf("expected location to be tagged with the allocation, got %q", id)
+	}
+
+	if _, err := p.Acquire(ctx, "def"); !errors.Is(err, ErrNoLocation) {
+		t.Fatalf("expected no free location, got %v", err)
+	}
+
+	client.buckets["shared"][location.Prefix+"output"] = "data"
+
+	if err := p.Release(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.buckets["shared"]) != 1 || client.buckets["shared"]["other/data"] != "keep" {
+		t.Fatalf("expected only the prefix to be emptied, got %v", client.buckets["shared"])
+	}
+}
+
+func TestSweep(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// A crashed run left e2e-0 tagged with its data in place.
+	client := &fakeS3{
+		buckets: map[string]map[string]string{
+			"e2e-0": {marker: "crashed", "output": "data"},
+			"e2e-1": {},
+		},
+	}
+
+	p := New(client, Buckets("e2e-0", "e2e-1")...)
+
+	swept, err := p.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(swept) != 1 || swept[0].Bucket != "e2e-0" {
+		t.Fatalf("expected e2e-0 to be swept, got %v", swept)
+	}
+
+	if len(client.buckets["e2e-0"]) != 0 {
+		t.Fatalf("expected e2e-0 to be emptied, got %v", client.buckets["e2e-0"])
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testcontainers provides containers as schedulable resources, started
+// on demand with testcontainers-go and optionally reused between tests e.g.
+//
+//	func TestMain(m *testing.M) {
+//	   postgres := smtesttc.New(testcontainers.ContainerRequest{
+//	     Image:        "postgres:16",
+//	     ExposedPorts: []string{"5432/tcp"},
+//	   }, smtesttc.WithReset(truncateTables))
+//
+//	   smtest.Start(smtest.ResourceSet{"postgres": 4}, smtest.WithProvider("postgres", postgres))
+//
+//	   code := m.Run()
+//
+//	   smtest.Report()
+//	   postgres.Close(context.Background())
+//
+//	   os.Exit(code)
+//	}
+//
+//	func TestDatabase(t *testing.T) {
+//	   allocation := smtest.Acquire(t, smtest.ResourceSet{"postgres": 1})
+//	   defer allocation.Release()
+//
+//	   endpoint, err := smtesttc.Containers(allocation, "postgres")[0].PortEndpoint(context.Background(), "5432/tcp", "")
+//	   ...
+//	}
+package testcontainers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	smtest "github.com/spjmurray/testing"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+const (
+	// labelAllocation is the container label holding the allocation ID that
+	// created it, so leaked containers can be traced back to a test.
+	labelAllocation = "smtest.allocation"
+)
+
+// ResetFunc returns a container to a clean state so it can be reused.
+type ResetFunc func(ctx context.Context, container testcontainers.Container) error
+
+// Provider starts containers from a template when a test is allocated the
+// resource, and stops or resets them when it is released.
+type Provider struct {
+	// request is the container template.
+	request testcontainers.ContainerRequest
+
+	// reset, if set, allows containers to be reused.
+	reset ResetFunc
+
+	// create starts a new container.
+	create func(ctx context.Context, request testcontainers.ContainerRequest) (testcontainers.Container, error)
+
+	// lock protects idle.
+	lock sync.Mutex
+
+	// idle are reset containers waiting to be reused.
+	idle []testcontainers.Container
+}
+
+// Ensure the interface is implemented.
+var _ smtest.Provider = &Provider{}
+
+// Option modifies the default behaviour of the provider.
+type Option func(*Provider)
+
+// WithReset enables container reuse, rather than terminating a container on
+// release, it is reset with the function and handed to the next test.  If the
+// reset fails, the container is terminated.
+func WithReset(reset ResetFunc) Option {
+	return func(p *Provider) {
+		p.reset = reset
+	}
+}
+
+// create starts a container with testcontainers.
+func create(ctx context.Context, request testcontainers.ContainerRequest) (testcontainers.Container, error) {
+	return testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: request,
+		Started:          true,
+	})
+}
+
+// New returns a provider that starts containers from the request.
+func New(request testcontainers.ContainerRequest, opts ...Option) *Provider {
+	p := &Provider{
+		request: request,
+		create:  create,
+	}
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Acquire returns an idle container if there is one, otherwise starts a new one.
+func (p *Provider) Acquire(ctx context.Context, id string) (any, error) {
+	p.lock.Lock()
+
+	if n := len(p.idle); n > 0 {
+		container := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+
+		p.lock.Unlock()
+
+		return container, nil
+	}
+
+	p.lock.Unlock()
+
+	request := p.request
+
+	request.Labels = map[string]string{}
+
+	for k, v := range p.request.Labels {
+		request.Labels[k] = v
+	}
+
+	request.Labels[labelAllocation] = id
+
+	return p.create(ctx, request)
+}
+
+// Release resets the container for reuse if enabled, otherwise terminates it.
+func (p *Provider) Release(ctx context.Context, instance any) error {
+	container, ok := instance.(testcontainers.Container)
+	if !ok {
+		return fmt.Errorf("unexpected instance type %T", instance)
+	}
+
+	if p.reset != nil {
+		if err := p.reset(ctx, container); err == nil {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			p.idle = append(p.idle, container)
+
+			return nil
+		}
+	}
+
+	return container.Terminate(ctx)
+}
+
+// Close terminates all idle containers, this should be called once all tests
+// have completed.
+func (p *Provider) Close(ctx context.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+
+	for _, container := range p.idle {
+		if err := container.Terminate(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	p.idle = nil
+
+	return errors.Join(errs...)
+}
+
+// Containers returns the containers allocated to a test for the named resource.
+func Containers(allocation *smtest.Allocation, name string) []testcontainers.Container {
+	instances := allocation.Instances(name)
+
+	containers := make([]testcontainers.Container, 0, len(instances))
+
+	for _, instance := range instances {
+		if container, ok := instance.(testcontainers.Container); ok {
+			containers = append(containers, container)
+		}
+	}
+
+	return containers
+}
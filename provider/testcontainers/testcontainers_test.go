@@ -0,0 +1,125 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testcontainers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// fakeContainer records its labels and whether it was terminated.
+type fakeContainer struct {
+	testcontainers.Container
+
+	labels     map[string]string
+	terminated bool
+}
+
+func (c *fakeContainer) Terminate(context.Context, ...testcontainers.TerminateOption) error {
+	c.terminated = true
+
+	return nil
+}
+
+func fakeCreate(_ context.Context, request testcontainers.ContainerRequest) (testcontainers.Container, error) {
+	return &fakeContainer{labels: request.Labels}, nil
+}
+
+func TestReuse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var resets int
+
+	p := New(testcontainers.ContainerRequest{Image: "postgres"}, WithReset(func(context.Context, testcontainers.Container) error {
+		resets++
+
+		if resets > 1 {
+			return errors.New("reset failed")
+		}
+
+		return nil
+	}))
+
+	p.create = fakeCreate
+
+	first, err := p.Acquire(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if label := first.(*fakeContainer).labels[labelAllocation]; label != "first" {
+		t.Fatalf("expected allocation label, got %q", label)
+	}
+
+	if err := p.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := p.Acquire(ctx, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second != first {
+		t.Fatal("expected container to be reused")
+	}
+
+	// The second reset fails, so the container is thrown away.
+	if err := p.Release(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !second.(*fakeContainer).terminated {
+		t.Fatal("expected container to be terminated")
+	}
+
+	third, err := p.Acquire(ctx, "third")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third == first {
+		t.Fatal("expected a new container")
+	}
+}
+
+func TestNoReuse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	p := New(testcontainers.ContainerRequest{Image: "postgres"})
+	p.create = fakeCreate
+
+	instance, err := p.Acquire(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Release(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	if !instance.(*fakeContainer).terminated {
+		t.Fatal("expected container to be terminated")
+	}
+}
@@ -0,0 +1,436 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// queueEntry is a test's place in an itemHeap.
+type queueEntry struct {
+	// item is the queued test.
+	item *queueItem
+
+	// seq is the item's placement this entry was made for, if the test has
+	// since moved, or been removed, the entry is stale.
+	seq uint64
+}
+
+// itemHeap orders tests by policy.  Tests that move to another heap, or leave
+// the queue, are left in place, and skipped, until enough accumulate to compact
+// it.
+type itemHeap struct {
+	// entries is the heap.
+	entries []queueEntry
+
+	// stale is the number of stale entries.
+	stale int
+}
+
+// Len implements heap.Interface.
+func (h *itemHeap) Len() int {
+	return len(h.entries)
+}
+
+// Less implements heap.Interface.
+func (h *itemHeap) Less(i, j int) bool {
+	return before(h.entries[i].item, h.entries[j].item)
+}
+
+// Swap implements heap.Interface.
+func (h *itemHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+}
+
+// Push implements heap.Interface.
+func (h *itemHeap) Push(x any) {
+	h.entries = append(h.entries, x.(queueEntry))
+}
+
+// Pop implements heap.Interface.
+func (h *itemHeap) Pop() any {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+
+	h.entries[n] = queueEntry{}
+	h.entries = h.entries[:n]
+
+	return e
+}
+
+// live returns whether the entry is the test's current placement.
+func (h *itemHeap) live(e queueEntry) bool {
+	return !e.item.removed && e.item.heap == h && e.item.seq == e.seq
+}
+
+// push places the test in the heap.
+func (h *itemHeap) push(item *queueItem) {
+	item.seq++
+	item.heap = h
+
+	heap.Push(h, queueEntry{item: item, seq: item.seq})
+}
+
+// peek returns the first test in the heap, or nil if it's empty.
+func (h *itemHeap) peek() *queueItem {
+	for len(h.entries) > 0 {
+		if e := h.entries[0]; h.live(e) {
+			return e.item
+		}
+
+		heap.Pop(h)
+		h.stale--
+	}
+
+	return nil
+}
+
+// pop removes and returns the first test in the heap.
+func (h *itemHeap) pop() *queueItem {
+	item := h.peek()
+	if item == nil {
+		return nil
+	}
+
+	heap.Pop(h)
+
+	item.heap = nil
+
+	return item
+}
+
+// leave records that the test has moved elsewhere, or left the queue.
+func (h *itemHeap) leave(item *queueItem) {
+	item.heap = nil
+
+	if h.stale++; h.stale > len(h.entries)/2 {
+		h.compact()
+	}
+}
+
+// compact drops stale entries.
+func (h *itemHeap) compact() {
+	entries := make([]queueEntry, 0, len(h.entries)-h.stale)
+
+	for _, e := range h.entries {
+		if h.live(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	h.entries = entries
+	h.stale = 0
+
+	heap.Init(h)
+}
+
+// waitQueue holds the tests waiting for resources.  Tests that have been
+// considered are indexed by the resource they didn't fit on, and how much of it
+// they need, so a scheduling pass only considers tests that can fit what's
+// free, in policy order, rather than rescanning the whole queue after every
+// event.
+type waitQueue struct {
+	// items are the queued tests keyed by allocation ID.
+	items map[string]*queueItem
+
+	// order is the queued tests in policy order.  Removed tests are left in
+	// place, and skipped, until enough accumulate to compact it.
+	order []*queueItem
+
+	// start is the index of the first test in order that may not have been
+	// removed.
+	start int
+
+	// removed is the number of removed tests left in order.
+	removed int
+
+	// fresh are the tests that have not been considered yet.
+	fresh *itemHeap
+
+	// retry are the tests the backend refused, which may change at any
+	// time, so they are always reconsidered.
+	retry *itemHeap
+
+	// blocked are the tests that didn't fit, keyed by the resource they
+	// didn't fit on, then by how much of it they need.
+	blocked map[string]map[int]*itemHeap
+
+	// head is the test last considered at the head of the queue under the
+	// FIFO policy.
+	head *queueItem
+
+	// chaos, if set, perturbs the order tests are granted in, and delays
+	// the grants.
+	chaos *chaos
+
+	// replay, if set, is a recorded grant order to reproduce.
+	replay *replay
+}
+
+// newWaitQueue returns an empty queue.
+func newWaitQueue() *waitQueue {
+	return &waitQueue{
+		items:   map[string]*queueItem{},
+		fresh:   &itemHeap{},
+		retry:   &itemHeap{},
+		blocked: map[string]map[int]*itemHeap{},
+	}
+}
+
+// before returns whether a is considered before b, that is in the order tests
+// arrived, unless chaos mode has ranked them randomly.
+func before(a, b *queueItem) bool {
+	if a.rank != b.rank {
+		return a.rank < b.rank
+	}
+
+	if !a.enqueued.Equal(b.enqueued) {
+		return a.enqueued.Before(b.enqueued)
+	}
+
+	return a.record.id < b.record.id
+}
+
+// len returns the number of queued tests.
+func (q *waitQueue) len() int {
+	return len(q.items)
+}
+
+// add queues a test.  Tests almost always arrive in order, so are appended,
+// except in chaos mode.
+func (q *waitQueue) add(item *queueItem) {
+	if q.chaos != nil {
+		item.rank = q.chaos.rank()
+	}
+
+	q.items[item.record.id] = item
+	q.fresh.push(item)
+
+	n := len(q.order)
+
+	if n == 0 || !before(item, q.order[n-1]) {
+		q.order = append(q.order, item)
+
+		return
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		return before(item, q.order[i])
+	})
+
+	q.order = append(q.order, nil)
+	copy(q.order[i+1:], q.order[i:])
+	q.order[i] = item
+
+	if i < q.start {
+		q.start = i
+	}
+}
+
+// remove dequeues a test, returning it, or nil if it's not queued.
+func (q *waitQueue) remove(id string) *queueItem {
+	item, ok := q.items[id]
+	if !ok {
+		return nil
+	}
+
+	delete(q.items, id)
+
+	item.removed = true
+
+	if item.heap != nil {
+		item.heap.leave(item)
+	}
+
+	if q.removed++; q.removed > len(q.order)/2 {
+		q.compact()
+	}
+
+	return item
+}
+
+// compact drops removed tests from the order.
+func (q *waitQueue) compact() {
+	order := make([]*queueItem, 0, len(q.items))
+
+	for _, item := range q.order {
+		if !item.removed {
+			order = append(order, item)
+		}
+	}
+
+	q.order = order
+	q.start = 0
+	q.removed = 0
+}
+
+// first returns the test at the head of the queue, or nil if it's empty.
+func (q *waitQueue) first() *queueItem {
+	for ; q.start < len(q.order); q.start++ {
+		if item := q.order[q.start]; !item.removed {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// ordered returns the queued tests in policy order.
+func (q *waitQueue) ordered() []*queueItem {
+	items := make([]*queueItem, 0, len(q.items))
+
+	for _, item := range q.order[q.start:] {
+		if !item.removed {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// block records that a test didn't fit on the resource, or was refused by the
+// backend if empty.
+func (q *waitQueue) block(item *queueItem, resource string) {
+	if item.heap != nil {
+		item.heap.leave(item)
+	}
+
+	item.considered = true
+	item.blockedOn = resource
+
+	if resource == "" {
+		q.retry.push(item)
+
+		return
+	}
+
+	amounts, ok := q.blocked[resource]
+	if !ok {
+		amounts = map[int]*itemHeap{}
+		q.blocked[resource] = amounts
+	}
+
+	h, ok := amounts[item.required[resource]]
+	if !ok {
+		h = &itemHeap{}
+		amounts[item.required[resource]] = h
+	}
+
+	h.push(item)
+}
+
+// eligible returns the heaps of tests that may fit what's free, that is those
+// that haven't been considered, and those blocked on less than is free.  Empty
+// heaps are discarded along the way.
+func (q *waitQueue) eligible(free ResourceSet) []*itemHeap {
+	heaps := []*itemHeap{q.fresh}
+
+	for resource, amounts := range q.blocked {
+		for amount, h := range amounts {
+			if h.peek() == nil {
+				delete(amounts, amount)
+
+				continue
+			}
+
+			if amount <= free[resource] {
+				heaps = append(heaps, h)
+			}
+		}
+
+		if len(amounts) == 0 {
+			delete(q.blocked, resource)
+		}
+	}
+
+	return heaps
+}
+
+// next removes and returns the first test in policy order from the heaps, or
+// nil if they are all empty.
+func next(heaps []*itemHeap) *queueItem {
+	var first *itemHeap
+
+	for _, h := range heaps {
+		item := h.peek()
+		if item == nil {
+			continue
+		}
+
+		if first == nil || before(item, first.peek()) {
+			first = h
+		}
+	}
+
+	if first == nil {
+		return nil
+	}
+
+	return first.pop()
+}
+
+// headMayFit returns whether the head of the queue may fit, that is it's new,
+// was refused by the backend, or what it didn't fit on is now free.
+func (q *waitQueue) headMayFit(item *queueItem, free ResourceSet) bool {
+	return item != q.head || !item.considered || item.blockedOn == "" || item.required[item.blockedOn] <= free[item.blockedOn]
+}
+
+// pending returns whether a scheduling pass may grant anything given what's
+// free.  Most events, for example periodic checks, change nothing, so the pass
+// can be skipped entirely.
+func (q *waitQueue) pending(free ResourceSet, policy Policy) bool {
+	// A replay may be waiting to skip a test that never arrives.
+	if q.replay != nil && !q.replay.done() && q.len() > 0 {
+		return true
+	}
+
+	if policy == PolicyFIFO {
+		item := q.first()
+
+		return item != nil && q.headMayFit(item, free)
+	}
+
+	if q.retry.peek() != nil {
+		return true
+	}
+
+	for _, h := range q.eligible(free) {
+		if h.peek() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inlinable returns whether tests may be granted without being queued, that is
+// nothing is queued, and the order of grants isn't being perturbed or replayed.
+func (q *waitQueue) inlinable() bool {
+	return q.len() == 0 && q.chaos == nil && (q.replay == nil || q.replay.done())
+}
+
+// blocking returns a resource the test doesn't fit on, if any.
+func blocking(free, required ResourceSet) (string, bool) {
+	for k, v := range required {
+		if free[k] < v {
+			return k, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,380 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newQueueItem returns a test enqueued at the given second.
+func newQueueItem(id string, enqueued int64, required ResourceSet) *queueItem {
+	return &queueItem{
+		wait:     make(chan interface{}),
+		required: required,
+		enqueued: time.Unix(enqueued, 0),
+		record:   &record{id: id, required: required, charged: required},
+	}
+}
+
+// ids returns the allocation IDs of the items.
+func ids(items []*queueItem) string {
+	result := ""
+
+	for _, item := range items {
+		result += item.record.id
+	}
+
+	return result
+}
+
+func TestWaitQueueOrder(t *testing.T) {
+	t.Parallel()
+
+	q := newWaitQueue()
+
+	// Tests that arrive out of order are still considered in order.
+	q.add(newQueueItem("b", 2, nil))
+	q.add(newQueueItem("d", 4, nil))
+	q.add(newQueueItem("a", 1, nil))
+	q.add(newQueueItem("c", 2, nil))
+
+	if order := ids(q.ordered()); order != "abcd" {
+		t.Fatalf("unexpected order %s", order)
+	}
+
+	if item := q.remove("a"); item == nil || !item.removed {
+		t.Fatal("expected item to be removed")
+	}
+
+	if q.remove("a") != nil {
+		t.Fatal("expected item to only be removed once")
+	}
+
+	if first := q.first(); first.record.id != "b" {
+		t.Fatalf("unexpected head %s", first.record.id)
+	}
+
+	// Removing most of the queue compacts it.
+	q.remove("c")
+	q.remove("b")
+
+	if len(q.order) != 1 || q.len() != 1 || ids(q.ordered()) != "d" {
+		t.Fatalf("expected queue to be compacted, got %d entries", len(q.order))
+	}
+}
+
+func TestWaitQueueEligible(t *testing.T) {
+	t.Parallel()
+
+	q := newWaitQueue()
+
+	blocked := []struct {
+		resource string
+		required ResourceSet
+	}{
+		{"cpu", ResourceSet{"cpu": 2}},
+		{"memory", ResourceSet{"memory": 4, "gpu": 1}},
+		{"", nil},
+		{"cpu", ResourceSet{"cpu": 4}},
+	}
+
+	for i, b := range blocked {
+		item := newQueueItem(fmt.Sprint(i), int64(i), b.required)
+
+		q.add(item)
+		q.block(item, b.resource)
+	}
+
+	q.add(newQueueItem("4", 4, nil))
+
+	// New tests and those blocked on no more than is free are eligible, in
+	// order.
+	drain := func(free ResourceSet) string {
+		var items []*queueItem
+
+		for item := next(q.eligible(free)); item != nil; item = next(q.eligible(free)) {
+			items = append(items, item)
+		}
+
+		return ids(items)
+	}
+
+	if eligible := drain(ResourceSet{"cpu": 3, "memory": 3}); eligible != "04" {
+		t.Fatalf("unexpected eligible tests %s", eligible)
+	}
+
+	if eligible := drain(ResourceSet{"cpu": 3, "memory": 3}); eligible != "" {
+		t.Fatalf("expected eligible tests to be taken, got %s", eligible)
+	}
+
+	// Blocking again moves the test between resources, and removed tests
+	// are skipped.
+	q.block(q.items["1"], "gpu")
+	q.remove("3")
+
+	if eligible := drain(ResourceSet{"cpu": 8, "memory": 8}); eligible != "" {
+		t.Fatalf("unexpected eligible tests %s", eligible)
+	}
+
+	if eligible := drain(ResourceSet{"gpu": 1}); eligible != "1" {
+		t.Fatalf("unexpected eligible tests %s", eligible)
+	}
+
+	if item := q.retry.pop(); item == nil || item.record.id != "2" {
+		t.Fatal("expected tests the backend refused to be retried")
+	}
+}
+
+func TestItemHeapCompact(t *testing.T) {
+	t.Parallel()
+
+	h := &itemHeap{}
+	q := newWaitQueue()
+
+	for i := 0; i < 8; i++ {
+		item := newQueueItem(fmt.Sprint(i), int64(i), nil)
+
+		q.add(item)
+		h.push(item)
+	}
+
+	// Tests moving elsewhere leave stale entries, that are dropped before
+	// they outnumber the live ones.
+	for i := 0; i < 5; i++ {
+		q.remove(fmt.Sprint(i))
+	}
+
+	if len(h.entries) > 4 || h.peek().record.id != "5" {
+		t.Fatalf("expected heap to be compacted, got %d entries", len(h.entries))
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	t.Parallel()
+
+	free := ResourceSet{"cpu": 4, "memory": 8}
+
+	if resource, blocked := blocking(free, ResourceSet{"cpu": 4, "memory": 16}); !blocked || resource != "memory" {
+		t.Fatalf("expected to be blocked on memory, got %q", resource)
+	}
+
+	if _, blocked := blocking(free, ResourceSet{"cpu": 4, "memory": 8}); blocked {
+		t.Fatal("expected to fit")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+
+	enqueue := make(chan *queueItem, maxBatch+1)
+	release := make(chan *record, 2)
+
+	q := newWaitQueue()
+	b := NewMemoryBackend(ResourceSet{"cpu": 8, "memory": 8})
+
+	for _, id := range []string{"a", "b"} {
+		if ok, _ := b.Acquire(context.Background(), id, ResourceSet{"cpu": 2}); !ok {
+			t.Fatal("expected acquire to succeed")
+		}
+
+		release <- &record{id: id, required: ResourceSet{"cpu": 2}}
+	}
+
+	for i := 0; i <= maxBatch; i++ {
+		item := newQueueItem(fmt.Sprint(i), int64(i), nil)
+
+		enqueue <- item
+	}
+
+	// A burst is processed in one go, but bounded so grants aren't delayed
+	// indefinitely.
+	released := coalesce(enqueue, release, q, b)
+
+	if released == nil || len(release) != 0 || b.Free()["cpu"] != 8 {
+		t.Fatalf("expected releases to be processed, free %v", b.Free())
+	}
+
+	if q.len() != maxBatch-2 || len(enqueue) != 3 {
+		t.Fatalf("expected a bounded batch, got %d queued", q.len())
+	}
+
+	if coalesce(enqueue, release, q, b) != nil || q.len() != maxBatch+1 {
+		t.Fatalf("expected the rest to be processed, got %d queued", q.len())
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	enqueue := make(chan *queueItem, maxBatch)
+	release := make(chan *record, maxBatch)
+
+	q := newWaitQueue()
+	b := NewMemoryBackend(ResourceSet{"cpu": 8})
+
+	if ok, _ := b.Acquire(context.Background(), "a", ResourceSet{"cpu": 2}); !ok {
+		t.Fatal("expected acquire to succeed")
+	}
+
+	// Requests are accepted without the scheduler having to be listening.
+	release <- &record{id: "a", required: ResourceSet{"cpu": 2}}
+
+	for i := 0; i < maxBatch; i++ {
+		item := newQueueItem(fmt.Sprint(i), int64(i), nil)
+
+		enqueue <- item
+	}
+
+	// Everything already buffered is processed, however much there is, so
+	// a withdrawal always sees the test it's withdrawing.
+	if released := drain(enqueue, release, q, b); released == nil || released.id != "a" {
+		t.Fatal("expected the release to be processed")
+	}
+
+	if q.len() != maxBatch || b.Free()["cpu"] != 8 {
+		t.Fatalf("expected everything to be processed, got %d queued", q.len())
+	}
+
+	if drain(enqueue, release, q, b) != nil {
+		t.Fatal("expected nothing left to process")
+	}
+}
+
+func TestWaitQueuePending(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []Policy{PolicyFirstFit, PolicyFIFO} {
+		q := newWaitQueue()
+
+		if q.pending(ResourceSet{"cpu": 8}, policy) {
+			t.Fatalf("%v: expected nothing to do with an empty queue", policy)
+		}
+
+		item := newQueueItem("a", 1, ResourceSet{"cpu": 4})
+
+		q.add(item)
+
+		if !q.pending(nil, policy) {
+			t.Fatalf("%v: expected a new test to be considered", policy)
+		}
+
+		q.head = item
+		q.block(item, "cpu")
+
+		if q.pending(ResourceSet{"cpu": 3, "memory": 8}, policy) {
+			t.Fatalf("%v: expected nothing to do until enough cpu is free", policy)
+		}
+
+		if !q.pending(ResourceSet{"cpu": 4}, policy) {
+			t.Fatalf("%v: expected the test to be reconsidered once enough cpu is free", policy)
+		}
+
+		q.block(item, "")
+
+		if !q.pending(nil, policy) {
+			t.Fatalf("%v: expected tests the backend refused to always be reconsidered", policy)
+		}
+	}
+}
+
+const (
+	// scaleAllocations is the number of queued allocations the scheduler
+	// core is designed to handle.
+	scaleAllocations = 10000
+
+	// scaleOverhead is the most the scheduler core may spend enqueueing,
+	// granting and releasing each allocation at that scale.
+	scaleOverhead = time.Millisecond
+)
+
+// schedule10k enqueues scaleAllocations tests of varying size against a small
+// pool, then releases them, one at a time, until every test is granted, as
+// the scheduler would.
+func schedule10k(tb testing.TB, policy Policy) {
+	tb.Helper()
+
+	pool := ResourceSet{"cpu": 64, "memory": 256}
+
+	q := newWaitQueue()
+	b := NewMemoryBackend(pool)
+
+	for i := 0; i < scaleAllocations; i++ {
+		required := ResourceSet{"cpu": 1 + i%8, "memory": 1 + i%32}
+
+		q.add(newQueueItem(fmt.Sprint(i), int64(i), required))
+	}
+
+	for q.len() > 0 || len(b.leases) > 0 {
+		if q.pending(b.Free(), policy) {
+			grant(q, b, nil, policy)
+		}
+
+		if len(b.leases) == 0 {
+			tb.Fatal("scheduler made no progress")
+		}
+
+		for id := range b.leases {
+			_ = b.Release(context.Background(), id)
+
+			break
+		}
+	}
+
+	// Memory is bounded by what's queued, not what has passed through.
+	entries := len(q.order) + len(q.fresh.entries) + len(q.retry.entries)
+
+	for _, amounts := range q.blocked {
+		for _, h := range amounts {
+			entries += len(h.entries)
+		}
+	}
+
+	if entries != 0 {
+		tb.Fatalf("expected an empty queue to hold nothing, got %d entries", entries)
+	}
+}
+
+func TestScale(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []Policy{PolicyFirstFit, PolicyFIFO} {
+		start := time.Now()
+
+		schedule10k(t, policy)
+
+		if perAllocation := time.Since(start) / scaleAllocations; perAllocation > scaleOverhead {
+			t.Fatalf("%v: expected at most %v per allocation, took %v", policy, scaleOverhead, perAllocation)
+		}
+	}
+}
+
+func BenchmarkSchedule10k(b *testing.B) {
+	for _, policy := range []Policy{PolicyFirstFit, PolicyFIFO} {
+		b.Run(policy.String(), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				schedule10k(b, policy)
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*scaleAllocations), "ns/allocation")
+		})
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// recordEnvironmentVariable names a file every grant is written to, in
+	// the order they were made, so the schedule can be replayed.
+	recordEnvironmentVariable = "SMTEST_RECORD"
+
+	// replayEnvironmentVariable names a file written via SMTEST_RECORD, whose
+	// grant order is reproduced.
+	replayEnvironmentVariable = "SMTEST_REPLAY"
+
+	// replaySkipAfter is how long a replay waits for the next recorded test
+	// to be queued before skipping it, as it may not run at all, e.g. when
+	// filtered with -run.
+	replaySkipAfter = time.Second
+)
+
+// decision is a grant, as written to the file named by SMTEST_RECORD.
+type decision struct {
+	// Test is the test name.
+	Test string `json:"test"`
+
+	// Resources are what the test was granted.
+	Resources ResourceSet `json:"resources"`
+
+	// Offset is how long after Start the grant was made, in seconds.
+	Offset float64 `json:"offset"`
+
+	// Waited is how long the test was queued for, in seconds.
+	Waited float64 `json:"waited"`
+}
+
+var (
+	// recorderLock serializes writes to the recorder, tests may be granted
+	// inline concurrently with the scheduler.
+	recorderLock sync.Mutex
+
+	// recordingFile is the file the recorder writes to.
+	recordingFile *os.File
+
+	// recorder, if set, has every grant written to it.
+	recorder *json.Encoder
+)
+
+// startRecording opens the recording file, if one is configured.
+func startRecording() {
+	recorderLock.Lock()
+	defer recorderLock.Unlock()
+
+	recorder = nil
+
+	path := os.Getenv(recordEnvironmentVariable)
+	if path == "" {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to create recording file: %v\n", err)
+		return
+	}
+
+	recordingFile = f
+	recorder = json.NewEncoder(f)
+}
+
+// stopRecording flushes the recording to disk and closes it.
+func stopRecording() {
+	recorderLock.Lock()
+	defer recorderLock.Unlock()
+
+	if recordingFile != nil {
+		if err := recordingFile.Sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "smtest: failed to sync recording file: %v\n", err)
+		}
+
+		if err := recordingFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "smtest: failed to close recording file: %v\n", err)
+		}
+	}
+
+	recordingFile = nil
+	recorder = nil
+}
+
+// recordGrant writes the grant, if recording is enabled.
+func recordGrant(r *record) {
+	recorderLock.Lock()
+	defer recorderLock.Unlock()
+
+	if recorder == nil {
+		return
+	}
+
+	now := time.Now()
+
+	d := &decision{
+		Test:      r.name,
+		Resources: r.charged,
+		Offset:    now.Sub(started).Seconds(),
+		Waited:    now.Sub(r.enqueued).Seconds(),
+	}
+
+	if err := recorder.Encode(d); err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to record grant: %v\n", err)
+	}
+}
+
+// readDecisions reads the test names from a recording in the order they were
+// granted.
+func readDecisions(r io.Reader) ([]string, error) {
+	var order []string
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		var d decision
+
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			return nil, err
+		}
+
+		order = append(order, d.Test)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// loadReplay reads the recording to replay, if one is configured.
+func loadReplay() (*replay, error) {
+	path := os.Getenv(replayEnvironmentVariable)
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", replayEnvironmentVariable, err)
+	}
+
+	defer f.Close()
+
+	order, err := readDecisions(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", replayEnvironmentVariable, err)
+	}
+
+	return &replay{
+		order: order,
+	}, nil
+}
+
+// replay reproduces the grant order of a recorded run, so a failure that only
+// happens under a particular interleaving can be debugged.  It is owned by the
+// scheduler goroutine.
+type replay struct {
+	// order is the names of tests in the order they were granted.
+	order []string
+
+	// next is the index of the next test to grant.
+	next int
+
+	// since is when the next test became next.
+	since time.Time
+}
+
+// done returns whether every recorded grant has been replayed, after which
+// tests are granted as usual.
+func (r *replay) done() bool {
+	return r.next >= len(r.order)
+}
+
+// expected returns the name of the next test to grant.
+func (r *replay) expected() string {
+	if r.since.IsZero() {
+		r.since = time.Now()
+	}
+
+	return r.order[r.next]
+}
+
+// advance moves on to the next recorded grant.
+func (r *replay) advance() {
+	r.next++
+	r.since = time.Now()
+}
+
+// named returns the first queued test with the name, or nil if none is.
+func (q *waitQueue) named(name string) *queueItem {
+	for _, item := range q.order[q.start:] {
+		if !item.removed && item.record.name == name {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// grantReplay grants queued tests strictly in the recorded order, returning
+// whether the recording has been replayed in full.  A recorded test that isn't
+// queued in time is skipped, as it may not run at all.
+func grantReplay(q *waitQueue, admit func(*queueItem) bool) bool {
+	r := q.replay
+
+	for !r.done() {
+		name := r.expected()
+
+		item := q.named(name)
+		if item == nil {
+			if time.Since(r.since) < replaySkipAfter {
+				return false
+			}
+
+			emit(nil, event{
+				Action:  "warn",
+				Message: fmt.Sprintf("replay skipping %s, it wasn't queued within %v", name, replaySkipAfter),
+			})
+
+			r.advance()
+
+			continue
+		}
+
+		if !admit(item) {
+			return false
+		}
+
+		r.advance()
+	}
+
+	return true
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadDecisions(t *testing.T) {
+	t.Parallel()
+
+	recording := `{"test":"TestB","resources":{"cpu":1},"offset":0.1,"waited":0}
+{"test":"TestA","resources":{"cpu":2},"offset":0.2,"waited":0.1}
+`
+
+	order, err := readDecisions(strings.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(order, ",") != "TestB,TestA" {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	if _, err := readDecisions(strings.NewReader("garbage\n")); err == nil {
+		t.Fatal("expected a corrupt recording to be rejected")
+	}
+}
+
+func TestGrantReplay(t *testing.T) {
+	t.Parallel()
+
+	q := newWaitQueue()
+	q.replay = &replay{
+		order: []string{"c", "missing", "a", "b"},
+	}
+
+	b := NewMemoryBackend(ResourceSet{"cpu": 8})
+
+	for i, name := range []string{"a", "b", "c"} {
+		item := newQueueItem(name, int64(i), ResourceSet{"cpu": 1})
+		item.record.name = name
+
+		q.add(item)
+	}
+
+	// Tests are granted in the recorded order, not the order they arrived,
+	// until a recorded test hasn't arrived.
+	grant(q, b, nil, PolicyFirstFit)
+
+	if order := ids(q.ordered()); order != "ab" {
+		t.Fatalf("expected only c to be granted, got %s queued", order)
+	}
+
+	if !q.pending(b.Free(), PolicyFirstFit) {
+		t.Fatal("expected the replay to be pending while waiting for a test")
+	}
+
+	// Tests that never arrive are eventually skipped.
+	q.replay.since = time.Now().Add(-replaySkipAfter)
+
+	grant(q, b, nil, PolicyFirstFit)
+
+	if q.len() != 0 || !q.replay.done() || !q.inlinable() {
+		t.Fatalf("expected the replay to complete, got %s queued", ids(q.ordered()))
+	}
+}
+
+// TestRecordGrant is not parallel as it replaces the recorder.
+func TestRecordGrant(t *testing.T) {
+	var buffer bytes.Buffer
+
+	recorderLock.Lock()
+	recorder = json.NewEncoder(&buffer)
+	recorderLock.Unlock()
+
+	t.Cleanup(func() {
+		recorderLock.Lock()
+		recorder = nil
+		recorderLock.Unlock()
+	})
+
+	recordGrant(&record{
+		name:     "TestRecorded",
+		required: ResourceSet{"cpu": 2},
+		enqueued: time.Now(),
+	})
+
+	order, err := readDecisions(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(order) != 1 || order[0] != "TestRecorded" {
+		t.Fatalf("unexpected recording %v", order)
+	}
+}
+
+// TestStopRecording is not parallel as it modifies the environment and replaces
+// the recorder.
+func TestStopRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.json")
+
+	t.Setenv(recordEnvironmentVariable, path)
+
+	startRecording()
+
+	recorderLock.Lock()
+	f := recordingFile
+	recorderLock.Unlock()
+
+	recordGrant(&record{
+		name:     "TestRecorded",
+		required: ResourceSet{"cpu": 2},
+		enqueued: time.Now(),
+	})
+
+	stopRecording()
+
+	if _, err := f.Write([]byte("\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the recording file to be closed, got %v", err)
+	}
+
+	recorderLock.Lock()
+	cleared := recorder == nil && recordingFile == nil
+	recorderLock.Unlock()
+
+	if !cleared {
+		t.Fatal("expected the recorder to be cleared")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := readDecisions(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Contains(order, "TestRecorded") {
+		t.Fatalf("unexpected recording %v", order)
+	}
+}
@@ -0,0 +1,531 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sampleInterval is how often process usage is sampled.
+	sampleInterval = 500 * time.Millisecond
+)
+
+// record tracks the lifecycle of a single allocation, and what was
+// observed while it was held.
+type record struct {
+	// id uniquely identifies the allocation.
+	id string
+
+	// name is the test name.
+	name string
+
+	// required is the set of resources that the test asked for.
+	required ResourceSet
+
+	// charged is what the allocation takes from the pool, and the backend,
+	// that is required adjusted by the scale set with WithScale.
+	charged ResourceSet
+
+	// enqueued is when the test joined the queue.
+	enqueued time.Time
+
+	// scheduled is when the test was granted its resources.
+	scheduled time.Time
+
+	// released is when the test gave its resources back.
+	released time.Time
+
+	// sampled is true if process usage was sampled at least once while
+	// the allocation was held.
+	sampled bool
+
+	// peakCPU is the highest number of CPU cores the process was observed
+	// to use while the allocation was held.
+	peakCPU float64
+
+	// peakMemory is the highest resident set size, in bytes, the process
+	// was observed to use while the allocation was held.
+	peakMemory int64
+
+	// unblockedBy is the allocation whose release allowed this one to be
+	// scheduled, if it had to wait.
+	unblockedBy *record
+
+	// paused is set while the test holds its resources, but is waiting to
+	// run in parallel.
+	paused bool
+}
+
+// export returns the public version of the record.
+func (r *record) export() *Record {
+	return &Record{
+		ID:        r.id,
+		Package:   testPackage(),
+		Test:      r.name,
+		Resources: r.required,
+		Enqueued:  r.enqueued,
+		Scheduled: r.scheduled,
+		Released:  r.released,
+	}
+}
+
+// summary is what is kept of a test's released allocations, so memory grows
+// with the number of tests rather than the number of allocations.
+type summary struct {
+	// required is the most of each resource any allocation asked for.
+	required ResourceSet
+
+	// usage is the resource hours held, the amount of each resource
+	// multiplied by how long it was held for.
+	usage map[string]float64
+
+	// count is the number of allocations released.
+	count int
+
+	// wait is the total time allocations were queued for.
+	wait time.Duration
+
+	// hold is the total time allocations were held for.
+	hold time.Duration
+
+	// sampled is true if any allocation had process usage sampled.
+	sampled bool
+
+	// peakCPU is the most CPU cores observed while any allocation was held.
+	peakCPU float64
+
+	// peakMemory is the largest resident set size, in bytes, observed while
+	// any allocation was held.
+	peakMemory int64
+}
+
+// fold adds a released allocation to its test's summary.
+func fold(summaries map[string]*summary, r *record) {
+	s, ok := summaries[r.name]
+	if !ok {
+		s = &summary{
+			required: ResourceSet{},
+			usage:    map[string]float64{},
+		}
+
+		summaries[r.name] = s
+	}
+
+	hold := r.released.Sub(r.scheduled)
+
+	for k, v := range r.required {
+		if v > s.required[k] {
+			s.required[k] = v
+		}
+
+		s.usage[k] += float64(v) * hold.Hours()
+	}
+
+	s.count++
+	s.wait += r.scheduled.Sub(r.enqueued)
+	s.hold += hold
+
+	if r.sampled {
+		s.sampled = true
+		s.peakCPU = math.Max(s.peakCPU, r.peakCPU)
+
+		if r.peakMemory > s.peakMemory {
+			s.peakMemory = r.peakMemory
+		}
+	}
+}
+
+var (
+	// recordsLock protects the allocation records and what is summarized
+	// of them, these are accessed by tests and the usage sampler concurrently.
+	recordsLock sync.Mutex
+
+	// records is every allocation that hasn't been released.  Suites run
+	// for hours, so released allocations are folded into summaries.
+	records = map[*record]interface{}{}
+
+	// held is the set of allocations currently granted to tests.
+	held = map[*record]interface{}{}
+
+	// summaries is what is known of each test's released allocations.
+	summaries = map[string]*summary{}
+
+	// waits is how long every granted allocation was queued for.
+	waits = newHistogram()
+
+	// lastReleased is the allocation released most recently, the end of
+	// the critical path.  It, and the allocations that unblocked it, are
+	// kept in full.
+	lastReleased *record
+
+	// firstEnqueued is when the first allocation joined the queue.
+	firstEnqueued time.Time
+
+	// allocationCount is the number of allocations made.
+	allocationCount int
+)
+
+// resetRecords forgets all allocations.
+func resetRecords() {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	records = map[*record]interface{}{}
+	held = map[*record]interface{}{}
+	summaries = map[string]*summary{}
+	waits = newHistogram()
+	lastReleased = nil
+	firstEnqueued = time.Time{}
+	allocationCount = 0
+	skipped = nil
+
+	resetInterned()
+}
+
+// addRecord registers a new allocation.
+func addRecord(r *record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	records[r] = nil
+
+	if firstEnqueued.IsZero() || r.enqueued.Before(firstEnqueued) {
+		firstEnqueued = r.enqueued
+	}
+
+	allocationCount++
+}
+
+// forgetRecord drops an allocation that was abandoned before it was ever
+// handed to the test.
+func forgetRecord(r *record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	delete(records, r)
+	delete(held, r)
+}
+
+// scheduleRecord marks an allocation as granted.
+func scheduleRecord(r *record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	r.scheduled = time.Now()
+	held[r] = nil
+
+	waits.add(r.scheduled.Sub(r.enqueued))
+}
+
+// pauseRecord marks whether a granted allocation's test is waiting to run in
+// parallel.
+func pauseRecord(r *record, paused bool) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	r.paused = paused
+}
+
+// releaseRecord marks an allocation as returned.
+func releaseRecord(r *record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	r.released = time.Now()
+	delete(held, r)
+	delete(records, r)
+
+	fold(summaries, r)
+
+	lastReleased = r
+}
+
+// unblock remembers that an allocation was only scheduled once another
+// was released.
+func unblock(r, by *record) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	r.unblockedBy = by
+}
+
+// sample periodically measures process usage and attributes it to every
+// allocation that is currently held.  As tests run concurrently in the same
+// process, this is an upper bound on what any individual test used.
+func sample(stop <-chan struct{}) {
+	lastTime := time.Now()
+	lastCPU, _ := processUsage()
+
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+
+		select {
+		case <-stop:
+			return
+		case now = <-ticker.C:
+		}
+
+		cpu, memory := processUsage()
+
+		cores := (cpu - lastCPU).Seconds() / now.Sub(lastTime).Seconds()
+
+		lastTime = now
+		lastCPU = cpu
+
+		recordsLock.Lock()
+
+		for r := range held {
+			r.sampled = true
+			r.peakCPU = math.Max(r.peakCPU, cores)
+
+			if memory > r.peakMemory {
+				r.peakMemory = memory
+			}
+		}
+
+		recordsLock.Unlock()
+	}
+}
+
+// recommendations compares what tests asked for against what was observed
+// and returns a human readable suggestion for every test that over-provisions.
+func recommendations(summaries map[string]*summary, o *options) []string {
+	names := make([]string, 0, len(summaries))
+
+	for name := range summaries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var result []string
+
+	for _, name := range names {
+		s := summaries[name]
+
+		if !s.sampled {
+			continue
+		}
+
+		if o.cpuResource != "" {
+			// Round up, a test using 2.1 cores needs 3 to be on the safe side.
+			observed := int(math.Ceil(s.peakCPU))
+
+			if requested := s.required[o.cpuResource]; observed < requested {
+				result = append(result, fmt.Sprintf("%s requests %d %s but never exceeded %d (held %.2fs)", name, requested, o.cpuResource, observed, s.hold.Seconds()))
+			}
+		}
+
+		if o.memoryResource != "" && o.memoryUnit > 0 {
+			observed := int((s.peakMemory + o.memoryUnit - 1) / o.memoryUnit)
+
+			if requested := s.required[o.memoryResource]; observed < requested {
+				result = append(result, fmt.Sprintf("%s requests %d %s but never exceeded %d (held %.2fs)", name, requested, o.memoryResource, observed, s.hold.Seconds()))
+			}
+		}
+	}
+
+	return result
+}
+
+// histogram counts durations in buckets that widen as durations grow, so
+// percentiles stay within a percent of the truth in bounded memory.
+type histogram struct {
+	// counts is the number of durations in each bucket.
+	counts map[int]int
+
+	// maxima is the longest duration seen in each bucket, this is what
+	// percentiles report so they are exact when durations are sparse.
+	maxima map[int]time.Duration
+
+	// total is the number of durations added.
+	total int
+}
+
+// histogramGrowth is how much wider each bucket is than the last.
+const histogramGrowth = 1.0 / 128
+
+// newHistogram returns an empty histogram.
+func newHistogram() *histogram {
+	return &histogram{
+		counts: map[int]int{},
+		maxima: map[int]time.Duration{},
+	}
+}
+
+// histogramBucket returns the bucket a duration falls in.
+func histogramBucket(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+
+	return int(math.Log(float64(d)) / math.Log1p(histogramGrowth))
+}
+
+// add records a duration.
+func (h *histogram) add(d time.Duration) {
+	b := histogramBucket(d)
+
+	h.counts[b]++
+
+	if m, ok := h.maxima[b]; !ok || d > m {
+		h.maxima[b] = d
+	}
+
+	h.total++
+}
+
+// percentile returns the nearest rank percentile (0-100) of the durations.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(h.total)))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	if rank > h.total {
+		rank = h.total
+	}
+
+	buckets := make([]int, 0, len(h.counts))
+
+	for b := range h.counts {
+		buckets = append(buckets, b)
+	}
+
+	sort.Ints(buckets)
+
+	var seen int
+
+	for _, b := range buckets {
+		seen += h.counts[b]
+
+		if seen >= rank {
+			return h.maxima[b]
+		}
+	}
+
+	return 0
+}
+
+// criticalPath returns the chain of allocations that determined the total
+// run time.  Starting with the last allocation to finish, we walk backwards
+// through the allocations whose release allowed it to be scheduled.  Tests
+// on this path are the ones to shrink or split to speed up the run.
+func criticalPath(last *record) []*record {
+	var path []*record
+
+	for r := last; r != nil; r = r.unblockedBy {
+		path = append([]*record{r}, path...)
+	}
+
+	return path
+}
+
+// Report is called from TestMain once all tests have completed, and prints
+// a summary of the run, for example:
+//
+//	func TestMain(m *testing.M) {
+//	   smtest.Start(resources, smtest.WithCPUResource(ResourceTypeCPU))
+//
+//	   code := m.Run()
+//
+//	   smtest.Report()
+//
+//	   os.Exit(code)
+//	}
+//
+// When CPU or memory resources are configured, this will recommend smaller
+// resource requests for tests that never used what they asked for.  Queue wait
+// time percentiles are also reported, and checked against any threshold set with
+// WithWaitThreshold.  Finally the critical path is reported, the tests
+// whose serialization due to resource contention determined the run time.
+// When a baseline is set with WithBaseline, tests that have got slower are
+// reported.  When prices are set with WithPrices, the cost of the run is reported
+// and checked against any budget.  Pool utilization is written to the file named
+// by SMTEST_UTILIZATION.
+func Report() {
+	stopTUI()
+
+	defer webhooks.Wait()
+
+	writeUtilization()
+
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	if waits.total > 0 {
+		emit(nil, event{
+			Action:  "wait",
+			Message: fmt.Sprintf("p50 %.2fs, p90 %.2fs, p99 %.2fs (%d tests)", waits.percentile(50).Seconds(), waits.percentile(90).Seconds(), waits.percentile(99).Seconds(), waits.total),
+		})
+	}
+
+	if config.waitThreshold > 0 {
+		if wait := waits.percentile(config.waitPercentile); wait > config.waitThreshold {
+			raise(Alert{
+				Kind:    AlertWaitThreshold,
+				Message: fmt.Sprintf("p%g wait time %.2fs exceeds threshold %.2fs", config.waitPercentile, wait.Seconds(), config.waitThreshold.Seconds()),
+			})
+		}
+	}
+
+	if config.baseline != "" {
+		compareBaseline(summaries)
+	}
+
+	if config.prices != nil {
+		reportCosts(summaries, records)
+	}
+
+	for _, line := range recommendations(summaries, &config) {
+		emit(nil, event{
+			Action:  "hint",
+			Message: line,
+		})
+	}
+
+	if path := criticalPath(lastReleased); len(path) > 0 {
+		end := path[len(path)-1].released
+
+		emit(nil, event{
+			Action:  "path",
+			Message: fmt.Sprintf("critical path of %d tests, run took %.2fs", len(path), end.Sub(firstEnqueued).Seconds()),
+		})
+
+		for _, r := range path {
+			emit(nil, event{
+				Action:    "path",
+				Test:      r.name,
+				Resources: r.required,
+				Message:   fmt.Sprintf("%s waited %.2fs, held %v for %.2fs", r.name, r.scheduled.Sub(r.enqueued).Seconds(), r.required, r.released.Sub(r.scheduled).Seconds()),
+			})
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// summarize folds the released records into per-test summaries.
+func summarize(records []*record) map[string]*summary {
+	summaries := map[string]*summary{}
+
+	for _, r := range records {
+		if !r.released.IsZero() {
+			fold(summaries, r)
+		}
+	}
+
+	return summaries
+}
+
+func TestRecommendations(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	records := []*record{
+		{
+			name:       "TestOversized",
+			required:   ResourceSet{"cpu": 16, "memory": 64},
+			scheduled:  now,
+			released:   now.Add(time.Second),
+			sampled:    true,
+			peakCPU:    3.2,
+			peakMemory: 2 << 30,
+		},
+		{
+			name:       "TestRightSized",
+			required:   ResourceSet{"cpu": 4, "memory": 2},
+			scheduled:  now,
+			released:   now.Add(time.Second),
+			sampled:    true,
+			peakCPU:    3.2,
+			peakMemory: 2 << 30,
+		},
+		{
+			name:      "TestUnsampled",
+			required:  ResourceSet{"cpu": 16},
+			scheduled: now,
+			released:  now.Add(time.Second),
+		},
+	}
+
+	o := &options{
+		cpuResource:    "cpu",
+		memoryResource: "memory",
+		memoryUnit:     1 << 30,
+	}
+
+	expected := []string{
+		"TestOversized requests 16 cpu but never exceeded 4 (held 1.00s)",
+		"TestOversized requests 64 memory but never exceeded 2 (held 1.00s)",
+	}
+
+	actual := recommendations(summarize(records), o)
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], actual[i])
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	waits := newHistogram()
+
+	// Added out of order, the histogram sorts them.
+	for i := 100; i >= 1; i-- {
+		waits.add(time.Duration(i) * time.Second)
+	}
+
+	for p, expected := range map[float64]time.Duration{
+		0:   time.Second,
+		50:  50 * time.Second,
+		90:  90 * time.Second,
+		99:  99 * time.Second,
+		100: 100 * time.Second,
+	} {
+		if actual := waits.percentile(p); actual != expected {
+			t.Fatalf("expected p%g to be %v, got %v", p, expected, actual)
+		}
+	}
+
+	if actual := newHistogram().percentile(50); actual != 0 {
+		t.Fatalf("expected empty percentile to be 0, got %v", actual)
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	first := &record{
+		name:      "TestFirst",
+		enqueued:  now,
+		scheduled: now,
+		released:  now.Add(2 * time.Second),
+	}
+
+	second := &record{
+		name:        "TestSecond",
+		enqueued:    now,
+		scheduled:   now.Add(2 * time.Second),
+		released:    now.Add(3 * time.Second),
+		unblockedBy: first,
+	}
+
+	path := criticalPath(second)
+
+	if len(path) != 2 || path[0] != first || path[1] != second {
+		t.Fatalf("unexpected critical path %v", path)
+	}
+
+	if path := criticalPath(nil); path != nil {
+		t.Fatalf("expected no critical path, got %v", path)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	waits := newHistogram()
+
+	// A day of waits a tenth of a second apart lands in a few thousand buckets
+	// rather than one entry each.
+	for d := time.Duration(0); d < 24*time.Hour; d += 100 * time.Millisecond {
+		waits.add(d)
+	}
+
+	if len(waits.counts) > 4096 {
+		t.Fatalf("expected bounded buckets, got %d", len(waits.counts))
+	}
+
+	for _, p := range []float64{1, 50, 90, 99} {
+		expected := time.Duration(p / 100 * float64(24*time.Hour))
+
+		if actual := waits.percentile(p); math.Abs(float64(actual-expected)) > float64(expected)*histogramGrowth {
+			t.Fatalf("expected p%g to be about %v, got %v", p, expected, actual)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	summaries := summarize([]*record{
+		{name: "TestA", required: ResourceSet{"cpu": 2}, enqueued: now, scheduled: now.Add(time.Second), released: now.Add(time.Hour + time.Second)},
+		{name: "TestA", required: ResourceSet{"cpu": 4}, enqueued: now, scheduled: now.Add(3 * time.Second), released: now.Add(2*time.Hour + 3*time.Second)},
+	})
+
+	s, ok := summaries["TestA"]
+	if !ok || len(summaries) != 1 {
+		t.Fatalf("expected a single summary, got %v", summaries)
+	}
+
+	if s.count != 2 || s.wait != 4*time.Second || s.hold != 3*time.Hour {
+		t.Fatalf("unexpected totals %+v", s)
+	}
+
+	if s.required["cpu"] != 4 || s.usage["cpu"] != 10 {
+		t.Fatalf("unexpected resources %v %v", s.required, s.usage)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+const (
+	// requirementsEnvironmentVariable overrides the requirements file set with
+	// WithRequirements.
+	requirementsEnvironmentVariable = "SMTEST_REQUIREMENTS"
+)
+
+// Requirement maps tests to the resources they require, and is an entry in a
+// requirements file.
+type Requirement struct {
+	// Test is a regular expression matched against the full test name, as
+	// with -run e.g. "^TestCluster/".
+	Test string `json:"test"`
+
+	// Resources are what matching tests require.
+	Resources ResourceSet `json:"resources"`
+}
+
+// requirement is a compiled Requirement.
+type requirement struct {
+	test      *regexp.Regexp
+	resources ResourceSet
+}
+
+// requirements are consulted, in order, for tests that don't declare what they
+// require.
+var requirements []requirement
+
+// parseRequirements decodes and compiles a requirements file, a JSON array of
+// Requirement.
+func parseRequirements(data []byte) ([]requirement, error) {
+	var entries []Requirement
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequirements, err)
+	}
+
+	result := make([]requirement, 0, len(entries))
+
+	for _, entry := range entries {
+		test, err := regexp.Compile(entry.Test)
+		if err != nil {
+			return nil, fmt.Errorf("%w: test %q: %w", ErrInvalidRequirements, entry.Test, err)
+		}
+
+		for k, v := range entry.Resources {
+			if v < 0 {
+				return nil, fmt.Errorf("%w: test %q requires negative %s", ErrInvalidRequirements, entry.Test, k)
+			}
+		}
+
+		result = append(result, requirement{
+			test:      test,
+			resources: entry.Resources,
+		})
+	}
+
+	return result, nil
+}
+
+// loadRequirements reads the requirements file named by the environment, or
+// set with WithRequirements, if any.
+func loadRequirements(o *options) ([]requirement, error) {
+	path := o.requirements
+
+	if value := os.Getenv(requirementsEnvironmentVariable); value != "" {
+		path = value
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := parseRequirements(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return result, nil
+}
+
+// lookupRequirements returns the resources required by the first entry that
+// matches the test, or nil if none do.
+func lookupRequirements(rules []requirement, name string) ResourceSet {
+	for _, rule := range rules {
+		if rule.test.MatchString(name) {
+			return rule.resources
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const requirementsFile = `[
+  {"test": "^TestCluster/large", "resources": {"cpu": 8, "memory": 32}},
+  {"test": "^TestCluster/", "resources": {"cpu": 2}}
+]`
+
+func TestParseRequirements(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseRequirements([]byte(requirementsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"TestCluster/large_nodes": "cpu=8,memory=32",
+		"TestCluster/small":       "cpu=2",
+		"TestOther":               "",
+	} {
+		r := lookupRequirements(rules, name)
+
+		if r.String() != expected {
+			t.Fatalf("expected %s to require %q, got %q", name, expected, r.String())
+		}
+	}
+
+	if r := lookupRequirements(rules, "TestOther"); r != nil {
+		t.Fatalf("expected no requirements, got %v", r)
+	}
+
+	for _, data := range []string{
+		`{"test": "^TestCluster/"}`,
+		`[{"test": "(", "resources": {"cpu": 1}}]`,
+		`[{"test": "^TestCluster/", "resources": {"cpu": -1}}]`,
+	} {
+		if _, err := parseRequirements([]byte(data)); !errors.Is(err, ErrInvalidRequirements) {
+			t.Fatalf("expected %q to be invalid, got %v", data, err)
+		}
+	}
+}
+
+// TestLoadRequirements is not parallel as it modifies the environment.
+func TestLoadRequirements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requirements.json")
+
+	if err := os.WriteFile(path, []byte(requirementsFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(requirementsEnvironmentVariable, "")
+
+	rules, err := loadRequirements(&options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rules != nil {
+		t.Fatal("expected no requirements without a file")
+	}
+
+	rules, err = loadRequirements(&options{requirements: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 requirements, got %d", len(rules))
+	}
+
+	// The environment overrides the option.
+	t.Setenv(requirementsEnvironmentVariable, filepath.Join(t.TempDir(), "missing.json"))
+
+	if _, err := loadRequirements(&options{requirements: path}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing file, got %v", err)
+	}
+}
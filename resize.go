@@ -0,0 +1,103 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+)
+
+// resizing is a request to the scheduler to replace the pool.
+type resizing struct {
+	// pool is the new pool.
+	pool ResourceSet
+
+	// done is closed once the pool is replaced.
+	done chan struct{}
+}
+
+// Resize changes the pool while tests are running, for example when an operator
+// raises a quota part way through a long run.  Growing the pool grants queued
+// tests immediately.  Shrinking it below what is held doesn't affect running
+// tests, but nothing more is granted until enough is released.  Overrides from
+// the environment and command line, and sharding, are applied as for Start.
+// With a backend only this process' view of the pool changes, the backend must
+// be resized by its own means.
+func Resize(resources ResourceSet) error {
+	if err := checkStarted(capacity()); err != nil {
+		return err
+	}
+
+	pool, err := overrideResources(resources, &config)
+	if err != nil {
+		return err
+	}
+
+	if err := checkStart(nil, pool); err != nil {
+		return err
+	}
+
+	if config.backend == nil {
+		pool = shard(pool)
+	}
+
+	r := &resizing{
+		pool: pool,
+		done: make(chan struct{}),
+	}
+
+	if err := submit(resize, r, "resize", enqueueTimeout()); err != nil {
+		return err
+	}
+
+	<-r.done
+
+	return nil
+}
+
+// resizeBackend adjusts the free resources by the change from the old pool to
+// the new one.  Free resources become negative if the pool shrinks below what
+// is held.
+func resizeBackend(b *MemoryBackend, old, pool ResourceSet) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for k, v := range old {
+		b.free[k] -= v
+	}
+
+	for k, v := range pool {
+		b.free[k] += v
+	}
+}
+
+// resizePool resizes the backend and makes the new pool visible to tests.  This
+// must only be called by the scheduler.
+func resizePool(b *MemoryBackend, pool ResourceSet) {
+	old := capacity()
+
+	resizeBackend(b, old, pool)
+
+	availableLock.Lock()
+	available = pool
+	availableLock.Unlock()
+
+	emit(nil, event{
+		Action:    "resize",
+		Resources: pool,
+		Message:   fmt.Sprintf("pool resized from %v to %v", old, pool),
+	})
+}
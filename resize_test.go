@@ -0,0 +1,70 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResizeBackend(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 8, "memory": 16}
+
+	b := NewMemoryBackend(pool)
+
+	if ok, err := b.Acquire(context.Background(), "a", ResourceSet{"cpu": 6}); err != nil || !ok {
+		t.Fatalf("expected acquisition, got %v %v", ok, err)
+	}
+
+	grown := ResourceSet{"cpu": 12, "memory": 16, "gpu": 2}
+
+	resizeBackend(b, pool, grown)
+
+	if free := b.Free(); free.String() != "cpu=6,gpu=2,memory=16" {
+		t.Fatalf("unexpected free resources after growing %v", free)
+	}
+
+	if err := checkInvariants(grown, b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shrinking below what is held leaves nothing to grant until enough is
+	// released.
+	shrunk := ResourceSet{"cpu": 4, "memory": 16}
+
+	resizeBackend(b, grown, shrunk)
+
+	if free := b.Free(); free["cpu"] != -2 {
+		t.Fatalf("expected over allocation after shrinking, got %v", free)
+	}
+
+	if err := checkInvariants(shrunk, b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := b.Acquire(context.Background(), "b", ResourceSet{"cpu": 1}); ok {
+		t.Fatal("expected nothing to be granted when over allocated")
+	}
+
+	_ = b.Release(context.Background(), "a")
+
+	if free := b.Free(); free["cpu"] != 4 {
+		t.Fatalf("expected the shrunk pool to be free, got %v", free)
+	}
+}
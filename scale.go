@@ -0,0 +1,82 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	// scaleEnvironmentVariable overrides the scale set with WithScale e.g.
+	// "0.5" to halve parallelism.
+	scaleEnvironmentVariable = "SMTEST_SCALE"
+)
+
+// overrideScale sets the scale from the environment, if given, and checks it's
+// valid.
+func overrideScale(o *options) error {
+	if value := os.Getenv(scaleEnvironmentVariable); value != "" {
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w: %w", scaleEnvironmentVariable, ErrInvalidScale, err)
+		}
+
+		if scale <= 0 {
+			return fmt.Errorf("%s: %w: %v", scaleEnvironmentVariable, ErrInvalidScale, scale)
+		}
+
+		o.scale = scale
+	}
+
+	if o.scale < 0 || math.IsNaN(o.scale) || math.IsInf(o.scale, 0) {
+		return fmt.Errorf("%w: %v", ErrInvalidScale, o.scale)
+	}
+
+	return nil
+}
+
+// scaleRequirements divides what a test requires by the scale, rounding up, so
+// a scale below 1 makes tests require more, and fewer run in parallel.  Tests
+// never require more than the pool, so nothing is skipped because of the scale,
+// nor less than one of anything they originally required.  The pool itself is
+// not scaled, so processes sharing it via a backend still agree on its size.
+func scaleRequirements(required, pool ResourceSet, scale float64) ResourceSet {
+	if scale == 0 || scale == 1 || required == nil {
+		return required
+	}
+
+	result := ResourceSet{}
+
+	for k, v := range required {
+		available, ok := pool[k]
+
+		if v == 0 || !ok || v > available {
+			result[k] = v
+
+			continue
+		}
+
+		scaled := math.Min(math.Ceil(float64(v)/scale), float64(available))
+
+		result[k] = max(int(scaled), 1)
+	}
+
+	return result
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestScaleRequirements(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 16, "memory": 64}
+
+	required := ResourceSet{"cpu": 4, "memory": 48, "gpu": 1, "disk": 0}
+
+	for _, test := range []struct {
+		scale    float64
+		expected string
+	}{
+		{scale: 0, expected: "cpu=4,disk=0,gpu=1,memory=48"},
+		{scale: 1, expected: "cpu=4,disk=0,gpu=1,memory=48"},
+		// Requirements grow, but never beyond the pool, and unknown or
+		// zero requirements are left alone.
+		{scale: 0.5, expected: "cpu=8,disk=0,gpu=1,memory=64"},
+		{scale: 0.3, expected: "cpu=14,disk=0,gpu=1,memory=64"},
+		// Requirements shrink, but never below one.
+		{scale: 3, expected: "cpu=2,disk=0,gpu=1,memory=16"},
+		{scale: 1000, expected: "cpu=1,disk=0,gpu=1,memory=1"},
+	} {
+		r := scaleRequirements(required, pool, test.scale)
+
+		if r.String() != test.expected {
+			t.Fatalf("scale %v: expected %s, got %v", test.scale, test.expected, r)
+		}
+	}
+
+	if required.String() != "cpu=4,disk=0,gpu=1,memory=48" {
+		t.Fatalf("expected requirements to be unmodified, got %v", required)
+	}
+
+	if r := scaleRequirements(nil, pool, 0.5); r != nil {
+		t.Fatalf("expected nil requirements to be unchanged, got %v", r)
+	}
+}
+
+// TestOverrideScale is not parallel as it modifies the environment.
+func TestOverrideScale(t *testing.T) {
+	t.Setenv(scaleEnvironmentVariable, "")
+
+	o := &options{scale: 2}
+
+	if err := overrideScale(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.scale != 2 {
+		t.Fatalf("expected scale to be unchanged, got %v", o.scale)
+	}
+
+	t.Setenv(scaleEnvironmentVariable, "0.5")
+
+	if err := overrideScale(o); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.scale != 0.5 {
+		t.Fatalf("expected scale to be overridden, got %v", o.scale)
+	}
+
+	for _, value := range []string{"half", "0", "-1", "NaN", "Inf"} {
+		t.Setenv(scaleEnvironmentVariable, value)
+
+		if err := overrideScale(&options{}); !errors.Is(err, ErrInvalidScale) {
+			t.Fatalf("expected %q to be invalid, got %v", value, err)
+		}
+	}
+
+	t.Setenv(scaleEnvironmentVariable, "")
+
+	if err := overrideScale(&options{scale: -1}); !errors.Is(err, ErrInvalidScale) {
+		t.Fatalf("expected negative scale to be invalid, got %v", err)
+	}
+}
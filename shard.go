@@ -0,0 +1,123 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const (
+	// shardCountEnvironmentVariable is set by Bazel to the number of shards
+	// a test target is split into.
+	shardCountEnvironmentVariable = "TEST_TOTAL_SHARDS"
+
+	// shardIndexEnvironmentVariable is set by Bazel to the zero based index
+	// of this shard.
+	shardIndexEnvironmentVariable = "TEST_SHARD_INDEX"
+)
+
+// Partition divides resources between count shards, returning the share of
+// the zero based shard index, so that across all shards no more than the
+// declared amount is ever used.  Where a resource doesn't divide evenly, the
+// remainder is handed out one per shard, starting at a different shard for each
+// resource so no single shard is favoured.  Every shard arrives at the same
+// answer, so no coordination is required.  This is done automatically for
+// Bazel test shards, and is provided for hand-rolled sharding.  It panics if
+// the index is not in the range [0, count).
+func Partition(resources ResourceSet, index, count int) ResourceSet {
+	if count < 1 || index < 0 || index >= count {
+		panic(fmt.Sprintf("smtest: shard index %d out of range for %d shards", index, count))
+	}
+
+	names := make([]string, 0, len(resources))
+
+	for name := range resources {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	result := ResourceSet{}
+
+	for offset, name := range names {
+		v := resources[name]
+
+		result[name] = v / count
+
+		if (index-offset%count+count)%count < v%count {
+			result[name]++
+		}
+	}
+
+	return result
+}
+
+// shard returns this process' share of the resources when running as a Bazel
+// test shard, otherwise the resources unmodified.
+func shard(resources ResourceSet) ResourceSet {
+	countValue := os.Getenv(shardCountEnvironmentVariable)
+	indexValue := os.Getenv(shardIndexEnvironmentVariable)
+
+	if countValue == "" || indexValue == "" {
+		return resources
+	}
+
+	count, err := strconv.Atoi(countValue)
+	if err != nil || count < 1 {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("ignoring invalid %s %q", shardCountEnvironmentVariable, countValue),
+		})
+
+		return resources
+	}
+
+	index, err := strconv.Atoi(indexValue)
+	if err != nil || index < 0 || index >= count {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("ignoring invalid %s %q", shardIndexEnvironmentVariable, indexValue),
+		})
+
+		return resources
+	}
+
+	return Partition(resources, index, count)
+}
+
+// PartitionInstances divides resources that are backed by specific instances,
+// for example GPUs or pre-created clusters, between count shards, returning the
+// instances belonging to the zero based shard index.  Every instance belongs to
+// exactly one shard, and the length of the result should be used as the
+// shard's count of the resource.  It panics if the index is not in the range
+// [0, count).
+func PartitionInstances[T any](instances []T, index, count int) []T {
+	if count < 1 || index < 0 || index >= count {
+		panic(fmt.Sprintf("smtest: shard index %d out of range for %d shards", index, count))
+	}
+
+	var result []T
+
+	for i := index; i < len(instances); i += count {
+		result = append(result, instances[i])
+	}
+
+	return result
+}
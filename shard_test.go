@@ -0,0 +1,117 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	t.Parallel()
+
+	resources := ResourceSet{"a": 10, "b": 2, "c": 3, "d": 0}
+
+	const count = 4
+
+	totals := ResourceSet{}
+
+	for index := 0; index < count; index++ {
+		share := Partition(resources, index, count)
+
+		if share.String() != Partition(resources, index, count).String() {
+			t.Fatal("expected partitioning to be deterministic")
+		}
+
+		for k, v := range share {
+			totals[k] += v
+		}
+	}
+
+	if totals.String() != resources.String() {
+		t.Fatalf("expected shares to sum to %v, got %v", resources, totals)
+	}
+
+	// Remainders of "a" (2) and "b" (2) would both go to shards 0 and 1
+	// without rotation.
+	first := Partition(resources, 0, count)
+
+	if first["a"] != 3 || first["b"] != 0 {
+		t.Fatalf("expected remainders to be rotated, got %v", first)
+	}
+}
+
+func TestPartitionInstances(t *testing.T) {
+	t.Parallel()
+
+	instances := []string{"a", "b", "c", "d", "e"}
+
+	seen := map[string]int{}
+
+	for index := 0; index < 2; index++ {
+		for _, instance := range PartitionInstances(instances, index, 2) {
+			seen[instance]++
+		}
+	}
+
+	if len(seen) != len(instances) {
+		t.Fatalf("expected every instance to be in a shard, got %v", seen)
+	}
+
+	for instance, n := range seen {
+		if n != 1 {
+			t.Fatalf("expected %s to be in exactly one shard", instance)
+		}
+	}
+
+	if share := PartitionInstances(instances, 1, 2); len(share) != 2 || share[0] != "b" || share[1] != "d" {
+		t.Fatalf("unexpected share %v", share)
+	}
+}
+
+func TestPartitionInvalid(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected an out of range index to panic")
+		}
+	}()
+
+	Partition(ResourceSet{"cpu": 8}, 2, 2)
+}
+
+// TestShard is not parallel as it sets the environment.
+func TestShard(t *testing.T) {
+	resources := ResourceSet{"cpu": 8}
+
+	if share := shard(resources); share["cpu"] != 8 {
+		t.Fatalf("expected resources to be unmodified outside Bazel, got %v", share)
+	}
+
+	t.Setenv(shardCountEnvironmentVariable, "3")
+	t.Setenv(shardIndexEnvironmentVariable, "2")
+
+	if share := shard(resources); share["cpu"] != 2 {
+		t.Fatalf("expected a third of the resources, got %v", share)
+	}
+
+	t.Setenv(shardIndexEnvironmentVariable, "3")
+
+	if share := shard(resources); share["cpu"] != 8 {
+		t.Fatalf("expected an invalid index to be ignored, got %v", share)
+	}
+}
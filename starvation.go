@@ -0,0 +1,80 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// starvationInterval is how often the queue is checked for starved
+	// tests.
+	starvationInterval = time.Second
+)
+
+// checkStarvation is called periodically by the scheduler and raises an
+// alert for any test that has been queued for longer than the configured
+// threshold, naming the tests that are holding the resources it needs.
+func checkStarvation(now time.Time) {
+	free := local.Free()
+
+	for _, item := range queue.items {
+		if item.warned || now.Sub(item.enqueued) < config.starvationThreshold {
+			continue
+		}
+
+		item.warned = true
+
+		short := ResourceSet{}
+
+		for k, v := range item.required {
+			if free[k] < v {
+				short[k] = v - free[k]
+			}
+		}
+
+		raise(Alert{
+			Kind:    AlertStarvation,
+			Message: fmt.Sprintf("%s has waited %v, short of %v, held by %s", item.record.name, now.Sub(item.enqueued).Round(time.Second), short, strings.Join(holdersOf(short), ", ")),
+		})
+	}
+}
+
+// holdersOf returns the names of all tests that are holding any of the
+// given resources.
+func holdersOf(resources ResourceSet) []string {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	var names []string
+
+	for r := range held {
+		for k := range resources {
+			if r.charged[k] > 0 {
+				names = append(names, r.name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
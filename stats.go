@@ -0,0 +1,114 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Statistics is a point in time summary of the scheduler, for metrics
+// exporters and dashboards.  It is shared, so must not be modified.
+type Statistics struct {
+	// Time is when the summary was taken.
+	Time time.Time
+
+	// Pool is the set of resources being scheduled.
+	Pool ResourceSet
+
+	// Free is the set of resources not allocated to a test.
+	Free ResourceSet
+
+	// Queued is the number of tests waiting for resources.
+	Queued int
+
+	// Held is the number of allocations currently granted.
+	Held int
+
+	// Granted is the number of allocations granted since Start.
+	Granted int64
+
+	// Released is the number of allocations returned since Start.
+	Released int64
+}
+
+var (
+	// stats is the latest summary.  It is replaced, never modified, so is
+	// read by any goroutine without locking.
+	stats atomic.Pointer[Statistics]
+
+	// queued is the length of the queue, as last seen by the scheduler.
+	queued atomic.Int64
+
+	// grantedCount counts allocations granted since Start.
+	grantedCount atomic.Int64
+
+	// releasedCount counts allocations returned since Start.
+	releasedCount atomic.Int64
+)
+
+// Stats returns a summary of the scheduler.  It never waits for the scheduler,
+// so may be polled frequently, for example by a metrics exporter, without
+// adding latency to grants and releases.  It returns nil when the scheduler is
+// not running.
+func Stats() *Statistics {
+	return stats.Load()
+}
+
+// publishStats replaces the summary after the scheduler's state has changed.
+// The scheduler and inline grants publish concurrently, so each rebuilds the
+// summary from the current state, and retries if another published first,
+// ensuring the last summary published is never older than the last change.
+func publishStats(b *MemoryBackend) {
+	for {
+		old := stats.Load()
+
+		b.lock.Lock()
+
+		free := make(ResourceSet, len(b.free))
+
+		for k, v := range b.free {
+			free[k] = v
+		}
+
+		held := len(b.leases)
+
+		b.lock.Unlock()
+
+		s := &Statistics{
+			Time:     time.Now(),
+			Pool:     capacity(),
+			Free:     free,
+			Queued:   int(queued.Load()),
+			Held:     held,
+			Granted:  grantedCount.Load(),
+			Released: releasedCount.Load(),
+		}
+
+		if stats.CompareAndSwap(old, s) {
+			return
+		}
+	}
+}
+
+// resetStats forgets the summary, and starts counting again.
+func resetStats() {
+	stats.Store(nil)
+	queued.Store(0)
+	grantedCount.Store(0)
+	releasedCount.Store(0)
+}
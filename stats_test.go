@@ -0,0 +1,75 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStats is not parallel as it needs nothing else to change the scheduler's
+// state.
+func TestStats(t *testing.T) {
+	if config.chaos {
+		t.Skip("tests are never granted inline in chaos mode")
+	}
+
+	before := Stats()
+	if before == nil {
+		t.Fatal("expected statistics while the scheduler is running")
+	}
+
+	if before.Pool["cpu"] == 0 || before.Free["cpu"] > before.Pool["cpu"] {
+		t.Fatalf("unexpected statistics %+v", before)
+	}
+
+	r := &record{
+		id:       newAllocationID(),
+		name:     "TestStats",
+		required: ResourceSet{"cpu": 1},
+		enqueued: time.Now(),
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !grantInline(r) {
+		cancelSubmit()
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected an uncontended request to be granted inline")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// Inline grants are visible immediately.
+	granted := Stats()
+
+	if granted.Granted <= before.Granted || granted.Held == 0 {
+		t.Fatalf("expected the grant to be counted, got %+v", granted)
+	}
+
+	release <- r
+
+	for Stats().Released <= granted.Released {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the release to be counted")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
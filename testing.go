@@ -17,7 +17,14 @@ limitations under the License.
 package testing
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -31,15 +38,48 @@ type queueItem struct {
 	// required is the set of resources that are required for the
 	// test to successfully execute.
 	required ResourceSet
+
+	// enqueued is when the test joined the queue.
+	enqueued time.Time
+
+	// warned is set once a starvation alert has been raised.
+	warned bool
+
+	// record is the allocation's history.
+	record *record
+
+	// err, if set when wait is closed, is why the test cannot be granted its
+	// resources.
+	err error
+
+	// considered is set once the scheduler has tried to grant the test its
+	// resources.
+	considered bool
+
+	// blockedOn is the resource the test last didn't fit on, or empty if
+	// the backend refused it.
+	blockedOn string
+
+	// removed is set once the test has left the queue.
+	removed bool
+
+	// heap is the index heap the test is currently placed in, if any.
+	heap *itemHeap
+
+	// seq counts the test's placements, so stale heap entries are skipped.
+	seq uint64
+
+	// rank, in chaos mode, is the test's random position in the queue.
+	rank int64
 }
 
-// transaction is used to enqueue an item.
-type transaction struct {
-	// name is the test name e.g. unique.
-	name string
+// withdrawal is used to remove a test that exited while queued.
+type withdrawal struct {
+	// id is the allocation ID.
+	id string
 
-	// item is the item to add to the queue.
-	item *queueItem
+	// granted is sent whether the test had been granted its resources.
+	granted chan bool
 }
 
 var (
@@ -47,20 +87,60 @@ var (
 	// Sadly the standard testing package doesn't allow a context etc.
 	// to be passed from TestMain to individual tests, so we're stack
 	// with "bad practice".  We can use this value to check if a test
-	// can actually be run.
+	// can actually be run.  It is set by Start, and replaced by the
+	// scheduler goroutine when the pool is resized, but never modified in
+	// place, so a set read via capacity may be used by any goroutine.
 	available ResourceSet
 
-	// unallocated is the set of resources that are not in use.
-	unallocated = ResourceSet{}
+	// availableLock protects available from tests that check it while
+	// Start or the scheduler is replacing it.
+	availableLock sync.RWMutex
+
+	// local accounts for the resources used by this process.  It, and
+	// queue, are owned by the scheduler goroutine, and must only be
+	// accessed from it e.g. via getState.  The exception is grantInline,
+	// which may acquire from local when nothing is queued.
+	local *MemoryBackend
 
 	// queue is the set of tests waiting to run.
-	queue = map[string]*queueItem{}
+	queue = newWaitQueue()
+
+	// uncontended is set by the scheduler while nothing is queued, and
+	// grants aren't being perturbed or replayed, so tests may be granted
+	// inline.
+	uncontended atomic.Bool
+
+	// submitting is the number of tests that weren't granted inline, and
+	// haven't yet been taken onto the queue by the scheduler.  These can't
+	// be seen in uncontended, so tests aren't granted inline until it's
+	// zero, as they would overtake them.
+	submitting atomic.Int64
+
+	// inlineLock serializes inline grants, so a test that isn't granted is
+	// counted as submitting before another test may be granted in its place.
+	inlineLock sync.Mutex
 
 	// enqueue adds a test to our scheduler.
-	enqueue chan *transaction
+	enqueue chan *queueItem
 
 	// release is called on test exit to release resources.
-	release chan ResourceSet
+	release chan *record
+
+	// withdraw removes a test from the queue that exited before it could
+	// register to release its resources.
+	withdraw chan *withdrawal
+
+	// resize replaces the pool.
+	resize chan *resizing
+
+	// config is the set of options passed to Start.
+	config options
+
+	// stop is closed by Stop to terminate background goroutines.
+	stop chan struct{}
+
+	// running tracks background goroutines so Stop can wait for them.
+	running sync.WaitGroup
 )
 
 // Start is called from TestMain to set things up for example:
@@ -87,100 +167,892 @@ var (
 //
 //	   smtest.Start(resources)
 //
-//	   os.Exit(m.Run())
+//	   code := m.Run()
+//
+//	   if err := smtest.Verify(); err != nil {
+//	     fmt.Println(err)
+//	     code = 1
+//	   }
+//
+//	   smtest.Report()
+//	   smtest.Stop()
+//
+//	   os.Exit(code)
 //	}
-func Start(resources ResourceSet) {
-	available = resources
+//
+// The resources may be overridden, or added to, by the SMTEST_RESOURCES
+// environment variable e.g. "cpu=16,memory=64Gi", so CI can size the pool per
+// runner class.  The memory resource, as set with WithMemoryResource, may be
+// given in bytes with a binary suffix.
+//
+// In test binaries the -smtest.resources flag overrides resources in the same
+// way, taking precedence over the environment, and -smtest.policy overrides the
+// policy set with WithPolicy, so they can be tuned per invocation like
+// -test.parallel e.g. "go test ./... -smtest.resources=cpu=8".  Start parses
+// the command line if it hasn't been already.
+//
+// Start panics if it has already been called, the resources are empty or
+// invalid, or the requirements file can't be read, as tests would otherwise
+// share corrupted state.
+func Start(resources ResourceSet, opts ...Option) {
+	o := options{}
 
-	for k, v := range available {
-		unallocated[k] = v
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	enqueue = make(chan *transaction)
-	release = make(chan ResourceSet)
+	parseFlags()
 
-	go func() {
-		for {
-			// Process new tests, and finishing tests in a concurrency
-			// safe way.  New tests go on the queue, finished tests will
-			// release their resource allocations.
-			select {
-			case transaction := <-enqueue:
-				queue[transaction.name] = transaction.item
-			case allocated := <-release:
-				for k, v := range allocated {
-					unallocated[k] += v
-				}
+	if err := overridePolicy(&o); err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	if err := overrideScale(&o); err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	if err := overrideChaos(&o); err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	overridePassthrough(&o)
+
+	// This takes a copy so the caller can't modify it under our feet.
+	pool, err := overrideResources(resources, &o)
+	if err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	if err := checkStart(capacity(), pool); err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	rules, err := loadRequirements(&o)
+	if err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	replayed, err := loadReplay()
+	if err != nil {
+		panic("smtest: " + err.Error())
+	}
+
+	started = time.Now()
+
+	config = o
+	requirements = rules
+
+	checkHostLimits(pool)
+
+	// A backend already shares the pool between processes, otherwise Bazel
+	// test shards each take a slice of it.
+	if config.backend == nil {
+		pool = shard(pool)
+	}
+
+	availableLock.Lock()
+	available = pool
+	availableLock.Unlock()
+
+	local = NewMemoryBackend(pool)
+	queue = newWaitQueue()
+
+	if config.chaos {
+		queue.chaos = newChaos(config.chaosSeed)
+
+		emit(nil, event{
+			Action:  "chaos",
+			Message: fmt.Sprintf("seed %d, reproduce with -%s=%d", config.chaosSeed, chaosFlagName, config.chaosSeed),
+		})
+	}
+
+	if replayed != nil {
+		queue.replay = replayed
+
+		emit(nil, event{
+			Action:  "replay",
+			Message: fmt.Sprintf("replaying %d grants", len(replayed.order)),
+		})
+	}
+
+	uncontended.Store(queue.inlinable())
+	submitting.Store(0)
+
+	resetRecords()
+	resetStats()
+	publishStats(local)
+
+	enqueue = make(chan *queueItem, maxBatch)
+	release = make(chan *record, maxBatch)
+	withdraw = make(chan *withdrawal)
+	resize = make(chan *resizing)
+	snapshot = make(chan chan *state)
+
+	stop = make(chan struct{})
+	suspected = false
+
+	startExport()
+	startJournal()
+	startRecording()
+	startTUI()
+
+	if len(config.dumpSignals) > 0 {
+		run(func() {
+			dumpOnSignal(config.dumpSignals, stop)
+		})
+	}
+
+	if len(config.interruptSignals) > 0 {
+		run(func() {
+			releaseOnInterrupt(config.interruptSignals, stop)
+		})
+	}
+
+	if config.cpuResource != "" || config.memoryResource != "" {
+		run(func() {
+			sample(stop)
+		})
+	}
+
+	var tickers []*time.Ticker
+
+	ticker := func(d time.Duration) <-chan time.Time {
+		t := time.NewTicker(d)
+		tickers = append(tickers, t)
+
+		return t.C
+	}
+
+	var starvation <-chan time.Time
+
+	if config.starvationThreshold > 0 {
+		starvation = ticker(starvationInterval)
+	}
+
+	var poll <-chan time.Time
+
+	var notify <-chan struct{}
+
+	if config.backend != nil {
+		poll = ticker(backendPollInterval)
+
+		if notifier, ok := config.backend.(Notifier); ok {
+			notify = notifier.Notify()
+		}
+
+		run(func() {
+			renewLeases(stop)
+		})
+	}
+
+	deadlock := ticker(deadlockInterval)
+
+	var sampling <-chan time.Time
+
+	if os.Getenv(utilizationEnvironmentVariable) != "" {
+		sampling = ticker(utilizationInterval)
+	}
+
+	run(func() {
+		defer func() {
+			for _, t := range tickers {
+				t.Stop()
+			}
+		}()
+
+		// A panic would otherwise kill the process with a confusing stack,
+		// so log the state and fail the waiting tests instead.
+		if err := protect(func() { schedule(starvation, sampling, deadlock, poll, notify) }); err != nil {
+			failScheduler(err)
+		}
+	})
+}
+
+// schedule is the scheduler loop, it runs until stopped.
+func schedule(starvation, sampling, deadlock, poll <-chan time.Time, notify <-chan struct{}) {
+	for {
+		// Process new tests, and finishing tests in a concurrency
+		// safe way.  New tests go on the queue, finished tests will
+		// release their resource allocations.
+		var released *record
+
+		select {
+		case <-stop:
+			return
+		case item := <-enqueue:
+			take(queue, item)
+		case released = <-release:
+			releaseLocal(local, released)
+		case w := <-withdraw:
+			// Enqueues are buffered, so the test may not be queued
+			// yet.
+			if last := drain(enqueue, release, queue, local); last != nil {
+				released = last
 			}
 
-			// For every item on the queue...
-			for name, item := range queue {
-				ok := true
-
-				// If all of its required resources can be satisfied...
-				for k, v := range item.required {
-					if unallocated[k] < v {
-						ok = false
-						break
-					}
-				}
-
-				if ok {
-					// Remove them from the unallocated pool, remove the
-					// enqueued item and release the test.
-					for k, v := range item.required {
-						unallocated[k] -= v
-					}
-
-					delete(queue, name)
-					close(item.wait)
-				}
+			w.granted <- withdrawFrom(queue, local, w.id)
+		case r := <-resize:
+			resizePool(local, r.pool)
+			close(r.done)
+		case reply := <-snapshot:
+			// Releases are buffered, so return those already made
+			// for the copy to agree with the allocation records.
+			if last := drain(enqueue, release, queue, local); last != nil {
+				released = last
 			}
+
+			reply <- copyState()
+		case now := <-starvation:
+			checkStarvation(now)
+		case now := <-sampling:
+			sampleUtilization(now)
+		case <-deadlock:
+			checkDeadlock()
+		case <-poll:
+		case <-notify:
+		}
+
+		// A wave of tests finishing, or subtests starting, arrives as a
+		// burst, so take everything already waiting and do one pass.
+		if last := coalesce(enqueue, release, queue, local); last != nil {
+			released = last
+		}
+
+		if queue.pending(local.Free(), config.policy) {
+			grant(queue, local, released, config.policy)
+		}
+
+		if config.selfCheck {
+			selfCheck()
 		}
+
+		queued.Store(int64(queue.len()))
+		uncontended.Store(queue.inlinable())
+
+		publishStats(local)
+	}
+}
+
+// run starts a background goroutine that Stop waits for.
+func run(f func()) {
+	running.Add(1)
+
+	go func() {
+		defer running.Done()
+
+		f()
 	}()
 }
 
+// Stop terminates the scheduler and its background goroutines, and waits for
+// them to exit, so tools such as goleak see a clean process.  It's called from
+// TestMain once all tests have completed, after Verify and Report, e.g.
+//
+//	code := m.Run()
+//
+//	smtest.Report()
+//	smtest.Stop()
+//
+//	os.Exit(code)
+//
+// Releases still in progress, for example from goroutines that outlived their
+// test, are given a grace period to complete, see WithShutdownGrace.  Once
+// stopped Start may be called again.
+func Stop() {
+	if capacity() == nil {
+		return
+	}
+
+	uncontended.Store(false)
+
+	stopTimeoutDump()
+	stopTUI()
+
+	if n := waitForReleases(shutdownGrace()); n > 0 {
+		emit(nil, event{
+			Action:  "warn",
+			Message: fmt.Sprintf("%d releases still in progress after %v, stopping anyway", n, shutdownGrace()),
+		})
+	}
+
+	close(stop)
+	running.Wait()
+
+	webhooks.Wait()
+
+	stopExport()
+	stopRecording()
+
+	availableLock.Lock()
+	available = nil
+	availableLock.Unlock()
+
+	stats.Store(nil)
+}
+
+var (
+	// ErrNotStarted is raised when the scheduler is used before Start is
+	// called, rather than blocking forever.
+	ErrNotStarted = errors.New("smtest.Start must be called from TestMain before tests acquire resources")
+
+	// ErrAlreadyStarted is raised when Start is called more than once.
+	ErrAlreadyStarted = errors.New("smtest.Start must only be called once")
+
+	// ErrUnknownResource is raised in strict mode when a test requires a
+	// resource that wasn't passed to Start.
+	ErrUnknownResource = errors.New("unknown resource")
+
+	// ErrSchedulerBlocked is raised when the scheduler doesn't accept a
+	// request in time, rather than hanging every test.
+	ErrSchedulerBlocked = errors.New("scheduler is not responding")
+)
+
+const (
+	// defaultShutdownGrace is how long Stop waits for releases in progress
+	// unless set with WithShutdownGrace.
+	defaultShutdownGrace = 10 * time.Second
+
+	// releasePollInterval is how often Stop checks for releases in progress.
+	releasePollInterval = 10 * time.Millisecond
+
+	// maxBatch is the most events processed before a scheduling pass, so
+	// a constant stream can't delay grants indefinitely.  It is also how
+	// many enqueues and releases are buffered, so tests finishing return
+	// immediately rather than waiting for the scheduler to accept them.
+	maxBatch = 1024
+
+	// defaultEnqueueTimeout is how long a request to the scheduler may block
+	// for unless set with WithEnqueueTimeout.
+	defaultEnqueueTimeout = time.Minute
+)
+
+// shutdownGrace returns how long Stop waits for releases in progress.
+func shutdownGrace() time.Duration {
+	if config.shutdownGrace > 0 {
+		return config.shutdownGrace
+	}
+
+	return defaultShutdownGrace
+}
+
+// waitForReleases waits up to the grace period for releases in progress to
+// complete, returning how many are still outstanding.
+func waitForReleases(grace time.Duration) int64 {
+	deadline := time.Now().Add(grace)
+
+	for {
+		n := releasing.Load()
+		if n == 0 || !time.Now().Before(deadline) {
+			return n
+		}
+
+		time.Sleep(releasePollInterval)
+	}
+}
+
+// enqueueTimeout returns how long a request to the scheduler may block for.
+func enqueueTimeout() time.Duration {
+	if config.enqueueTimeout > 0 {
+		return config.enqueueTimeout
+	}
+
+	return defaultEnqueueTimeout
+}
+
+// submit sends a request to the scheduler, giving up if it's not accepted in
+// time, for example because the scheduler is wedged.
+func submit[T any](ch chan<- T, v T, what string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case ch <- v:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: %s not accepted after %v", ErrSchedulerBlocked, what, timeout)
+	}
+}
+
+// checkStart returns an error if the scheduler has already been started with
+// a pool, or the resources are unusable.
+func checkStart(pool, resources ResourceSet) error {
+	if pool != nil {
+		return ErrAlreadyStarted
+	}
+
+	if len(resources) == 0 {
+		return fmt.Errorf("%w: no resources", ErrInvalidResourceSet)
+	}
+
+	for k, v := range resources {
+		if k == "" {
+			return fmt.Errorf("%w: empty resource name", ErrInvalidResourceSet)
+		}
+
+		if v < 0 {
+			return fmt.Errorf("%w: %s is negative: %d", ErrInvalidResourceSet, k, v)
+		}
+	}
+
+	return nil
+}
+
+// checkStarted returns an error if Start hasn't set the pool.
+func checkStarted(pool ResourceSet) error {
+	if pool == nil {
+		return ErrNotStarted
+	}
+
+	return nil
+}
+
+// checkKnown returns an error, in strict mode, if any required resource isn't
+// in the pool, as it's most likely a typo.
+func checkKnown(pool, required ResourceSet, strict bool) error {
+	if !strict {
+		return nil
+	}
+
+	var unknown []string
+
+	for k := range required {
+		if _, ok := pool[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	known := make([]string, 0, len(pool))
+
+	for k := range pool {
+		known = append(known, k)
+	}
+
+	sort.Strings(unknown)
+	sort.Strings(known)
+
+	return fmt.Errorf("%w: %s, expected one of %s", ErrUnknownResource, strings.Join(unknown, ", "), strings.Join(known, ", "))
+}
+
+// capacity returns the resources available to this process.  The result must
+// not be modified.
+func capacity() ResourceSet {
+	availableLock.RLock()
+	defer availableLock.RUnlock()
+
+	return available
+}
+
+// grant releases queued tests whose resources can be acquired from this
+// process' pool, in the order defined by the policy.  Only tests that may now
+// fit, because they are new, were refused by the backend, or need no more of
+// what they didn't fit on than is now free, are considered.  Released is the
+// allocation, if any, that was just returned.
+func grant(q *waitQueue, b *MemoryBackend, released *record, policy Policy) {
+	free := b.Free()
+
+	// admit grants the test its resources if it fits, otherwise records
+	// what it's blocked on.
+	admit := func(item *queueItem) bool {
+		if resource, blocked := blocking(free, item.required); blocked {
+			q.block(item, resource)
+
+			return false
+		}
+
+		if !acquire(b, item.record) {
+			q.block(item, "")
+
+			return false
+		}
+
+		for k, v := range item.required {
+			free[k] -= v
+		}
+
+		// Remember what allowed this test to run for critical path
+		// analysis.
+		if released != nil {
+			unblock(item.record, released)
+		}
+
+		recordGrant(item.record)
+
+		// Remove the enqueued item and release the test.
+		q.remove(item.record.id)
+
+		if q.chaos != nil {
+			time.AfterFunc(q.chaos.delay(), func() {
+				close(item.wait)
+			})
+
+			return true
+		}
+
+		close(item.wait)
+
+		return true
+	}
+
+	// Once replayed in full, tests are granted by policy.
+	if q.replay != nil && !grantReplay(q, admit) {
+		return
+	}
+
+	if policy == PolicyFIFO {
+		// Nothing may overtake the head of the queue, which can only
+		// fit if it's new or what it didn't fit on is now free.
+		for item := q.first(); item != nil; item = q.first() {
+			if !q.headMayFit(item, free) {
+				return
+			}
+
+			q.head = item
+
+			if !admit(item) {
+				return
+			}
+		}
+
+		return
+	}
+
+	// Tests the backend refused are each tried once per pass, those it
+	// refuses again wait for the next.
+	retry := q.retry
+	q.retry = &itemHeap{}
+
+	// Free only shrinks as tests are granted, so each test is considered at
+	// most once, and the pass stops as soon as nothing left may fit.
+	for {
+		item := next(append(q.eligible(free), retry))
+		if item == nil {
+			return
+		}
+
+		admit(item)
+	}
+}
+
+// releaseLocal returns an allocation's resources to this process' pool.
+func releaseLocal(b *MemoryBackend, r *record) {
+	_ = b.Release(context.Background(), r.id)
+
+	releasedCount.Add(1)
+}
+
+// coalesce processes enqueues and releases that are already waiting, up to
+// maxBatch of them, so they are granted in a single pass rather than one each.
+// It returns the last allocation released, if any.
+func coalesce(enqueue <-chan *queueItem, release <-chan *record, q *waitQueue, b *MemoryBackend) *record {
+	var released *record
+
+	for i := 0; i < maxBatch; i++ {
+		select {
+		case item := <-enqueue:
+			take(q, item)
+		case r := <-release:
+			releaseLocal(b, r)
+
+			released = r
+		default:
+			return released
+		}
+	}
+
+	return released
+}
+
+// drain processes every enqueue and release already buffered, so requests
+// made before, for example, a withdrawal are seen first.  It returns the last
+// allocation released, if any.
+func drain(enqueue <-chan *queueItem, release <-chan *record, q *waitQueue, b *MemoryBackend) *record {
+	var released *record
+
+	for i := len(enqueue); i > 0; i-- {
+		take(q, <-enqueue)
+	}
+
+	for i := len(release); i > 0; i-- {
+		released = <-release
+
+		releaseLocal(b, released)
+	}
+
+	return released
+}
+
+// acquire takes the allocation's resources from this process' pool and, if the
+// pool is shared, the backend.  If the backend refuses then the local resources
+// are returned, so a partial grant is never leaked.
+func acquire(b *MemoryBackend, r *record) bool {
+	if ok, _ := b.Acquire(context.Background(), r.id, r.charged); !ok {
+		return false
+	}
+
+	if config.backend != nil && !backendAcquire(r) {
+		_ = b.Release(context.Background(), r.id)
+
+		return false
+	}
+
+	grantedCount.Add(1)
+
+	return true
+}
+
+// take adds a test that was submitted to the queue.  Inline grants are stopped
+// before it stops being counted as submitting, and until the scheduler sees
+// whether the queue is empty at the end of the pass.
+func take(q *waitQueue, item *queueItem) {
+	uncontended.Store(false)
+	submitting.Add(-1)
+
+	q.add(item)
+}
+
+// grantInline acquires the allocation's resources directly, rather than via
+// the scheduler, when nothing is queued or waiting to be.  Nothing can be
+// overtaken, so this respects the policy, and the common uncontended case
+// doesn't wait for a round trip through the queue and a scheduling pass.  A
+// test that isn't granted is counted as submitting, so must be enqueued, or
+// given up with cancelSubmit.
+func grantInline(r *record) bool {
+	inlineLock.Lock()
+	defer inlineLock.Unlock()
+
+	if submitting.Load() == 0 && uncontended.Load() && acquire(local, r) {
+		recordGrant(r)
+		publishStats(local)
+
+		return true
+	}
+
+	submitting.Add(1)
+
+	return false
+}
+
+// cancelSubmit gives up a test that wasn't granted inline, and won't be
+// enqueued, for example because the scheduler isn't responding.
+func cancelSubmit() {
+	submitting.Add(-1)
+}
+
+// withdrawFrom removes an allocation from the queue, and returns any resources
+// it may have been granted, returning whether it was.
+func withdrawFrom(q *waitQueue, b *MemoryBackend, id string) bool {
+	q.remove(id)
+
+	b.lock.Lock()
+	_, granted := b.leases[id]
+	b.lock.Unlock()
+
+	_ = b.Release(context.Background(), id)
+
+	return granted
+}
+
+// abandon withdraws an allocation whose test exited, for example by being
+// skipped or panicking, after it was queued but before its resources could be
+// released by cleanup.  The backend is always told, even if the allocation was
+// never granted, as a queueing backend holds a place in its queue for it, and
+// may grant it later.
+func abandon(r *record) {
+	w := &withdrawal{
+		id:      r.id,
+		granted: make(chan bool),
+	}
+
+	if err := submit(withdraw, w, "withdrawal of "+r.name, enqueueTimeout()); err != nil {
+		emit(nil, event{
+			Action:  "warn",
+			Message: err.Error(),
+		})
+	} else {
+		<-w.granted
+	}
+
+	if config.backend != nil {
+		backendRelease(r)
+	}
+
+	forgetRecord(r)
+}
+
+// Ordering defines when a test's resources are granted relative to it waiting
+// for go test to run it in parallel, which is limited by -parallel.
+type Ordering int
+
+const (
+	// OrderingParallelFirst waits to run in parallel, then for resources,
+	// this is the default.  Tests occupy a -parallel slot while they wait for
+	// resources, so with a low -parallel fewer tests may run than resources
+	// allow, however tests that hold resources are always running, so will
+	// eventually release them.
+	OrderingParallelFirst Ordering = iota
+
+	// OrderingAcquireFirst waits for resources, then to run in parallel.
+	// Tests are granted resources in the order they are declared, and never
+	// occupy a -parallel slot while waiting for resources.  However, tests
+	// hold resources while they wait for their parent to start its parallel
+	// subtests, which it can't do while a sibling is still waiting for those
+	// resources.  This deadlock is detected and fails the waiting tests, so
+	// siblings must collectively fit in the pool.
+	OrderingAcquireFirst
+)
+
 // Parallel is called from individual tests, it delegates concurrency to the native
 // testing library, but crucially only releases a test for execution once resource
 // is available.  If a test requires too many resources, or none are available at all
-// then the test is skipped.
+// then the test is skipped, see WithStrict.  The returned function releases the
+// resources, this happens automatically when the test completes, so it's only
+// required when the resources can be returned early.  If required is nil, the
+// test's requirements are looked up in the file set with WithRequirements.
 func Parallel(t *testing.T, required ResourceSet) func() {
+	t.Helper()
+
+	return Acquire(t, required).Release
+}
+
+// Acquire behaves like Parallel, but returns the allocation, whose ID can be
+// used to tag any external infrastructure the test creates.  If the test can
+// never be granted its resources, for example because its parent holds them
+// until it completes, it fails with ErrDeadlock rather than hanging.
+func Acquire(t *testing.T, required ResourceSet) *Allocation {
+	t.Helper()
+
+	pool := capacity()
+
+	if err := checkStarted(pool); err != nil {
+		t.Fatal(err)
+	}
+
+	if required == nil {
+		required = lookupRequirements(requirements, t.Name())
+	}
+
+	if config.passthrough {
+		return passthrough(t, required)
+	}
+
+	if err := checkKnown(pool, required, config.strict); err != nil {
+		t.Fatal(err)
+	}
+
 	for k, v := range required {
-		availableResource, ok := available[k]
+		availableResource, ok := pool[k]
 		if !ok || v > availableResource {
+			recordSkip(t.Name())
 			t.Skipf("test requires %d %s, %d available", v, k, availableResource)
 		}
 	}
 
+	if config.timeoutDumpMargin > 0 {
+		startTimeoutDump()
+	}
+
 	// This call pops the test onto the queue, and will respect go's standard
 	// concurrency guarantees...
-	t.Parallel()
+	if config.ordering == OrderingParallelFirst {
+		t.Parallel()
+	}
+
+	// Suites run for hours, so records share one copy of each distinct
+	// set of resources.
+	required = internResources(required)
 
-	wait := make(chan interface{})
+	// Only what is taken from the pool is scaled, the test, its instances
+	// and reports all see what it declared.
+	charged := internResources(scaleRequirements(required, pool, config.scale))
 
 	// Enqueue the test with the scheduler...
-	transaction := &transaction{
-		name: t.Name(),
-		item: &queueItem{
-			wait:     wait,
-			required: required,
-		},
+	r := &record{
+		id:       newAllocationID(),
+		name:     t.Name(),
+		required: required,
+		charged:  charged,
+		enqueued: time.Now(),
 	}
 
-	enqueue <- transaction
+	addRecord(r)
 
-	fmt.Printf("+++ ALLOC %s\n", t.Name())
+	var item *queueItem
+
+	if !grantInline(r) {
+		item = &queueItem{
+			wait:     make(chan interface{}),
+			required: charged,
+			enqueued: r.enqueued,
+			record:   r,
+		}
+
+		if err := submit(enqueue, item, "enqueue of "+r.name, enqueueTimeout()); err != nil {
+			cancelSubmit()
+			t.Fatal(err)
+		}
+	}
+
+	// Until the cleanup is registered, the test exiting, however that happens,
+	// must remove it from the queue and return anything it was granted.
+	registered := false
+
+	defer func() {
+		if !registered {
+			abandon(r)
+		}
+	}()
+
+	emit(t, event{
+		Action:    "alloc",
+		Test:      t.Name(),
+		ID:        r.id,
+		Resources: required,
+	})
 
 	// Wait for resource to become available...
-	<-wait
+	if item != nil {
+		waitForGrant(t, r, item.wait)
 
-	fmt.Printf("+++ SCHED %s\n", t.Name())
+		if err := item.err; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	emit(t, event{
+		Action:    "sched",
+		Test:      t.Name(),
+		ID:        r.id,
+		Resources: required,
+	})
+
+	scheduleRecord(r)
+	journal(journalSchedule, r)
+	setProfileLabels(r)
+
+	allocation := newAllocation(t, r)
 
-	start := time.Now()
+	track(allocation)
 
-	return func() {
-		fmt.Printf("+++ END   %s (%.2fs)\n", t.Name(), time.Since(start).Seconds())
+	// Resources are always returned, even if the test panics, calls FailNow
+	// or forgets to release them.
+	t.Cleanup(allocation.cleanup)
 
-		release <- required
+	registered = true
+
+	if config.ordering == OrderingAcquireFirst {
+		pauseRecord(r, true)
+		t.Parallel()
+		pauseRecord(r, false)
+	}
+
+	if err := allocation.provision(); err != nil {
+		allocation.release()
+		t.Fatalf("failed to acquire resource instances: %v", err)
 	}
+
+	return allocation
 }
@@ -17,16 +17,46 @@ limitations under the License.
 package testing_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"go.uber.org/goleak"
+
 	smtest "github.com/spjmurray/testing"
 )
 
 const (
 	ResourceCPU = "cpu"
 	ResourceRAM = "memory"
+
+	// ResourceBroken is provided by brokenProvider.
+	ResourceBroken = "broken"
+
+	// interruptEnvironmentVariable is the journal used when the test binary
+	// is re-run by TestInterrupt.
+	interruptEnvironmentVariable = "SMTEST_TEST_INTERRUPT"
+
+	// interruptNoJournal re-runs the test binary for TestInterrupt without
+	// a journal configured.
+	interruptNoJournal = "none"
+
+	// orderingEnvironmentVariable selects OrderingAcquireFirst when the
+	// test binary is re-run by TestOrdering.
+	orderingEnvironmentVariable = "SMTEST_TEST_ORDERING"
+
+	// abandonEnvironmentVariable is the backend log used when the test binary
+	// is re-run by TestAbandon.
+	abandonEnvironmentVariable = "SMTEST_TEST_ABANDON"
 )
 
 func TestMain(m *testing.M) {
@@ -35,9 +65,51 @@ func TestMain(m *testing.M) {
 		ResourceRAM: 64,
 	}
 
-	smtest.Start(resources)
+	options := []smtest.Option{
+		smtest.WithCPUResource(ResourceCPU),
+		smtest.WithMemoryResource(ResourceRAM, 1<<30),
+		smtest.WithSelfCheck(),
+	}
+
+	if os.Getenv(orderingEnvironmentVariable) == "acquire" {
+		options = append(options, smtest.WithOrdering(smtest.OrderingAcquireFirst))
+	}
+
+	if path := os.Getenv(interruptEnvironmentVariable); path != "" {
+		resources[ResourceBroken] = 1
+
+		options = append(options, smtest.WithInterruptCleanup(), smtest.WithProvider(ResourceBroken, brokenProvider{}))
 
-	os.Exit(m.Run())
+		if path != interruptNoJournal {
+			options = append(options, smtest.WithJournal(path, nil))
+		}
+	}
+
+	if path := os.Getenv(abandonEnvironmentVariable); path != "" {
+		options = append(options, smtest.WithBackend(&refusingBackend{path: path}))
+	}
+
+	smtest.Start(resources, options...)
+
+	code := m.Run()
+
+	if err := smtest.Verify(); err != nil {
+		fmt.Println(err)
+
+		code = 1
+	}
+
+	smtest.Report()
+	smtest.Stop()
+
+	// Nothing may be left running once stopped.
+	if err := goleak.Find(); err != nil {
+		fmt.Println(err)
+
+		code = 1
+	}
+
+	os.Exit(code)
 }
 
 func TestSuccess1(t *testing.T) {
@@ -84,6 +156,446 @@ func TestSuccess4(t *testing.T) {
 	time.Sleep(time.Second)
 }
 
+// TestNoRelease takes the whole pool and never releases it, TestSuccess4 can
+// only run if it's released automatically when the test completes.
+func TestNoRelease(t *testing.T) {
+	resources := smtest.ResourceSet{
+		ResourceCPU: 16,
+		ResourceRAM: 64,
+	}
+
+	smtest.Parallel(t, resources)
+}
+
+// TestSkipAfterAcquire takes the whole pool then is skipped by a later
+// precondition, the resources must be returned for the other tests to run.
+func TestSkipAfterAcquire(t *testing.T) {
+	resources := smtest.ResourceSet{
+		ResourceCPU: 16,
+		ResourceRAM: 64,
+	}
+
+	smtest.Acquire(t, resources)
+
+	t.Skip("precondition not met")
+}
+
+// TestStress runs many short tests, with varying requirements, concurrently
+// to shake out data races when run with -race.
+func TestStress(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		resources := smtest.ResourceSet{
+			ResourceCPU: i%4 + 1,
+			ResourceRAM: i%16 + 1,
+		}
+
+		t.Run(fmt.Sprintf("Test%d", i), func(t *testing.T) {
+			defer smtest.Parallel(t, resources)()
+		})
+	}
+}
+
+// TestRepeated re-runs the stress test in a new process with -count, -shuffle
+// and a high -parallel, so tests with identical names are queued together and
+// in a random order.
+func TestRepeated(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("runs the test binary")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^(TestStress|TestNoRelease|TestSkipAfterAcquire)$", "-test.count=3", "-test.shuffle=on", "-test.parallel=64")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("repeated run failed: %v\n%s", err, out)
+	}
+}
+
+// TestOrderingSiblings has subtests that collectively fit in the pool, so
+// succeed with either ordering.
+func TestOrderingSiblings(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprintf("Test%d", i), func(t *testing.T) {
+			defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 4})()
+
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+}
+
+// TestOrderingContended has subtests that each need the whole pool, so when
+// resources are acquired first the second holds up its parent, and the first
+// can't run in parallel to release them.  It's only run by TestOrdering.
+func TestOrderingContended(t *testing.T) {
+	if os.Getenv(orderingEnvironmentVariable) == "" {
+		t.Skip("run by TestOrdering")
+	}
+
+	for i := 0; i < 2; i++ {
+		t.Run(fmt.Sprintf("Test%d", i), func(t *testing.T) {
+			defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 16})()
+		})
+	}
+}
+
+// TestOrdering re-runs the ordering tests in a new process with -parallel=1,
+// for both orderings.
+func TestOrdering(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("runs the test binary")
+	}
+
+	tests := []struct {
+		ordering string
+		fail     bool
+	}{
+		{ordering: "parallel"},
+		{ordering: "acquire", fail: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.ordering, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestOrdering(Siblings|Contended)$", "-test.parallel=1")
+			cmd.Env = append(os.Environ(), orderingEnvironmentVariable+"="+test.ordering)
+
+			out, err := cmd.CombinedOutput()
+
+			if !test.fail {
+				if err != nil {
+					t.Fatalf("run failed: %v\n%s", err, out)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(string(out), "--- FAIL: TestOrderingContended/Test1") || !strings.Contains(string(out), smtest.ErrDeadlock.Error()) {
+				t.Fatalf("expected a detected deadlock: %v\n%s", err, out)
+			}
+
+			if strings.Contains(string(out), "--- FAIL: TestOrderingSiblings") {
+				t.Fatalf("expected siblings to succeed\n%s", out)
+			}
+		})
+	}
+}
+
+// brokenProvider provides instances that can't be cleaned up.
+type brokenProvider struct{}
+
+func (brokenProvider) Acquire(_ context.Context, id string) (any, error) {
+	return id, nil
+}
+
+func (brokenProvider) Release(_ context.Context, _ any) error {
+	return errors.New("instance is broken")
+}
+
+// TestInterrupted holds an allocation that can be cleaned up, and one that
+// can't, then interrupts itself.  It's only run by TestInterrupt.
+func TestInterrupted(t *testing.T) {
+	if os.Getenv(interruptEnvironmentVariable) == "" {
+		t.Skip("run by TestInterrupt")
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	t.Run("Broken", func(t *testing.T) {
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 1, ResourceBroken: 1})
+
+		wg.Done()
+
+		time.Sleep(time.Minute)
+	})
+
+	t.Run("Clean", func(t *testing.T) {
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 1})
+
+		wg.Done()
+		wg.Wait()
+
+		p, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.Signal(os.Interrupt); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Minute)
+	})
+}
+
+// interrupt re-runs TestInterrupted in a new process with the journal, and
+// returns its output.
+func interrupt(t *testing.T, journal string, env ...string) string {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInterrupted$", "-test.parallel=2")
+	cmd.Env = append(os.Environ(), interruptEnvironmentVariable+"="+journal)
+	cmd.Env = append(cmd.Env, env...)
+
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "1 allocations could not be cleaned up") {
+		t.Fatalf("expected interrupted run to fail: %v\n%s", err, out)
+	}
+
+	return string(out)
+}
+
+// checkInterruptJournal checks that only the allocation that couldn't be
+// cleaned up is left in the journal, and the output says so.
+func checkInterruptJournal(t *testing.T, path, out string) {
+	t.Helper()
+
+	if !strings.Contains(out, "they remain in the journal "+path) {
+		t.Fatalf("expected the journal %s to be reported\n%s", path, out)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := map[string]string{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry struct {
+			Action string `json:"action"`
+			ID     string `json:"id"`
+			Test   string `json:"test"`
+		}
+
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Action == "release" {
+			delete(pending, entry.ID)
+		} else {
+			pending[entry.ID] = entry.Test
+		}
+	}
+
+	if len(pending) != 1 {
+		t.Fatalf("expected one allocation in the journal, got %v", pending)
+	}
+
+	for _, test := range pending {
+		if test != "TestInterrupted/Broken" {
+			t.Fatalf("expected broken allocation in the journal, got %s", test)
+		}
+	}
+}
+
+// TestInterrupt re-runs TestInterrupted in a new process, and checks that only
+// the allocation that couldn't be cleaned up is left in the journal, or in one
+// under the temporary directory when none is configured.
+func TestInterrupt(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("runs the test binary")
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupt cannot be sent")
+	}
+
+	t.Run("Journal", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "journal")
+
+		checkInterruptJournal(t, path, interrupt(t, path))
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+
+		out := interrupt(t, interruptNoJournal, "TMPDIR="+dir)
+
+		paths, err := filepath.Glob(filepath.Join(dir, "smtest-journal-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(paths) != 1 {
+			t.Fatalf("expected one journal in %s, got %v", dir, paths)
+		}
+
+		checkInterruptJournal(t, paths[0], out)
+	})
+}
+
+// refusingBackend never grants CPU, so tests requiring it stay queued, and
+// panics when retrying anything else, which fails the scheduler.  Every call
+// is logged to the file, so the test can check what was released.
+type refusingBackend struct {
+	path  string
+	lock  sync.Mutex
+	tried map[string]bool
+}
+
+func (b *refusingBackend) log(action, id string) {
+	f, err := os.OpenFile(b.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		panic(err)
+	}
+
+	defer f.Close()
+
+	fmt.Fprintln(f, action, id)
+}
+
+func (b *refusingBackend) Acquire(_ context.Context, id string, required smtest.ResourceSet) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.log("acquire", id)
+
+	if b.tried == nil {
+		b.tried = map[string]bool{}
+	}
+
+	if required[ResourceCPU] == 0 && b.tried[id] {
+		panic("refusing backend retried")
+	}
+
+	b.tried[id] = true
+
+	return false, nil
+}
+
+func (b *refusingBackend) Renew(_ context.Context, _ string) error {
+	return nil
+}
+
+func (b *refusingBackend) Release(_ context.Context, id string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.log("release", id)
+
+	return nil
+}
+
+// TestAbandoned queues a test that is never granted its resources, then fails
+// the scheduler with another.  It's only run by TestAbandon.
+func TestAbandoned(t *testing.T) {
+	if os.Getenv(abandonEnvironmentVariable) == "" {
+		t.Skip("run by TestAbandon")
+	}
+
+	queued := make(chan struct{})
+
+	t.Run("Queued", func(t *testing.T) {
+		close(queued)
+
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 1})
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		<-queued
+
+		// Give the first test time to be queued with the scheduler.
+		time.Sleep(100 * time.Millisecond)
+
+		smtest.Acquire(t, smtest.ResourceSet{ResourceRAM: 1})
+	})
+}
+
+// TestAbandon re-runs TestAbandoned in a new process, and checks that every
+// allocation the backend was asked for, including the one never granted, was
+// released from it.
+func TestAbandon(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("runs the test binary")
+	}
+
+	path := filepath.Join(t.TempDir(), "backend")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAbandoned$", "-test.parallel=2")
+	cmd.Env = append(os.Environ(), abandonEnvironmentVariable+"="+path)
+
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), smtest.ErrSchedulerPanic.Error()) {
+		t.Fatalf("expected the scheduler to panic: %v\n%s", err, out)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := map[string]bool{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		action, id, _ := strings.Cut(line, " ")
+
+		pending[id] = action == "acquire"
+	}
+
+	if len(pending) != 2 {
+		t.Fatalf("expected two allocations in the backend log, got %v", pending)
+	}
+
+	for id, held := range pending {
+		if held {
+			t.Fatalf("expected allocation %s to be released from the backend\n%s", id, data)
+		}
+	}
+}
+
+// TestScaled checks a scaled allocation takes more from the pool, but the test
+// still sees what it declared.  It's only run by TestScaling.
+func TestScaled(t *testing.T) {
+	if os.Getenv("SMTEST_SCALE") == "" {
+		t.Skip("run by TestScaling")
+	}
+
+	a := smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 4})
+
+	if resources := a.Resources(); resources[ResourceCPU] != 4 {
+		t.Fatalf("expected the declared requirement, got %v", resources)
+	}
+
+	if free := smtest.Stats().Free[ResourceCPU]; free != 8 {
+		t.Fatalf("expected the scaled requirement to be taken from the pool, %d free", free)
+	}
+}
+
+// TestScaling re-runs TestScaled in a new process with a scale set.
+func TestScaling(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("runs the test binary")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestScaled$")
+	cmd.Env = append(os.Environ(), "SMTEST_SCALE=0.5")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("scaled run failed: %v\n%s", err, out)
+	}
+}
+
 func TestSkip1(t *testing.T) {
 	resources := smtest.ResourceSet{
 		ResourceCPU: 32,
@@ -99,3 +611,40 @@ func TestSkip2(t *testing.T) {
 
 	defer smtest.Parallel(t, resources)()
 }
+
+// TestResize is not parallel as it resizes the pool shared by all tests.
+func TestResize(t *testing.T) {
+	resources := smtest.ResourceSet{
+		ResourceCPU: 16,
+		ResourceRAM: 64,
+	}
+
+	defer func() {
+		if err := smtest.Resize(resources); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if err := smtest.Resize(smtest.ResourceSet{ResourceCPU: 32, ResourceRAM: 64}); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := false
+
+	// Acquire makes the test parallel, so group it to wait for it to end.
+	t.Run("Group", func(t *testing.T) {
+		t.Run("Large", func(t *testing.T) {
+			defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 32})()
+
+			ran = true
+		})
+	})
+
+	if !ran {
+		t.Fatal("expected test requiring the resized pool to run")
+	}
+
+	if err := smtest.Resize(smtest.ResourceSet{ResourceCPU: -1}); !errors.Is(err, smtest.ErrInvalidResourceSet) {
+		t.Fatalf("expected invalid resources, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tuiEnvironmentVariable enables the live terminal view when set to
+	// a non-empty value.
+	tuiEnvironmentVariable = "SMTEST_TUI"
+
+	// tuiInterval is how often the terminal view is redrawn.
+	tuiInterval = 500 * time.Millisecond
+
+	// tuiTerminal is where the view is drawn, this bypasses go test's
+	// own output handling.
+	tuiTerminal = "/dev/tty"
+)
+
+var (
+	// tuiLock protects the terminal view.
+	tuiLock sync.Mutex
+
+	// tui, if set, is the terminal the live view is drawn on.
+	tui *os.File
+
+	// tuiDone is closed to stop drawing.
+	tuiDone chan interface{}
+)
+
+// startTUI starts the live terminal view if enabled.
+func startTUI() {
+	if os.Getenv(tuiEnvironmentVariable) == "" {
+		return
+	}
+
+	f, err := os.OpenFile(tuiTerminal, os.O_WRONLY, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: terminal view disabled: %v\n", err)
+		return
+	}
+
+	tuiLock.Lock()
+	defer tuiLock.Unlock()
+
+	tui = f
+	tuiDone = make(chan interface{})
+
+	// Switch to the alternate screen and hide the cursor.
+	fmt.Fprint(tui, "\x1b[?1049h\x1b[?25l")
+
+	done := tuiDone
+
+	// Run via the scheduler's wait group so Stop doesn't return with a
+	// redraw still in flight.
+	run(func() {
+		ticker := time.NewTicker(tuiInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				drawTUI()
+			}
+		}
+	})
+}
+
+// stopTUI stops the live terminal view and restores the terminal.
+func stopTUI() {
+	tuiLock.Lock()
+	defer tuiLock.Unlock()
+
+	if tui == nil {
+		return
+	}
+
+	close(tuiDone)
+
+	fmt.Fprint(tui, "\x1b[?25h\x1b[?1049l")
+
+	tui.Close()
+	tui = nil
+}
+
+// tuiActive returns whether the live view is being drawn.
+func tuiActive() bool {
+	tuiLock.Lock()
+	defer tuiLock.Unlock()
+
+	return tui != nil
+}
+
+// drawTUI draws a single frame of the live view.
+func drawTUI() {
+	s := getState()
+
+	now := time.Now()
+
+	var b strings.Builder
+
+	// Clear the screen and home the cursor.
+	b.WriteString("\x1b[H\x1b[2J")
+
+	fmt.Fprintf(&b, "smtest %s (%.0fs)\n\n", now.Format(time.TimeOnly), now.Sub(started).Seconds())
+
+	b.WriteString("RESOURCES\n")
+
+	pool := capacity()
+
+	keys := make([]string, 0, len(pool))
+
+	for k := range pool {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %-16s %6d/%-6d free\n", k, s.unallocated[k], pool[k])
+	}
+
+	recordsLock.Lock()
+
+	var holders []*record
+
+	for r := range held {
+		holders = append(holders, r)
+	}
+
+	recordsLock.Unlock()
+
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].scheduled.Before(holders[j].scheduled)
+	})
+
+	fmt.Fprintf(&b, "\nRUNNING (%d)\n", len(holders))
+
+	for _, r := range holders {
+		fmt.Fprintf(&b, "  %8.1fs %s %v\n", now.Sub(r.scheduled).Seconds(), r.name, r.required)
+	}
+
+	fmt.Fprintf(&b, "\nQUEUED (%d)\n", len(s.waiting))
+
+	for _, w := range s.waiting {
+		fmt.Fprintf(&b, "  %8.1fs %s %v\n", now.Sub(w.enqueued).Seconds(), w.name, w.required)
+	}
+
+	tuiLock.Lock()
+	defer tuiLock.Unlock()
+
+	if tui != nil {
+		fmt.Fprint(tui, b.String())
+	}
+}
@@ -16,5 +16,56 @@ limitations under the License.
 
 package testing
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 // ResourceSet is a map of a quantifiable resource to an integral amount.
 type ResourceSet map[string]int
+
+// String returns the resource set in a canonical form e.g. "cpu=8,memory=32".
+func (r ResourceSet) String() string {
+	keys := make([]string, 0, len(r))
+
+	for k := range r {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, r[k])
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// ParseResourceSet parses a resource set in the form returned by String.
+func ParseResourceSet(s string) (ResourceSet, error) {
+	r := ResourceSet{}
+
+	if s == "" {
+		return r, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%w: resource %q not of the form name=value", ErrInvalidResourceSet, part)
+		}
+
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: resource %q value: %w", ErrInvalidResourceSet, part, err)
+		}
+
+		r[k] = value
+	}
+
+	return r, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processUsage returns the CPU time consumed by the process so far, and its
+// resident set size in bytes.
+func processUsage() (time.Duration, int64) {
+	var rusage syscall.Rusage
+
+	var cpu time.Duration
+
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err == nil {
+		cpu = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+	}
+
+	// The second field of statm is the number of resident pages.
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return cpu, 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return cpu, 0
+	}
+
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return cpu, 0
+	}
+
+	return cpu, pages * int64(os.Getpagesize())
+}
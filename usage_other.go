@@ -0,0 +1,43 @@
+//go:build !linux
+
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+// processUsage returns the CPU time consumed by the process so far, and its
+// resident set size in bytes.  Without a portable way of querying the operating
+// system, these are estimated by the Go runtime.
+func processUsage() (time.Duration, int64) {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/total:cpu-seconds"},
+		{Name: "/cpu/classes/idle:cpu-seconds"},
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+
+	metrics.Read(samples)
+
+	cpu := samples[0].Value.Float64() - samples[1].Value.Float64()
+	memory := samples[2].Value.Uint64() - samples[3].Value.Uint64()
+
+	return time.Duration(cpu * float64(time.Second)), int64(memory)
+}
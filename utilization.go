@@ -0,0 +1,181 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// utilizationEnvironmentVariable names a file that pool utilization
+	// over time is written to by Report.  Files with a .svg extension are
+	// written as a heatmap image, anything else as JSON.
+	utilizationEnvironmentVariable = "SMTEST_UTILIZATION"
+
+	// utilizationInterval is how often pool utilization is sampled.
+	utilizationInterval = time.Second
+
+	// heatmapCell is the size of a single heatmap cell in pixels.
+	heatmapCell = 12
+
+	// heatmapLabelWidth is the space given to resource names in pixels.
+	heatmapLabelWidth = 120
+)
+
+// UtilizationSample is the fraction of each resource that was allocated at
+// a point in time.
+type UtilizationSample struct {
+	// Time is when the sample was taken.
+	Time time.Time `json:"time"`
+
+	// Utilization maps resource names to the fraction, from 0 to 1, of
+	// that resource that was allocated.
+	Utilization map[string]float64 `json:"utilization"`
+}
+
+// Utilization is the history of pool utilization over a run, as written to
+// the file named by the SMTEST_UTILIZATION environment variable.
+type Utilization struct {
+	// Resources are the resource names in a stable order.
+	Resources []string `json:"resources"`
+
+	// Interval is the time between samples.
+	Interval time.Duration `json:"interval"`
+
+	// Samples are the utilization samples in time order.
+	Samples []UtilizationSample `json:"samples"`
+}
+
+var (
+	// utilizationLock protects utilizationSamples.
+	utilizationLock sync.Mutex
+
+	// utilizationSamples is the utilization history.
+	utilizationSamples []UtilizationSample
+)
+
+// sampleUtilization is called periodically by the scheduler to record the
+// fraction of every resource that is currently allocated.
+func sampleUtilization(now time.Time) {
+	sample := UtilizationSample{
+		Time:        now,
+		Utilization: map[string]float64{},
+	}
+
+	free := local.Free()
+
+	for k, v := range capacity() {
+		if v > 0 {
+			sample.Utilization[k] = float64(v-free[k]) / float64(v)
+		}
+	}
+
+	utilizationLock.Lock()
+	defer utilizationLock.Unlock()
+
+	utilizationSamples = append(utilizationSamples, sample)
+}
+
+// utilization returns the utilization history.
+func utilization() *Utilization {
+	u := &Utilization{
+		Interval: utilizationInterval,
+	}
+
+	for k := range capacity() {
+		u.Resources = append(u.Resources, k)
+	}
+
+	sort.Strings(u.Resources)
+
+	utilizationLock.Lock()
+	defer utilizationLock.Unlock()
+
+	u.Samples = append(u.Samples, utilizationSamples...)
+
+	return u
+}
+
+// writeHeatmap draws the utilization history as an SVG heatmap, one row per
+// resource and one column per sample, where darker cells are busier.
+func writeHeatmap(w io.Writer, u *Utilization) error {
+	width := heatmapLabelWidth + len(u.Samples)*heatmapCell
+	height := len(u.Resources) * heatmapCell
+
+	if _, err := fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"monospace\" font-size=\"10\">\n", width, height); err != nil {
+		return err
+	}
+
+	for row, k := range u.Resources {
+		y := row * heatmapCell
+
+		if _, err := fmt.Fprintf(w, "<text x=\"0\" y=\"%d\">%s</text>\n", y+heatmapCell-2, k); err != nil {
+			return err
+		}
+
+		for column, sample := range u.Samples {
+			x := heatmapLabelWidth + column*heatmapCell
+
+			// Interpolate from white when idle to red when saturated.
+			shade := int(255 * (1 - sample.Utilization[k]))
+
+			if _, err := fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"rgb(255,%d,%d)\"><title>%s %s %.0f%%</title></rect>\n", x, y, heatmapCell, heatmapCell, shade, shade, k, sample.Time.Format(time.TimeOnly), sample.Utilization[k]*100); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintln(w, "</svg>")
+
+	return err
+}
+
+// writeUtilization writes the utilization history, if configured, in the
+// format implied by the file extension.
+func writeUtilization() {
+	path := os.Getenv(utilizationEnvironmentVariable)
+	if path == "" {
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to create utilization file: %v\n", err)
+		return
+	}
+
+	defer f.Close()
+
+	u := utilization()
+
+	if filepath.Ext(path) == ".svg" {
+		err = writeHeatmap(f, u)
+	} else {
+		err = json.NewEncoder(f).Encode(u)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smtest: failed to write utilization file: %v\n", err)
+	}
+}
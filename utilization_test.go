@@ -0,0 +1,56 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeatmap(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	u := &Utilization{
+		Resources: []string{"cpu", "memory"},
+		Interval:  time.Second,
+		Samples: []UtilizationSample{
+			{Time: now, Utilization: map[string]float64{"cpu": 1, "memory": 0}},
+			{Time: now.Add(time.Second), Utilization: map[string]float64{"cpu": 0.5, "memory": 0.25}},
+		},
+	}
+
+	var b strings.Builder
+
+	if err := writeHeatmap(&b, u); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := b.String()
+
+	if n := strings.Count(svg, "<rect"); n != 4 {
+		t.Fatalf("expected 4 cells, got %d", n)
+	}
+
+	for _, fill := range []string{"rgb(255,0,0)", "rgb(255,255,255)", "rgb(255,127,127)", "rgb(255,191,191)"} {
+		if !strings.Contains(svg, fill) {
+			t.Fatalf("expected cell with fill %s", fill)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrLeak is returned by Verify when resources were never returned.
+	ErrLeak = errors.New("resources were not returned")
+
+	// ErrSkipBudget is returned by Verify when more tests were skipped for
+	// lack of resources than allowed by WithSkipBudget.
+	ErrSkipBudget = errors.New("skip budget exceeded")
+)
+
+// skipped are the tests that were skipped because they required more than the
+// pool has, protected by recordsLock.
+var skipped []string
+
+// recordSkip remembers that a test was skipped for lack of resources.
+func recordSkip(name string) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	skipped = append(skipped, name)
+}
+
+// leaks compares the free resources against the pool, returning an error
+// naming the holders of any that are missing.
+func leaks(pool, free ResourceSet, holders []*record) error {
+	missing := ResourceSet{}
+
+	for k, v := range pool {
+		if short := v - free[k]; short > 0 {
+			missing[k] = short
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(holders))
+
+	for _, r := range holders {
+		names = append(names, fmt.Sprintf("%s %v", r.name, r.required))
+	}
+
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return fmt.Errorf("%w: %v", ErrLeak, missing)
+	}
+
+	return fmt.Errorf("%w: %v held by %s", ErrLeak, missing, strings.Join(names, ", "))
+}
+
+// overBudget returns an error naming the skipped tests if more were skipped, of
+// the total that required resources, than the limit or fraction allow.
+func overBudget(skipped []string, total, limit int, fraction float64) error {
+	count := len(skipped)
+
+	if count == 0 {
+		return nil
+	}
+
+	var reason string
+
+	switch {
+	case limit >= 0 && count > limit:
+		reason = fmt.Sprintf("%d tests skipped, at most %d allowed", count, limit)
+	case fraction >= 0 && float64(count) > fraction*float64(total):
+		reason = fmt.Sprintf("%d of %d tests skipped, at most %g%% allowed", count, total, fraction*100)
+	default:
+		return nil
+	}
+
+	names := append([]string(nil), skipped...)
+
+	sort.Strings(names)
+
+	return fmt.Errorf("%w: %s: %s", ErrSkipBudget, reason, strings.Join(names, ", "))
+}
+
+// Verify checks that every resource has been returned to the pool, and if not
+// returns an error wrapping ErrLeak listing the tests that still hold them.
+// When WithSkipBudget is set, it also checks that too many tests weren't
+// skipped for lack of resources, and if so the error wraps ErrSkipBudget.
+// Failures are also raised as alerts, AlertLeak and AlertBudget respectively.
+// It should be called from TestMain once all tests have completed e.g.
+//
+//	code := m.Run()
+//
+//	if err := smtest.Verify(); err != nil {
+//	  fmt.Println(err)
+//	  code = 1
+//	}
+func Verify() error {
+	if err := checkStarted(capacity()); err != nil {
+		return err
+	}
+
+	s := getState()
+
+	recordsLock.Lock()
+
+	holders := make([]*record, 0, len(held))
+
+	for r := range held {
+		holders = append(holders, r)
+	}
+
+	var budget error
+
+	if config.skipBudget {
+		budget = overBudget(skipped, allocationCount+len(skipped), config.skipLimit, config.skipFraction)
+	}
+
+	recordsLock.Unlock()
+
+	leak := leaks(capacity(), s.unallocated, holders)
+
+	if leak != nil {
+		raise(Alert{
+			Kind:    AlertLeak,
+			Message: leak.Error(),
+		})
+	}
+
+	if budget != nil {
+		raise(Alert{
+			Kind:    AlertBudget,
+			Message: budget.Error(),
+		})
+	}
+
+	return errors.Join(leak, budget)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLeaks(t *testing.T) {
+	t.Parallel()
+
+	pool := ResourceSet{"cpu": 8, "memory": 16}
+
+	if err := leaks(pool, ResourceSet{"cpu": 8, "memory": 16}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	holders := []*record{
+		{name: "TestLeaky", required: ResourceSet{"cpu": 2}},
+	}
+
+	err := leaks(pool, ResourceSet{"cpu": 6, "memory": 16}, holders)
+	if !errors.Is(err, ErrLeak) {
+		t.Fatalf("expected a leak, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "TestLeaky") {
+		t.Fatalf("expected the holder to be named, got %v", err)
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	t.Parallel()
+
+	skipped := []string{"TestB", "TestA"}
+
+	// Within budget, or unchecked.
+	for _, budget := range []struct {
+		limit    int
+		fraction float64
+	}{
+		{limit: 2, fraction: -1},
+		{limit: -1, fraction: 0.2},
+		{limit: -1, fraction: -1},
+	} {
+		if err := overBudget(skipped, 10, budget.limit, budget.fraction); err != nil {
+			t.Fatalf("expected %+v to be within budget, got %v", budget, err)
+		}
+	}
+
+	if err := overBudget(nil, 10, 0, 0); err != nil {
+		t.Fatalf("expected no skips to be within budget, got %v", err)
+	}
+
+	err := overBudget(skipped, 10, 1, -1)
+	if !errors.Is(err, ErrSkipBudget) {
+		t.Fatalf("expected the limit to be exceeded, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "TestA, TestB") {
+		t.Fatalf("expected the skipped tests to be named, got %v", err)
+	}
+
+	if err := overBudget(skipped, 10, -1, 0.1); !errors.Is(err, ErrSkipBudget) {
+		t.Fatalf("expected the fraction to be exceeded, got %v", err)
+	}
+}
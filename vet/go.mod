@@ -0,0 +1,13 @@
+module github.com/spjmurray/testing/vet
+
+go 1.25.0
+
+require (
+	github.com/spjmurray/testing v0.1.0
+	golang.org/x/tools v0.47.0
+)
+
+require (
+	golang.org/x/mod v0.37.0 // indirect
+	golang.org/x/sync v0.21.0 // indirect
+)
@@ -0,0 +1,404 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vet
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"sort"
+
+	smtest "github.com/spjmurray/testing"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+// OvercommitAnalyzer reports tests that require more of a resource than the
+// pool has, so are always skipped, at review time rather than as a skip that
+// goes unnoticed.  What a test requires is the most, of the constant
+// requirements passed to Parallel or Acquire in its body, that it provably
+// holds at the same time, so is the minimum it needs.  Requests released
+// before the next is made, or made in different branches, loop bodies or
+// blocks, which may not all run, are not counted together.  Subtests and
+// other function literals are checked on their own.  The pool is set with the
+// -pool flag, or is that passed to Start in the same package.
+var OvercommitAnalyzer = &analysis.Analyzer{
+	Name:     "overcommit",
+	Doc:      "report tests that require more resources than the pool has",
+	URL:      "https://pkg.go.dev/github.com/spjmurray/testing/vet",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      runOvercommit,
+}
+
+// poolFlag is the pool to check tests against e.g. cpu=16,memory=64.
+var poolFlag string
+
+func init() {
+	OvercommitAnalyzer.Flags.StringVar(&poolFlag, "pool", "", "pool to check tests against e.g. cpu=16,memory=64, by default that passed to smtest.Start")
+}
+
+// resourceAmounts returns the constant amounts in a resource set literal, and
+// false if any of the names or amounts are not constant.
+func resourceAmounts(pass *analysis.Pass, lit *ast.CompositeLit) (smtest.ResourceSet, bool) {
+	amounts := smtest.ResourceSet{}
+
+	all := true
+
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			all = false
+
+			continue
+		}
+
+		key, ok := pass.TypesInfo.Types[kv.Key]
+		if !ok || key.Value == nil || key.Value.Kind() != constant.String {
+			all = false
+
+			continue
+		}
+
+		value, ok := pass.TypesInfo.Types[kv.Value]
+		if !ok || value.Value == nil || value.Value.Kind() != constant.Int {
+			all = false
+
+			continue
+		}
+
+		amount, ok := constant.Int64Val(value.Value)
+		if !ok {
+			all = false
+
+			continue
+		}
+
+		amounts[constant.StringVal(key.Value)] += int(amount)
+	}
+
+	return amounts, all
+}
+
+// targetPool returns the pool to check tests against, and false if there
+// isn't one.  When Start is called more than once, the largest amount of each
+// resource is used.
+func targetPool(pass *analysis.Pass, insp *inspector.Inspector) (smtest.ResourceSet, bool, error) {
+	if poolFlag != "" {
+		pool, err := smtest.ParseResourceSet(poolFlag)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return pool, true, nil
+	}
+
+	pools, ok := startPools(pass, insp)
+	if !ok {
+		return nil, false, nil
+	}
+
+	result := smtest.ResourceSet{}
+
+	for _, lit := range pools {
+		amounts, ok := resourceAmounts(pass, lit)
+		if !ok {
+			return nil, false, nil
+		}
+
+		for k, v := range amounts {
+			if v > result[k] {
+				result[k] = v
+			}
+		}
+	}
+
+	return result, true, nil
+}
+
+// add adds the amounts to the total.
+func add(total, amounts smtest.ResourceSet) {
+	for k, v := range amounts {
+		total[k] += v
+	}
+}
+
+// raise raises the peak to the amounts, where they are greater.
+func raise(peak, amounts smtest.ResourceSet) {
+	for k, v := range amounts {
+		if v > peak[k] {
+			peak[k] = v
+		}
+	}
+}
+
+// requests returns the sum of constant requirements made directly by the
+// statement, ignoring nested function literals, and the object the result of
+// the request is assigned to, if there is exactly one request.
+func requests(pass *analysis.Pass, stmt ast.Node) (smtest.ResourceSet, types.Object) {
+	required := smtest.ResourceSet{}
+
+	var calls []*ast.CallExpr
+
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if !isRequest(pass, n) || len(n.Args) < 2 {
+				return true
+			}
+
+			lit, ok := ast.Unparen(n.Args[1]).(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+
+			amounts, _ := resourceAmounts(pass, lit)
+
+			add(required, amounts)
+
+			calls = append(calls, n)
+		}
+
+		return true
+	})
+
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || len(calls) != 1 || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 || ast.Unparen(assign.Rhs[0]) != calls[0] {
+		return required, nil
+	}
+
+	ident, ok := assign.Lhs[0].(*ast.Ident)
+	if !ok {
+		return required, nil
+	}
+
+	return required, pass.TypesInfo.ObjectOf(ident)
+}
+
+// releasedBy returns whether the statement releases the allocation held by
+// the object, either by calling the release function returned by Parallel, or
+// the Release method of the allocation returned by Acquire.  Deferred calls
+// and function literals are ignored as they release later, if at all.
+func releasedBy(pass *analysis.Pass, stmt ast.Stmt, obj types.Object) bool {
+	is := func(e ast.Expr) bool {
+		ident, ok := ast.Unparen(e).(*ast.Ident)
+
+		return ok && pass.TypesInfo.Uses[ident] == obj
+	}
+
+	found := false
+
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit, *ast.DeferStmt, *ast.GoStmt:
+			return false
+		case *ast.CallExpr:
+			if is(n.Fun) {
+				found = true
+			}
+
+			if sel, ok := ast.Unparen(n.Fun).(*ast.SelectorExpr); ok && sel.Sel.Name == "Release" && is(sel.X) {
+				found = true
+			}
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// held is an allocation made in a block.
+type held struct {
+	// amounts are what was requested.
+	amounts smtest.ResourceSet
+
+	// obj is what the allocation is assigned to, if anything.
+	obj types.Object
+}
+
+// blockRequirement returns the most the statements hold at once.  Allocations
+// are held from their request until the end of the block, or until they are
+// released by a later statement.  Only one branch of an if or switch runs, so
+// a nested statement counts the most of any of its branches, on top of what is
+// held when it is entered.  To never report a test that can run, what it
+// requests isn't counted against the statements that follow it.
+func blockRequirement(pass *analysis.Pass, stmts []ast.Stmt) smtest.ResourceSet {
+	peak := smtest.ResourceSet{}
+
+	var holding []held
+
+	total := func() smtest.ResourceSet {
+		sum := smtest.ResourceSet{}
+
+		for _, h := range holding {
+			add(sum, h.amounts)
+		}
+
+		return sum
+	}
+
+	// nested adds the requirement of a nested statement to what is held.
+	nested := func(required smtest.ResourceSet) {
+		sum := total()
+
+		add(sum, required)
+		raise(peak, sum)
+	}
+
+	// simple holds the requests made by the statement.
+	simple := func(stmt ast.Stmt) {
+		if stmt == nil {
+			return
+		}
+
+		amounts, obj := requests(pass, stmt)
+
+		holding = append(holding, held{amounts: amounts, obj: obj})
+
+		raise(peak, total())
+	}
+
+	for _, stmt := range stmts {
+		if labeled, ok := stmt.(*ast.LabeledStmt); ok {
+			stmt = labeled.Stmt
+		}
+
+		// Anything released by this statement is no longer held by any
+		// that follow it.
+		kept := holding[:0]
+
+		for _, h := range holding {
+			if h.obj == nil || !releasedBy(pass, stmt, h.obj) {
+				kept = append(kept, h)
+			}
+		}
+
+		holding = kept
+
+		switch stmt := stmt.(type) {
+		case *ast.BlockStmt:
+			nested(blockRequirement(pass, stmt.List))
+		case *ast.IfStmt:
+			simple(stmt.Init)
+			nested(ifRequirement(pass, stmt))
+		case *ast.ForStmt:
+			simple(stmt.Init)
+			nested(blockRequirement(pass, stmt.Body.List))
+		case *ast.RangeStmt:
+			nested(blockRequirement(pass, stmt.Body.List))
+		case *ast.SwitchStmt:
+			simple(stmt.Init)
+			nested(clausesRequirement(pass, stmt.Body))
+		case *ast.TypeSwitchStmt:
+			simple(stmt.Init)
+			nested(clausesRequirement(pass, stmt.Body))
+		case *ast.SelectStmt:
+			nested(clausesRequirement(pass, stmt.Body))
+		default:
+			simple(stmt)
+		}
+	}
+
+	return peak
+}
+
+// ifRequirement returns the most either branch of the if statement holds.
+func ifRequirement(pass *analysis.Pass, stmt *ast.IfStmt) smtest.ResourceSet {
+	required := blockRequirement(pass, stmt.Body.List)
+
+	switch e := stmt.Else.(type) {
+	case *ast.BlockStmt:
+		raise(required, blockRequirement(pass, e.List))
+	case *ast.IfStmt:
+		raise(required, ifRequirement(pass, e))
+	}
+
+	return required
+}
+
+// clausesRequirement returns the most any case of a switch or select holds.
+func clausesRequirement(pass *analysis.Pass, body *ast.BlockStmt) smtest.ResourceSet {
+	required := smtest.ResourceSet{}
+
+	for _, stmt := range body.List {
+		switch clause := stmt.(type) {
+		case *ast.CaseClause:
+			raise(required, blockRequirement(pass, clause.Body))
+		case *ast.CommClause:
+			raise(required, blockRequirement(pass, clause.Body))
+		}
+	}
+
+	return required
+}
+
+func runOvercommit(pass *analysis.Pass) (any, error) {
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	pool, ok, err := targetPool(pass, insp)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	insp.Preorder([]ast.Node{(*ast.FuncDecl)(nil), (*ast.FuncLit)(nil)}, func(n ast.Node) {
+		var body *ast.BlockStmt
+
+		var pos token.Pos
+
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+			pos = n.Name.Pos()
+		case *ast.FuncLit:
+			body = n.Body
+			pos = n.Pos()
+		}
+
+		if body == nil {
+			return
+		}
+
+		required := blockRequirement(pass, body.List)
+
+		names := make([]string, 0, len(required))
+
+		for k := range required {
+			names = append(names, k)
+		}
+
+		sort.Strings(names)
+
+		for _, k := range names {
+			// Resources missing from the pool passed to Start are reported by
+			// Analyzer.
+			if _, ok := pool[k]; !ok && poolFlag == "" {
+				continue
+			}
+
+			if required[k] > pool[k] {
+				pass.Reportf(pos, "requests %d %s, pool is %d, so it can never run", required[k], k, pool[k])
+			}
+		}
+	})
+
+	return nil, nil
+}
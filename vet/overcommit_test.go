@@ -0,0 +1,49 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vet
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// TestOvercommit checks tests requiring more than the pool passed to Start
+// are reported.
+func TestOvercommit(t *testing.T) {
+	t.Parallel()
+
+	analysistest.Run(t, analysistest.TestData(), OvercommitAnalyzer, "overcommit", "config")
+}
+
+// TestOvercommitPool checks tests requiring more than the pool set with the
+// flag are reported.
+//
+// TestOvercommitPool is not parallel as it modifies the analyzer's flags.
+func TestOvercommitPool(t *testing.T) {
+	if err := OvercommitAnalyzer.Flags.Set("pool", "cpu=4"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := OvercommitAnalyzer.Flags.Set("pool", ""); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	analysistest.Run(t, analysistest.TestData(), OvercommitAnalyzer, "overcommitpool")
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+// The pool is modified after initialization, so can't be checked.
+var pool = smtest.ResourceSet{
+	"cpu": 16,
+}
+
+func TestMain(m *testing.M) {
+	pool["gpu"] = 1
+
+	smtest.Start(pool)
+
+	os.Exit(m.Run())
+}
+
+func TestModified(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{"gpu": 1})()
+}
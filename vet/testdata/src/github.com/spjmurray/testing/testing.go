@@ -0,0 +1,16 @@
+// Package testing is a stub of the scheduler's API.
+package testing
+
+import "testing"
+
+type ResourceSet map[string]int
+
+type Allocation struct{}
+
+func (a *Allocation) Release() {}
+
+func Start(resources ResourceSet) {}
+
+func Parallel(t *testing.T, required ResourceSet) func() { return nil }
+
+func Acquire(t *testing.T, required ResourceSet) *Allocation { return nil }
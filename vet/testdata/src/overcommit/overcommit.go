@@ -0,0 +1,104 @@
+package overcommit
+
+import (
+	"os"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const ResourceCPU = "cpu"
+
+func TestMain(m *testing.M) {
+	smtest.Start(smtest.ResourceSet{ResourceCPU: 16})
+
+	os.Exit(m.Run())
+}
+
+func TestFits(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 16})()
+}
+
+func TestTooBig(t *testing.T) { // want `requests 32 cpu, pool is 16, so it can never run`
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 32})()
+}
+
+func TestHeldTogether(t *testing.T) { // want `requests 20 cpu, pool is 16, so it can never run`
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})()
+
+	smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+}
+
+func TestUnknown(t *testing.T) {
+	n := 32
+
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: n})()
+}
+
+func TestSubtests(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 8})()
+
+	t.Run("fits", func(t *testing.T) {
+		defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 16})()
+	})
+
+	t.Run("too big", func(t *testing.T) { // want `requests 17 cpu, pool is 16, so it can never run`
+		defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 17})()
+	})
+}
+
+func TestReleasedInTurn(t *testing.T) {
+	release := smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})
+
+	release()
+
+	a := smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+
+	a.Release()
+
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})()
+}
+
+func TestReleasedLater(t *testing.T) { // want `requests 20 cpu, pool is 16, so it can never run`
+	release := smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})
+	defer release()
+
+	smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+}
+
+func TestBranches(t *testing.T) {
+	if testing.Short() {
+		defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})()
+	} else if testing.Verbose() {
+		defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 12})()
+	} else {
+		defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 14})()
+	}
+}
+
+func TestCases(t *testing.T) {
+	switch {
+	case testing.Short():
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 16})
+	default:
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 16})
+	}
+}
+
+func TestScopes(t *testing.T) {
+	{
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+	}
+
+	for i := 0; i < 2; i++ {
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+	}
+}
+
+func TestBranchHeld(t *testing.T) { // want `requests 20 cpu, pool is 16, so it can never run`
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 10})()
+
+	if testing.Short() {
+		smtest.Acquire(t, smtest.ResourceSet{ResourceCPU: 10})
+	}
+}
@@ -0,0 +1,19 @@
+package overcommitpool
+
+import (
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestFits(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{"cpu": 4})()
+}
+
+func TestTooBig(t *testing.T) { // want `requests 8 cpu, pool is 4, so it can never run`
+	defer smtest.Parallel(t, smtest.ResourceSet{"cpu": 8})()
+}
+
+func TestMissing(t *testing.T) { // want `requests 1 gpu, pool is 0, so it can never run`
+	defer smtest.Parallel(t, smtest.ResourceSet{"gpu": 1})()
+}
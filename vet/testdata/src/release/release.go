@@ -0,0 +1,52 @@
+package release
+
+import (
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func Deferred(t *testing.T) {
+	defer smtest.Parallel(t, nil)()
+}
+
+func DeferredVariable(t *testing.T) {
+	release := smtest.Parallel(t, nil)
+	defer release()
+}
+
+func Cleanup(t *testing.T) {
+	t.Cleanup(smtest.Parallel(t, nil))
+}
+
+func CleanupVariable(t *testing.T) {
+	release := smtest.Parallel(t, nil)
+	t.Cleanup(release)
+}
+
+func Returned(t *testing.T) func() {
+	var release = smtest.Parallel(t, nil)
+
+	return release
+}
+
+// Resources are returned when the test ends, so needn't be released early.
+func Discarded(t *testing.T) {
+	smtest.Parallel(t, nil)
+}
+
+func Blank(t *testing.T) {
+	_ = smtest.Parallel(t, nil)
+}
+
+func Unused(t *testing.T) {
+	release := smtest.Parallel(t, nil) // want `the release function returned by Parallel, release, is never called`
+	_ = release
+}
+
+func Closure(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {
+		release := smtest.Parallel(t, nil) // want `the release function returned by Parallel, release, is never called`
+		_ = release
+	})
+}
@@ -0,0 +1,22 @@
+package start
+
+import (
+	"os"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+func TestMain(m *testing.M) {
+	smtest.Acquire(nil, nil) // want `resources are requested before smtest.Start is called`
+
+	code := m.Run() // want `tests are run before smtest.Start is called, so they fail to request resources`
+
+	smtest.Start(smtest.ResourceSet{"cpu": 1})
+
+	os.Exit(code)
+}
+
+func TestExample(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{"cpu": 1})()
+}
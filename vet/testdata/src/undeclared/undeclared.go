@@ -0,0 +1,33 @@
+package undeclared
+
+import (
+	"os"
+	"testing"
+
+	smtest "github.com/spjmurray/testing"
+)
+
+const (
+	ResourceCPU    = "cpu"
+	ResourceMemory = "memory"
+	ResourceGPU    = "gpu"
+)
+
+var pool = smtest.ResourceSet{
+	ResourceCPU:    16,
+	ResourceMemory: 64,
+}
+
+func TestMain(m *testing.M) {
+	smtest.Start(pool)
+
+	os.Exit(m.Run())
+}
+
+func TestDeclared(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 1, ResourceMemory: 4})()
+}
+
+func TestUndeclared(t *testing.T) {
+	defer smtest.Parallel(t, smtest.ResourceSet{ResourceCPU: 1, ResourceGPU: 1})() // want `resource "gpu" is not in the pool passed to smtest.Start`
+}
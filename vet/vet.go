@@ -0,0 +1,494 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vet provides analyzers that find misuse of the scheduler at review
+// time, rather than as hangs, panics or skips at run time.  They can be run
+// with go vet via cmd/smtest-vet e.g.
+//
+//	go vet -vettool=$(which smtest-vet) ./...
+//
+// or added to any driver that accepts analyzers, for example golangci-lint.
+//
+// Analyzer reports:
+//
+//   - The release function returned by Parallel being assigned to a variable
+//     that is never called, deferred, or handed to something else to call.
+//     Resources are returned when the test ends, so discarding the release
+//     function is fine, but keeping it suggests they were meant to be
+//     returned early.
+//   - Parallel or Acquire being called in TestMain before Start, or m.Run being
+//     called before Start when tests call Parallel or Acquire.
+//   - Tests requiring a resource that isn't in the pool passed to Start, when
+//     the pool is a literal, or a variable initialized with one, in the same
+//     package.  Resources added with SMTEST_RESOURCES or -smtest.resources are,
+//     by their nature, not seen.
+//
+// OvercommitAnalyzer reports tests that require more resources than the pool
+// has, so can never run.
+package vet
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const (
+	// smtestPath is the import path of the scheduler.
+	smtestPath = "github.com/spjmurray/testing"
+
+	// configPath is the import path of the configuration package, which
+	// starts the scheduler from a file.
+	configPath = smtestPath + "/config"
+)
+
+// Analyzer reports misuse of the scheduler.
+var Analyzer = &analysis.Analyzer{
+	Name:     "smtest",
+	Doc:      "report misuse of the github.com/spjmurray/testing scheduler",
+	URL:      "https://pkg.go.dev/github.com/spjmurray/testing/vet",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+// isFunc returns whether the call is to one of the named functions in the
+// package.
+func isFunc(pass *analysis.Pass, call *ast.CallExpr, path string, names ...string) bool {
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != path {
+		return false
+	}
+
+	// Methods are never of interest.
+	if fn.Type().(*types.Signature).Recv() != nil {
+		return false
+	}
+
+	for _, name := range names {
+		if fn.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRequest returns whether the call requests resources.
+func isRequest(pass *analysis.Pass, call *ast.CallExpr) bool {
+	return isFunc(pass, call, smtestPath, "Parallel", "Acquire")
+}
+
+// isStart returns whether the call starts the scheduler.
+func isStart(pass *analysis.Pass, call *ast.CallExpr) bool {
+	return isFunc(pass, call, smtestPath, "Start") || isFunc(pass, call, configPath, "StartFromConfig")
+}
+
+// isRun returns whether the call is to testing.M's Run method.
+func isRun(pass *analysis.Pass, call *ast.CallExpr) bool {
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Name() != "Run" {
+		return false
+	}
+
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return false
+	}
+
+	ptr, ok := recv.Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+
+	named, ok := ptr.Elem().(*types.Named)
+
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "testing" && named.Obj().Name() == "M"
+}
+
+// enclosingBody returns the body of the innermost function in the stack.
+func enclosingBody(stack []ast.Node) *ast.BlockStmt {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.FuncDecl:
+			return n.Body
+		case *ast.FuncLit:
+			return n.Body
+		}
+	}
+
+	return nil
+}
+
+// released returns whether the release function is called, deferred, passed
+// to something else e.g. t.Cleanup, or returned to the caller in the body.
+func released(pass *analysis.Pass, body *ast.BlockStmt, obj types.Object) bool {
+	is := func(e ast.Expr) bool {
+		ident, ok := ast.Unparen(e).(*ast.Ident)
+
+		return ok && pass.TypesInfo.Uses[ident] == obj
+	}
+
+	found := false
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if is(n.Fun) {
+				found = true
+			}
+
+			for _, arg := range n.Args {
+				if is(arg) {
+					found = true
+				}
+			}
+		case *ast.ReturnStmt:
+			for _, result := range n.Results {
+				if is(result) {
+					found = true
+				}
+			}
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// checkRelease reports calls to Parallel whose release function is assigned
+// to a variable that is never used to return the resources early.  Discarding
+// it is fine, resources are returned when the test ends.
+func checkRelease(pass *analysis.Pass, call *ast.CallExpr, stack []ast.Node) {
+	var lhs ast.Expr
+
+	switch parent := stack[len(stack)-2].(type) {
+	case *ast.AssignStmt:
+		for i, rhs := range parent.Rhs {
+			if rhs == call && i < len(parent.Lhs) {
+				lhs = parent.Lhs[i]
+			}
+		}
+	case *ast.ValueSpec:
+		for i, value := range parent.Values {
+			if value == call && i < len(parent.Names) {
+				lhs = parent.Names[i]
+			}
+		}
+	}
+
+	ident, ok := lhs.(*ast.Ident)
+	if !ok || ident.Name == "_" {
+		return
+	}
+
+	obj := pass.TypesInfo.ObjectOf(ident)
+	if obj == nil {
+		return
+	}
+
+	body := enclosingBody(stack)
+	if body == nil {
+		return
+	}
+
+	if !released(pass, body, obj) {
+		pass.Reportf(call.Pos(), "the release function returned by Parallel, %s, is never called", ident.Name)
+	}
+}
+
+// checkTestMain reports resource requests made before the scheduler is
+// started in TestMain.  Calls are considered in source order.
+func checkTestMain(pass *analysis.Pass, decl *ast.FuncDecl, requested bool) {
+	start := token.NoPos
+
+	var requests, runs []*ast.CallExpr
+
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		switch {
+		case isStart(pass, call):
+			if start == token.NoPos || call.Pos() < start {
+				start = call.Pos()
+			}
+		case isRequest(pass, call):
+			requests = append(requests, call)
+		case isRun(pass, call):
+			runs = append(runs, call)
+		}
+
+		return true
+	})
+
+	before := func(call *ast.CallExpr) bool {
+		return start == token.NoPos || call.Pos() < start
+	}
+
+	for _, call := range requests {
+		if before(call) {
+			pass.Reportf(call.Pos(), "resources are requested before smtest.Start is called")
+		}
+	}
+
+	if !requested {
+		return
+	}
+
+	for _, call := range runs {
+		if before(call) {
+			pass.Reportf(call.Pos(), "tests are run before smtest.Start is called, so they fail to request resources")
+		}
+	}
+}
+
+// resourceKeys returns the names of the resources in a resource set literal,
+// and false if any are not constant.
+func resourceKeys(pass *analysis.Pass, lit *ast.CompositeLit) (map[string]ast.Expr, bool) {
+	keys := map[string]ast.Expr{}
+
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, false
+		}
+
+		tv, ok := pass.TypesInfo.Types[kv.Key]
+		if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+			return nil, false
+		}
+
+		keys[constant.StringVal(tv.Value)] = kv.Key
+	}
+
+	return keys, true
+}
+
+// poolLiteral returns the resource set literal passed to Start, either
+// directly or via a variable that is initialized with it and never assigned
+// again.
+func poolLiteral(pass *analysis.Pass, e ast.Expr) *ast.CompositeLit {
+	e = ast.Unparen(e)
+
+	if lit, ok := e.(*ast.CompositeLit); ok {
+		return lit
+	}
+
+	ident, ok := e.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	obj, ok := pass.TypesInfo.Uses[ident].(*types.Var)
+	if !ok {
+		return nil
+	}
+
+	var init ast.Expr
+
+	assignments := 0
+
+	// Look for the variable's initialization, and anything else that may
+	// modify it.
+	record := func(lhs, rhs ast.Expr) {
+		if index, ok := lhs.(*ast.IndexExpr); ok {
+			lhs = index.X
+		}
+
+		if id, ok := lhs.(*ast.Ident); ok && pass.TypesInfo.ObjectOf(id) == obj {
+			init = rhs
+			assignments++
+		}
+	}
+
+	for _, file := range pass.Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ValueSpec:
+				for i, name := range n.Names {
+					var value ast.Expr
+
+					if i < len(n.Values) {
+						value = n.Values[i]
+					}
+
+					record(name, value)
+				}
+			case *ast.AssignStmt:
+				for i, lhs := range n.Lhs {
+					var value ast.Expr
+
+					if len(n.Lhs) == len(n.Rhs) {
+						value = n.Rhs[i]
+					}
+
+					record(lhs, value)
+				}
+			case *ast.IncDecStmt:
+				record(n.X, nil)
+			case *ast.UnaryExpr:
+				// Taking the address may allow anything to modify it.
+				if n.Op == token.AND {
+					record(n.X, nil)
+				}
+			}
+
+			return true
+		})
+	}
+
+	if assignments != 1 || init == nil {
+		return nil
+	}
+
+	lit, _ := ast.Unparen(init).(*ast.CompositeLit)
+
+	return lit
+}
+
+// startPools returns the resource set literals passed to Start, and false if
+// there is no call to Start, or what it is passed can't be determined.
+func startPools(pass *analysis.Pass, insp *inspector.Inspector) ([]*ast.CompositeLit, bool) {
+	var pools []*ast.CompositeLit
+
+	known := true
+
+	insp.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+
+		if isFunc(pass, call, configPath, "StartFromConfig") {
+			known = false
+
+			return
+		}
+
+		if !isFunc(pass, call, smtestPath, "Start") || len(call.Args) == 0 {
+			return
+		}
+
+		lit := poolLiteral(pass, call.Args[0])
+		if lit == nil {
+			known = false
+
+			return
+		}
+
+		pools = append(pools, lit)
+	})
+
+	return pools, known && len(pools) > 0
+}
+
+// declaredResources returns the resources in the pool passed to Start, and
+// false if they can't be determined.
+func declaredResources(pass *analysis.Pass, insp *inspector.Inspector) (map[string]bool, bool) {
+	pools, ok := startPools(pass, insp)
+	if !ok {
+		return nil, false
+	}
+
+	declared := map[string]bool{}
+
+	for _, lit := range pools {
+		keys, ok := resourceKeys(pass, lit)
+		if !ok {
+			return nil, false
+		}
+
+		for k := range keys {
+			declared[k] = true
+		}
+	}
+
+	return declared, true
+}
+
+// checkUndeclared reports resources required by a test that aren't in the
+// pool.
+func checkUndeclared(pass *analysis.Pass, call *ast.CallExpr, declared map[string]bool) {
+	if len(call.Args) < 2 {
+		return
+	}
+
+	lit, ok := ast.Unparen(call.Args[1]).(*ast.CompositeLit)
+	if !ok {
+		return
+	}
+
+	keys, _ := resourceKeys(pass, lit)
+
+	for name, key := range keys {
+		if !declared[name] {
+			pass.Reportf(key.Pos(), "resource %q is not in the pool passed to smtest.Start", name)
+		}
+	}
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	// The scheduler's own tests misuse it on purpose.
+	if pass.Pkg.Path() == smtestPath {
+		return nil, nil
+	}
+
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	declared, known := declaredResources(pass, insp)
+
+	requested := false
+
+	insp.WithStack([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+
+		call := n.(*ast.CallExpr)
+
+		if !isRequest(pass, call) {
+			return true
+		}
+
+		requested = true
+
+		if isFunc(pass, call, smtestPath, "Parallel") {
+			checkRelease(pass, call, stack)
+		}
+
+		if known {
+			checkUndeclared(pass, call, declared)
+		}
+
+		return true
+	})
+
+	insp.Preorder([]ast.Node{(*ast.FuncDecl)(nil)}, func(n ast.Node) {
+		decl := n.(*ast.FuncDecl)
+
+		if decl.Name.Name == "TestMain" && decl.Recv == nil && decl.Body != nil {
+			checkTestMain(pass, decl, requested)
+		}
+	})
+
+	return nil, nil
+}
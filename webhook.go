@@ -0,0 +1,92 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookTimeout is how long a webhook has to respond.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhookPayload is posted to webhooks when an alert is raised.  The text
+// field makes it compatible with Slack incoming webhooks, kind and message
+// allow other consumers to filter alerts.
+type webhookPayload struct {
+	// Text is a human readable summary of the alert.
+	Text string `json:"text"`
+
+	// Kind is the type of alert.
+	Kind AlertKind `json:"kind"`
+
+	// Message is the alert's message.
+	Message string `json:"message"`
+}
+
+var (
+	// webhooks tracks in flight webhook requests so Report can wait for
+	// them before the process exits.
+	webhooks sync.WaitGroup
+)
+
+// webhook returns an alert hook that posts alerts to the URL.  Requests
+// are made asynchronously as alert hooks must not block.
+func webhook(url string) func(Alert) {
+	client := &http.Client{
+		Timeout: webhookTimeout,
+	}
+
+	return func(alert Alert) {
+		payload := &webhookPayload{
+			Text:    fmt.Sprintf("smtest %s: %s", alert.Kind, alert.Message),
+			Kind:    alert.Kind,
+			Message: alert.Message,
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "smtest: failed to marshal webhook payload: %v\n", err)
+			return
+		}
+
+		webhooks.Add(1)
+
+		go func() {
+			defer webhooks.Done()
+
+			response, err := client.Post(url, "application/json", bytes.NewReader(data))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "smtest: webhook failed: %v\n", err)
+				return
+			}
+
+			defer response.Body.Close()
+
+			if response.StatusCode < 200 || response.StatusCode >= 300 {
+				fmt.Fprintf(os.Stderr, "smtest: webhook failed: unexpected status %s\n", response.Status)
+			}
+		}()
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023-2024 Simon Murray.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	payloads := make(chan webhookPayload, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		payloads <- payload
+	}))
+
+	defer server.Close()
+
+	webhook(server.URL)(Alert{
+		Kind:    AlertStarvation,
+		Message: "uh oh",
+	})
+
+	payload := <-payloads
+
+	if payload.Kind != AlertStarvation || payload.Message != "uh oh" || payload.Text != "smtest Starvation: uh oh" {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+}